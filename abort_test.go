@@ -0,0 +1,69 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// abort sends a request to AbortHandler.
+func (ta *testAssembler) abort(uploadID int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/upload", nil)
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	rec := httptest.NewRecorder()
+	ta.a.AbortHandler(rec, req)
+	return rec
+}
+
+func TestAbortHandler(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+
+	if rec := ta.abort(uploadID); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := ta.a.Config.Tracker.GetUpload(uploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("aborted upload is still tracked: %v", err)
+	}
+	// Chunks and the upload info sidecar are removed.
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got chunk files %v after aborting", files)
+	}
+	rec := ta.send(uploadID, 2, "c", nil)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != ErrUploadNotFound.Error() {
+		t.Errorf("chunk of an aborted upload: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Aborting again is fine.
+	if rec := ta.abort(uploadID); rec.Code != http.StatusOK {
+		t.Errorf("aborting again: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAbortHook(t *testing.T) {
+	var aborted []string
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Hooks: AssemblerHooks{OnUploadAborted: func(fileID string) {
+			aborted = append(aborted, fileID)
+		}},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	if err := ta.a.Abort(uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if err := ta.a.Abort(uploadID); err != nil {
+		t.Fatal(err)
+	}
+	// The hook is only called for the upload that was aborted.
+	if len(aborted) != 1 || aborted[0] != ta.a.fileID(uploadID) {
+		t.Errorf("hook was called with %q", aborted)
+	}
+}
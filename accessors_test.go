@@ -0,0 +1,58 @@
+package assemble
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// userKey is a context key type of another package with the same
+// underlying type and values as the assembler's keys.
+type userKey int
+
+func TestAccessors(t *testing.T) {
+	var fileID string
+	var fileIDOK bool
+	var metadata map[string]interface{}
+	ta := newTestAssembler(t, nil)
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		// Keys set by other middleware don't replace the assembler's.
+		ctx := context.WithValue(r.Context(), userKey(fileIDKey), "user file ID")
+		ctx = context.WithValue(ctx, "id", "user id")
+		ctx = context.WithValue(ctx, userKey(metadataKey), map[string]interface{}{"user": true})
+		r = r.WithContext(ctx)
+		fileID, fileIDOK = GetFileID(r)
+		metadata = GetFileMetadata(r)
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1, "metadata": {"owner": "alice"}}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+
+	if !fileIDOK || fileID != ta.a.fileID(uploadID) {
+		t.Errorf("got file ID %q, %v, want %q", fileID, fileIDOK, ta.a.fileID(uploadID))
+	}
+	if metadata["owner"] != "alice" || metadata["user"] != nil {
+		t.Errorf("got metadata %v", metadata)
+	}
+}
+
+func TestAccessorsOutsideAssembler(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx := context.WithValue(r.Context(), userKey(fileIDKey), "user file ID")
+	ctx = context.WithValue(ctx, userKey(rejectionKey), &rejectionSlot{})
+	r = r.WithContext(ctx)
+	if fileID, ok := GetFileID(r); ok {
+		t.Errorf("got file ID %q", fileID)
+	}
+	if metadata := GetFileMetadata(r); metadata != nil {
+		t.Errorf("got metadata %v", metadata)
+	}
+	if _, _, ok := GetRejection(r); ok {
+		t.Error("got a rejection before RejectFile")
+	}
+	RejectFile(r, http.StatusForbidden, "rejected")
+	status, reason, ok := GetRejection(r)
+	if !ok || status != http.StatusForbidden || reason != "rejected" {
+		t.Errorf("got rejection %d %q %v, want %d %q", status, reason, ok, http.StatusForbidden, "rejected")
+	}
+}
@@ -2,14 +2,33 @@ package assemble
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errFileTooLarge   = errors.New("file exceeds maximum size")
+	errChunkTooLarge  = errors.New("chunk exceeds maximum size")
+	errChunkConflict  = errors.New("chunk was already received with different contents")
+	errTooManyUploads = errors.New("too many uploads in progress")
+	errTooManyChunks  = &requestError{kind: ErrInvalidChunkTotal, err: errors.New("upload has too many chunks")}
+	errClosed         = errors.New("assembler is closed")
+
+	errDownstreamTimeout = errors.New("processing the file took too long")
 )
 
 const (
@@ -19,7 +38,36 @@ const (
 
 type FileChunksAssembler struct {
 	Config *AssemblerConfig
-	data   *tracker
+
+	// Serializes requests for the same upload within this process.
+	uploadLocks sync.Map
+
+	// Serializes starting uploads so the number of uploads can be checked
+	// before adding another.
+	startLock sync.Mutex
+
+	// Goroutines that Close waits for, i.e. janitors, chunk cleanup and
+	// webhooks. Webhooks stop retrying once closing is cancelled by Close.
+	background    sync.WaitGroup
+	janitors      []func()
+	closeLock     sync.Mutex
+	closed        int32
+	closing       context.Context
+	cancelClosing context.CancelFunc
+
+	// Final progress updates of completed uploads by upload ID.
+	completions sync.Map
+
+	// Names of completed files that are being written, so that concurrent
+	// uploads with the same file name don't pick the same name. Values are
+	// channels that are closed once the name is released.
+	completedNames sync.Map
+
+	// Uploads whose chunks are being combined by this process. An upload is
+	// added by claimCompletion and removed when completeUpload returns.
+	combining sync.Map
+
+	counters assemblerCounters
 }
 
 type AssemblerConfig struct {
@@ -43,6 +91,548 @@ type AssemblerConfig struct {
 	//
 	// Default: $HOME/.go-assemble-data/completed
 	CompletedDir string
+
+	// Path to directory where the default store writes completed files
+	// before moving them into CompletedDir, e.g. so that partially written
+	// files aren't seen by anything watching CompletedDir. It should be on
+	// the same filesystem as CompletedDir, otherwise files are copied
+	// instead of renamed, which is logged when the assembler is created.
+	//
+	// Default: completed files are written next to their final path
+	WorkingDir string
+
+	// Backend where chunks and completed files are stored. If provided,
+	// ChunksDir and CompletedDir are not used.
+	//
+	// Default: FilesystemStore using ChunksDir and CompletedDir
+	Store ChunkStore
+
+	// Permissions of chunk files created by the default store. Like all file
+	// permissions, these are subject to the process umask.
+	//
+	// Default: 0644
+	ChunkFileMode os.FileMode
+
+	// Permissions of completed files created by the default store.
+	//
+	// Default: 0666
+	CompletedFileMode os.FileMode
+
+	// Permissions of the default directories if they need to be created.
+	//
+	// Default: 0755
+	DirMode os.FileMode
+
+	// Name files created by the default store after a hash of the upload ID
+	// instead of the upload ID itself.
+	HashFileNames bool
+
+	// Records the state of in-progress uploads. A shared tracker is needed
+	// when uploads are spread across multiple assembler instances.
+	//
+	// Default: MemoryTracker
+	Tracker Tracker
+
+	// Header name for the hex-encoded checksum of a chunk. If set, chunks
+	// sent with this header are rejected when the checksum doesn't match.
+	//
+	// Default: checksums are not verified
+	ChunkChecksumHeader string
+
+	// Algorithm used for ChunkChecksumHeader.
+	//
+	// Default: sha256
+	ChunkChecksumAlgorithm ChecksumAlgorithm
+
+	// Header name for the hex-encoded SHA256 of the completed file. It can be
+	// sent with any chunk, or as "checksum" when starting the upload. If the
+	// completed file doesn't match, it is deleted and the upload is rejected.
+	//
+	// Default: completed files are not verified unless "checksum" is sent
+	FileChecksumHeader string
+
+	// Keep chunks of an upload that failed checksum verification, so that
+	// corrupted chunks can be sent again to complete the upload.
+	KeepChunksOnChecksumMismatch bool
+
+	// Algorithm used for the hash of the completed file, which is returned in
+	// the final progress update.
+	//
+	// Default: md5
+	CompletedFileHashAlgorithm ChecksumAlgorithm
+
+	// Maximum size of a completed file in bytes. Uploads are cancelled as
+	// soon as the chunks received exceed this size.
+	//
+	// Default: 0 (unlimited)
+	MaxFileSize int64
+
+	// Maximum sizes of completed files by mimetype, which replace MaxFileSize
+	// for uploads of that "type". Keys can be wildcards like "image/*", and
+	// exact mimetypes take precedence over wildcards. A size of 0 means
+	// unlimited.
+	MaxFileSizeByType map[string]int64
+
+	// Maximum size of a chunk in bytes. Larger chunks are rejected without
+	// being read entirely into memory.
+	//
+	// Default: 0 (unlimited)
+	MaxChunkSize int64
+
+	// Maximum number of parts in a request to BatchChunksMiddleware. The
+	// next part gets HTTP 413 as its result, and the rest aren't read.
+	//
+	// Default: DefaultMaxBatchParts
+	MaxBatchParts int
+
+	// Accept an empty chunk if it's the only chunk of its upload, so that
+	// empty files can be uploaded. Empty chunks are otherwise rejected with
+	// HTTP 400.
+	AllowEmptyFile bool
+
+	// Reject a chunk with HTTP 409 if a chunk with the same sequence number
+	// but different contents was already received. Sending the same chunk
+	// again is still allowed.
+	RejectChunkOverwrite bool
+
+	// Time since an upload was started or last received a chunk, after which
+	// it is removed by Sweep.
+	//
+	// Default: 0 (uploads don't expire)
+	IncompleteUploadTTL time.Duration
+
+	// Time since an upload was started within which it must be completed,
+	// regardless of activity, e.g. to enforce the window of a pre-signed
+	// upload. Chunks received after it are rejected with HTTP 410 and the
+	// upload is removed.
+	//
+	// Default: 0 (no deadline)
+	MaxUploadDuration time.Duration
+
+	// Maximum number of uploads in progress. Requests to start another
+	// upload are rejected with HTTP 429 until one of them finishes.
+	//
+	// Default: 0 (unlimited)
+	MaxConcurrentUploads int
+
+	// Receives events for chunks and uploads, e.g. to record them in a
+	// metrics system.
+	//
+	// Default: events are discarded
+	Metrics Metrics
+
+	// Starts spans around chunk requests, storing chunks and combining
+	// them, e.g. with an adapter for OpenTelemetry. If it implements
+	// TracePropagator, chunk requests continue the client's trace.
+	//
+	// Default: no tracing
+	Tracer Tracer
+
+	// Receives log lines for chunks, completed and rejected uploads, and
+	// errors that can't be returned to a client.
+	//
+	// Default: log lines are discarded
+	Logger Logger
+
+	// Functions called when chunks are stored and uploads complete or are
+	// aborted.
+	Hooks AssemblerHooks
+
+	// URL that receives a POST request with the file ID, size, content type
+	// and hash of each completed upload that wasn't rejected downstream.
+	// Failed requests are retried with backoff in the background.
+	//
+	// Default: no webhook is sent
+	CompletionWebhookURL string
+
+	// HTTP client used for CompletionWebhookURL.
+	//
+	// Default: http.DefaultClient
+	WebhookClient *http.Client
+
+	// Timeout of each request to CompletionWebhookURL.
+	//
+	// Default: 10s
+	WebhookTimeout time.Duration
+
+	// Name of the file part holding the chunk when chunks are sent as
+	// multipart/form-data. The upload and chunk IDs can also be sent as form
+	// values with the same names as their headers.
+	//
+	// Default: file
+	ChunkFormField string
+
+	// Mimetypes that can be uploaded, which may include wildcards like
+	// "image/*". Uploads with a "type" in their metadata that isn't allowed
+	// are rejected with HTTP 415 when they are started, as are chunks whose
+	// metadata headers declare such a type. Uploads without a "type" are
+	// treated as application/octet-stream.
+	//
+	// Default: all mimetypes are allowed
+	AllowedMimeTypes []string
+
+	// Minimum space in bytes to leave free on the volume backing ChunksDir.
+	// Chunks that would leave less are rejected with HTTP 507. This is only
+	// checked for stores that implement FreeSpaceReporter.
+	//
+	// Default: 0 (not checked)
+	MinFreeDiskBytes int64
+
+	// Size in bytes of every chunk except the last, which can be smaller.
+	// Chunks of any other size are rejected with HTTP 400, to catch clients
+	// that don't split files evenly. This isn't used by TusHandler.
+	//
+	// Default: 0 (chunks can be any size)
+	ExpectedChunkSize int64
+
+	// Delete the chunks of a completed upload before the downstream handler
+	// is called, instead of in the background. Cleanup errors are logged
+	// either way.
+	SynchronousCleanup bool
+
+	// Validate chunks and respond with the progress of uploads as usual,
+	// but don't store chunks or combine them, so that clients can be tested
+	// against a real server without filling its storage. The downstream
+	// handler is never called. Store, ChunksDir and CompletedDir aren't
+	// used.
+	ValidateOnly bool
+
+	// How long the final progress update of an upload is remembered, so that
+	// a chunk re-sent after the upload completed gets the same response
+	// instead of an error. Completed uploads are only remembered by the
+	// instance that completed them, and expired ones are removed by Sweep.
+	//
+	// Default: 0 (not remembered)
+	CompletedUploadTTL time.Duration
+
+	// Time since a completed file was written after which Sweep deletes it,
+	// so that CompletedDir doesn't grow without bound when completed files
+	// aren't needed once they've been processed. The store must implement
+	// CompletedSweeper.
+	//
+	// Default: 0 (completed files are kept)
+	CompletedFileTTL time.Duration
+
+	// Delete the completed file once the downstream handler has returned,
+	// unless it rejected the file or responded with an error. The handler
+	// must be done reading the request body by then, and must not keep
+	// using it in other goroutines.
+	AutoDeleteAfterDownstream bool
+
+	// Prefix of headers that are added to the metadata of an upload, with
+	// the prefix removed from their names. They can be sent when starting
+	// the upload or with any chunk, and later values replace earlier ones.
+	// The "type" can't change once it's set, and chunks with a different
+	// one are rejected with HTTP 409.
+	//
+	// Default: x-assemble-meta-
+	MetadataHeaderPrefix string
+
+	// Header name for the original name of the file, which can be sent when
+	// starting the upload or with any chunk. Directories are removed from it
+	// and it is added to the metadata as "name".
+	//
+	// Default: x-assemble-filename
+	FileNameHeader string
+
+	// Name completed files after the file name from FileNameHeader instead
+	// of the upload ID. By default, if a completed file with that name
+	// already exists, a number is added to the name, e.g. "report (1).pdf".
+	// See OnExistingCompleted.
+	NameCompletedFiles bool
+
+	// Returns the name of the completed file of an upload, given its file ID
+	// and metadata. The name can contain forward slashes to put the file in
+	// subdirectories, which are created as needed. If the name is taken, a
+	// number is added to it like with NameCompletedFiles. This takes
+	// precedence over NameCompletedFiles.
+	//
+	// Default: the file ID
+	CompletedNamer func(fileID string, meta map[string]interface{}) string
+
+	// Decompress chunks sent with "Content-Encoding: gzip" or "deflate"
+	// before storing them, so the completed file has the original contents.
+	// MaxChunkSize and chunk checksums apply to the decompressed chunk. Other
+	// encodings are rejected with HTTP 415.
+	DecompressChunks bool
+
+	// Encrypt chunks stored by the default store with AES-GCM using this key,
+	// which must be 32 bytes long for AES-256. The key is never logged.
+	EncryptionKey []byte
+
+	// Keep completed files written by the default store encrypted with
+	// EncryptionKey. They are decrypted before being passed downstream.
+	EncryptCompletedFiles bool
+
+	// Header with a base64-encoded 32 byte key for each upload, e.g.
+	// x-assemble-enc-key, so that chunks are encrypted with a key chosen by
+	// the client rather than one kept by the server. Uploads must be started
+	// with the key, and only its SHA256 fingerprint is kept. Every chunk
+	// must be sent with the same key, which is also used to decrypt the
+	// chunks when they're combined. Chunks without the key are rejected
+	// with HTTP 400, and chunks with a different key with HTTP 403; the
+	// upload itself is left as it is.
+	// Completed files are written decrypted. It can't be used with
+	// EncryptionKey, and the store must implement KeyedStore.
+	//
+	// Default: "" (disabled)
+	UploadKeyHeader string
+
+	// Store one copy of completed files with the same contents in the
+	// default store, using hard links. This is only supported on Linux,
+	// macOS and FreeBSD.
+	DeduplicateCompletedFiles bool
+
+	// Decode chunk bodies from standard base64 before storing them, for
+	// clients that can't send binary data. Invalid base64 is rejected with
+	// HTTP 400, and MaxChunkSize applies to the decoded chunk.
+	Base64Chunks bool
+
+	// Gets the upload and chunk IDs of requests that don't have them in
+	// headers, e.g. QueryChunkIDs for clients behind proxies that strip
+	// custom headers. This also applies to StatusHandler and AbortHandler.
+	ChunkIDExtractor ChunkIDExtractor
+
+	// Add the location of the completed file to the final progress update,
+	// e.g. its path on the server or its S3 URL. Stores that implement
+	// Locator decide what the location is. This is disabled by default
+	// because it can reveal details about the server.
+	ExposeCompletedLocation bool
+
+	// Header name for marking the last chunk of an upload, e.g.
+	// "x-assemble-final-chunk: true". Uploads can then be started without
+	// total_chunks, and the number of chunks is set when the final chunk is
+	// received. If total_chunks is given, the final chunk must agree with it.
+	FinalChunkHeader string
+
+	// Add the sequence numbers of chunks that haven't been received to
+	// progress updates of incomplete uploads, so clients know exactly which
+	// chunks to send again.
+	ReportMissingChunks bool
+
+	// Maximum number of missing chunks listed in a progress update with
+	// ReportMissingChunks, or returned by MissingChunks. If more are
+	// missing, the first ones are listed and "missing_truncated" is set.
+	//
+	// Default: DefaultMaxMissingChunks
+	MaxMissingChunks int
+
+	// Maximum time the downstream handler can take to process a completed
+	// file. Its request's context is cancelled after this time, and the
+	// final progress update is sent with HTTP 504 without waiting for it to
+	// return.
+	//
+	// Default: no timeout
+	DownstreamTimeout time.Duration
+
+	// Header name for the size of the completed file in bytes, which can be
+	// sent when starting the upload or with any chunk. It is returned in
+	// progress updates so clients can show progress in bytes. It can also be
+	// sent as "size" when starting the upload. Once it's known, chunks with
+	// a different size are rejected with HTTP 409.
+	//
+	// Default: x-assemble-total-size
+	TotalSizeHeader string
+
+	// Writes progress updates in response to chunks, e.g. to wrap them in
+	// an API's usual envelope or to use a different format. It must write
+	// the status in the ProgressInfo. Errors for invalid requests are still
+	// written as JSON.
+	//
+	// Default: EncodeProgressJSON
+	ResponseEncoder func(w http.ResponseWriter, progress ProgressInfo)
+
+	// JSON keys to use for fields of progress updates instead of their
+	// usual ones, e.g. {"have": "received_chunks", "error": "message"}, so
+	// that responses match an existing client's contract. Keys must be
+	// fields of ProgressInfo as they are named in JSON, and each field must
+	// end up with a different name. This applies to any ResponseEncoder
+	// that encodes the ProgressInfo as JSON.
+	//
+	// Default: nil (the usual names)
+	ProgressFieldNames map[string]string
+
+	// Writes error responses, e.g. to use an API's usual error format or
+	// add request IDs. It must write the status. Internal errors are
+	// logged before it's called, and are given to it with 500 as an
+	// InternalError, whose message doesn't reveal the cause.
+	//
+	// Default: WriteErrorJSON
+	ErrorHandler func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+	// Number of the first chunk of an upload, for clients that number
+	// chunks from 1. It must be 0 or 1, and applies to every chunk sequence
+	// number sent to or from clients.
+	ChunkSequenceBase int
+
+	// Generates the IDs of new uploads, e.g. RandomUploadID so that IDs
+	// can't be guessed. Generated IDs that are already in use are replaced.
+	//
+	// Default: the tracker's IDs, which count up from 0
+	UploadIDGenerator UploadIDGenerator
+
+	// Scans completed files before they are passed downstream, e.g. for
+	// viruses. If it returns an error, the completed file is deleted and
+	// the upload is rejected with ScanRejectedStatus. The error is logged
+	// but not sent to the client. The context is cancelled if the client
+	// goes away or ScanTimeout is exceeded.
+	Scanner func(ctx context.Context, r io.Reader) error
+
+	// Status of uploads rejected by Scanner.
+	//
+	// Default: 422
+	ScanRejectedStatus int
+
+	// Maximum time Scanner can take. Uploads whose scan doesn't finish in
+	// time are rejected with HTTP 504.
+	//
+	// Default: no timeout
+	ScanTimeout time.Duration
+
+	// Processes completed files after they are scanned, e.g. to resize
+	// images, and returns the path of the output, which is passed downstream
+	// instead. It's given the location returned by the store, which is the
+	// path of the file with the default store. If the output is a new file,
+	// the original is deleted, and the output becomes the location of the
+	// upload. If it returns an error, the completed file is deleted and the
+	// upload is rejected with HTTP 422. The error is logged but not sent to
+	// the client.
+	PostProcessor func(ctx context.Context, path string) (string, error)
+
+	// Reject completed files with HTTP 415 if their contents don't match
+	// their "type", as detected by http.DetectContentType. Uploads without a
+	// type must match AllowedMimeTypes instead, if it's set. Only common
+	// types can be detected, so other types will always be rejected.
+	VerifyContentType bool
+
+	// Maximum number of chunks an upload can have. Uploads started with
+	// more are rejected with HTTP 400, as are chunks past the limit of
+	// uploads that don't know their number of chunks yet.
+	//
+	// Default: 0 (unlimited)
+	MaxChunkTotal int64
+
+	// Append the chunks of each upload to a single file in ChunksDir, with
+	// an index of where each chunk is, instead of saving each chunk as a
+	// separate file. This is for uploads with many chunks, and is only used
+	// by the default store.
+	AppendChunks bool
+
+	// Continue combining an upload's chunks from where a combine that was
+	// interrupted by a crash left off, instead of starting again, if the
+	// chunks were kept. This is for very large files, and is only used by
+	// the default store without EncryptionKey or UploadKeyHeader.
+	ResumeCombine bool
+
+	// Reject completed file names and file IDs that Windows can't use, such
+	// as "CON", "nul.txt" or names ending with a dot or space, even when
+	// not running on Windows, where this is always enabled. It's only used
+	// by the default store.
+	StrictFilenameSafety bool
+
+	// Size in bytes of the buffers the default store uses to copy chunks
+	// into completed files. Larger buffers mean fewer writes for uploads
+	// with small chunks.
+	//
+	// Default: 32 KiB
+	CombineBufferSize int
+
+	// What to do when a completed file with the same name already exists.
+	//
+	// Default: ExistingCompletedOverwrite for files named after the upload
+	// ID, and ExistingCompletedVersion for names from NameCompletedFiles or
+	// CompletedNamer
+	OnExistingCompleted ExistingCompletedPolicy
+
+	// Header name for a JSON object that can replace the separate headers
+	// of chunk requests, e.g. "x-assemble-control". Its "id" and "seq" are
+	// the upload and chunk IDs, "total" is the number of chunks, and
+	// "contentType" is the type of the file. IDs can be numbers or strings.
+	// Uploads can then be started without total_chunks. A "total" that
+	// differs from the upload's is rejected with HTTP 409.
+	// Separate headers take precedence. Malformed JSON is rejected with HTTP
+	// 400.
+	//
+	// Default: disabled
+	ControlHeader string
+
+	// Added to the upload ID to make the file ID, which names the upload's
+	// files in the store and is passed to hooks and metrics. Assemblers that
+	// share a store, e.g. for different routes with different limits, need
+	// different prefixes so that their uploads don't collide. Prefixes
+	// shouldn't be prefixes of each other, and with the default store they
+	// can't contain slashes.
+	//
+	// Default: no prefix
+	FileIDPrefix string
+
+	// Checks the file ID of each new upload, e.g. to enforce a naming scheme
+	// of the store, in place of the default store's StrictFilenameSafety
+	// checks. Uploads whose IDs are rejected fail to start, unless
+	// UploadIDGenerator is set, in which case another ID is tried. The
+	// default store rejects file IDs containing path separators, NUL, "."
+	// or ".." regardless, but validators used with stores that write to the
+	// filesystem by other means must reject them themselves.
+	//
+	// Default: no check
+	FileIDValidator func(fileID string) error
+
+	// Header name for the byte offset of each chunk in the file, e.g.
+	// "x-assemble-offset", for clients that send byte ranges instead of
+	// numbered chunks. Chunk IDs aren't needed, and uploads must be started
+	// with their size but without total_chunks. The upload completes once
+	// the ranges received cover the whole file. Chunks that overlap a
+	// received range are rejected with HTTP 409, and chunks past the end of
+	// the file with HTTP 400. MaxChunkTotal limits the number of ranges,
+	// and ExpectedChunkSize, FinalChunkHeader and ReportMissingChunks don't
+	// apply. The store must implement SequenceFinalizer, and
+	// ChunkSequenceBase must be 0. Since chunks are numbered by their
+	// offsets, this can't be used with MemoryTracker.CompactChunks.
+	//
+	// Default: disabled
+	OffsetHeader string
+
+	// Tells the time for upload creation and expiry, receive times of
+	// chunks, Sweep and upload durations in Metrics, so that they can be
+	// tested with a fake clock. StartJanitor still sweeps every interval
+	// of real time.
+	//
+	// Default: the system clock
+	Clock Clock
+
+	// Methods of chunk requests. Requests with other methods are rejected
+	// with HTTP 405, so the middleware doesn't rely on the router to
+	// filter them. HEAD requests for checking progress are always allowed
+	// by ChunksMiddleware. A chunk sent again with PUT isn't stored again if
+	// it has the same contents, and is rejected with HTTP 409 if it doesn't.
+	//
+	// Default: POST
+	ChunkMethods []string
+
+	// Maximum time for reading the body of a chunk request, so that slow
+	// clients can't hold requests open indefinitely. Requests whose body
+	// isn't read in time are rejected with HTTP 408. This sets the
+	// connection's read deadline when the ResponseWriter supports it.
+	// Otherwise, clients that stop sending entirely are only stopped by
+	// the server's ReadTimeout.
+	//
+	// Default: no timeout
+	ChunkReadTimeout time.Duration
+
+	// Gets the user of each request and their limit for the total size of
+	// their completed files, e.g. from an auth token. Uploads that would
+	// take a user over their limit are rejected with HTTP 413 when they're
+	// started with a size or once their chunks add up to more than the
+	// user has left. Uploads in progress don't count towards the limit
+	// until they complete.
+	QuotaResolver QuotaResolver
+
+	// Records the total size of each user's completed files for
+	// QuotaResolver. A shared or persistent store is needed for usage to be
+	// shared between assemblers or kept across restarts.
+	//
+	// Default: NewMemoryQuotaStore()
+	QuotaStore QuotaStore
 }
 
 func NewFileChunksAssembler(config *AssemblerConfig) *FileChunksAssembler {
@@ -55,169 +645,1381 @@ func NewFileChunksAssembler(config *AssemblerConfig) *FileChunksAssembler {
 	if config.ChunkIdentifierHeader == "" {
 		config.ChunkIdentifierHeader = DefaultChunkIdentifierHeader
 	}
-	if config.ChunksDir == "" {
-		chunksDirBase, err := os.UserHomeDir()
-		if err != nil {
-			panic(err)
+	if config.ChunkChecksumAlgorithm == "" {
+		config.ChunkChecksumAlgorithm = ChecksumSHA256
+	}
+	if _, err := config.ChunkChecksumAlgorithm.new(); err != nil {
+		panic(err)
+	}
+	if config.CompletedFileHashAlgorithm == "" {
+		config.CompletedFileHashAlgorithm = ChecksumMD5
+	}
+	if _, err := config.CompletedFileHashAlgorithm.new(); err != nil {
+		panic(err)
+	}
+	if config.ChunkFileMode == 0 {
+		config.ChunkFileMode = DefaultChunkFileMode
+	}
+	if config.CompletedFileMode == 0 {
+		config.CompletedFileMode = DefaultCompletedFileMode
+	}
+	if config.DirMode == 0 {
+		config.DirMode = DefaultDirMode
+	}
+	if config.CombineBufferSize <= 0 {
+		config.CombineBufferSize = DefaultCombineBufferSize
+	}
+	if len(config.EncryptionKey) != 0 && len(config.EncryptionKey) != 32 {
+		panic(errInvalidEncryptionKey)
+	}
+	if config.ChunkSequenceBase != 0 && config.ChunkSequenceBase != 1 {
+		panic(errInvalidChunkSequenceBase)
+	}
+	if err := config.OnExistingCompleted.validate(); err != nil {
+		panic(err)
+	}
+	if config.OffsetHeader != "" && config.ChunkSequenceBase != 0 {
+		panic(errOffsetsUnsupported)
+	}
+	if err := validateProgressFieldNames(config.ProgressFieldNames); err != nil {
+		panic(err)
+	}
+	if config.ValidateOnly {
+		config.Store = discardStore{}
+	}
+	if config.Store == nil {
+		if config.ChunksDir == "" {
+			chunksDirBase, err := os.UserHomeDir()
+			if err != nil {
+				panic(err)
+			}
+			config.ChunksDir = path.Join(chunksDirBase, ".go-assemble-data", "chunks")
+			if err := os.MkdirAll(config.ChunksDir, config.DirMode); err != nil {
+				panic(err)
+			}
+		}
+		if config.CompletedDir == "" {
+			completedDirBase, err := os.UserHomeDir()
+			if err != nil {
+				panic(err)
+			}
+			config.CompletedDir = path.Join(completedDirBase, ".go-assemble-data", "completed")
+			if err := os.MkdirAll(config.CompletedDir, config.DirMode); err != nil {
+				panic(err)
+			}
 		}
-		config.ChunksDir = path.Join(chunksDirBase, ".go-assemble-data", "chunks")
-		if err := os.MkdirAll(config.ChunksDir, 0755); err != nil {
-			panic(err)
+		store := NewFilesystemStore(config.ChunksDir, config.CompletedDir)
+		store.ChunkFileMode = config.ChunkFileMode
+		store.CompletedFileMode = config.CompletedFileMode
+		store.DirMode = config.DirMode
+		store.HashFileNames = config.HashFileNames
+		store.EncryptionKey = config.EncryptionKey
+		store.EncryptCompletedFiles = config.EncryptCompletedFiles
+		store.Deduplicate = config.DeduplicateCompletedFiles
+		store.AppendChunks = config.AppendChunks
+		store.ResumeCombine = config.ResumeCombine
+		store.StrictFileNames = config.StrictFilenameSafety
+		store.FileIDValidator = config.FileIDValidator
+		store.CombineBufferSize = config.CombineBufferSize
+		if config.WorkingDir != "" {
+			if err := os.MkdirAll(config.WorkingDir, config.DirMode); err != nil {
+				panic(err)
+			}
+			store.WorkingDir = config.WorkingDir
 		}
+		config.Store = store
 	}
-	if config.CompletedDir == "" {
-		completedDirBase, err := os.UserHomeDir()
-		if err != nil {
-			panic(err)
+	if store, ok := config.Store.(*FilesystemStore); ok && store.Deduplicate && !linkCountSupported {
+		panic(errDeduplicateUnsupported)
+	}
+	if _, ok := config.Store.(SequenceFinalizer); config.OffsetHeader != "" && !ok {
+		panic(errOffsetsUnsupported)
+	}
+	if _, ok := config.Store.(CompletedSweeper); config.CompletedFileTTL > 0 && !ok {
+		panic(errCompletedTTLUnsupported)
+	}
+	if config.UploadKeyHeader != "" {
+		if config.EncryptionKey != nil {
+			panic(errUploadKeyWithMasterKey)
 		}
-		config.CompletedDir = path.Join(completedDirBase, ".go-assemble-data", "completed")
-		if err := os.MkdirAll(config.CompletedDir, 0755); err != nil {
-			panic(err)
+		if _, ok := config.Store.(KeyedStore); !ok {
+			panic(errUploadKeysUnsupported)
+		}
+	}
+	if config.Tracker == nil {
+		config.Tracker = NewMemoryTracker()
+	}
+	if err := checkCompactChunks(config); err != nil {
+		panic(err)
+	}
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+	if config.QuotaStore == nil {
+		config.QuotaStore = NewMemoryQuotaStore()
+	}
+	if len(config.ChunkMethods) == 0 {
+		config.ChunkMethods = []string{http.MethodPost}
+	}
+	if config.Metrics == nil {
+		config.Metrics = noopMetrics{}
+	}
+	if config.Tracer == nil {
+		config.Tracer = noopTracer{}
+	}
+	if config.Logger == nil {
+		config.Logger = discardLogger{}
+	}
+	if config.WebhookClient == nil {
+		config.WebhookClient = http.DefaultClient
+	}
+	if config.ScanRejectedStatus == 0 {
+		config.ScanRejectedStatus = DefaultScanRejectedStatus
+	}
+	if config.ResponseEncoder == nil {
+		config.ResponseEncoder = EncodeProgressJSON
+	}
+	if config.ErrorHandler == nil {
+		config.ErrorHandler = WriteErrorJSON
+	}
+	if config.TotalSizeHeader == "" {
+		config.TotalSizeHeader = DefaultTotalSizeHeader
+	}
+	if config.FileNameHeader == "" {
+		config.FileNameHeader = DefaultFileNameHeader
+	}
+	if config.MetadataHeaderPrefix == "" {
+		config.MetadataHeaderPrefix = DefaultMetadataHeaderPrefix
+	}
+	if config.ChunkFormField == "" {
+		config.ChunkFormField = DefaultChunkFormField
+	}
+	if config.MaxBatchParts <= 0 {
+		config.MaxBatchParts = DefaultMaxBatchParts
+	}
+	if config.MaxMissingChunks <= 0 {
+		config.MaxMissingChunks = DefaultMaxMissingChunks
+	}
+	if config.WebhookTimeout == 0 {
+		config.WebhookTimeout = DefaultWebhookTimeout
+	}
+	if config.WorkingDir != "" {
+		if same, err := sameFilesystem(config.WorkingDir, config.CompletedDir); err == nil && !same {
+			config.Logger.Info("working directory is on a different filesystem than the completed directory, so completed files will be copied",
+				"working_dir", config.WorkingDir, "completed_dir", config.CompletedDir)
 		}
 	}
+	if err := recoverUploads(config); err != nil {
+		panic(err)
+	}
+	closing, cancelClosing := context.WithCancel(context.Background())
 	return &FileChunksAssembler{
-		Config: config,
-		data: &tracker{
-			uploads:      sync.Map{},
-			chunkDir:     config.ChunksDir,
-			completedDir: config.CompletedDir,
-		},
+		Config:        config,
+		closing:       closing,
+		cancelClosing: cancelClosing,
+	}
+}
+
+// recoverUploads restores uploads started before a restart. This is only
+// needed when the tracker doesn't outlive the process.
+func recoverUploads(config *AssemblerConfig) error {
+	recoverable, ok := config.Store.(RecoverableStore)
+	if !ok {
+		return nil
+	}
+	memoryTracker, ok := config.Tracker.(*MemoryTracker)
+	if !ok {
+		return nil
+	}
+	uploads, err := recoverable.RecoverUploads()
+	if err != nil {
+		return err
 	}
+	for _, u := range uploads {
+		// Skip uploads of other assemblers sharing the store.
+		if !strings.HasPrefix(u.FileID, config.FileIDPrefix) {
+			continue
+		}
+		uploadID, err := strconv.ParseInt(strings.TrimPrefix(u.FileID, config.FileIDPrefix), 10, 64)
+		if err != nil || uploadID < 0 {
+			continue
+		}
+		memoryTracker.RestoreUpload(uploadID, u.Info, u.Chunks, config.Clock.Now())
+	}
+	return nil
+}
+
+// fileID returns the ID of an upload in the store.
+func (a *FileChunksAssembler) fileID(uploadID int64) string {
+	return a.Config.FileIDPrefix + strconv.FormatInt(uploadID, 10)
 }
 
-func (a *FileChunksAssembler) getActiveUpload(r *http.Request) (*activeUpload, error) {
-	headerVal := r.Header.Get(a.Config.UploadIdentifierHeader)
+func (a *FileChunksAssembler) getUploadID(r *http.Request) (int64, error) {
+	headerVal, err := a.uploadIDValue(r)
+	if err != nil {
+		return 0, err
+	}
 	uploadID, err := strconv.ParseInt(headerVal, 10, 64)
 	if err != nil {
-		return nil, err
+		if headerVal == "" {
+			return 0, &requestError{kind: ErrMissingUploadID, err: err}
+		}
+		return 0, &requestError{kind: ErrInvalidUploadID, err: err}
 	}
-	f, exists := a.data.uploads.Load(uploadID)
-	if !exists {
-		return nil, fmt.Errorf("upload ID not found")
+	return uploadID, nil
+}
+
+// lockUpload serializes requests for an upload and returns a function that
+// releases the lock. Locks are deleted when uploads are removed, so a
+// request that was waiting for a deleted lock tries again with the lock
+// that replaced it. Otherwise two requests could hold different locks for
+// the same upload at once.
+func (a *FileChunksAssembler) lockUpload(uploadID int64) func() {
+	for {
+		l, _ := a.uploadLocks.LoadOrStore(uploadID, &sync.Mutex{})
+		mu := l.(*sync.Mutex)
+		mu.Lock()
+		if current, ok := a.uploadLocks.Load(uploadID); ok && current == mu {
+			return mu.Unlock
+		}
+		mu.Unlock()
 	}
-	return f.(*activeUpload), nil
 }
 
 func (a *FileChunksAssembler) getChunkID(r *http.Request) (int64, error) {
-	headerVal := r.Header.Get(a.Config.ChunkIdentifierHeader)
+	if a.Config.OffsetHeader != "" {
+		return a.getOffset(r)
+	}
+	headerVal, err := a.chunkIDValue(r)
+	if err != nil {
+		return 0, err
+	}
 	chunkSequenceID, err := strconv.ParseInt(headerVal, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("must be an integer")
+		return 0, &requestError{kind: ErrInvalidChunkID, err: errors.New("must be an integer")}
 	}
-	if chunkSequenceID < 0 {
-		return 0, fmt.Errorf("cannot be negative")
+	if chunkSequenceID < int64(a.Config.ChunkSequenceBase) {
+		if a.Config.ChunkSequenceBase == 0 {
+			return 0, &requestError{kind: ErrInvalidChunkID, err: errors.New("cannot be negative")}
+		}
+		return 0, &requestError{kind: ErrInvalidChunkID, err: fmt.Errorf("must be at least %d", a.Config.ChunkSequenceBase)}
 	}
-	return chunkSequenceID, nil
+	return chunkSequenceID - int64(a.Config.ChunkSequenceBase), nil
 }
 
-func (a *FileChunksAssembler) UploadStartHandler(w http.ResponseWriter, r *http.Request) {
-	var info fileInfo
-	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
-		badRequest(w, err)
-		return
+// readLimited reads a chunk, stopping as soon as it exceeds the maximum
+// chunk size. Content-Length isn't trusted. If reading fails, the data read
+// so far is returned with the error.
+func (a *FileChunksAssembler) readLimited(body io.Reader) ([]byte, error) {
+	if a.Config.MaxChunkSize <= 0 {
+		return ioutil.ReadAll(body)
 	}
-	if info.TotalChunks == 0 {
-		badRequest(w, fmt.Errorf("invalid number of expected chunks"))
-		return
+	chunkData, err := ioutil.ReadAll(io.LimitReader(body, a.Config.MaxChunkSize+1))
+	if err != nil {
+		return chunkData, err
 	}
-	uploadID := a.data.createUpload(info)
-	_ = json.NewEncoder(w).Encode(map[string]int64{
-		"id": uploadID,
-	})
+	if int64(len(chunkData)) > a.Config.MaxChunkSize {
+		return nil, errChunkTooLarge
+	}
+	return chunkData, nil
 }
 
-// Middleware wraps an endpoint that expects a single file. It will collect
-// chunks in files until it has determined all chunks have been received.
-// For requests that don't have the correct headers, HTTP 400 is returned.
-// In downstream handlers, the request body becomes the complete file and
-// response cannot be written to (nil).
-func (a *FileChunksAssembler) ChunksMiddleware(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		currentUpload, err := a.getActiveUpload(r)
-		if err != nil {
-			badRequest(w, err)
-			return
+// checkChunkSize checks that a chunk is ExpectedChunkSize, or at most that
+// if it is the last chunk. Chunks can arrive in any order, so the last chunk
+// is the one with the highest sequence number.
+func (a *FileChunksAssembler) checkChunkSize(seq int64, totalChunks int64, size int) error {
+	if a.Config.ExpectedChunkSize <= 0 {
+		return nil
+	}
+	if seq == totalChunks-1 {
+		if int64(size) > a.Config.ExpectedChunkSize {
+			return fmt.Errorf("last chunk must be at most %d bytes", a.Config.ExpectedChunkSize)
 		}
-		// For each file being uploaded, only one chunk can be processed at a time.
-		currentUpload.lock.Lock()
-		defer currentUpload.lock.Unlock()
+		return nil
+	}
+	if int64(size) != a.Config.ExpectedChunkSize {
+		return fmt.Errorf("chunk must be %d bytes", a.Config.ExpectedChunkSize)
+	}
+	return nil
+}
 
-		chunkSequenceID, err := a.getChunkID(r)
-		if err != nil {
-			badRequest(w, err)
-			return
-		}
-		if chunkSequenceID >= currentUpload.info.TotalChunks {
-			badRequest(w, fmt.Errorf("invalid chunk ID"))
-			return
-		}
-		chunkData, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		if len(chunkData) == 0 {
-			badRequest(w, fmt.Errorf("chunk cannot be empty"))
-			return
-		}
-		if err := a.data.addChunk(currentUpload, chunkSequenceID, chunkData); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		response := progressResponse{
-			CurrentChunks:  currentUpload.countChunks(),
-			ExpectedChunks: currentUpload.totalChunks(),
-		}
-		if currentUpload.countChunks() == currentUpload.totalChunks() {
-			completedFilePath, err := a.data.combineChunks(currentUpload)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
+func (a *FileChunksAssembler) verifyChunkChecksum(r *http.Request, chunkData []byte) error {
+	if a.Config.ChunkChecksumHeader == "" {
+		return nil
+	}
+	checksum := r.Header.Get(a.Config.ChunkChecksumHeader)
+	if checksum == "" {
+		return nil
+	}
+	if err := verifyChecksum(a.Config.ChunkChecksumAlgorithm, chunkData, checksum); err != nil {
+		return fmt.Errorf("chunk %w", err)
+	}
+	return nil
+}
 
-			contentType := currentUpload.info.Metadata["type"]
-			if contentType == nil {
-				contentType = "application/octet-stream"
-			}
-			r.Header.Set("Content-Type", contentType.(string))
+func (a *FileChunksAssembler) getFileChecksum(r *http.Request) (string, error) {
+	if a.Config.FileChecksumHeader == "" {
+		return "", nil
+	}
+	checksum := r.Header.Get(a.Config.FileChecksumHeader)
+	if checksum == "" {
+		return "", nil
+	}
+	return parseFileChecksum(checksum)
+}
 
-			contentLength, err := getFileSize(completedFilePath)
+// createUpload starts tracking an upload if the limit on concurrent uploads
+// hasn't been reached. The limit is only enforced exactly within a process;
+// instances sharing a tracker may briefly exceed it together.
+func (a *FileChunksAssembler) createUpload(info UploadInfo) (int64, error) {
+	if a.Config.MaxConcurrentUploads <= 0 {
+		return a.newUploadID(info)
+	}
+	a.startLock.Lock()
+	defer a.startLock.Unlock()
+	count, err := a.Config.Tracker.CountUploads()
+	if err != nil {
+		return 0, err
+	}
+	if count >= int64(a.Config.MaxConcurrentUploads) {
+		return 0, errTooManyUploads
+	}
+	return a.newUploadID(info)
+}
+
+// internalError logs an error that the client can't do anything about and
+// responds with HTTP 500.
+func (a *FileChunksAssembler) internalError(w http.ResponseWriter, r *http.Request, code string, msg string, err error, keysAndValues ...interface{}) {
+	a.Config.Logger.Error(msg, append(keysAndValues, "code", code, "error", err)...)
+	a.writeError(w, r, http.StatusInternalServerError, &InternalError{Code: code, Message: msg, Err: err})
+}
+
+// rejectUpload records that an upload was rejected before it completed.
+func (a *FileChunksAssembler) rejectUpload(uploadID int64, reason string) {
+	a.Config.Logger.Info("upload rejected", "upload_id", uploadID, "reason", reason)
+	a.Config.Metrics.UploadRejected(a.fileID(uploadID), reason)
+}
+
+// startUpload checks that an upload is allowed and starts tracking it.
+func (a *FileChunksAssembler) startUpload(info UploadInfo) (int64, error) {
+	if !a.typeAllowed(info) {
+		return 0, errMimeTypeNotAllowed
+	}
+	info.CreatedAt = a.Config.Clock.Now()
+	uploadID, err := a.createUpload(info)
+	if err != nil {
+		return 0, err
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		if err := recoverable.SaveUploadInfo(a.fileID(uploadID), info); err != nil {
+			return 0, fmt.Errorf("saving upload info: %w", err)
+		}
+	}
+	return uploadID, nil
+}
+
+func (a *FileChunksAssembler) startUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errMimeTypeNotAllowed):
+		a.writeError(w, r, http.StatusUnsupportedMediaType, err)
+	case errors.Is(err, errTooManyUploads):
+		a.writeError(w, r, http.StatusTooManyRequests, err)
+	default:
+		a.internalError(w, r, CodeUploadStartFailed, "failed to start upload", err)
+	}
+}
+
+func (a *FileChunksAssembler) UploadStartHandler(w http.ResponseWriter, r *http.Request) {
+	if a.isClosed() {
+		a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+		return
+	}
+	var info UploadInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	// Without total_chunks, the number of chunks must be sent with the
+	// chunks. With offsets, it's only known once the upload completes.
+	if a.Config.OffsetHeader != "" {
+		if info.TotalChunks != 0 {
+			a.badRequest(w, r, ErrInvalidChunkTotal)
+			return
+		}
+	} else if info.TotalChunks < 0 || (info.TotalChunks == 0 && a.Config.FinalChunkHeader == "" && a.Config.ControlHeader == "") {
+		a.badRequest(w, r, ErrInvalidChunkTotal)
+		return
+	}
+	if a.Config.MaxChunkTotal > 0 && info.TotalChunks > a.Config.MaxChunkTotal {
+		a.badRequest(w, r, errTooManyChunks)
+		return
+	}
+	size, err := a.totalSize(r)
+	if err != nil || info.Size < 0 {
+		a.badRequest(w, r, errInvalidTotalSize)
+		return
+	}
+	if size > 0 {
+		info.Size = size
+	}
+	// The limit depends on the type, which can be sent as a header.
+	mergeMetadata(&info, a.headerMetadata(r))
+	if maxSize := a.maxFileSize(info); maxSize > 0 && info.Size > maxSize {
+		a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+	if err := a.checkQuota(r, info.Size); err != nil {
+		a.quotaError(w, r, err)
+		return
+	}
+	if info.Checksum != "" {
+		checksum, err := parseFileChecksum(info.Checksum)
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		info.Checksum = checksum
+	}
+	if err := a.setKeyFingerprint(r, &info); err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	uploadID, err := a.startUpload(info)
+	if err != nil {
+		a.startUploadError(w, r, err)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(startResponse{
+		ID:             uploadID,
+		TotalChunks:    info.TotalChunks,
+		FirstChunk:     a.Config.ChunkSequenceBase,
+		MaxChunkSize:   a.Config.MaxChunkSize,
+		UploadIDHeader: a.Config.UploadIdentifierHeader,
+		ChunkIDHeader:  a.Config.ChunkIdentifierHeader,
+	})
+}
+
+// StatusHandler responds with the chunks received so far for the upload ID
+// in the request headers, so that an interrupted upload can be resumed by
+// sending only the missing chunks. HTTP 404 is returned for unknown uploads,
+// including uploads that have already completed.
+func (a *FileChunksAssembler) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if a.isClosed() {
+		a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+		return
+	}
+	uploadID, err := a.getUploadID(r)
+	if err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	info, err := a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			a.writeError(w, r, http.StatusNotFound, err)
+		} else {
+			a.internalError(w, r, CodeUploadLookupFailed, "failed to get upload", err, "upload_id", uploadID)
+		}
+		return
+	}
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			a.writeError(w, r, http.StatusNotFound, err)
+		} else {
+			a.internalError(w, r, CodeChunkLookupFailed, "failed to get received chunks", err, "upload_id", uploadID)
+		}
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(statusResponse{
+		ReceivedChunks: a.toClientSeqs(received),
+		ExpectedChunks: info.TotalChunks,
+		Complete:       info.TotalChunks > 0 && int64(len(received)) == info.TotalChunks,
+	})
+}
+
+// AbortHandler cancels the upload with the ID in the request headers. It
+// responds with HTTP 200 even if the upload doesn't exist.
+func (a *FileChunksAssembler) AbortHandler(w http.ResponseWriter, r *http.Request) {
+	if a.isClosed() {
+		a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+		return
+	}
+	uploadID, err := a.getUploadID(r)
+	if err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	if err := a.Abort(uploadID); err != nil {
+		a.internalError(w, r, CodeAbortFailed, "failed to abort upload", err, "upload_id", uploadID)
+		return
+	}
+}
+
+// Middleware wraps an endpoint that expects a single file. It will collect
+// chunks in files until it has determined all chunks have been received.
+// For requests that don't have the correct headers, HTTP 400 is returned.
+// In downstream handlers, the request body becomes the complete file and
+// response cannot be written to (nil). HEAD requests with an upload ID get
+// the upload's progress in headers instead.
+func (a *FileChunksAssembler) ChunksMiddleware(h http.Handler) http.Handler {
+	return a.chunksMiddleware(h, false)
+}
+
+// ChunksMiddlewareWithResponse is like ChunksMiddleware, except downstream
+// handlers can write a response. Its status is used for the final progress
+// update, and its body is added to it as "result", e.g. to return the URL
+// of the processed file. Bodies that aren't JSON are added as a string.
+func (a *FileChunksAssembler) ChunksMiddlewareWithResponse(h http.Handler) http.Handler {
+	return a.chunksMiddleware(h, true)
+}
+
+func (a *FileChunksAssembler) chunksMiddleware(h http.Handler, withResponse bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isClosed() {
+			a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+			return
+		}
+		if r.Method == http.MethodHead {
+			a.chunksHead(w, r)
+			return
+		}
+		if !a.checkMethod(w, r, http.MethodHead) {
+			return
+		}
+		r, span := a.startChunkSpan(r)
+		defer span.End()
+		// The IDs may be form values, so a multipart body has to be read first.
+		var chunkData []byte
+		multipartChunk := isMultipartChunk(r)
+		if multipartChunk {
+			stop := a.limitBodyRead(w, r)
+			var err error
+			chunkData, err = a.readMultipartChunk(r)
+			stop()
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				if errors.Is(err, errChunkTooLarge) {
+					a.writeError(w, r, http.StatusRequestEntityTooLarge, err)
+				} else if errors.Is(err, errChunkReadTimeout) {
+					a.writeError(w, r, http.StatusRequestTimeout, err)
+				} else {
+					a.badRequest(w, r, err)
+				}
 				return
 			}
-			r.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
-
-			// Remove chunk-specific headers from request.
-			r.Header.Del(a.Config.UploadIdentifierHeader)
-			r.Header.Del(a.Config.ChunkIdentifierHeader)
+		}
+		uploadID, err := a.getUploadID(r)
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		span.SetAttributes(Attribute{Key: AttrFileID, Value: a.fileID(uploadID)})
+		// For each file being uploaded, only one chunk can be processed at a time.
+		unlock := a.lockUpload(uploadID)
+		defer unlock()
+		if completed, ok := a.getCompletion(uploadID); ok {
+			if _, ok := a.checkUploadKey(w, r, UploadInfo{KeyFingerprint: completed.keyFingerprint}); !ok {
+				return
+			}
+			a.writeProgress(w, completed.status, completed.response)
+			return
+		}
+		info, err := a.Config.Tracker.GetUpload(uploadID)
+		if err != nil {
+			if errors.Is(err, ErrUploadNotFound) {
+				a.uploadLocks.Delete(uploadID)
+				a.badRequest(w, r, err)
+			} else {
+				a.internalError(w, r, CodeUploadLookupFailed, "failed to get upload", err, "upload_id", uploadID)
+			}
+			return
+		}
+		if a.uploadExpired(info) {
+			a.expireUpload(w, r, uploadID, info)
+			return
+		}
+		key, ok := a.checkUploadKey(w, r, info)
+		if !ok {
+			return
+		}
 
-			// Add the file stream as request body.
-			f, err := os.Open(completedFilePath)
+		chunkSequenceID, err := a.getChunkID(r)
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		span.SetAttributes(Attribute{Key: AttrChunk, Value: chunkSequenceID})
+		offsets := a.Config.OffsetHeader != ""
+		if !offsets && a.Config.MaxChunkTotal > 0 && chunkSequenceID >= a.Config.MaxChunkTotal {
+			a.badRequest(w, r, errTooManyChunks)
+			return
+		}
+		if err := a.checkDeclared(r, info); err != nil {
+			a.writeError(w, r, http.StatusConflict, err)
+			return
+		}
+		final, err := a.isFinalChunk(r)
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		if final && !offsets {
+			if err := a.setFinalChunk(uploadID, chunkSequenceID, &info); err != nil {
+				if errors.Is(err, ErrInvalidFinalChunk) {
+					a.badRequest(w, r, err)
+				} else {
+					a.internalError(w, r, CodeUploadUpdateFailed, "failed to set final chunk", err, "upload_id", uploadID)
+				}
+				return
+			}
+		}
+		if control, _ := a.controlHeader(r); control.Total != "" && !offsets {
+			if err := a.setControlTotal(uploadID, control.Total, &info); err != nil {
+				var quantityErr *chunkQuantityError
+				if errors.As(err, &quantityErr) {
+					a.writeError(w, r, http.StatusConflict, quantityErr)
+				} else if errors.Is(err, ErrInvalidChunkTotal) {
+					a.badRequest(w, r, err)
+				} else {
+					a.internalError(w, r, CodeUploadUpdateFailed, "failed to set total chunks", err, "upload_id", uploadID)
+				}
+				return
+			}
+		}
+		// The number of chunks isn't known until the final chunk is received
+		// if the upload was started without it.
+		if !offsets && info.TotalChunks > 0 && chunkSequenceID >= info.TotalChunks {
+			a.badRequest(w, r, ErrSequenceOutOfRange)
+			return
+		}
+		fileChecksum, err := a.getFileChecksum(r)
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		if !multipartChunk {
+			stop := a.limitBodyRead(w, r)
+			defer stop()
+			body, err := a.chunkBody(r)
+			if err != nil {
+				if errors.Is(err, errUnsupportedEncoding) {
+					a.writeError(w, r, http.StatusUnsupportedMediaType, err)
+				} else {
+					a.badRequest(w, r, err)
+				}
+				return
+			}
+			chunkData, err = a.readLimited(body)
+			// The deadline would otherwise also apply to the server's
+			// reads of the connection while the chunk is processed.
+			stop()
+			if err != nil {
+				if errors.Is(err, errChunkTooLarge) {
+					a.writeError(w, r, http.StatusRequestEntityTooLarge, err)
+				} else if errors.Is(err, errChunkReadTimeout) {
+					a.writeError(w, r, http.StatusRequestTimeout, err)
+				} else if errors.Is(err, errInvalidEncoding) {
+					a.badRequest(w, r, err)
+				} else {
+					a.internalError(w, r, CodeChunkReadFailed, "failed to read chunk", err, "upload_id", uploadID, "chunk", chunkSequenceID)
+				}
+				return
+			}
+		}
+		span.SetAttributes(Attribute{Key: AttrBytes, Value: int64(len(chunkData))})
+		if len(chunkData) == 0 && !(a.Config.AllowEmptyFile && info.TotalChunks == 1) {
+			a.badRequest(w, r, ErrEmptyChunk)
+			return
+		}
+		if !offsets {
+			if err := a.checkChunkSize(chunkSequenceID, info.TotalChunks, len(chunkData)); err != nil {
+				a.badRequest(w, r, err)
+				return
+			}
+		}
+		if err := a.verifyChunkChecksum(r, chunkData); err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		if fileChecksum != "" && fileChecksum != info.Checksum {
+			info.Checksum = fileChecksum
+			if err := a.setFileChecksum(uploadID, info); err != nil {
+				a.internalError(w, r, CodeUploadUpdateFailed, "failed to set file checksum", err, "upload_id", uploadID)
+				return
+			}
+		}
+		if size, err := a.totalSize(r); err != nil {
+			a.badRequest(w, r, err)
+			return
+		} else if size > 0 && size != info.Size {
+			info.Size = size
+			if err := a.setSize(uploadID, info); err != nil {
+				a.internalError(w, r, CodeUploadUpdateFailed, "failed to set upload size", err, "upload_id", uploadID)
+				return
+			}
+		}
+		if mergeMetadata(&info, a.headerMetadata(r)) {
+			// A type can be declared by a chunk of an upload started
+			// without one.
+			if !a.typeAllowed(info) {
+				a.writeError(w, r, http.StatusUnsupportedMediaType, errMimeTypeNotAllowed)
+				return
+			}
+			if err := a.setMetadata(uploadID, info); err != nil {
+				a.internalError(w, r, CodeUploadUpdateFailed, "failed to set metadata", err, "upload_id", uploadID)
+				return
+			}
+		}
+		if offsets {
+			ranges, err := a.checkRange(uploadID, info, chunkSequenceID, int64(len(chunkData)))
+			if err != nil {
+				a.rangeError(w, r, uploadID, err)
+				return
+			}
+			if a.Config.MaxChunkTotal > 0 && ranges > a.Config.MaxChunkTotal {
+				a.badRequest(w, r, errTooManyChunks)
+				return
+			}
+		}
+		maxFileSize := a.maxFileSize(info)
+		if maxFileSize > 0 && int64(len(chunkData)) > maxFileSize {
+			a.cleanupUpload(uploadID)
+			a.rejectUpload(uploadID, errFileTooLarge.Error())
+			a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		chunk := ChunkInfo{
+			Size:       int64(len(chunkData)),
+			ReceivedAt: a.Config.Clock.Now(),
+		}
+		// PUT is idempotent, so sending the same chunk again with it doesn't
+		// store the chunk again, and sending different contents is a
+		// conflict even without RejectChunkOverwrite.
+		put := r.Method == http.MethodPut
+		duplicate := false
+		if a.Config.RejectChunkOverwrite || put {
+			chunkChecksum := sha256.Sum256(chunkData)
+			chunk.Checksum = hex.EncodeToString(chunkChecksum[:])
+			previous, exists, err := a.Config.Tracker.GetChunk(uploadID, chunkSequenceID)
+			if err != nil {
+				a.internalError(w, r, CodeChunkLookupFailed, "failed to get chunk", err, "upload_id", uploadID, "chunk", chunkSequenceID)
+				return
+			}
+			// Chunks recovered after a restart don't have a checksum.
+			if exists && previous.Checksum != "" && previous.Checksum != chunk.Checksum {
+				a.writeError(w, r, http.StatusConflict, errChunkConflict)
+				return
+			}
+			duplicate = put && exists && previous.Checksum != ""
+		}
+		var progress Progress
+		if duplicate {
+			progress, err = a.Config.Tracker.GetProgress(uploadID)
+			if err != nil {
+				a.internalError(w, r, CodeUploadLookupFailed, "failed to get progress", err, "upload_id", uploadID)
+				return
+			}
+		} else {
+			if err := a.checkFreeSpace(len(chunkData)); err != nil {
+				a.freeSpaceError(w, r, uploadID, err)
+				return
+			}
+			progress, err = a.storeChunk(r.Context(), uploadID, chunkSequenceID, chunkData, chunk, key)
+			if err != nil {
+				a.internalError(w, r, CodeChunkWriteFailed, "failed to store chunk", err, "upload_id", uploadID, "chunk", chunkSequenceID)
+				return
+			}
+		}
+		if offsets {
+			if err := a.completeRanges(uploadID, &info, progress); err != nil {
+				a.internalError(w, r, CodeUploadUpdateFailed, "failed to set total chunks", err, "upload_id", uploadID)
+				return
+			}
+		}
+		a.Config.Hooks.progress(a.fileID(uploadID), progress.Chunks, info.TotalChunks)
+		// Chunks can arrive in any order, so the limit is checked against all
+		// chunks received so far rather than the completed file.
+		if maxFileSize > 0 && progress.Bytes > maxFileSize {
+			a.cleanupUpload(uploadID)
+			a.rejectUpload(uploadID, errFileTooLarge.Error())
+			a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		if !a.cancelOverQuota(w, r, uploadID, progress.Bytes) {
+			return
+		}
+		response := ProgressInfo{
+			CurrentChunks:  progress.Chunks,
+			ExpectedChunks: info.TotalChunks,
+			ReceivedBytes:  progress.Bytes,
+			ExpectedBytes:  info.Size,
+		}
+		if a.Config.ReportMissingChunks && !offsets && progress.Chunks != info.TotalChunks {
+			response.MissingChunks, response.MissingTruncated, err = a.MissingChunks(uploadID)
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				a.internalError(w, r, CodeChunkLookupFailed, "failed to get missing chunks", err, "upload_id", uploadID)
 				return
 			}
-			defer func() { _ = f.Close() }()
-			r.Body = f
+		}
+		completed, err := a.claimCompletion(uploadID)
+		if err != nil {
+			a.internalError(w, r, CodeCompletionClaimFailed, "failed to claim completion", err, "upload_id", uploadID)
+			return
+		}
+		status := http.StatusOK
+		if completed {
+			result, err := a.completeUpload(r, h, uploadID, withResponse, key)
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					// The client has gone away, so there's no one to respond to.
+					a.Config.Logger.Info("upload cancelled while combining chunks", "upload_id", uploadID)
+					return
+				}
+				a.internalError(w, r, CodeCombineFailed, "failed to complete upload", err, "upload_id", uploadID)
+				return
+			}
+			response.Complete = true
+			response.FileHash = result.fileHash
+			response.Location = result.location
+			if result.downstream != nil {
+				response.Result = result.downstream.result()
+				if result.downstream.status != 0 {
+					status = result.downstream.status
+				}
+			}
+			if result.rejectedCode != 0 {
+				response.RejectedError = &result.rejectedError
+				status = result.rejectedCode
+			}
+			a.rememberCompletion(uploadID, info, status, response)
+		}
+		a.writeProgress(w, status, response)
+	})
+}
+
+func (a *FileChunksAssembler) freeSpaceError(w http.ResponseWriter, r *http.Request, uploadID int64, err error) {
+	if errors.Is(err, errInsufficientStorage) {
+		a.Config.Logger.Error("not enough free space for chunk", "upload_id", uploadID)
+		a.writeError(w, r, http.StatusInsufficientStorage, err)
+		return
+	}
+	a.internalError(w, r, CodeFreeSpaceCheckFailed, "failed to check free space", err, "upload_id", uploadID)
+}
+
+// storeChunk saves a chunk and records it as received. The chunk is
+// encrypted with key if it isn't nil.
+func (a *FileChunksAssembler) storeChunk(ctx context.Context, uploadID int64, seq int64, chunkData []byte, chunk ChunkInfo, key []byte) (progress Progress, err error) {
+	fileID := a.fileID(uploadID)
+	_, span := a.Config.Tracer.Start(ctx, SpanStoreChunk,
+		Attribute{Key: AttrFileID, Value: fileID},
+		Attribute{Key: AttrChunk, Value: seq},
+		Attribute{Key: AttrBytes, Value: int64(len(chunkData))},
+	)
+	defer func() { endSpan(span, err) }()
+	if key != nil {
+		err = a.Config.Store.(KeyedStore).WriteChunkWithKey(fileID, seq, chunkData, key)
+	} else {
+		err = a.Config.Store.WriteChunk(fileID, seq, chunkData)
+	}
+	if err != nil {
+		return Progress{}, fmt.Errorf("writing chunk: %w", err)
+	}
+	progress, err = a.Config.Tracker.AddChunk(uploadID, seq, chunk)
+	if err != nil {
+		return Progress{}, fmt.Errorf("adding chunk: %w", err)
+	}
+	a.countChunk(uploadID, progress)
+	a.Config.Logger.Debug("chunk received", "upload_id", uploadID, "chunk", seq, "bytes", len(chunkData))
+	a.Config.Metrics.ChunkReceived(fileID, len(chunkData))
+	a.Config.Hooks.chunkStored(fileID, seq)
+	return progress, nil
+}
+
+// claimCompletion returns true if the caller should combine the chunks of
+// an upload, which must then be done with completeUpload.
+//
+// Each upload is combined at most once: the tracker's claim is exclusive
+// across instances, and the upload's lock keeps requests within this
+// process from completing it concurrently. In case either doesn't hold,
+// e.g. with a tracker whose claim isn't atomic, the upload also can't be
+// claimed while this process is combining it.
+func (a *FileChunksAssembler) claimCompletion(uploadID int64) (bool, error) {
+	completed, err := a.Config.Tracker.ClaimCompletion(uploadID)
+	if err != nil || !completed {
+		return false, err
+	}
+	if _, combining := a.combining.LoadOrStore(uploadID, struct{}{}); combining {
+		a.Config.Logger.Error("upload claimed while being combined", "upload_id", uploadID)
+		return false, nil
+	}
+	return true, nil
+}
+
+type uploadResult struct {
+	fileHash string
+
+	// Set if ExposeCompletedLocation is enabled.
+	location string
+
+	// What the downstream handler wrote, if it was given a response.
+	downstream *bufferedResponse
+
+	// Set if the completed file was rejected.
+	rejectedCode  int
+	rejectedError string
+}
+
+// completeUpload combines the chunks of an upload that has been claimed and
+// passes the completed file to h as the body of r. If withResponse is false,
+// h is given a nil ResponseWriter.
+func (a *FileChunksAssembler) completeUpload(r *http.Request, h http.Handler, uploadID int64, withResponse bool, key []byte) (uploadResult, error) {
+	defer a.combining.Delete(uploadID)
+	if a.Config.ValidateOnly {
+		return a.completeValidation(uploadID)
+	}
+	fileID := a.fileID(uploadID)
+	// Another instance may have changed the upload since it was read.
+	info, err := a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		return uploadResult{}, err
+	}
+	combineCtx, span := a.Config.Tracer.Start(r.Context(), SpanCombine,
+		Attribute{Key: AttrFileID, Value: fileID},
+		Attribute{Key: AttrTotalChunks, Value: info.TotalChunks},
+	)
+	combined, err := a.combineChunks(combineCtx, uploadID, info, key)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, errFileChecksumMismatch) {
+			a.rejectUpload(uploadID, err.Error())
+			return uploadResult{
+				rejectedCode:  http.StatusBadRequest,
+				rejectedError: err.Error(),
+			}, nil
+		}
+		if errors.Is(err, errCompletedFileExists) {
+			a.rejectUpload(uploadID, err.Error())
+			return uploadResult{
+				rejectedCode:  http.StatusConflict,
+				rejectedError: err.Error(),
+			}, nil
+		}
+		if errors.Is(err, errReservedFileName) {
+			a.rejectUpload(uploadID, err.Error())
+			return uploadResult{
+				rejectedCode:  http.StatusBadRequest,
+				rejectedError: err.Error(),
+			}, nil
+		}
+		return uploadResult{}, err
+	}
+	if rejected, passed, err := a.verifyContentType(uploadID, combined.name, info); err != nil || !passed {
+		return rejected, err
+	}
+	if rejected, passed, err := a.scanCompleted(r.Context(), uploadID, combined.name); err != nil || !passed {
+		return rejected, err
+	}
+	if rejected, passed, err := a.postProcess(r.Context(), uploadID, &combined); err != nil || !passed {
+		return rejected, err
+	}
+	result := uploadResult{fileHash: combined.hash}
+	if a.Config.ExposeCompletedLocation {
+		result.location, err = a.completedLocation(combined)
+		if err != nil {
+			return uploadResult{}, fmt.Errorf("locating completed file: %w", err)
+		}
+	}
+	a.Config.Hooks.uploadComplete(fileID, combined.location)
+	completedFile, contentLength, err := a.openCombined(combined)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("opening completed file: %w", err)
+	}
+	defer func() { _ = completedFile.Close() }()
+
+	r.Header.Set("Content-Type", contentType(info))
+	r.Header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+	r.ContentLength = contentLength
+
+	// Remove chunk-specific headers from request.
+	r.Header.Del(a.Config.UploadIdentifierHeader)
+	r.Header.Del(a.Config.ChunkIdentifierHeader)
+	if a.Config.ChunkChecksumHeader != "" {
+		r.Header.Del(a.Config.ChunkChecksumHeader)
+	}
+	if a.Config.FileChecksumHeader != "" {
+		r.Header.Del(a.Config.FileChecksumHeader)
+	}
+	if a.Config.FinalChunkHeader != "" {
+		r.Header.Del(a.Config.FinalChunkHeader)
+	}
+	if a.Config.ControlHeader != "" {
+		r.Header.Del(a.Config.ControlHeader)
+	}
+	if a.Config.UploadKeyHeader != "" {
+		r.Header.Del(a.Config.UploadKeyHeader)
+	}
+
+	// Add the file stream as request body.
+	r.Body = completedFile
+
+	ctx := context.WithValue(r.Context(), metadataKey, info.Metadata)
+	ctx = context.WithValue(ctx, fileIDKey, fileID)
+	ctx = context.WithValue(ctx, rejectionKey, &rejectionSlot{})
+	if a.Config.DownstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.Config.DownstreamTimeout)
+		defer cancel()
+	}
+	req := *r.WithContext(ctx)
+	// Cannot send a response downstream as it's used for the final progress
+	// update, unless it's buffered.
+	var downstream http.ResponseWriter
+	if withResponse {
+		result.downstream = newBufferedResponse()
+		downstream = result.downstream
+	}
+	if !a.serveDownstream(h, downstream, &req) {
+		result.downstream = nil
+		result.rejectedCode = http.StatusGatewayTimeout
+		result.rejectedError = errDownstreamTimeout.Error()
+		result.location = ""
+		a.rejectUpload(uploadID, result.rejectedError)
+		return result, nil
+	}
+
+	if status, reason, rejected := GetRejection(&req); rejected {
+		result.rejectedCode = status
+		result.rejectedError = reason
+		result.location = ""
+		a.rejectUpload(uploadID, result.rejectedError)
+		return result, nil
+	}
+	if a.Config.AutoDeleteAfterDownstream && (result.downstream == nil || result.downstream.status < 400) {
+		_ = completedFile.Close()
+		a.deleteAfterDownstream(uploadID, combined)
+	}
+	atomic.AddInt64(&a.counters.completedUploads, 1)
+	a.addQuotaUsage(r, uploadID, contentLength)
+	a.Config.Logger.Info("upload completed", "upload_id", uploadID, "bytes", contentLength)
+	a.Config.Metrics.UploadCompleted(fileID, contentLength, a.Config.Clock.Now().Sub(info.CreatedAt))
+	if a.Config.CompletionWebhookURL != "" {
+		payload := completionWebhook{
+			FileID:      fileID,
+			Size:        contentLength,
+			ContentType: contentType(info),
+			Hash:        combined.hash,
+		}
+		a.goBackground(func() {
+			a.notifyCompletion(a.closing, payload)
+		})
+	}
+	return result, nil
+}
+
+// serveDownstream calls h and returns false if it didn't return within
+// DownstreamTimeout. The handler is left running in that case, with the
+// context of req cancelled, and req must not be used again.
+func (a *FileChunksAssembler) serveDownstream(h http.Handler, w http.ResponseWriter, req *http.Request) bool {
+	if a.Config.DownstreamTimeout <= 0 {
+		h.ServeHTTP(w, req)
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.ServeHTTP(w, req)
+	}()
+	// The request's context is also cancelled if the client goes away, so
+	// a separate timer tells the two apart.
+	timer := time.NewTimer(a.Config.DownstreamTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (a *FileChunksAssembler) setFileChecksum(uploadID int64, info UploadInfo) error {
+	if err := a.Config.Tracker.SetChecksum(uploadID, info.Checksum); err != nil {
+		return err
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		return recoverable.SaveUploadInfo(a.fileID(uploadID), info)
+	}
+	return nil
+}
+
+// combinedFile is a completed file written by combineChunks.
+type combinedFile struct {
+	// Name of the file in the store.
+	name     string
+	location string
+	hash     string
+
+	// Set if PostProcessor wrote a new file, which is at location and
+	// replaces the file in the store.
+	processed bool
+}
+
+// combineChunks finalizes the upload in the store and then removes its
+// chunks. The hash of the completed file is computed while the file is
+// written. If the upload has a checksum and the completed file doesn't match
+// it, the completed file is deleted. If the chunks couldn't be combined,
+// e.g. because ctx was cancelled, the upload can be completed again by
+// resending a chunk.
+func (a *FileChunksAssembler) combineChunks(ctx context.Context, uploadID int64, info UploadInfo, key []byte) (combinedFile, error) {
+	fileID := a.fileID(uploadID)
+	fileHash, err := a.Config.CompletedFileHashAlgorithm.new()
+	if err != nil {
+		return combinedFile{}, err
+	}
+	var digest io.Writer = fileHash
+	var checksum hash.Hash
+	if info.Checksum != "" {
+		checksum = sha256.New()
+		digest = io.MultiWriter(fileHash, checksum)
+	}
+	name, release, err := a.reserveCompletedName(ctx, fileID, info)
+	if errors.Is(err, errCompletedFileExists) {
+		a.finishUpload(uploadID)
+		return combinedFile{}, err
+	}
+	if err != nil {
+		if releaseErr := a.Config.Tracker.ReleaseCompletion(uploadID); releaseErr != nil {
+			a.Config.Logger.Error("failed to release completion", "upload_id", uploadID, "error", releaseErr)
+		}
+		return combinedFile{}, err
+	}
+	defer release()
+	completedFilePath, err := a.finalize(ctx, uploadID, name, info, key, digest)
+	if errors.Is(err, errReservedFileName) {
+		// The name won't change, so the upload can't be completed.
+		a.finishUpload(uploadID)
+		return combinedFile{}, err
+	}
+	if err != nil {
+		if releaseErr := a.Config.Tracker.ReleaseCompletion(uploadID); releaseErr != nil {
+			a.Config.Logger.Error("failed to release completion", "upload_id", uploadID, "error", releaseErr)
+		}
+		return combinedFile{}, fmt.Errorf("combining chunks: %w", err)
+	}
+	if checksum != nil && hex.EncodeToString(checksum.Sum(nil)) != info.Checksum {
+		if err := a.Config.Store.DeleteCompleted(name); err != nil {
+			return combinedFile{}, err
+		}
+		if a.Config.KeepChunksOnChecksumMismatch {
+			if err := a.Config.Tracker.ReleaseCompletion(uploadID); err != nil {
+				return combinedFile{}, err
+			}
+		} else {
+			a.finishUpload(uploadID)
+		}
+		return combinedFile{}, errFileChecksumMismatch
+	}
+	a.finishUpload(uploadID)
+	return combinedFile{
+		name:     name,
+		location: completedFilePath,
+		hash:     hex.EncodeToString(fileHash.Sum(nil)),
+	}, nil
+}
+
+// finalize combines the chunks of an upload in order, decrypting them with
+// key if it isn't nil. With offsets, the chunks are numbered by their
+// offsets rather than from 0.
+func (a *FileChunksAssembler) finalize(ctx context.Context, uploadID int64, name string, info UploadInfo, key []byte, digest io.Writer) (string, error) {
+	fileID := a.fileID(uploadID)
+	if a.Config.OffsetHeader == "" && key == nil {
+		return a.Config.Store.Finalize(ctx, fileID, name, info.TotalChunks, digest)
+	}
+	seqs := sequence(info.TotalChunks)
+	if a.Config.OffsetHeader != "" {
+		received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+		if err != nil {
+			return "", err
+		}
+		seqs = received
+	}
+	if key != nil {
+		return a.Config.Store.(KeyedStore).FinalizeWithKey(ctx, fileID, name, seqs, key, digest)
+	}
+	return a.Config.Store.(SequenceFinalizer).FinalizeSequences(ctx, fileID, name, seqs, digest)
+}
+
+// removeUpload deletes the chunks of an upload and stops tracking it. The
+// first error is returned after attempting every step.
+func (a *FileChunksAssembler) removeUpload(uploadID int64) error {
+	fileID := a.fileID(uploadID)
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			a.uncountUpload(uploadID)
+			return nil
+		}
+		return err
+	}
+	var firstErr error
+	for _, seq := range received {
+		if err := a.Config.Store.DeleteChunk(fileID, seq); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		if err := recoverable.DeleteUploadInfo(fileID); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if err := a.Config.Tracker.RemoveUpload(uploadID); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	a.uncountUpload(uploadID)
+	a.uploadLocks.Delete(uploadID)
+	return firstErr
+}
 
-			ctx := context.WithValue(
-				r.Context(),
-				contextKey("metadata"),
-				currentUpload.info.Metadata,
-			)
-			// Cannot send a response downstream as it's used for the final progress update.
-			req := *r.WithContext(ctx)
-			h.ServeHTTP(nil, &req)
+// finishUpload removes an upload after its chunks have been combined. The
+// caller must hold the upload's lock. Unless SynchronousCleanup is set, the
+// upload is removed in the background once the lock is released.
+func (a *FileChunksAssembler) finishUpload(uploadID int64) {
+	if a.Config.SynchronousCleanup {
+		a.cleanupUpload(uploadID)
+		return
+	}
+	a.goBackground(func() {
+		unlock := a.lockUpload(uploadID)
+		defer unlock()
+		a.cleanupUpload(uploadID)
+	})
+}
+
+// cleanupUpload removes an upload where errors can't be returned to the
+// client, so they are only logged.
+func (a *FileChunksAssembler) cleanupUpload(uploadID int64) {
+	if err := a.removeUpload(uploadID); err != nil {
+		a.Config.Logger.Error("failed to remove upload", "upload_id", uploadID, "error", err)
+	}
+}
+
+// Sweep removes uploads that have been inactive for longer than
+// IncompleteUploadTTL and returns how many were removed. Completed uploads
+// older than CompletedUploadTTL are also forgotten, and completed files
+// older than CompletedFileTTL are deleted.
+func (a *FileChunksAssembler) Sweep() (int, error) {
+	now := a.Config.Clock.Now()
+	a.forgetCompletions(now)
+	if err := a.sweepCompleted(now); err != nil {
+		return 0, err
+	}
+	if a.Config.IncompleteUploadTTL <= 0 {
+		return 0, nil
+	}
+	stale, err := a.Config.Tracker.StaleUploads(now.Add(-a.Config.IncompleteUploadTTL))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, uploadID := range stale {
+		if err := a.Abort(uploadID); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
 
-			rejectedFileCode := req.Context().Value(contextKey("error-code"))
-			if rejectedFileCode != nil {
-				rejectedFileErr := req.Context().Value(contextKey("error-message")).(string)
-				response.RejectedError = &rejectedFileErr
-				w.WriteHeader(rejectedFileCode.(int))
+// StartJanitor calls Sweep every interval in a new goroutine, so uploads
+// are removed once they've been inactive for IncompleteUploadTTL. The
+// returned function stops the janitor and can be called more than once.
+// Janitors are also stopped by Close.
+func (a *FileChunksAssembler) StartJanitor(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	a.goBackground(func() {
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := a.Sweep(); err != nil {
+					a.Config.Logger.Error("failed to sweep expired uploads", "error", err)
+				}
+			case <-done:
+				return
 			}
 		}
-		w.Header().Add("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(response)
 	})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+	a.closeLock.Lock()
+	a.janitors = append(a.janitors, stop)
+	a.closeLock.Unlock()
+	return stop
+}
+
+// goBackground runs f in a goroutine that Close waits for.
+func (a *FileChunksAssembler) goBackground(f func()) {
+	a.background.Add(1)
+	go func() {
+		defer a.background.Done()
+		f()
+	}()
+}
+
+func (a *FileChunksAssembler) isClosed() bool {
+	return atomic.LoadInt32(&a.closed) == 1
+}
+
+// Close stops the janitors and pending webhooks, waits for chunks of
+// finished uploads to be deleted, and then closes the store and tracker if
+// they implement io.Closer. Handlers respond with HTTP 503 once Close has
+// been called, so it should be called after the HTTP server has shut down.
+// The first error is returned, and calling Close again does nothing.
+func (a *FileChunksAssembler) Close() error {
+	if !atomic.CompareAndSwapInt32(&a.closed, 0, 1) {
+		return nil
+	}
+	a.closeLock.Lock()
+	for _, stop := range a.janitors {
+		stop()
+	}
+	a.janitors = nil
+	a.closeLock.Unlock()
+	a.cancelClosing()
+	a.background.Wait()
+
+	var firstErr error
+	if closer, ok := a.Config.Store.(io.Closer); ok {
+		firstErr = closer.Close()
+	}
+	if closer, ok := a.Config.Tracker.(io.Closer); ok {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Abort cancels an upload and deletes the chunks received for it. Aborting
+// an upload that doesn't exist is not an error.
+func (a *FileChunksAssembler) Abort(uploadID int64) error {
+	unlock := a.lockUpload(uploadID)
+	defer unlock()
+	if _, err := a.Config.Tracker.GetUpload(uploadID); err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			a.uploadLocks.Delete(uploadID)
+			return nil
+		}
+		return err
+	}
+	if err := a.removeUpload(uploadID); err != nil {
+		return err
+	}
+	a.Config.Hooks.uploadAborted(a.fileID(uploadID))
+	return nil
 }
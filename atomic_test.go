@@ -0,0 +1,54 @@
+package assemble
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// observingWriter calls observe on every write, which a digest passed to
+// Finalize gets while the completed file is being written.
+type observingWriter struct {
+	observe func()
+}
+
+func (w observingWriter) Write(p []byte) (int, error) {
+	w.observe()
+	return len(p), nil
+}
+
+func TestCompletedFilesAppearAtomically(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	completedPath := filepath.Join(store.CompletedDir, "file")
+	writeChunks(t, store, "1", "old")
+	if _, err := store.Finalize(context.Background(), "1", "file", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	writeChunks(t, store, "2", "new ", "contents")
+	writes := 0
+	digest := observingWriter{observe: func() {
+		writes++
+		data, err := ioutil.ReadFile(completedPath)
+		if err != nil || string(data) != "old" {
+			t.Errorf("while combining, got completed file %q (%v)", data, err)
+		}
+	}}
+	if _, err := store.Finalize(context.Background(), "2", "file", 2, digest); err != nil {
+		t.Fatal(err)
+	}
+	if writes == 0 {
+		t.Fatal("the completed file wasn't observed while being written")
+	}
+	if got := readCompletedFile(t, store, "file"); got != "new contents" {
+		t.Errorf("got completed file %q", got)
+	}
+	files, err := readDirFiles(store.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got completed directory %v, want only the completed file", files)
+	}
+}
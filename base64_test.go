@@ -0,0 +1,51 @@
+package assemble
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBase64Chunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Base64Chunks: true, MaxChunkSize: 8})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	// The encoded chunk is longer than MaxChunkSize, but the decoded one
+	// isn't.
+	first := base64.StdEncoding.EncodeToString([]byte("\x00\x01binary"))
+	ta.mustSend(uploadID, 0, first, nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, base64.StdEncoding.EncodeToString([]byte("\xff")), nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "\x00\x01binary\xff" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestBase64ChunksErrors(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Base64Chunks: true, MaxChunkSize: 8})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	for name, test := range map[string]struct {
+		body   string
+		status int
+	}{
+		"malformed": {"not base64!", http.StatusBadRequest},
+		"truncated": {"YWJj=", http.StatusBadRequest},
+		"too large": {base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 9))), http.StatusRequestEntityTooLarge},
+	} {
+		if rec := ta.send(uploadID, 0, test.body, nil); rec.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", name, rec.Code, rec.Body.String(), test.status)
+		}
+	}
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 0 {
+		t.Errorf("got received chunks %v, %v", received, err)
+	}
+}
+
+func TestBase64GzipChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Base64Chunks: true, DecompressChunks: true})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	chunk := base64.StdEncoding.EncodeToString([]byte(gzipped(t, "hello")))
+	ta.mustSend(uploadID, 0, chunk, map[string]string{"Content-Encoding": "gzip"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello" {
+		t.Errorf("got completed files %q, want %q", files, []string{"hello"})
+	}
+}
@@ -0,0 +1,104 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// DefaultMaxBatchParts is the default of AssemblerConfig.MaxBatchParts.
+const DefaultMaxBatchParts = 100
+
+var errTooManyBatchParts = errors.New("batch has too many parts")
+
+// batchResult is the response to one part of a batch.
+type batchResult struct {
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+func newBatchResult(response *bufferedResponse) batchResult {
+	status := response.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return batchResult{Status: status, Response: response.result()}
+}
+
+// BatchChunksMiddleware is like ChunksMiddleware for requests that carry
+// chunks of several uploads, to cut the overhead of uploading many small
+// files. The body is a multipart payload where each part is a chunk, with
+// the headers that would otherwise be sent with the request, such as the
+// upload and chunk IDs, as part headers. Headers of the request apply to
+// every part that doesn't override them. Each part is handled as if it had
+// been sent on its own, and the response is a JSON array with the status
+// and progress update of each part in order.
+//
+// Parts that can't be read get an error result, and since the rest of the
+// body can't be read either, the batch ends there with the results of the
+// parts before it. The same happens after MaxBatchParts parts, with HTTP
+// 413 as the result of the next part.
+func (a *FileChunksAssembler) BatchChunksMiddleware(h http.Handler) http.Handler {
+	chunks := a.chunksMiddleware(h, false)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isClosed() {
+			a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+			return
+		}
+		if !a.checkMethod(w, r) {
+			return
+		}
+		reader, err := r.MultipartReader()
+		if err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		results := []batchResult{}
+		failed := func(status int, err error) {
+			response := newBufferedResponse()
+			a.writeError(response, r, status, err)
+			results = append(results, newBatchResult(response))
+		}
+		for {
+			part, err := reader.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				failed(http.StatusBadRequest, err)
+				break
+			}
+			if len(results) == a.Config.MaxBatchParts {
+				failed(http.StatusRequestEntityTooLarge, errTooManyBatchParts)
+				break
+			}
+			chunkData, err := a.readLimited(part)
+			if errors.Is(err, errChunkTooLarge) {
+				failed(http.StatusRequestEntityTooLarge, err)
+				continue
+			}
+			if err != nil {
+				failed(http.StatusBadRequest, err)
+				break
+			}
+			// Headers describing the batch's body don't apply to the part.
+			chunk := r.Clone(r.Context())
+			chunk.Header.Del("Content-Type")
+			chunk.Header.Del("Content-Length")
+			chunk.Header.Del("Content-Encoding")
+			for name, values := range part.Header {
+				chunk.Header[name] = values
+			}
+			chunk.Body = ioutil.NopCloser(bytes.NewReader(chunkData))
+			chunk.ContentLength = int64(len(chunkData))
+			response := newBufferedResponse()
+			chunks.ServeHTTP(response, chunk)
+			results = append(results, newBatchResult(response))
+		}
+		w.Header().Add("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(results)
+	})
+}
@@ -0,0 +1,124 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+type batchPart struct {
+	uploadID int64
+	seq      int64
+	body     string
+}
+
+// batchBody encodes parts as a multipart body and returns it with its
+// content type.
+func batchBody(t *testing.T, parts []batchPart) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		header := textproto.MIMEHeader{}
+		header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(part.uploadID, 10))
+		header.Set(DefaultChunkIdentifierHeader, strconv.FormatInt(part.seq, 10))
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(part.body))
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+func (ta *testAssembler) sendBatch(body *bytes.Buffer, contentType string) []batchResult {
+	ta.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/batch", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	ta.a.BatchChunksMiddleware(ta.downstream).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		ta.t.Fatalf("batch: got %d %s", rec.Code, rec.Body.String())
+	}
+	var results []batchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		ta.t.Fatal(err)
+	}
+	return results
+}
+
+func resultStatuses(results []batchResult) []int {
+	statuses := make([]int, len(results))
+	for i, result := range results {
+		statuses[i] = result.Status
+	}
+	return statuses
+}
+
+func TestBatchChunks(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	first := ta.startUpload(`{"total_chunks": 1}`, nil)
+	second := ta.startUpload(`{"total_chunks": 2}`, nil)
+
+	results := ta.sendBatch(batchBody(t, []batchPart{
+		{first, 0, "one"},
+		{second, 0, "tw"},
+		{second, 1, "o"},
+	}))
+	if got := resultStatuses(results); len(got) != 3 || got[0] != 200 || got[1] != 200 || got[2] != 200 {
+		t.Errorf("got statuses %v", got)
+	}
+	if files := ta.completedFiles(); len(files) != 2 || files[0] != "one" || files[1] != "two" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestBatchChunksTooManyParts(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxBatchParts: 2})
+	uploadID := ta.startUpload(`{"total_chunks": 4}`, nil)
+
+	results := ta.sendBatch(batchBody(t, []batchPart{
+		{uploadID, 0, "a"},
+		{uploadID, 1, "b"},
+		{uploadID, 2, "c"},
+		{uploadID, 3, "d"},
+	}))
+	got := resultStatuses(results)
+	if len(got) != 3 || got[0] != 200 || got[1] != 200 || got[2] != http.StatusRequestEntityTooLarge {
+		t.Errorf("got statuses %v", got)
+	}
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 2 {
+		t.Errorf("got received chunks %v, %v", received, err)
+	}
+}
+
+func TestBatchChunksUnreadablePart(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+
+	body, contentType := batchBody(t, []batchPart{
+		{uploadID, 0, "a"},
+		{uploadID, 1, "second part"},
+	})
+	// The body ends in the middle of the second part. Its contents can't
+	// appear in the boundary, which is hex.
+	truncated := bytes.NewBuffer(body.Bytes()[:bytes.Index(body.Bytes(), []byte("second"))+len("second")])
+
+	results := ta.sendBatch(truncated, contentType)
+	got := resultStatuses(results)
+	if len(got) != 2 || got[0] != 200 || got[1] != http.StatusBadRequest {
+		t.Errorf("got statuses %v", got)
+	}
+	// The part stored before the error is kept.
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 1 || received[0] != 0 {
+		t.Errorf("got received chunks %v, %v", received, err)
+	}
+}
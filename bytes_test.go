@@ -0,0 +1,65 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReceivedBytes(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, map[string]string{DefaultTotalSizeHeader: "10"})
+	for _, step := range []struct {
+		seq  int64
+		body string
+		want int64
+	}{
+		{2, "ghij", 4},
+		{0, "abc", 7},
+		// A chunk sent again replaces the stored one.
+		{0, "abc", 7},
+		{1, "def", 10},
+	} {
+		progress := ta.mustSend(uploadID, step.seq, step.body, nil, http.StatusOK)
+		if progress.ReceivedBytes != step.want || progress.ExpectedBytes != 10 {
+			t.Errorf("chunk %d: got %d of %d bytes, want %d of 10", step.seq, progress.ReceivedBytes, progress.ExpectedBytes, step.want)
+		}
+	}
+}
+
+func TestExpectedBytes(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+
+	// Without a size, only the received bytes are known.
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "ab", nil, http.StatusOK); progress.ExpectedBytes != 0 {
+		t.Errorf("got %d expected bytes without a size", progress.ExpectedBytes)
+	}
+	// The size can be sent with any chunk.
+	progress := ta.mustSend(uploadID, 1, "cde", map[string]string{DefaultTotalSizeHeader: "5"}, http.StatusOK)
+	if progress.ReceivedBytes != 5 || progress.ExpectedBytes != 5 {
+		t.Errorf("got %d of %d bytes, want 5 of 5", progress.ReceivedBytes, progress.ExpectedBytes)
+	}
+
+	// Or in the body of the start request.
+	uploadID = ta.startUpload(`{"total_chunks": 2, "size": 4}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "ab", nil, http.StatusOK); progress.ExpectedBytes != 4 {
+		t.Errorf("got %d expected bytes, want 4", progress.ExpectedBytes)
+	}
+}
+
+func TestInvalidTotalSize(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	for _, size := range []string{"-1", "0", "ten"} {
+		if rec := ta.start(`{"total_chunks": 1}`, map[string]string{DefaultTotalSizeHeader: size}); rec.Code != http.StatusBadRequest {
+			t.Errorf("starting with size %q: got %d, want %d", size, rec.Code, http.StatusBadRequest)
+		}
+	}
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec := ta.send(uploadID, 0, "a", map[string]string{DefaultTotalSizeHeader: "ten"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if got := errorBody(t, rec); got != errInvalidTotalSize.Error() {
+		t.Errorf("got error %q, want %q", got, errInvalidTotalSize)
+	}
+}
@@ -0,0 +1,76 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFinalizeCancelled(t *testing.T) {
+	completedDir := t.TempDir()
+	store := NewFilesystemStore(t.TempDir(), completedDir)
+	writeChunks(t, store, "1", "a", "b", "c")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The context is cancelled while the first chunk is being copied.
+	_, err := store.Finalize(ctx, "1", "file", 3, observingWriter{observe: cancel})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	files, err := readDirFiles(completedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got completed files %v after cancelling", files)
+	}
+	// The chunks are kept, so the file can still be completed.
+	if _, err := store.Finalize(context.Background(), "1", "file", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompletedFile(t, store, "file"); got != "abc" {
+		t.Errorf("got %q, want %q", got, "abc")
+	}
+}
+
+func TestMemoryStoreFinalizeCancelled(t *testing.T) {
+	store := NewMemoryStore()
+	writeChunks(t, store, "1", "a", "b")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.Finalize(ctx, "1", "file", 2, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	if _, _, err := store.OpenCompleted("file"); !errors.Is(err, ErrCompletedFileNotFound) {
+		t.Errorf("got error %v opening the completed file, want %v", err, ErrCompletedFileNotFound)
+	}
+}
+
+func TestChunksMiddlewareCancelled(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := chunkRequest(http.MethodPost, uploadID, 1, "b", nil).WithContext(ctx)
+	ta.h.ServeHTTP(httptest.NewRecorder(), req)
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Fatalf("got completed files %q after the client went away", files)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got files %v in the completed directory after cancelling", files)
+	}
+
+	// Resending the chunk completes the upload.
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+}
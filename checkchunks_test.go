@@ -0,0 +1,49 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFinalizeChecksChunksFirst(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	writeChunks(t, store, "1", "a", "b", "c")
+	if err := os.Remove(store.chunkFilePath("1", 2)); err != nil {
+		t.Fatal(err)
+	}
+	digest := &writeSizes{}
+	_, err := store.Finalize(context.Background(), "1", "file", 3, digest)
+	if !errors.Is(err, errChunkFileMissing) {
+		t.Fatalf("got %v, want %v", err, errChunkFileMissing)
+	}
+	// Nothing was combined before the missing chunk was found.
+	if digest.total != 0 {
+		t.Errorf("%d bytes were combined", digest.total)
+	}
+	if files, _ := readDirFiles(store.CompletedDir); len(files) != 0 {
+		t.Errorf("got completed directory %v", files)
+	}
+}
+
+func TestUploadWithDeletedChunkCanBeRetried(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	chunkPath := filepath.Join(ta.a.Config.ChunksDir, ta.a.fileID(uploadID)+"-0")
+	if err := os.Remove(chunkPath); err != nil {
+		t.Fatal(err)
+	}
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusInternalServerError)
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Fatalf("got completed files %q", files)
+	}
+	// Resending the missing chunk completes the upload.
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
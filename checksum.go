@@ -0,0 +1,61 @@
+package assemble
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+)
+
+type ChecksumAlgorithm string
+
+const (
+	ChecksumCRC32  ChecksumAlgorithm = "crc32"
+	ChecksumMD5    ChecksumAlgorithm = "md5"
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+)
+
+func (algo ChecksumAlgorithm) new() (hash.Hash, error) {
+	switch algo {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algo)
+}
+
+var errFileChecksumMismatch = errors.New("file checksum mismatch")
+
+// parseFileChecksum validates a hex-encoded SHA256 and returns it in
+// lowercase.
+func parseFileChecksum(checksum string) (string, error) {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid file checksum")
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// verifyChecksum compares data with a hex-encoded checksum.
+func verifyChecksum(algo ChecksumAlgorithm, data []byte, expected string) error {
+	expectedSum, err := hex.DecodeString(expected)
+	if err != nil {
+		return fmt.Errorf("invalid checksum")
+	}
+	h, err := algo.new()
+	if err != nil {
+		return err
+	}
+	_, _ = h.Write(data)
+	if !bytes.Equal(h.Sum(nil), expectedSum) {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package assemble
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestChunkChecksum(t *testing.T) {
+	const checksumHeader = "x-chunk-checksum"
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkChecksumHeader: checksumHeader})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 0, "a", map[string]string{checksumHeader: sha256Hex("a")}, http.StatusOK)
+
+	rec := ta.send(uploadID, 1, "corrupted", map[string]string{checksumHeader: sha256Hex("b")})
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != "chunk checksum mismatch" {
+		t.Errorf("corrupted chunk: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := ta.send(uploadID, 1, "b", map[string]string{checksumHeader: "not hex"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid checksum: got %d %s", rec.Code, rec.Body.String())
+	}
+	// Chunks without the header aren't verified.
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	ta.mustSend(uploadID, 2, "c", map[string]string{checksumHeader: sha256Hex("c")}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abc" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestChunkChecksumAlgorithms(t *testing.T) {
+	md5Sum := md5.Sum([]byte("data"))
+	for algo, checksum := range map[ChecksumAlgorithm]string{
+		ChecksumCRC32:  fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte("data"))),
+		ChecksumMD5:    hex.EncodeToString(md5Sum[:]),
+		ChecksumSHA256: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7",
+	} {
+		if err := verifyChecksum(algo, []byte("data"), checksum); err != nil {
+			t.Errorf("%s: %v", algo, err)
+		}
+		if err := verifyChecksum(algo, []byte("other"), checksum); err == nil {
+			t.Errorf("%s: other data matched", algo)
+		}
+	}
+	if err := verifyChecksum("sha1", []byte("data"), "00"); err == nil {
+		t.Error("unsupported algorithm was accepted")
+	}
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFileChecksum(t *testing.T) {
+	const checksumHeader = "x-file-checksum"
+	ta := newTestAssembler(t, &AssemblerConfig{FileChecksumHeader: checksumHeader})
+
+	// Sent when starting the upload.
+	uploadID := ta.startUpload(fmt.Sprintf(`{"total_chunks": 2, "checksum": %q}`, sha256Hex("ab")), nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+
+	// Sent with a chunk, in uppercase.
+	uploadID = ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", map[string]string{checksumHeader: strings.ToUpper(sha256Hex("ab"))}, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 2 {
+		t.Fatalf("got completed files %q", files)
+	}
+
+	uploadID = ta.startUpload(`{"total_chunks": 2}`, nil)
+	if rec := ta.send(uploadID, 0, "a", map[string]string{checksumHeader: "abc"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid checksum: got %d %s", rec.Code, rec.Body.String())
+	}
+	ta.mustSend(uploadID, 0, "a", map[string]string{checksumHeader: sha256Hex("ab")}, http.StatusOK)
+	progress := ta.mustSend(uploadID, 1, "x", nil, http.StatusBadRequest)
+	if progress.RejectedError == nil || *progress.RejectedError != errFileChecksumMismatch.Error() {
+		t.Errorf("got progress %+v", progress)
+	}
+	if files := ta.completedFiles(); len(files) != 2 {
+		t.Errorf("mismatched file was passed downstream: %q", files)
+	}
+	completed, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := completed[ta.a.fileID(uploadID)]; ok {
+		t.Error("mismatched completed file was kept")
+	}
+}
+
+func TestKeepChunksOnChecksumMismatch(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{KeepChunksOnChecksumMismatch: true})
+	uploadID := ta.startUpload(fmt.Sprintf(`{"total_chunks": 2, "checksum": %q}`, sha256Hex("ab")), nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "x", nil, http.StatusBadRequest)
+	// The corrupted chunk is sent again.
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
@@ -0,0 +1,299 @@
+package assemble
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// With AppendChunks, the chunks of an upload are appended to a single log
+// file in the order they arrive. An index file next to it has an entry for
+// each write with the chunk's sequence number, offset and length in the log.
+// Later entries replace earlier ones for the same chunk, and entries with a
+// negative length delete it. Deleted chunks still take up space in the log
+// until every chunk of the upload has been deleted, which removes both
+// files.
+//
+// The log is synced before an entry is added to the index, and each entry
+// has a checksum, so after a crash the index only refers to chunks that
+// were written completely. Entries that were cut short are ignored.
+const (
+	chunkLogExt    = ".log"
+	chunkIndexExt  = ".idx"
+	indexEntrySize = 3*8 + 4
+)
+
+var errChunkNotLogged = fmt.Errorf("chunk isn't in the log: %w", os.ErrNotExist)
+
+type chunkLogEntry struct {
+	offset int64
+	length int64
+}
+
+// chunkLog holds the open files and index of an upload's log. lock guards
+// all of its fields.
+type chunkLog struct {
+	log    *os.File
+	index  *os.File
+	size   int64
+	chunks map[int64]chunkLogEntry
+
+	// Set when the log has been removed or closed, so it must not be used
+	// again.
+	removed bool
+
+	lock sync.Mutex
+}
+
+// withChunkLog calls f with the log of an upload while holding its lock. If
+// create is false and the upload has no log, f isn't called and
+// errChunkNotLogged is returned.
+func (s *FilesystemStore) withChunkLog(fileID string, create bool, f func(l *chunkLog) error) error {
+	for {
+		v, _ := s.chunkLogs.LoadOrStore(fileID, &chunkLog{})
+		l := v.(*chunkLog)
+		l.lock.Lock()
+		if l.removed {
+			// Removed after it was loaded, so try again with a new log.
+			l.lock.Unlock()
+			continue
+		}
+		if l.chunks == nil {
+			if err := s.openChunkLog(fileID, l, create); err != nil {
+				l.removed = true
+				s.chunkLogs.Delete(fileID)
+				l.lock.Unlock()
+				if errors.Is(err, os.ErrNotExist) && !create {
+					return errChunkNotLogged
+				}
+				return err
+			}
+		}
+		err := f(l)
+		l.lock.Unlock()
+		return err
+	}
+}
+
+// openChunkLog opens the files of a log and reads its index.
+func (s *FilesystemStore) openChunkLog(fileID string, l *chunkLog, create bool) error {
+	flags := os.O_RDWR | os.O_APPEND
+	if create {
+		flags |= os.O_CREATE
+	}
+	logFile, err := os.OpenFile(s.chunkLogPath(fileID), flags, s.ChunkFileMode)
+	if err != nil {
+		return err
+	}
+	indexFile, err := os.OpenFile(s.chunkIndexPath(fileID), flags, s.ChunkFileMode)
+	if err != nil {
+		_ = logFile.Close()
+		return err
+	}
+	info, err := logFile.Stat()
+	if err == nil {
+		err = truncatePartialEntry(indexFile)
+	}
+	if err != nil {
+		_ = logFile.Close()
+		_ = indexFile.Close()
+		return err
+	}
+	chunks, err := readChunkIndex(indexFile, info.Size())
+	if err != nil {
+		_ = logFile.Close()
+		_ = indexFile.Close()
+		return err
+	}
+	l.log = logFile
+	l.index = indexFile
+	l.size = info.Size()
+	l.chunks = chunks
+	return nil
+}
+
+// readChunkIndex returns the chunks in an index that are within a log of
+// the given size.
+func readChunkIndex(r io.Reader, logSize int64) (map[int64]chunkLogEntry, error) {
+	chunks := make(map[int64]chunkLogEntry)
+	var entry [indexEntrySize]byte
+	for {
+		if _, err := io.ReadFull(r, entry[:]); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return chunks, nil
+			}
+			return nil, err
+		}
+		if binary.BigEndian.Uint32(entry[24:]) != crc32.ChecksumIEEE(entry[:24]) {
+			continue
+		}
+		seq := int64(binary.BigEndian.Uint64(entry[0:]))
+		offset := int64(binary.BigEndian.Uint64(entry[8:]))
+		length := int64(binary.BigEndian.Uint64(entry[16:]))
+		if length < 0 {
+			delete(chunks, seq)
+			continue
+		}
+		if offset < 0 || offset+length > logSize {
+			continue
+		}
+		chunks[seq] = chunkLogEntry{offset: offset, length: length}
+	}
+}
+
+// truncatePartialEntry removes an entry that was cut short by a crash, so
+// that the entries added after it aren't misaligned.
+func truncatePartialEntry(indexFile *os.File) error {
+	info, err := indexFile.Stat()
+	if err != nil {
+		return err
+	}
+	if partial := info.Size() % indexEntrySize; partial != 0 {
+		return indexFile.Truncate(info.Size() - partial)
+	}
+	return nil
+}
+
+// writeEntry adds an entry to the index. Entries are written in a single
+// call so that a crash can only cut short the last one.
+func (l *chunkLog) writeEntry(seq int64, entry chunkLogEntry) error {
+	var b [indexEntrySize]byte
+	binary.BigEndian.PutUint64(b[0:], uint64(seq))
+	binary.BigEndian.PutUint64(b[8:], uint64(entry.offset))
+	binary.BigEndian.PutUint64(b[16:], uint64(entry.length))
+	binary.BigEndian.PutUint32(b[24:], crc32.ChecksumIEEE(b[:24]))
+	_, err := l.index.Write(b[:])
+	return err
+}
+
+func (s *FilesystemStore) appendChunk(fileID string, seq int64, data []byte) error {
+	return s.withChunkLog(fileID, true, func(l *chunkLog) error {
+		entry := chunkLogEntry{offset: l.size, length: int64(len(data))}
+		n, err := l.log.Write(data)
+		l.size += int64(n)
+		if err != nil {
+			return err
+		}
+		if err := l.log.Sync(); err != nil {
+			return err
+		}
+		if err := l.writeEntry(seq, entry); err != nil {
+			return err
+		}
+		l.chunks[seq] = entry
+		return nil
+	})
+}
+
+// readLoggedChunk returns the data of a chunk as it was written.
+func (s *FilesystemStore) readLoggedChunk(fileID string, seq int64) ([]byte, error) {
+	var data []byte
+	err := s.withChunkLog(fileID, false, func(l *chunkLog) error {
+		entry, ok := l.chunks[seq]
+		if !ok {
+			return errChunkNotLogged
+		}
+		data = make([]byte, entry.length)
+		_, err := l.log.ReadAt(data, entry.offset)
+		return err
+	})
+	return data, err
+}
+
+// copyLoggedChunk streams a chunk to w.
+func (s *FilesystemStore) copyLoggedChunk(w io.Writer, fileID string, seq int64, buf []byte) error {
+	return s.withChunkLog(fileID, false, func(l *chunkLog) error {
+		entry, ok := l.chunks[seq]
+		if !ok {
+			return errChunkNotLogged
+		}
+		_, err := io.CopyBuffer(w, io.NewSectionReader(l.log, entry.offset, entry.length), buf)
+		return err
+	})
+}
+
+// deleteLoggedChunk removes a chunk from the index, and removes the log
+// once it has no chunks left.
+func (s *FilesystemStore) deleteLoggedChunk(fileID string, seq int64) error {
+	return s.withChunkLog(fileID, false, func(l *chunkLog) error {
+		if _, ok := l.chunks[seq]; !ok {
+			return errChunkNotLogged
+		}
+		delete(l.chunks, seq)
+		if len(l.chunks) > 0 {
+			return l.writeEntry(seq, chunkLogEntry{length: -1})
+		}
+		l.removed = true
+		s.chunkLogs.Delete(fileID)
+		_ = l.log.Close()
+		_ = l.index.Close()
+		logErr := os.Remove(s.chunkLogPath(fileID))
+		indexErr := os.Remove(s.chunkIndexPath(fileID))
+		if logErr != nil {
+			return logErr
+		}
+		return indexErr
+	})
+}
+
+// Close closes the logs that are open. They are opened again if the store
+// is used afterwards.
+func (s *FilesystemStore) Close() error {
+	var firstErr error
+	s.chunkLogs.Range(func(fileID, v interface{}) bool {
+		l := v.(*chunkLog)
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		if l.removed {
+			return true
+		}
+		l.removed = true
+		s.chunkLogs.Delete(fileID)
+		if l.chunks == nil {
+			return true
+		}
+		for _, f := range []*os.File{l.log, l.index} {
+			if err := f.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return true
+	})
+	return firstErr
+}
+
+// loggedChunkSizes returns the sizes of the chunks in an index file, for
+// recovering uploads.
+func (s *FilesystemStore) loggedChunkSizes(indexName string) (map[int64]int64, error) {
+	logInfo, err := os.Stat(path.Join(s.ChunksDir, indexName[:len(indexName)-len(chunkIndexExt)]+chunkLogExt))
+	if err != nil {
+		return nil, err
+	}
+	indexFile, err := os.Open(path.Join(s.ChunksDir, indexName))
+	if err != nil {
+		return nil, err
+	}
+	defer indexFile.Close()
+	chunks, err := readChunkIndex(indexFile, logInfo.Size())
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[int64]int64, len(chunks))
+	for seq, entry := range chunks {
+		sizes[seq] = entry.length
+	}
+	return sizes, nil
+}
+
+func (s *FilesystemStore) chunkLogPath(fileID string) string {
+	return path.Join(s.ChunksDir, s.fileName(fileID)+chunkLogExt)
+}
+
+func (s *FilesystemStore) chunkIndexPath(fileID string) string {
+	return path.Join(s.ChunksDir, s.fileName(fileID)+chunkIndexExt)
+}
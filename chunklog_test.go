@@ -0,0 +1,182 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newAppendStore(chunksDir string, completedDir string) *FilesystemStore {
+	store := NewFilesystemStore(chunksDir, completedDir)
+	store.AppendChunks = true
+	return store
+}
+
+func TestAppendChunksStore(t *testing.T) {
+	testChunkStore(t, func(t *testing.T) ChunkStore {
+		return newAppendStore(t.TempDir(), t.TempDir())
+	})
+}
+
+func TestAppendChunksReorders(t *testing.T) {
+	store := newAppendStore(t.TempDir(), t.TempDir())
+	for _, chunk := range []struct {
+		seq  int64
+		data string
+	}{{2, "c"}, {0, "x"}, {1, "b"}, {0, "a"}} {
+		if err := store.WriteChunk("1", chunk.seq, []byte(chunk.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := readDirFiles(store.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("got chunk files %v, want a log and an index", files)
+	}
+	if _, err := store.Finalize(context.Background(), "1", "file", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompletedFile(t, store, "file"); got != "abc" {
+		t.Errorf("got completed file %q", got)
+	}
+
+	// Deleting every chunk removes the log.
+	for seq := int64(0); seq < 3; seq++ {
+		if err := store.DeleteChunk("1", seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files, _ := readDirFiles(store.ChunksDir); len(files) != 0 {
+		t.Errorf("got chunk files %v after deleting every chunk", files)
+	}
+}
+
+func TestAppendChunksIgnoresTornIndexEntry(t *testing.T) {
+	chunksDir, completedDir := t.TempDir(), t.TempDir()
+	store := newAppendStore(chunksDir, completedDir)
+	writeChunks(t, store, "1", "a", "b")
+
+	// A crash while writing an index entry leaves part of it behind.
+	index, err := os.OpenFile(store.chunkIndexPath("1"), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	_ = index.Close()
+
+	restarted := newAppendStore(chunksDir, completedDir)
+	if err := restarted.SaveUploadInfo("1", UploadInfo{TotalChunks: 3}); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := restarted.RecoverUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || len(recovered[0].Chunks) != 2 || recovered[0].Chunks[1] != 1 {
+		t.Fatalf("got recovered uploads %+v", recovered)
+	}
+	writeChunks(t, restarted, "1", "a", "b", "c")
+	if _, err := restarted.Finalize(context.Background(), "1", "file", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompletedFile(t, restarted, "file"); got != "abc" {
+		t.Errorf("got completed file %q", got)
+	}
+}
+
+func TestAppendChunksUpload(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AppendChunks: true})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	for _, seq := range []int64{1, 2, 0} {
+		ta.mustSend(uploadID, seq, strings.Repeat(string(rune('a'+seq)), 2), nil, http.StatusOK)
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "aabbcc" {
+		t.Errorf("got completed files %q", files)
+	}
+	if files, _ := readDirFiles(ta.a.Config.ChunksDir); len(files) != 0 {
+		t.Errorf("got chunk files %v after the upload completed", files)
+	}
+}
+
+func TestAppendChunksClose(t *testing.T) {
+	store := newAppendStore(t.TempDir(), t.TempDir())
+	writeChunks(t, store, "1", "a", "b")
+	v, _ := store.chunkLogs.Load("1")
+	l := v.(*chunkLog)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []*os.File{l.log, l.index} {
+		if _, err := f.Stat(); !errors.Is(err, os.ErrClosed) {
+			t.Errorf("%s is still open after Close: %v", f.Name(), err)
+		}
+	}
+	// The log is opened again when it's next used.
+	writeChunks(t, store, "1", "a", "b", "c")
+	if _, err := store.Finalize(context.Background(), "1", "file", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompletedFile(t, store, "file"); got != "abc" {
+		t.Errorf("got completed file %q", got)
+	}
+}
+
+func TestAppendChunksClosedWithAssembler(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AppendChunks: true})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if err := ta.a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	open := 0
+	ta.a.Config.Store.(*FilesystemStore).chunkLogs.Range(func(interface{}, interface{}) bool {
+		open++
+		return true
+	})
+	if open != 0 {
+		t.Errorf("got %d open logs after closing the assembler", open)
+	}
+}
+
+// Each iteration writes, combines and deletes the chunks of an upload.
+func BenchmarkAppendChunks(b *testing.B) {
+	chunk := []byte(strings.Repeat("x", 4096))
+	for _, test := range []struct {
+		name   string
+		append bool
+	}{
+		{"files", false},
+		{"log", true},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			store := NewFilesystemStore(b.TempDir(), b.TempDir())
+			store.AppendChunks = test.append
+			defer store.Close()
+			const chunks = 100
+			b.SetBytes(chunks * int64(len(chunk)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for seq := int64(0); seq < chunks; seq++ {
+					if err := store.WriteChunk("1", seq, chunk); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if _, err := store.Finalize(context.Background(), "1", "file", chunks, nil); err != nil {
+					b.Fatal(err)
+				}
+				for seq := int64(0); seq < chunks; seq++ {
+					if err := store.DeleteChunk("1", seq); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
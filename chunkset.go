@@ -0,0 +1,159 @@
+package assemble
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+var (
+	errCompactChunksUnbounded   = errors.New("MemoryTracker.CompactChunks needs MaxChunkTotal")
+	errCompactChunksWithOffsets = errors.New("MemoryTracker.CompactChunks can't be used with OffsetHeader")
+)
+
+// checkCompactChunks makes sure that the memory of a compact chunk set is
+// bounded. It grows with the highest sequence number received, which is
+// limited by MaxChunkTotal but not by the number of chunks a client says it
+// will send. With offsets, sequence numbers are byte offsets, so they
+// aren't bounded at all.
+func checkCompactChunks(config *AssemblerConfig) error {
+	tracker, ok := config.Tracker.(*MemoryTracker)
+	if !ok || !tracker.CompactChunks {
+		return nil
+	}
+	if config.OffsetHeader != "" {
+		return errCompactChunksWithOffsets
+	}
+	if config.MaxChunkTotal <= 0 {
+		return errCompactChunksUnbounded
+	}
+	return nil
+}
+
+// chunkSet is the set of received chunks of an upload in MemoryTracker.
+type chunkSet interface {
+	// add records a chunk and returns the size of the chunk it replaced, or
+	// 0 if it wasn't received before.
+	add(seq int64, chunk ChunkInfo) int64
+	get(seq int64) (ChunkInfo, bool)
+	len() int64
+
+	// sequences returns the sequence numbers in ascending order.
+	sequences() []int64
+}
+
+func newChunkSet(compact bool) chunkSet {
+	if compact {
+		return &bitsetChunks{}
+	}
+	return mapChunks{}
+}
+
+type mapChunks map[int64]ChunkInfo
+
+func (m mapChunks) add(seq int64, chunk ChunkInfo) int64 {
+	previous := m[seq].Size
+	m[seq] = chunk
+	return previous
+}
+
+func (m mapChunks) get(seq int64) (ChunkInfo, bool) {
+	chunk, ok := m[seq]
+	return chunk, ok
+}
+
+func (m mapChunks) len() int64 {
+	return int64(len(m))
+}
+
+func (m mapChunks) sequences() []int64 {
+	received := make([]int64, 0, len(m))
+	for seq := range m {
+		received = append(received, seq)
+	}
+	sort.Slice(received, func(i, j int) bool {
+		return received[i] < received[j]
+	})
+	return received
+}
+
+// bitsetChunks records received chunks in a bitset and their sizes in a
+// slice, both indexed by sequence number, which takes a fraction of the
+// memory of a map. Checksums are only kept for chunks that have one, and
+// receive times aren't kept.
+type bitsetChunks struct {
+	received  []uint64
+	sizes     []int64
+	count     int64
+	checksums map[int64]string
+}
+
+func (b *bitsetChunks) add(seq int64, chunk ChunkInfo) int64 {
+	if seq >= int64(len(b.sizes)) {
+		b.grow(seq + 1)
+	}
+	word, bit := seq/64, uint(seq%64)
+	var previous int64
+	if b.received[word]&(1<<bit) != 0 {
+		previous = b.sizes[seq]
+	} else {
+		b.received[word] |= 1 << bit
+		b.count++
+	}
+	b.sizes[seq] = chunk.Size
+	if chunk.Checksum != "" {
+		if b.checksums == nil {
+			b.checksums = make(map[int64]string)
+		}
+		b.checksums[seq] = chunk.Checksum
+	} else {
+		delete(b.checksums, seq)
+	}
+	return previous
+}
+
+// grow makes room for at least n chunks, at least doubling the capacity so
+// that chunks received in order don't copy the slices each time.
+func (b *bitsetChunks) grow(n int64) {
+	if n <= int64(cap(b.sizes)) {
+		b.sizes = b.sizes[:n]
+	} else {
+		sizes := make([]int64, n, max64(n, 2*int64(cap(b.sizes))))
+		copy(sizes, b.sizes)
+		b.sizes = sizes
+	}
+	words := (n + 63) / 64
+	if words > int64(len(b.received)) {
+		b.received = append(b.received, make([]uint64, words-int64(len(b.received)))...)
+	}
+}
+
+func (b *bitsetChunks) get(seq int64) (ChunkInfo, bool) {
+	if seq < 0 || seq >= int64(len(b.sizes)) || b.received[seq/64]&(1<<uint(seq%64)) == 0 {
+		return ChunkInfo{}, false
+	}
+	return ChunkInfo{Size: b.sizes[seq], Checksum: b.checksums[seq]}, true
+}
+
+func (b *bitsetChunks) len() int64 {
+	return b.count
+}
+
+func (b *bitsetChunks) sequences() []int64 {
+	received := make([]int64, 0, b.count)
+	for i, word := range b.received {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			received = append(received, int64(i)*64+int64(bit))
+			word &= word - 1
+		}
+	}
+	return received
+}
+
+func max64(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,114 @@
+package assemble
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBitsetChunks(t *testing.T) {
+	chunks := newChunkSet(true)
+	for _, seq := range []int64{130, 0, 64, 3} {
+		if previous := chunks.add(seq, ChunkInfo{Size: seq + 1}); previous != 0 {
+			t.Errorf("chunk %d: replaced size %d", seq, previous)
+		}
+	}
+	if previous := chunks.add(64, ChunkInfo{Size: 7, Checksum: "abc"}); previous != 65 {
+		t.Errorf("replacing chunk 64: got previous size %d, want 65", previous)
+	}
+	if chunks.len() != 4 {
+		t.Errorf("got %d chunks, want 4", chunks.len())
+	}
+	if got := chunks.sequences(); !reflect.DeepEqual(got, []int64{0, 3, 64, 130}) {
+		t.Errorf("got sequences %v", got)
+	}
+	if chunk, ok := chunks.get(64); !ok || chunk.Size != 7 || chunk.Checksum != "abc" {
+		t.Errorf("got chunk 64 %+v %v", chunk, ok)
+	}
+	for _, seq := range []int64{-1, 1, 131, 1000} {
+		if _, ok := chunks.get(seq); ok {
+			t.Errorf("chunk %d was received", seq)
+		}
+	}
+}
+
+func TestCompactChunksConfig(t *testing.T) {
+	for name, config := range map[string]*AssemblerConfig{
+		"without MaxChunkTotal": {
+			Tracker: &MemoryTracker{CompactChunks: true},
+		},
+		"with OffsetHeader": {
+			Tracker:       &MemoryTracker{CompactChunks: true},
+			MaxChunkTotal: 100,
+			OffsetHeader:  "x-offset",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("NewFileChunksAssembler didn't panic")
+				}
+			}()
+			config.ChunksDir = t.TempDir()
+			config.CompletedDir = t.TempDir()
+			NewFileChunksAssembler(config)
+		})
+	}
+}
+
+func TestCompactChunksBoundedByMaxChunkTotal(t *testing.T) {
+	tracker := &MemoryTracker{CompactChunks: true}
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Tracker:          tracker,
+		MaxChunkTotal:    4,
+		FinalChunkHeader: "x-final",
+	})
+	uploadID := ta.startUpload(`{}`, nil)
+
+	rec := ta.send(uploadID, 1<<40, "far away", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", map[string]string{"x-final": "true"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+// benchmarkChunkSet adds a million chunks to a set, and reports the memory
+// the set holds on to besides what is allocated while it grows.
+func benchmarkChunkSet(b *testing.B, compact bool) {
+	const chunks = 1000000
+	chunk := ChunkInfo{Size: 1 << 20, ReceivedAt: time.Now()}
+	var retained int64
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		b.StartTimer()
+		set := newChunkSet(compact)
+		for seq := int64(0); seq < chunks; seq++ {
+			set.add(seq, chunk)
+		}
+		b.StopTimer()
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		retained += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+		runtime.KeepAlive(set)
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+}
+
+func BenchmarkChunkSetMap(b *testing.B) {
+	benchmarkChunkSet(b, false)
+}
+
+func BenchmarkChunkSetBitset(b *testing.B) {
+	benchmarkChunkSet(b, true)
+}
@@ -0,0 +1,89 @@
+package assemble
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countingReader is an endless body that counts the bytes read from it.
+type countingReader struct {
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+func TestMaxChunkSize(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxChunkSize: 4})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "1234", nil, http.StatusOK)
+	rec := ta.send(uploadID, 1, "12345", nil)
+	if rec.Code != http.StatusRequestEntityTooLarge || errorBody(t, rec) != errChunkTooLarge.Error() {
+		t.Errorf("chunk over the limit: got %d %s", rec.Code, rec.Body.String())
+	}
+	// Only the chunk is rejected.
+	ta.mustSend(uploadID, 1, "5", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "12345" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestMaxChunkSizeIgnoresContentLength(t *testing.T) {
+	const maxChunkSize = 1024
+	ta := newTestAssembler(t, &AssemblerConfig{MaxChunkSize: maxChunkSize})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+
+	body := &countingReader{}
+	req := httptest.NewRequest(http.MethodPost, "/parts", io.LimitReader(body, 1<<30))
+	req.ContentLength = 10
+	req.Header.Set("Content-Length", "10")
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	req.Header.Set(DefaultChunkIdentifierHeader, "0")
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	// The oversized body wasn't read in full.
+	if body.read > 64*1024 {
+		t.Errorf("read %d bytes of a chunk limited to %d", body.read, maxChunkSize)
+	}
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if strings.Contains(data, "xxxx") {
+			t.Errorf("oversized chunk was stored in %s", name)
+		}
+	}
+}
+
+func TestExpectedChunkSize(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ExpectedChunkSize: 3})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	// The last chunk can be smaller, and arrive first.
+	ta.mustSend(uploadID, 2, "g", nil, http.StatusOK)
+	for _, chunk := range []string{"ab", "abcd"} {
+		if rec := ta.send(uploadID, 1, chunk, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("middle chunk %q: got %d %s", chunk, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := ta.send(uploadID, 2, "ghij", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("last chunk over the size: got %d %s", rec.Code, rec.Body.String())
+	}
+	ta.mustSend(uploadID, 1, "def", nil, http.StatusOK)
+	ta.mustSend(uploadID, 0, "abc", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abcdefg" {
+		t.Errorf("got completed files %q", files)
+	}
+}
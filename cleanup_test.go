@@ -0,0 +1,116 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// newAsyncCleanupAssembler returns an assembler that removes completed
+// uploads in the background.
+func newAsyncCleanupAssembler(t *testing.T) *testAssembler {
+	t.Helper()
+	ta := newTestAssembler(t, nil)
+	ta.a.Config.SynchronousCleanup = false
+	return ta
+}
+
+// checkNoUploadsLeft waits for background cleanup and fails the test if
+// any upload or chunk is left.
+func checkNoUploadsLeft(t *testing.T, ta *testAssembler) {
+	t.Helper()
+	if err := ta.a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	count, err := ta.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d uploads are still tracked", count)
+	}
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		t.Errorf("%s was left in the chunks directory", name)
+	}
+}
+
+func TestSynchronousCleanup(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	// Nothing is left without waiting for the background.
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got chunk files %v after the upload completed", files)
+	}
+}
+
+// TestAsynchronousCleanupRace is meant to be run with -race. Chunks for an
+// upload arrive while it's removed in the background.
+func TestAsynchronousCleanupRace(t *testing.T) {
+	ta := newAsyncCleanupAssembler(t)
+	for i := 0; i < 10; i++ {
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+		ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+		// Resending the chunk gets either a response for the completed
+		// upload or an unknown upload, depending on whether it was removed.
+		rec := ta.send(uploadID, 0, "a", nil)
+		if rec.Code == http.StatusInternalServerError {
+			t.Errorf("resending chunk: got %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	checkNoUploadsLeft(t, ta)
+	if files := ta.completedFiles(); len(files) != 10 {
+		t.Errorf("got %d completed files, want 10", len(files))
+	}
+}
+
+// TestConcurrentStartAndAbort is meant to be run with -race. Chunks that
+// wait for an upload's lock while it's aborted must not be stored.
+func TestConcurrentStartAndAbort(t *testing.T) {
+	ta := newAsyncCleanupAssembler(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := ta.start(`{"total_chunks": 4}`, nil)
+			var response startResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || rec.Code != http.StatusOK {
+				t.Errorf("starting upload: got %d %s", rec.Code, rec.Body.String())
+				return
+			}
+			uploadID := response.ID
+			var requests sync.WaitGroup
+			for seq := int64(0); seq < 4; seq++ {
+				requests.Add(1)
+				go func(seq int64) {
+					defer requests.Done()
+					rec := ta.send(uploadID, seq, "x", nil)
+					if rec.Code != http.StatusOK && rec.Code != http.StatusBadRequest {
+						t.Errorf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+					}
+				}(seq)
+			}
+			requests.Add(1)
+			go func() {
+				defer requests.Done()
+				if err := ta.a.Abort(uploadID); err != nil {
+					t.Errorf("aborting upload %d: %v", uploadID, err)
+				}
+			}()
+			requests.Wait()
+		}()
+	}
+	wg.Wait()
+	checkNoUploadsLeft(t, ta)
+}
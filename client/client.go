@@ -0,0 +1,492 @@
+// Package client uploads files in chunks to a server using go-assemble.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dchenz/go-assemble"
+)
+
+const (
+	DefaultChunkSize    = 5 << 20
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = time.Second
+)
+
+var errEmptyFile = errors.New("file is empty")
+
+// ErrAlreadyExists is returned by UploadIfNew for files that the server
+// already has.
+var ErrAlreadyExists = errors.New("server already has the file")
+
+// Uploader splits files into chunks and uploads them to the endpoints of
+// UploadStartHandler and ChunksMiddleware.
+type Uploader struct {
+	// URL of the endpoint that starts uploads.
+	StartURL string
+
+	// URL of the endpoint that receives chunks.
+	ChunksURL string
+
+	// URL of the endpoint of ExistsHandler, which is needed for Exists and
+	// UploadIfNew.
+	ExistsURL string
+
+	// Size of each chunk in bytes, except for the last one.
+	//
+	// Default: 5 MiB
+	ChunkSize int64
+
+	// Default: http.DefaultClient
+	HTTPClient *http.Client
+
+	// Number of times a chunk is sent again after a network error or a
+	// response with HTTP 408, 429 or 5xx. The wait between attempts starts
+	// at RetryBackoff and doubles each time. Set it to -1 to disable
+	// retries.
+	//
+	// Default: 3
+	MaxRetries int
+
+	// Default: 1s
+	RetryBackoff time.Duration
+
+	// These must match the assembler's config.
+	//
+	// Default: x-assemble-upload-id and x-assemble-chunk-id
+	UploadIdentifierHeader string
+	ChunkIdentifierHeader  string
+
+	// If set, the progress of the upload is sent to it after each chunk.
+	// Sends block, so it must be read from until Upload returns.
+	Progress chan<- Progress
+
+	// Number of chunks that are sent at the same time. The server combines
+	// the chunks in order regardless of the order they arrive in.
+	//
+	// Default: 1
+	Concurrency int
+}
+
+// Progress of an upload, as reported by the server.
+type Progress struct {
+	UploadID    int64
+	Chunks      int64
+	TotalChunks int64
+	Bytes       int64
+	TotalBytes  int64
+}
+
+// Result of a completed upload.
+type Result struct {
+	UploadID int64
+
+	// Hash of the completed file computed by the server.
+	Hash string
+
+	// Location of the completed file, if the server exposes it.
+	Location string
+
+	// Response of the downstream handler, if the server uses
+	// ChunksMiddlewareWithResponse.
+	Result json.RawMessage
+}
+
+// Error is returned for requests that the server rejected, including
+// completed files that the downstream handler rejected.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// chunkResponse is the progress update or error sent by the server.
+type chunkResponse struct {
+	Have          int64           `json:"have"`
+	Want          int64           `json:"want"`
+	ReceivedBytes int64           `json:"received_bytes"`
+	Hash          string          `json:"hash"`
+	Location      string          `json:"location"`
+	Error         string          `json:"error"`
+	Result        json.RawMessage `json:"result"`
+}
+
+func NewUploader(startURL string, chunksURL string) *Uploader {
+	return &Uploader{
+		StartURL:  startURL,
+		ChunksURL: chunksURL,
+	}
+}
+
+// Upload starts an upload and sends size bytes from r in chunks. Metadata
+// is sent with the start request and should include the "type" of the file.
+func (u *Uploader) Upload(ctx context.Context, r io.Reader, size int64, metadata map[string]interface{}) (*Result, error) {
+	if size <= 0 {
+		return nil, errEmptyFile
+	}
+	return u.upload(ctx, r, size, metadata, "")
+}
+
+// UploadIfNew hashes size bytes from r and asks the server whether it
+// already has a file with the same contents. If it does, ErrAlreadyExists
+// is returned. Otherwise r is rewound and uploaded like with Upload, with
+// the hash as the checksum of the upload so the server verifies it.
+func (u *Uploader) UploadIfNew(ctx context.Context, r io.ReadSeeker, size int64, metadata map[string]interface{}) (*Result, error) {
+	if size <= 0 {
+		return nil, errEmptyFile
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	if _, err := io.CopyN(sum, r, size); err != nil {
+		return nil, fmt.Errorf("hashing file: %w", err)
+	}
+	checksum := hex.EncodeToString(sum.Sum(nil))
+	exists, err := u.Exists(ctx, checksum)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAlreadyExists
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return u.upload(ctx, r, size, metadata, checksum)
+}
+
+// Exists asks the server at ExistsURL whether it has a completed file with
+// the given hex-encoded SHA256.
+func (u *Uploader) Exists(ctx context.Context, checksum string) (bool, error) {
+	existsURL, err := url.Parse(u.ExistsURL)
+	if err != nil {
+		return false, err
+	}
+	query := existsURL.Query()
+	query.Set("sha256", checksum)
+	existsURL.RawQuery = query.Encode()
+	resp, err := u.do(ctx, http.MethodGet, existsURL.String(), nil, nil)
+	if err != nil {
+		return false, fmt.Errorf("checking if file exists: %w", err)
+	}
+	var exists struct {
+		Exists bool `json:"exists"`
+	}
+	if err := json.Unmarshal(resp, &exists); err != nil {
+		return false, fmt.Errorf("checking if file exists: %w", err)
+	}
+	return exists.Exists, nil
+}
+
+func (u *Uploader) upload(ctx context.Context, r io.Reader, size int64, metadata map[string]interface{}, checksum string) (*Result, error) {
+	chunkSize := u.chunkSize()
+	totalChunks := (size + chunkSize - 1) / chunkSize
+	uploadID, err := u.start(ctx, totalChunks, size, metadata, checksum)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &uploadState{uploadID: uploadID, cancel: cancel, size: size}
+
+	// Chunks are read one at a time as workers become free, so at most one
+	// chunk per worker is held in memory.
+	chunks := make(chan chunk)
+	var wg sync.WaitGroup
+	for i := 0; i < u.concurrency(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				resp, err := u.sendChunk(ctx, uploadID, c.seq, c.data)
+				if err != nil {
+					s.fail(fmt.Errorf("sending chunk %d: %w", c.seq, err))
+					return
+				}
+				if !s.received(resp) {
+					return
+				}
+				if u.Progress != nil {
+					select {
+					case u.Progress <- s.progress():
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+	readErr := readChunks(ctx, r, size, chunkSize, chunks)
+	close(chunks)
+	wg.Wait()
+	if readErr != nil {
+		s.fail(readErr)
+	}
+	return s.result(ctx)
+}
+
+type chunk struct {
+	seq  int64
+	data []byte
+}
+
+// readChunks sends chunks read from r until all of them have been sent or
+// ctx is cancelled.
+func readChunks(ctx context.Context, r io.Reader, size int64, chunkSize int64, chunks chan<- chunk) error {
+	totalChunks := (size + chunkSize - 1) / chunkSize
+	for seq := int64(0); seq < totalChunks; seq++ {
+		n := chunkSize
+		if seq == totalChunks-1 {
+			n = size - seq*chunkSize
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("reading chunk %d: %w", seq, err)
+		}
+		select {
+		case chunks <- chunk{seq: seq, data: data}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return nil
+}
+
+// uploadState collects the responses of chunks sent by multiple workers.
+type uploadState struct {
+	uploadID int64
+	cancel   func()
+
+	lock sync.Mutex
+	err  error
+
+	// The response that completed the upload. Responses can arrive in any
+	// order, so it isn't necessarily the response to the last chunk.
+	completed *chunkResponse
+
+	// Highest progress reported by the server so far.
+	have  int64
+	want  int64
+	bytes int64
+	size  int64
+}
+
+// fail records the first error and stops the other workers.
+func (s *uploadState) fail(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cancel()
+}
+
+// received records a response and returns false if the upload has failed.
+func (s *uploadState) received(resp chunkResponse) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if resp.Have > s.have {
+		s.have = resp.Have
+	}
+	if resp.ReceivedBytes > s.bytes {
+		s.bytes = resp.ReceivedBytes
+	}
+	s.want = resp.Want
+	if resp.Have == resp.Want && s.completed == nil {
+		s.completed = &resp
+	}
+	return s.err == nil
+}
+
+func (s *uploadState) progress() Progress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return Progress{
+		UploadID:    s.uploadID,
+		Chunks:      s.have,
+		TotalChunks: s.want,
+		Bytes:       s.bytes,
+		TotalBytes:  s.size,
+	}
+}
+
+func (s *uploadState) result(ctx context.Context) (*Result, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.completed == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("upload incomplete: server has %d of %d chunks", s.have, s.want)
+	}
+	return &Result{
+		UploadID: s.uploadID,
+		Hash:     s.completed.Hash,
+		Location: s.completed.Location,
+		Result:   s.completed.Result,
+	}, nil
+}
+
+func (u *Uploader) start(ctx context.Context, totalChunks int64, size int64, metadata map[string]interface{}, checksum string) (int64, error) {
+	start := map[string]interface{}{
+		"total_chunks": totalChunks,
+		"size":         size,
+		"metadata":     metadata,
+	}
+	if checksum != "" {
+		start["checksum"] = checksum
+	}
+	body, err := json.Marshal(start)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := u.do(ctx, http.MethodPost, u.StartURL, body, nil)
+	if err != nil {
+		return 0, fmt.Errorf("starting upload: %w", err)
+	}
+	var started struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(resp, &started); err != nil {
+		return 0, fmt.Errorf("starting upload: %w", err)
+	}
+	return started.ID, nil
+}
+
+func (u *Uploader) sendChunk(ctx context.Context, uploadID int64, seq int64, data []byte) (chunkResponse, error) {
+	header := http.Header{}
+	header.Set(u.uploadIdentifierHeader(), strconv.FormatInt(uploadID, 10))
+	header.Set(u.chunkIdentifierHeader(), strconv.FormatInt(seq, 10))
+	resp, err := u.do(ctx, http.MethodPost, u.ChunksURL, data, header)
+	if err != nil {
+		return chunkResponse{}, err
+	}
+	var progress chunkResponse
+	if err := json.Unmarshal(resp, &progress); err != nil {
+		return chunkResponse{}, err
+	}
+	return progress, nil
+}
+
+// do sends a request, retrying transient failures, and returns the body of
+// a successful response.
+func (u *Uploader) do(ctx context.Context, method string, url string, body []byte, header http.Header) ([]byte, error) {
+	backoff := u.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	maxRetries := u.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := u.doOnce(ctx, method, url, body, header)
+		if err == nil || !retryable(err) || attempt >= maxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (u *Uploader) doOnce(ctx context.Context, method string, url string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	client := u.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var errResp chunkResponse
+		_ = json.Unmarshal(respBody, &errResp)
+		if errResp.Error == "" {
+			errResp.Error = http.StatusText(resp.StatusCode)
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+	return respBody, nil
+}
+
+// retryable returns whether a request might succeed if it's sent again.
+// Errors other than rejections by the server are assumed to be network
+// errors.
+func retryable(err error) bool {
+	var respErr *Error
+	if !errors.As(err, &respErr) {
+		return true
+	}
+	switch {
+	case respErr.StatusCode == http.StatusRequestTimeout,
+		respErr.StatusCode == http.StatusTooManyRequests,
+		respErr.StatusCode >= 500 && respErr.StatusCode != http.StatusInsufficientStorage:
+		return true
+	}
+	return false
+}
+
+func (u *Uploader) concurrency() int {
+	if u.Concurrency <= 0 {
+		return 1
+	}
+	return u.Concurrency
+}
+
+func (u *Uploader) chunkSize() int64 {
+	if u.ChunkSize <= 0 {
+		return DefaultChunkSize
+	}
+	return u.ChunkSize
+}
+
+func (u *Uploader) uploadIdentifierHeader() string {
+	if u.UploadIdentifierHeader == "" {
+		return assemble.DefaultUploadIdentifierHeader
+	}
+	return u.UploadIdentifierHeader
+}
+
+func (u *Uploader) chunkIdentifierHeader() string {
+	if u.ChunkIdentifierHeader == "" {
+		return assemble.DefaultChunkIdentifierHeader
+	}
+	return u.ChunkIdentifierHeader
+}
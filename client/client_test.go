@@ -0,0 +1,375 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dchenz/go-assemble"
+)
+
+// testServer runs an assembler and records the completed files passed
+// downstream.
+type testServer struct {
+	*httptest.Server
+	a *assemble.FileChunksAssembler
+
+	lock      sync.Mutex
+	completed [][]byte
+}
+
+// newTestServer starts a server with the start endpoint at /start, the
+// chunks endpoint at /chunks and the exists endpoint at /exists. wrap, if
+// not nil, wraps the chunks endpoint.
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, &assemble.AssemblerConfig{}, wrap)
+}
+
+// newTestServerWithConfig is like newTestServer with an assembler
+// configured by config, whose directories are temporary.
+func newTestServerWithConfig(t *testing.T, config *assemble.AssemblerConfig, wrap func(http.Handler) http.Handler) *testServer {
+	t.Helper()
+	config.ChunksDir = t.TempDir()
+	config.CompletedDir = t.TempDir()
+	s := &testServer{a: assemble.NewFileChunksAssembler(config)}
+	var chunks http.Handler = s.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading completed file: %v", err)
+		}
+		s.lock.Lock()
+		s.completed = append(s.completed, body)
+		s.lock.Unlock()
+	}))
+	if wrap != nil {
+		chunks = wrap(chunks)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", s.a.UploadStartHandler)
+	mux.Handle("/chunks", chunks)
+	mux.HandleFunc("/exists", s.a.ExistsHandler)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(func() {
+		s.Close()
+		_ = s.a.Close()
+	})
+	return s
+}
+
+func (s *testServer) uploader() *Uploader {
+	u := NewUploader(s.URL+"/start", s.URL+"/chunks")
+	u.ExistsURL = s.URL + "/exists"
+	u.RetryBackoff = time.Millisecond
+	return u
+}
+
+func (s *testServer) completedFiles() [][]byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([][]byte(nil), s.completed...)
+}
+
+// testFile returns size bytes that differ between chunks.
+func testFile(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestUpload(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.uploader()
+	u.ChunkSize = 10
+	progress := make(chan Progress, 10)
+	u.Progress = progress
+	data := testFile(35)
+
+	result, err := u.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), map[string]interface{}{"type": "application/octet-stream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Hash == "" {
+		t.Error("got no hash for the completed file")
+	}
+	files := s.completedFiles()
+	if len(files) != 1 || !bytes.Equal(files[0], data) {
+		t.Fatalf("got completed files %v, want the uploaded file", files)
+	}
+	close(progress)
+	var chunks []int64
+	for p := range progress {
+		if p.UploadID != result.UploadID || p.TotalChunks != 4 || p.TotalBytes != 35 {
+			t.Errorf("got progress %+v", p)
+		}
+		chunks = append(chunks, p.Chunks)
+	}
+	if len(chunks) != 4 || chunks[3] != 4 {
+		t.Errorf("got progress of chunks %v, want 1 to 4", chunks)
+	}
+}
+
+func TestUploadEmptyFile(t *testing.T) {
+	s := newTestServer(t, nil)
+	if _, err := s.uploader().Upload(context.Background(), bytes.NewReader(nil), 0, nil); !errors.Is(err, errEmptyFile) {
+		t.Errorf("got error %v, want %v", err, errEmptyFile)
+	}
+}
+
+func TestUploadShortReader(t *testing.T) {
+	s := newTestServer(t, nil)
+	u := s.uploader()
+	u.ChunkSize = 10
+	if _, err := u.Upload(context.Background(), bytes.NewReader(testFile(15)), 25, nil); err == nil {
+		t.Error("uploading a file shorter than its size succeeded")
+	}
+	if files := s.completedFiles(); len(files) != 0 {
+		t.Errorf("got %d completed files", len(files))
+	}
+}
+
+// failFirst responds to the first attempt at each chunk with status.
+func failFirst(status int) func(http.Handler) http.Handler {
+	var lock sync.Mutex
+	failed := make(map[string]bool)
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seq := r.Header.Get(assemble.DefaultChunkIdentifierHeader)
+			lock.Lock()
+			fail := !failed[seq]
+			failed[seq] = true
+			lock.Unlock()
+			if fail {
+				w.WriteHeader(status)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	s := newTestServer(t, failFirst(http.StatusServiceUnavailable))
+	u := s.uploader()
+	u.ChunkSize = 10
+	data := testFile(25)
+	if _, err := u.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if files := s.completedFiles(); len(files) != 1 || !bytes.Equal(files[0], data) {
+		t.Errorf("got completed files %v, want the uploaded file", files)
+	}
+}
+
+func TestUploadDoesntRetryRejections(t *testing.T) {
+	s := newTestServer(t, failFirst(http.StatusBadRequest))
+	u := s.uploader()
+	u.ChunkSize = 10
+	_, err := u.Upload(context.Background(), bytes.NewReader(testFile(25)), 25, nil)
+	var respErr *Error
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got error %v, want a %d response", err, http.StatusBadRequest)
+	}
+}
+
+func TestUploadRetryLimit(t *testing.T) {
+	attempts := 0
+	s := newTestServer(t, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	})
+	u := s.uploader()
+	u.MaxRetries = 2
+	_, err := u.Upload(context.Background(), bytes.NewReader(testFile(5)), 5, nil)
+	var respErr *Error
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got error %v, want a %d response", err, http.StatusBadGateway)
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, want 3", attempts)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for status, want := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusRequestTimeout:      true,
+		http.StatusConflict:            false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusInsufficientStorage: false,
+	} {
+		if got := retryable(&Error{StatusCode: status}); got != want {
+			t.Errorf("%d: got %v, want %v", status, got, want)
+		}
+	}
+	if !retryable(errors.New("connection reset")) {
+		t.Error("network errors aren't retried")
+	}
+}
+
+func TestConcurrentUpload(t *testing.T) {
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	s := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			lock.Unlock()
+			time.Sleep(time.Millisecond)
+			h.ServeHTTP(w, r)
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+		})
+	})
+	u := s.uploader()
+	u.ChunkSize = 4096
+	u.Concurrency = 4
+	data := testFile(100*4096 + 123)
+	if _, err := u.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if files := s.completedFiles(); len(files) != 1 || !bytes.Equal(files[0], data) {
+		t.Fatal("the completed file doesn't match the uploaded file")
+	}
+	if maxInFlight < 2 || maxInFlight > 4 {
+		t.Errorf("got up to %d chunks sent at once, want 2 to 4", maxInFlight)
+	}
+}
+
+func TestConcurrentUploadCompletesBeforeEarlierResponses(t *testing.T) {
+	const totalChunks = 5
+	othersDone := make(chan struct{})
+	var lock sync.Mutex
+	others := 0
+	s := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if r.Header.Get(assemble.DefaultChunkIdentifierHeader) == "0" {
+				// Chunk 0 is stored first, but its response arrives after
+				// the response that completed the upload.
+				select {
+				case <-othersDone:
+				case <-time.After(5 * time.Second):
+					t.Error("the other chunks weren't sent")
+				}
+			} else {
+				lock.Lock()
+				others++
+				if others == totalChunks-1 {
+					close(othersDone)
+				}
+				lock.Unlock()
+			}
+			for name, values := range rec.Header() {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(rec.Code)
+			_, _ = w.Write(rec.Body.Bytes())
+		})
+	})
+	u := s.uploader()
+	u.ChunkSize = 10
+	u.Concurrency = totalChunks
+	data := testFile(totalChunks * 10)
+	result, err := u.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Hash == "" {
+		t.Error("got the result of an incomplete response")
+	}
+	if files := s.completedFiles(); len(files) != 1 || !bytes.Equal(files[0], data) {
+		t.Fatal("the completed file doesn't match the uploaded file")
+	}
+}
+
+func TestConcurrentUploadStopsOnError(t *testing.T) {
+	var lock sync.Mutex
+	sent := 0
+	s := newTestServer(t, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			sent++
+			lock.Unlock()
+			if r.Header.Get(assemble.DefaultChunkIdentifierHeader) == "3" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	u := s.uploader()
+	u.ChunkSize = 10
+	u.Concurrency = 2
+	data := testFile(1000)
+	_, err := u.Upload(context.Background(), bytes.NewReader(data), int64(len(data)), nil)
+	var respErr *Error
+	if !errors.As(err, &respErr) || respErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got error %v, want a %d response", err, http.StatusBadRequest)
+	}
+	// The workers stop soon after the failure instead of sending every
+	// chunk.
+	lock.Lock()
+	defer lock.Unlock()
+	if sent >= 100 {
+		t.Errorf("sent %d chunks after chunk 3 failed", sent)
+	}
+}
+
+func TestUploadIfNew(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+	default:
+		t.Skip("deduplication isn't supported")
+	}
+	s := newTestServerWithConfig(t, &assemble.AssemblerConfig{DeduplicateCompletedFiles: true}, nil)
+	u := s.uploader()
+	u.ChunkSize = 10
+	data := testFile(25)
+
+	// The file is hashed from the reader's position, and rewound to it to
+	// be uploaded.
+	r := bytes.NewReader(append([]byte("skipped"), data...))
+	if _, err := r.Seek(int64(len("skipped")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.UploadIfNew(context.Background(), r, int64(len(data)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.UploadIfNew(context.Background(), bytes.NewReader(data), int64(len(data)), nil); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("got error %v uploading the file again, want %v", err, ErrAlreadyExists)
+	}
+	files := s.completedFiles()
+	if len(files) != 1 || !bytes.Equal(files[0], data) {
+		t.Fatalf("got completed files %v, want the file once", files)
+	}
+
+	sum := sha256.Sum256(data)
+	if exists, err := u.Exists(context.Background(), hex.EncodeToString(sum[:])); err != nil || !exists {
+		t.Errorf("Exists of the uploaded file: got %v, %v", exists, err)
+	}
+	sum = sha256.Sum256([]byte("other"))
+	if exists, err := u.Exists(context.Background(), hex.EncodeToString(sum[:])); err != nil || exists {
+		t.Errorf("Exists of another file: got %v, %v", exists, err)
+	}
+}
@@ -0,0 +1,15 @@
+package assemble
+
+import "time"
+
+// Clock tells the time, e.g. a fake clock in tests of upload expiry.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
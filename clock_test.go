@@ -0,0 +1,38 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{Clock: clock, CompletedUploadTTL: time.Minute})
+	start := clock.Now()
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	info, err := ta.a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.CreatedAt.Equal(start) {
+		t.Errorf("got CreatedAt %v, want the clock's time %v", info.CreatedAt, start)
+	}
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+
+	// The completion is remembered until the clock passes its TTL.
+	clock.Advance(59 * time.Second)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	clock.Advance(2 * time.Second)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusBadRequest)
+	if files := ta.completedFiles(); len(files) != 1 {
+		t.Errorf("got %d completed files, want 1", len(files))
+	}
+}
+
+func TestDefaultClock(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	if _, ok := ta.a.Config.Clock.(systemClock); !ok {
+		t.Errorf("got default clock %T", ta.a.Config.Clock)
+	}
+}
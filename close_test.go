@@ -0,0 +1,71 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var errCloseFailed = errors.New("close failed")
+
+// closingStore counts the times it's closed.
+type closingStore struct {
+	*MemoryStore
+	closes int
+	err    error
+}
+
+func (s *closingStore) Close() error {
+	s.closes++
+	return s.err
+}
+
+type closingTracker struct {
+	*MemoryTracker
+	closes int
+	err    error
+}
+
+func (t *closingTracker) Close() error {
+	t.closes++
+	return t.err
+}
+
+func TestCloseClosesStoreAndTracker(t *testing.T) {
+	store := &closingStore{MemoryStore: NewMemoryStore(), err: errCloseFailed}
+	tracker := &closingTracker{MemoryTracker: NewMemoryTracker(), err: errors.New("tracker close failed")}
+	a := NewFileChunksAssembler(&AssemblerConfig{Store: store, Tracker: tracker})
+	// The first error is returned, but the tracker is still closed.
+	if err := a.Close(); !errors.Is(err, errCloseFailed) {
+		t.Errorf("got error %v, want %v", err, errCloseFailed)
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("closing again: got error %v", err)
+	}
+	if store.closes != 1 || tracker.closes != 1 {
+		t.Errorf("store closed %d times and tracker %d times, want once each", store.closes, tracker.closes)
+	}
+}
+
+func TestHandlersAfterClose(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if err := ta.a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"start":  ta.start(`{"total_chunks": 1}`, nil),
+		"chunk":  ta.send(uploadID, 1, "b", nil),
+		"status": ta.status(uploadID),
+		"abort":  ta.abort(uploadID),
+	} {
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s: got %d, want %d", name, rec.Code, http.StatusServiceUnavailable)
+		}
+	}
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Errorf("got completed files %q after closing", files)
+	}
+}
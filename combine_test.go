@@ -0,0 +1,118 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// writeSizes records the sizes of writes.
+type writeSizes struct {
+	total   int
+	largest int
+}
+
+func (w *writeSizes) Write(p []byte) (int, error) {
+	w.total += len(p)
+	if len(p) > w.largest {
+		w.largest = len(p)
+	}
+	return len(p), nil
+}
+
+func TestCombineStreamsChunks(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	chunk := bytes.Repeat([]byte("x"), 10*DefaultCombineBufferSize+1)
+	for seq := int64(0); seq < 2; seq++ {
+		if err := store.WriteChunk("1", seq, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var sizes writeSizes
+	if _, err := store.Finalize(context.Background(), "1", "file", 2, &sizes); err != nil {
+		t.Fatal(err)
+	}
+	if sizes.total != 2*len(chunk) {
+		t.Errorf("got %d bytes, want %d", sizes.total, 2*len(chunk))
+	}
+	// Chunks are copied a buffer at a time instead of being read whole.
+	if sizes.largest > DefaultCombineBufferSize {
+		t.Errorf("got a write of %d bytes, more than the buffer size %d", sizes.largest, DefaultCombineBufferSize)
+	}
+}
+
+func TestCombineBufferSize(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.CombineBufferSize = 1000
+	writeChunks(t, store, "1", string(bytes.Repeat([]byte("x"), 4500)))
+	var sizes writeSizes
+	if _, err := store.Finalize(context.Background(), "1", "file", 1, &sizes); err != nil {
+		t.Fatal(err)
+	}
+	if sizes.total != 4500 || sizes.largest != 1000 {
+		t.Errorf("got %d bytes in writes of up to %d, want 4500 in writes of 1000", sizes.total, sizes.largest)
+	}
+}
+
+func TestCombineBufferSizeConfig(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CombineBufferSize: 1000})
+	if store := ta.a.Config.Store.(*FilesystemStore); store.CombineBufferSize != 1000 {
+		t.Errorf("got buffer size %d, want 1000", store.CombineBufferSize)
+	}
+	ta = newTestAssembler(t, nil)
+	if store := ta.a.Config.Store.(*FilesystemStore); store.CombineBufferSize != DefaultCombineBufferSize {
+		t.Errorf("got default buffer size %d, want %d", store.CombineBufferSize, DefaultCombineBufferSize)
+	}
+}
+
+// benchmarkCombine measures combining chunks of chunkSize bytes with store.
+// Finalize leaves the chunks in place, so they're only written once.
+func benchmarkCombine(b *testing.B, store *FilesystemStore, chunks int64, chunkSize int) {
+	chunk := bytes.Repeat([]byte("x"), chunkSize)
+	for seq := int64(0); seq < chunks; seq++ {
+		if err := store.WriteChunk("1", seq, chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.SetBytes(chunks * int64(chunkSize))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.Finalize(context.Background(), "1", "file", chunks, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Allocations per combine stay the same as chunks get larger.
+func BenchmarkCombineLargeChunks(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		bytes int
+	}{
+		{"1M", 1 << 20},
+		{"8M", 8 << 20},
+		{"32M", 32 << 20},
+	} {
+		b.Run(size.name, func(b *testing.B) {
+			benchmarkCombine(b, NewFilesystemStore(b.TempDir(), b.TempDir()), 4, size.bytes)
+		})
+	}
+}
+
+func BenchmarkCombine(b *testing.B) {
+	for _, size := range []struct {
+		name  string
+		bytes int
+	}{
+		{"4K", 4 << 10},
+		{"32K", 32 << 10},
+		{"1M", 1 << 20},
+	} {
+		b.Run(size.name, func(b *testing.B) {
+			store := NewFilesystemStore(b.TempDir(), b.TempDir())
+			store.CombineBufferSize = size.bytes
+			benchmarkCombine(b, store, 64, 64<<10)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package assemble
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFinalChunkCombinedOnce(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedUploadTTL: time.Hour})
+	for i := 0; i < 10; i++ {
+		uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+		ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+		var wg sync.WaitGroup
+		for j := 0; j < 20; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if rec := ta.send(uploadID, 1, "b", nil); rec.Code != http.StatusOK {
+					t.Errorf("got %d %s", rec.Code, rec.Body.String())
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	if files := ta.completedFiles(); len(files) != 10 {
+		t.Errorf("got %d completed files from 10 uploads", len(files))
+	}
+}
+
+// racyTracker lets every caller claim the completion of an upload, like a
+// tracker whose claim isn't atomic.
+type racyTracker struct {
+	*MemoryTracker
+}
+
+func (racyTracker) ClaimCompletion(uploadID int64) (bool, error) {
+	return true, nil
+}
+
+func TestClaimCompletionWhileCombining(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Tracker: racyTracker{NewMemoryTracker()}})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	if claimed, err := ta.a.claimCompletion(uploadID); err != nil || !claimed {
+		t.Fatalf("got %v, %v claiming the upload", claimed, err)
+	}
+	if claimed, err := ta.a.claimCompletion(uploadID); err != nil || claimed {
+		t.Errorf("got %v, %v claiming the upload while it's being combined", claimed, err)
+	}
+}
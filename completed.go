@@ -0,0 +1,71 @@
+package assemble
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers of progress updates, so clients can tell whether an upload is
+// complete without parsing the body. FileHashHeader is only set once the
+// completed file's hash has been computed.
+const (
+	CompleteHeader = "x-assemble-complete"
+	FileHashHeader = "x-assemble-file-hash"
+)
+
+// completedUpload is the final progress update of an upload, which is sent
+// again if a chunk of the upload is re-sent after it completed. The key
+// fingerprint is kept since the upload's info is gone by then, and the
+// update is only sent to requests with the upload's key.
+type completedUpload struct {
+	status         int
+	response       ProgressInfo
+	keyFingerprint string
+	expires        time.Time
+}
+
+func (a *FileChunksAssembler) rememberCompletion(uploadID int64, info UploadInfo, status int, response ProgressInfo) {
+	if a.Config.CompletedUploadTTL <= 0 {
+		return
+	}
+	a.completions.Store(uploadID, completedUpload{
+		status:         status,
+		response:       response,
+		keyFingerprint: info.KeyFingerprint,
+		expires:        a.Config.Clock.Now().Add(a.Config.CompletedUploadTTL),
+	})
+}
+
+func (a *FileChunksAssembler) getCompletion(uploadID int64) (completedUpload, bool) {
+	v, ok := a.completions.Load(uploadID)
+	if !ok {
+		return completedUpload{}, false
+	}
+	completed := v.(completedUpload)
+	if a.Config.Clock.Now().After(completed.expires) {
+		a.completions.Delete(uploadID)
+		return completedUpload{}, false
+	}
+	return completed, true
+}
+
+// forgetCompletions removes completed uploads that have expired.
+func (a *FileChunksAssembler) forgetCompletions(now time.Time) {
+	a.completions.Range(func(key, value interface{}) bool {
+		if now.After(value.(completedUpload).expires) {
+			a.completions.Delete(key)
+		}
+		return true
+	})
+}
+
+func (a *FileChunksAssembler) writeProgress(w http.ResponseWriter, status int, response ProgressInfo) {
+	response.Status = status
+	response.fieldNames = a.Config.ProgressFieldNames
+	w.Header().Set(CompleteHeader, strconv.FormatBool(response.Complete))
+	if response.FileHash != "" {
+		w.Header().Set(FileHashHeader, response.FileHash)
+	}
+	a.Config.ResponseEncoder(w, response)
+}
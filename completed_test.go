@@ -0,0 +1,72 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestResentFinalChunk(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedUploadTTL: time.Hour})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	first := ta.send(uploadID, 1, "b", nil)
+	if first.Code != http.StatusOK {
+		t.Fatalf("got %d %s", first.Code, first.Body.String())
+	}
+
+	// Any chunk re-sent after completion gets the same response without
+	// combining the chunks again.
+	for _, seq := range []int64{1, 0} {
+		again := ta.send(uploadID, seq, "x", nil)
+		if again.Code != first.Code || again.Body.String() != first.Body.String() {
+			t.Errorf("chunk %d: got %d %s, want %d %s", seq, again.Code, again.Body.String(), first.Code, first.Body.String())
+		}
+		if got := again.Header().Get(CompleteHeader); got != "true" {
+			t.Errorf("chunk %d: got %s %q, want true", seq, CompleteHeader, got)
+		}
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+}
+
+func TestResentFinalChunkNotRemembered(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	rec := ta.send(uploadID, 0, "a", nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+	}
+	if got := errorBody(t, rec); got != ErrUploadNotFound.Error() {
+		t.Errorf("got error %q, want %q", got, ErrUploadNotFound)
+	}
+	if files := ta.completedFiles(); len(files) != 1 {
+		t.Errorf("got %d completed files, want 1", len(files))
+	}
+}
+
+func TestSweepForgetsCompletions(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{Clock: clock, CompletedUploadTTL: time.Minute})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if _, err := ta.a.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ta.a.completions.Load(uploadID); !ok {
+		t.Fatal("Sweep forgot a completion that hasn't expired")
+	}
+	clock.Advance(2 * time.Minute)
+	if _, err := ta.a.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ta.a.completions.Load(uploadID); ok {
+		t.Error("Sweep didn't forget an expired completion")
+	}
+	if _, err := ta.a.Config.Tracker.GetUpload(uploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("got error %v, want %v", err, ErrUploadNotFound)
+	}
+}
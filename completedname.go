@@ -0,0 +1,149 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Gives up on finding a free name after this many attempts.
+const maxCompletedNameAttempts = 1000
+
+// ExistingCompletedPolicy decides what happens when an upload completes
+// and a completed file with the same name already exists.
+type ExistingCompletedPolicy string
+
+const (
+	// Replace the existing file. An upload that completes while another one
+	// is writing a file with the same name waits for it to finish first.
+	ExistingCompletedOverwrite ExistingCompletedPolicy = "overwrite"
+
+	// Reject the upload with HTTP 409 and keep the existing file.
+	ExistingCompletedError ExistingCompletedPolicy = "error"
+
+	// Add a number to the name of the new file, e.g. "report (1).pdf".
+	ExistingCompletedVersion ExistingCompletedPolicy = "version"
+)
+
+var errCompletedFileExists = errors.New("a completed file with the same name already exists")
+
+func (policy ExistingCompletedPolicy) validate() error {
+	switch policy {
+	case "", ExistingCompletedOverwrite, ExistingCompletedError, ExistingCompletedVersion:
+		return nil
+	}
+	return fmt.Errorf("unsupported existing completed file policy: %s", policy)
+}
+
+// completedName returns the name of the completed file of an upload.
+func (a *FileChunksAssembler) completedName(fileID string, info UploadInfo) string {
+	if a.Config.CompletedNamer != nil {
+		if name := a.Config.CompletedNamer(fileID, info.Metadata); name != "" {
+			return name
+		}
+		return fileID
+	}
+	if a.Config.NameCompletedFiles {
+		if name, ok := info.Metadata["name"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return fileID
+}
+
+// completedLocation returns the location of a completed file that is
+// given to clients.
+func (a *FileChunksAssembler) completedLocation(combined combinedFile) (string, error) {
+	if combined.processed {
+		return combined.location, nil
+	}
+	if locator, ok := a.Config.Store.(Locator); ok {
+		return locator.Locate(combined.name)
+	}
+	return combined.location, nil
+}
+
+// reserveCompletedName returns a name for the completed file of an upload
+// according to OnExistingCompleted, and a function that must be called once
+// the file has been written. With ExistingCompletedOverwrite, it waits for
+// another upload writing a file with the same name to finish, unless ctx is
+// done first. Otherwise names being written by another upload are treated
+// as existing files.
+func (a *FileChunksAssembler) reserveCompletedName(ctx context.Context, fileID string, info UploadInfo) (string, func(), error) {
+	name := a.completedName(fileID, info)
+	policy := a.Config.OnExistingCompleted
+	if policy == "" {
+		// Completed files named after the upload ID are replaced like
+		// before names could be chosen.
+		policy = ExistingCompletedVersion
+		if name == fileID {
+			policy = ExistingCompletedOverwrite
+		}
+	}
+	if policy != ExistingCompletedVersion {
+		var release func()
+		for {
+			var released <-chan struct{}
+			release, released = a.reserveName(name)
+			if release != nil {
+				break
+			}
+			if policy == ExistingCompletedError {
+				return "", nil, errCompletedFileExists
+			}
+			select {
+			case <-released:
+			case <-ctx.Done():
+				return "", nil, ctx.Err()
+			}
+		}
+		if policy == ExistingCompletedError && a.completedExists(name) {
+			release()
+			return "", nil, errCompletedFileExists
+		}
+		return name, release, nil
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < maxCompletedNameAttempts; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+		}
+		release, _ := a.reserveName(candidate)
+		if release == nil {
+			continue
+		}
+		if a.completedExists(candidate) {
+			release()
+			continue
+		}
+		return candidate, release, nil
+	}
+	return "", nil, fmt.Errorf("no free name for completed file %q", name)
+}
+
+// reserveName reserves a completed file name, and returns a function that
+// releases it. If the name is already reserved, it returns a nil function
+// and a channel that is closed once the name is released.
+func (a *FileChunksAssembler) reserveName(name string) (func(), <-chan struct{}) {
+	released := make(chan struct{})
+	if existing, reserved := a.completedNames.LoadOrStore(name, released); reserved {
+		return nil, existing.(chan struct{})
+	}
+	return func() {
+		a.completedNames.Delete(name)
+		close(released)
+	}, nil
+}
+
+func (a *FileChunksAssembler) completedExists(name string) bool {
+	f, _, err := a.Config.Store.OpenCompleted(name)
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	return true
+}
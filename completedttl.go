@@ -0,0 +1,114 @@
+package assemble
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errCompletedTTLUnsupported = errors.New("CompletedFileTTL needs a store that implements CompletedSweeper")
+
+// DeleteCompletedBefore deletes completed files last written before the
+// given time and returns how many were deleted. Files that are still being
+// written, and the partial files kept by ResumeCombine, are left alone.
+// Blobs of deduplicated files are deleted once no completed file links to
+// them.
+func (s *FilesystemStore) DeleteCompletedBefore(before time.Time) (int, error) {
+	blobs := path.Join(s.CompletedDir, blobsDir)
+	deleted := 0
+	err := filepath.WalkDir(s.CompletedDir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if p == blobs {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".partial") || strings.HasSuffix(p, ".partial"+partialOwnerExt) || strings.HasSuffix(p, ".dedup") {
+			return nil
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		deleted++
+		return nil
+	})
+	if err != nil {
+		return deleted, err
+	}
+	if s.Deduplicate {
+		err = s.removeUnlinkedBlobs()
+	}
+	return deleted, err
+}
+
+// removeUnlinkedBlobs deletes blobs that no completed file links to. A blob
+// deleted while an upload is linking to it is recreated by deduplicate.
+func (s *FilesystemStore) removeUnlinkedBlobs() error {
+	blobs := path.Join(s.CompletedDir, blobsDir)
+	entries, err := os.ReadDir(blobs)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if linkCount(info) == 1 {
+			if err := os.Remove(path.Join(blobs, entry.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sweepCompleted deletes completed files older than CompletedFileTTL.
+func (a *FileChunksAssembler) sweepCompleted(now time.Time) error {
+	if a.Config.CompletedFileTTL <= 0 {
+		return nil
+	}
+	deleted, err := a.Config.Store.(CompletedSweeper).DeleteCompletedBefore(now.Add(-a.Config.CompletedFileTTL))
+	if deleted > 0 {
+		a.Config.Logger.Info("deleted expired completed files", "count", deleted)
+	}
+	return err
+}
+
+// deleteAfterDownstream deletes a completed file once the downstream
+// handler has returned, for AutoDeleteAfterDownstream. The file must have
+// been closed.
+func (a *FileChunksAssembler) deleteAfterDownstream(uploadID int64, combined combinedFile) {
+	var err error
+	if combined.processed {
+		err = os.Remove(combined.location)
+	} else {
+		err = a.Config.Store.DeleteCompleted(combined.name)
+	}
+	if err != nil {
+		a.Config.Logger.Error("failed to delete completed file", "upload_id", uploadID, "error", err)
+	}
+}
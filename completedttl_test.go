@@ -0,0 +1,81 @@
+package assemble
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompletedFileTTL(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedFileTTL: time.Hour})
+	old := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(old, 0, "old", nil, http.StatusOK)
+	recent := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(recent, 0, "recent", nil, http.StatusOK)
+
+	written := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(ta.a.Config.CompletedDir, ta.a.fileID(old)), written, written); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ta.a.Sweep(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{ta.a.fileID(recent): "recent"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v after sweeping, want %v", files, want)
+	}
+}
+
+func TestCompletedFileTTLUnsupported(t *testing.T) {
+	defer func() {
+		if recover() != errCompletedTTLUnsupported {
+			t.Error("CompletedFileTTL was accepted with a store that can't sweep")
+		}
+	}()
+	NewFileChunksAssembler(&AssemblerConfig{
+		Store:            NewMemoryStore(),
+		CompletedFileTTL: time.Hour,
+	})
+}
+
+func TestAutoDeleteAfterDownstream(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AutoDeleteAfterDownstream: true})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "hello ", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "world", nil, http.StatusOK)
+	// The handler read the whole file before it was deleted.
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"hello world"}) {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got completed files %v after the handler returned", files)
+	}
+}
+
+func TestAutoDeleteKeepsRejectedFiles(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AutoDeleteAfterDownstream: true})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		RejectFile(r, http.StatusForbidden, "kept for review")
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "suspicious", nil, http.StatusForbidden)
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{ta.a.fileID(uploadID): "suspicious"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
@@ -0,0 +1,33 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCompleteHeader(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedFileHashAlgorithm: ChecksumSHA256})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	for seq, body := range []string{"hello", " "} {
+		rec := ta.send(uploadID, int64(seq), body, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get(CompleteHeader); got != "false" {
+			t.Errorf("chunk %d: got %s %q, want false", seq, CompleteHeader, got)
+		}
+		if got := rec.Header().Get(FileHashHeader); got != "" {
+			t.Errorf("chunk %d: got %s %q before completion", seq, FileHashHeader, got)
+		}
+	}
+	rec := ta.send(uploadID, 2, "world", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("final chunk: got %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get(CompleteHeader); got != "true" {
+		t.Errorf("final chunk: got %s %q, want true", CompleteHeader, got)
+	}
+	if got, want := rec.Header().Get(FileHashHeader), sha256Hex("hello world"); got != want {
+		t.Errorf("final chunk: got %s %q, want %q", FileHashHeader, got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaxConcurrentUploads(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxConcurrentUploads: 2})
+	first := ta.startUpload(`{"total_chunks": 1}`, nil)
+	second := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec := ta.start(`{"total_chunks": 1}`, nil)
+	if rec.Code != http.StatusTooManyRequests || errorBody(t, rec) != errTooManyUploads.Error() {
+		t.Fatalf("third upload: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// Completed and aborted uploads free their slots.
+	ta.mustSend(first, 0, "a", nil, http.StatusOK)
+	ta.startUpload(`{"total_chunks": 1}`, nil)
+	if err := ta.a.Abort(second); err != nil {
+		t.Fatal(err)
+	}
+	ta.startUpload(`{"total_chunks": 1}`, nil)
+	if rec := ta.start(`{"total_chunks": 1}`, nil); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("upload over the limit: got %d %s", rec.Code, rec.Body.String())
+	}
+}
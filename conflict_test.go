@@ -0,0 +1,33 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRejectChunkOverwrite(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{RejectChunkOverwrite: true})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	rec := ta.send(uploadID, 0, "corrupted", nil)
+	if rec.Code != http.StatusConflict || errorBody(t, rec) != errChunkConflict.Error() {
+		t.Errorf("conflicting chunk: got %d %s", rec.Code, rec.Body.String())
+	}
+	// Retries with the same contents are fine.
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestChunkOverwriteAllowedByDefault(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "x", nil, http.StatusOK)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
@@ -0,0 +1,83 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// controlHeader is the JSON sent in ControlHeader.
+type controlHeader struct {
+	ID          controlNumber `json:"id"`
+	Seq         controlNumber `json:"seq"`
+	Total       controlNumber `json:"total"`
+	ContentType string        `json:"contentType"`
+}
+
+// controlNumber is an integer that can also be sent as a string, since
+// JavaScript can't represent every upload ID as a number.
+type controlNumber string
+
+func (n *controlNumber) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*n = controlNumber(s)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return err
+	}
+	*n = controlNumber(number)
+	return nil
+}
+
+// controlHeader returns the contents of ControlHeader, which are empty if
+// it isn't set.
+func (a *FileChunksAssembler) controlHeader(r *http.Request) (controlHeader, error) {
+	var control controlHeader
+	if a.Config.ControlHeader == "" {
+		return control, nil
+	}
+	value := r.Header.Get(a.Config.ControlHeader)
+	if value == "" {
+		return control, nil
+	}
+	if err := json.Unmarshal([]byte(value), &control); err != nil {
+		return controlHeader{}, &requestError{kind: ErrInvalidControlHeader, err: fmt.Errorf("invalid %s: %v", a.Config.ControlHeader, err)}
+	}
+	return control, nil
+}
+
+// setControlTotal sets the number of chunks of an upload from the control
+// header, like setFinalChunk. If the number is already known, it has to
+// agree.
+func (a *FileChunksAssembler) setControlTotal(uploadID int64, total controlNumber, info *UploadInfo) error {
+	totalChunks, err := strconv.ParseInt(string(total), 10, 64)
+	if err != nil || totalChunks <= 0 {
+		return ErrInvalidChunkTotal
+	}
+	if a.Config.MaxChunkTotal > 0 && totalChunks > a.Config.MaxChunkTotal {
+		return errTooManyChunks
+	}
+	if info.TotalChunks > 0 {
+		if totalChunks != info.TotalChunks {
+			return &chunkQuantityError{expected: info.TotalChunks, declared: totalChunks}
+		}
+		return nil
+	}
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		return err
+	}
+	if len(received) > 0 && received[len(received)-1] >= totalChunks {
+		return &requestError{kind: ErrInvalidChunkTotal, err: fmt.Errorf("chunk %d was already received", a.toClientSeq(received[len(received)-1]))}
+	}
+	info.TotalChunks = totalChunks
+	return a.setTotalChunks(uploadID, *info)
+}
@@ -0,0 +1,101 @@
+package assemble
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testControlHeader = "x-assemble-control"
+
+// sendControl sends a chunk whose IDs are only in the control header.
+func (ta *testAssembler) sendControl(control string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/parts", bytes.NewBufferString(body))
+	req.Header.Set(testControlHeader, control)
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestControlHeader(t *testing.T) {
+	var contentType, control string
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		control = r.Header.Get(testControlHeader)
+		ta.downstream.ServeHTTP(w, r)
+	}))
+	// The number of chunks can come from the control header alone.
+	uploadID := ta.startUpload(`{}`, nil)
+	// IDs can be numbers or strings.
+	for i, header := range []string{
+		fmt.Sprintf(`{"id": "%d", "seq": 0, "total": 2, "contentType": "text/plain"}`, uploadID),
+		fmt.Sprintf(`{"id": %d, "seq": "1", "total": "2"}`, uploadID),
+	} {
+		if rec := ta.sendControl(header, []string{"hello ", "world"}[i]); rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: got %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"hello world"}) {
+		t.Errorf("got completed files %q", files)
+	}
+	if contentType != "text/plain" {
+		t.Errorf("got content type %q, want text/plain", contentType)
+	}
+	if control != "" {
+		t.Errorf("the control header %q was passed downstream", control)
+	}
+}
+
+func TestControlHeaderSeparateHeadersTakePrecedence(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	header := map[string]string{testControlHeader: `{"id": 1, "seq": 5}`}
+	ta.mustSend(uploadID, 0, "a", header, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", header, http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"ab"}) {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestControlHeaderTotalConflict(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	rec := ta.sendControl(fmt.Sprintf(`{"id": %d, "seq": 0, "total": 3}`, uploadID), "a")
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+}
+
+func TestMalformedControlHeader(t *testing.T) {
+	for name, control := range map[string]string{
+		"not JSON":     `id=%d`,
+		"truncated":    `{"id": %d, "seq": 0`,
+		"wrong type":   `{"id": [%d], "seq": 0}`,
+		"not a number": `{"id": %d, "seq": 0, "total": "two"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+			uploadID := ta.startUpload(`{}`, nil)
+			rec := ta.sendControl(fmt.Sprintf(control, uploadID), "a")
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+			}
+			if len(ta.completedFiles()) != 0 {
+				t.Error("a chunk with a malformed control header completed the upload")
+			}
+		})
+	}
+}
+
+func TestControlHeaderErrorNamesHeader(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	rec := ta.sendControl(`{`, "a")
+	if message := errorBody(t, rec); !strings.Contains(message, testControlHeader) {
+		t.Errorf("got error %q, want it to name %s", message, testControlHeader)
+	}
+}
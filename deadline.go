@@ -0,0 +1,34 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+)
+
+var errUploadExpired = errors.New("upload wasn't completed in time")
+
+// uploadExpired returns whether an upload has run past MaxUploadDuration.
+func (a *FileChunksAssembler) uploadExpired(info UploadInfo) bool {
+	return a.Config.MaxUploadDuration > 0 && a.Config.Clock.Now().Sub(info.CreatedAt) > a.Config.MaxUploadDuration
+}
+
+// expireUpload removes an upload that has run past MaxUploadDuration and
+// responds with HTTP 410. The response is remembered like the final
+// progress update of a completed upload, so chunks that are still in flight
+// get it too.
+func (a *FileChunksAssembler) expireUpload(w http.ResponseWriter, r *http.Request, uploadID int64, info UploadInfo) {
+	response := ProgressInfo{
+		ExpectedChunks: info.TotalChunks,
+		ExpectedBytes:  info.Size,
+	}
+	if progress, err := a.Config.Tracker.GetProgress(uploadID); err == nil {
+		response.CurrentChunks = progress.Chunks
+		response.ReceivedBytes = progress.Bytes
+	}
+	a.cleanupUpload(uploadID)
+	reason := errUploadExpired.Error()
+	a.rejectUpload(uploadID, reason)
+	response.RejectedError = &reason
+	a.rememberCompletion(uploadID, info, http.StatusGone, response)
+	a.writeProgress(w, http.StatusGone, response)
+}
@@ -0,0 +1,40 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaxUploadDuration(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{
+		MaxUploadDuration:  time.Minute,
+		CompletedUploadTTL: time.Hour,
+		Clock:              clock,
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	clock.Advance(50 * time.Second)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+
+	// Activity doesn't extend the deadline.
+	clock.Advance(20 * time.Second)
+	progress := ta.mustSend(uploadID, 2, "c", nil, http.StatusGone)
+	if progress.RejectedError == nil || *progress.RejectedError != errUploadExpired.Error() {
+		t.Errorf("got progress %+v", progress)
+	}
+	if progress.CurrentChunks != 2 {
+		t.Errorf("got %d chunks, want the 2 received before the deadline", progress.CurrentChunks)
+	}
+	if _, err := ta.a.Config.Tracker.GetUpload(uploadID); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("expired upload is still tracked: %v", err)
+	}
+	// Chunks in flight get the same response while the expiry is
+	// remembered.
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusGone)
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Errorf("got completed files %q", files)
+	}
+}
@@ -0,0 +1,22 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// checkDeclared rejects chunks that declare a different total size or
+// content type than their upload already has, since the client would then
+// be sending a different file. Values are recorded by the first request that
+// declares them, and chunks that don't declare them are accepted.
+func (a *FileChunksAssembler) checkDeclared(r *http.Request, info UploadInfo) error {
+	if size, err := a.totalSize(r); err == nil && size > 0 && info.Size > 0 && size != info.Size {
+		return fmt.Errorf("%w: upload has %d bytes but the chunk declares %d", ErrTotalSizeChange, info.Size, size)
+	}
+	declared, _ := a.headerMetadata(r)["type"].(string)
+	existing, _ := info.Metadata["type"].(string)
+	if declared != "" && existing != "" && declared != existing {
+		return fmt.Errorf("%w: upload has %q but the chunk declares %q", ErrContentTypeChange, existing, declared)
+	}
+	return nil
+}
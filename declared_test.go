@@ -0,0 +1,53 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestContentTypeChange(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	png := map[string]string{DefaultMetadataHeaderPrefix + "type": "image/png"}
+	ta.mustSend(uploadID, 0, "a", png, http.StatusOK)
+
+	rec := ta.send(uploadID, 1, "b", map[string]string{DefaultMetadataHeaderPrefix + "type": "image/jpeg"})
+	if rec.Code != http.StatusConflict || !strings.HasPrefix(errorBody(t, rec), ErrContentTypeChange.Error()) {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+	// The rejected chunk wasn't stored.
+	progress := ta.mustSend(uploadID, 1, "b", png, http.StatusOK)
+	if progress.CurrentChunks != 2 || progress.ReceivedBytes != 2 {
+		t.Errorf("got progress %+v", progress)
+	}
+	// Chunks that don't declare a type are accepted.
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abc" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestContentTypeChangeInControlHeader(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	uploadID := ta.startUpload(`{"total_chunks": 2, "metadata": {"type": "text/plain"}}`, nil)
+	rec := ta.sendControl(fmt.Sprintf(`{"id": %d, "seq": 0, "contentType": "text/html"}`, uploadID), "a")
+	if rec.Code != http.StatusConflict || !strings.HasPrefix(errorBody(t, rec), ErrContentTypeChange.Error()) {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+}
+
+func TestTotalSizeChange(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "ab", map[string]string{DefaultTotalSizeHeader: "4"}, http.StatusOK)
+	rec := ta.send(uploadID, 1, "cd", map[string]string{DefaultTotalSizeHeader: "5"})
+	if rec.Code != http.StatusConflict || !strings.HasPrefix(errorBody(t, rec), ErrTotalSizeChange.Error()) {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+	ta.mustSend(uploadID, 1, "cd", map[string]string{DefaultTotalSizeHeader: "4"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abcd" {
+		t.Errorf("got completed files %q", files)
+	}
+}
@@ -0,0 +1,127 @@
+package assemble
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// Completed files with the same contents are hard links to a file in this
+// directory of CompletedDir, named after the SHA256 of the contents.
+const blobsDir = ".blobs"
+
+// Attempts to link to a blob that is deleted concurrently.
+const dedupAttempts = 3
+
+var errDeduplicateUnsupported = errors.New("FilesystemStore.Deduplicate isn't supported on this platform")
+
+// deduplicate replaces a completed file with a link to an existing blob
+// with the same contents, or makes it the blob if there isn't one. Linking
+// fails if the blob exists, so only one of the uploads that finish with the
+// same contents at the same time creates it.
+func (s *FilesystemStore) deduplicate(completedFilePath string, sum []byte) error {
+	blobPath := path.Join(s.CompletedDir, blobsDir, hex.EncodeToString(sum))
+	if err := os.MkdirAll(path.Dir(blobPath), s.dirMode()); err != nil {
+		return err
+	}
+	for i := 0; i < dedupAttempts; i++ {
+		err := os.Link(completedFilePath, blobPath)
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		// The rename replaces the completed file atomically.
+		tmp := completedFilePath + ".dedup"
+		_ = os.Remove(tmp)
+		err = os.Link(blobPath, tmp)
+		if errors.Is(err, os.ErrNotExist) {
+			// The blob was deleted since linking to it failed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// Links share the blob's modification time, which CompletedFileTTL
+		// should count from the newest of them.
+		now := time.Now()
+		_ = os.Chtimes(tmp, now, now)
+		return os.Rename(tmp, completedFilePath)
+	}
+	return nil
+}
+
+// HasContent returns whether there is a completed file with the given
+// SHA256. The blobs are the index, so it's always false unless Deduplicate
+// is set.
+func (s *FilesystemStore) HasContent(sum []byte) (bool, error) {
+	if !s.Deduplicate {
+		return false, nil
+	}
+	_, err := os.Stat(path.Join(s.CompletedDir, blobsDir, hex.EncodeToString(sum)))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// removeDeduplicated removes a completed file, and its blob if no other
+// completed file links to it.
+func (s *FilesystemStore) removeDeduplicated(name string) error {
+	blob, err := s.soleBlob(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.completedFilePath(name)); err != nil {
+		return err
+	}
+	return blob.remove()
+}
+
+// dedupBlob is a blob that only one completed file links to.
+type dedupBlob struct {
+	path string
+	info os.FileInfo
+}
+
+// soleBlob returns the blob of a completed file if no other completed file
+// links to it, so that it can be removed along with the file. It must be
+// found before the file is removed, since it can only be found from the
+// contents.
+func (s *FilesystemStore) soleBlob(name string) (*dedupBlob, error) {
+	info, err := os.Stat(s.completedFilePath(name))
+	if err != nil {
+		return nil, err
+	}
+	if linkCount(info) != 2 {
+		return nil, nil
+	}
+	f, _, err := s.OpenCompleted(name)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.New()
+	_, err = io.Copy(sum, f)
+	_ = f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &dedupBlob{
+		path: path.Join(s.CompletedDir, blobsDir, hex.EncodeToString(sum.Sum(nil))),
+		info: info,
+	}, nil
+}
+
+// remove deletes the blob once its completed file is gone, unless another
+// completed file has linked to it since.
+func (b *dedupBlob) remove() error {
+	if b == nil {
+		return nil
+	}
+	if blob, err := os.Stat(b.path); err == nil && os.SameFile(blob, b.info) && linkCount(blob) == 1 {
+		return os.Remove(b.path)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package assemble
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newDedupStore(t *testing.T) *FilesystemStore {
+	t.Helper()
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.Deduplicate = true
+	return store
+}
+
+func finalizeChunks(t *testing.T, ctx context.Context, store *FilesystemStore, fileID string, name string, chunks ...string) error {
+	t.Helper()
+	for seq, chunk := range chunks {
+		if err := store.WriteChunk(fileID, int64(seq), []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := store.Finalize(ctx, fileID, name, int64(len(chunks)), nil)
+	return err
+}
+
+func readCompleted(t *testing.T, store *FilesystemStore, name string) string {
+	t.Helper()
+	f, _, err := store.OpenCompleted(name)
+	if err != nil {
+		t.Fatalf("opening %s: %v", name, err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func countBlobs(t *testing.T, store *FilesystemStore) int {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(store.CompletedDir, blobsDir))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
+
+func TestDeduplicateSharesBlobs(t *testing.T) {
+	if !linkCountSupported {
+		t.Skip("link counts aren't supported")
+	}
+	store := newDedupStore(t)
+	ctx := context.Background()
+	if err := finalizeChunks(t, ctx, store, "1", "a", "same ", "contents"); err != nil {
+		t.Fatal(err)
+	}
+	if err := finalizeChunks(t, ctx, store, "2", "b", "same contents"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, store); n != 1 {
+		t.Errorf("got %d blobs, want 1", n)
+	}
+	if err := store.DeleteCompleted("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompleted(t, store, "b"); got != "same contents" {
+		t.Errorf("got %q", got)
+	}
+	if err := store.DeleteCompleted("b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countBlobs(t, store); n != 0 {
+		t.Errorf("got %d blobs after deleting every file, want 0", n)
+	}
+}
+
+func TestDeduplicateKeepsFileWhenReplacingFails(t *testing.T) {
+	if !linkCountSupported {
+		t.Skip("link counts aren't supported")
+	}
+	store := newDedupStore(t)
+	if err := finalizeChunks(t, context.Background(), store, "1", "report", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := finalizeChunks(t, cancelled, store, "2", "report", "new"); err == nil {
+		t.Fatal("combining with a cancelled context succeeded")
+	}
+	if got := readCompleted(t, store, "report"); got != "old" {
+		t.Errorf("got %q after a failed combine, want the existing file", got)
+	}
+	if n := countBlobs(t, store); n != 1 {
+		t.Errorf("got %d blobs, want 1", n)
+	}
+
+	// Once the file is replaced, the blob of the old contents is removed.
+	if err := finalizeChunks(t, context.Background(), store, "3", "report", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompleted(t, store, "report"); got != "new" {
+		t.Errorf("got %q", got)
+	}
+	if n := countBlobs(t, store); n != 1 {
+		t.Errorf("got %d blobs after replacing the file, want 1", n)
+	}
+}
@@ -0,0 +1,41 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDownstreamTimeout(t *testing.T) {
+	cancelled := make(chan error, 1)
+	ta := newTestAssembler(t, &AssemblerConfig{DownstreamTimeout: 10 * time.Millisecond})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			cancelled <- r.Context().Err()
+		case <-time.After(5 * time.Second):
+			cancelled <- nil
+		}
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusGatewayTimeout)
+	if progress.RejectedError == nil || *progress.RejectedError != errDownstreamTimeout.Error() {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, errDownstreamTimeout)
+	}
+	// The handler sees its context cancelled, either by the deadline or
+	// by the assembler giving up on it.
+	if err := <-cancelled; err == nil {
+		t.Error("the handler's context wasn't cancelled")
+	}
+}
+
+func TestDownstreamWithinTimeout(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{DownstreamTimeout: time.Minute})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK); progress.RejectedError != nil {
+		t.Errorf("got rejection %q", *progress.RejectedError)
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "a" {
+		t.Errorf("got completed files %q, want %q", files, []string{"a"})
+	}
+}
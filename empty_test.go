@@ -0,0 +1,53 @@
+package assemble
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAllowEmptyFile(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AllowEmptyFile: true})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "", nil, http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{""}) {
+		t.Errorf("got files passed downstream %q, want one empty file", files)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{ta.a.fileID(uploadID): ""}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
+
+func TestEmptyChunkRejected(t *testing.T) {
+	for name, test := range map[string]struct {
+		allowEmptyFile bool
+		totalChunks    string
+		seq            int64
+		header         map[string]string
+	}{
+		"by default":          {false, `{"total_chunks": 1}`, 0, nil},
+		"in a multipart file": {true, `{"total_chunks": 2}`, 0, nil},
+		// The final chunk of an upload started without its size.
+		"after other chunks": {true, `{}`, 1, finalChunk},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ta := newTestAssembler(t, &AssemblerConfig{
+				AllowEmptyFile:   test.allowEmptyFile,
+				FinalChunkHeader: testFinalChunkHeader,
+			})
+			uploadID := ta.startUpload(test.totalChunks, nil)
+			if test.seq > 0 {
+				ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+			}
+			rec := ta.send(uploadID, test.seq, "", test.header)
+			if rec.Code != http.StatusBadRequest || errorBody(t, rec) != ErrEmptyChunk.Error() {
+				t.Errorf("got %d %s, want %d %q", rec.Code, rec.Body.String(), http.StatusBadRequest, ErrEmptyChunk)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package assemble
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+type xmlProgress struct {
+	XMLName  xml.Name `xml:"progress"`
+	Have     int64    `xml:"have,attr"`
+	Want     int64    `xml:"want,attr"`
+	Complete bool     `xml:"complete,attr"`
+}
+
+func encodeProgressXML(w http.ResponseWriter, progress ProgressInfo) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("x-progress-status", strconv.Itoa(progress.Status))
+	w.WriteHeader(progress.Status)
+	_ = xml.NewEncoder(w).Encode(xmlProgress{
+		Have:     progress.CurrentChunks,
+		Want:     progress.ExpectedChunks,
+		Complete: progress.Complete,
+	})
+}
+
+func TestResponseEncoder(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ResponseEncoder: encodeProgressXML})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	for seq, want := range []string{
+		`<progress have="1" want="2" complete="false"></progress>`,
+		`<progress have="2" want="2" complete="true"></progress>`,
+	} {
+		rec := ta.send(uploadID, int64(seq), "x", nil)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("chunk %d: got %d %s, want 200 %s", seq, rec.Code, rec.Body.String(), want)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/xml" {
+			t.Errorf("chunk %d: got Content-Type %q", seq, got)
+		}
+		if got := rec.Header().Get("x-progress-status"); got != "200" {
+			t.Errorf("chunk %d: got status %q passed to the encoder", seq, got)
+		}
+	}
+}
+
+func TestResponseEncoderRejectedStatus(t *testing.T) {
+	var statuses []int
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ResponseEncoder: func(w http.ResponseWriter, progress ProgressInfo) {
+			statuses = append(statuses, progress.Status)
+			EncodeProgressJSON(w, progress)
+		},
+	})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		RejectFile(r, http.StatusForbidden, "no")
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusForbidden)
+	// Errors aren't progress updates, so they go to ErrorHandler instead.
+	ta.send(uploadID, 5, "a", nil)
+	if len(statuses) != 1 || statuses[0] != http.StatusForbidden {
+		t.Errorf("got statuses %v passed to the encoder, want [403]", statuses)
+	}
+}
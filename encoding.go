@@ -0,0 +1,60 @@
+package assemble
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errInvalidEncoding     = errors.New("chunk can't be decoded")
+)
+
+// chunkBody returns the body of a chunk request, base64-decoded if
+// Base64Chunks is enabled and then decompressed according to its
+// Content-Encoding if DecompressChunks is enabled. The decoded size is
+// limited by readLimited like a plain body.
+func (a *FileChunksAssembler) chunkBody(r *http.Request) (io.Reader, error) {
+	var body io.Reader = r.Body
+	if a.Config.Base64Chunks {
+		body = decodingReader{base64.NewDecoder(base64.StdEncoding, body)}
+	}
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if !a.Config.DecompressChunks || encoding == "" || encoding == "identity" {
+		return body, nil
+	}
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(body)
+	case "deflate":
+		// HTTP's deflate is the zlib format.
+		body, err = zlib.NewReader(body)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidEncoding, err)
+	}
+	return decodingReader{body}, nil
+}
+
+// decodingReader marks errors from a decompressor so that they aren't
+// mistaken for failures to read the request.
+type decodingReader struct {
+	r io.Reader
+}
+
+func (d decodingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = fmt.Errorf("%w: %v", errInvalidEncoding, err)
+	}
+	return n, err
+}
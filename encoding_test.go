@@ -0,0 +1,77 @@
+package assemble
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func deflated(t *testing.T, data string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write([]byte(data))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestDecompressChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{DecompressChunks: true})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 0, gzipped(t, "hello "), map[string]string{"Content-Encoding": "gzip"}, http.StatusOK)
+	ta.mustSend(uploadID, 1, deflated(t, "compressed "), map[string]string{"Content-Encoding": "deflate"}, http.StatusOK)
+	ta.mustSend(uploadID, 2, "world", map[string]string{"Content-Encoding": "identity"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello compressed world" {
+		t.Errorf("got completed files %q, want %q", files, []string{"hello compressed world"})
+	}
+}
+
+func TestDecompressChunksDisabled(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	chunk := gzipped(t, "hello")
+	ta.mustSend(uploadID, 0, chunk, map[string]string{"Content-Encoding": "gzip"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != chunk {
+		t.Errorf("got completed files %q, want the gzipped chunk", files)
+	}
+}
+
+func TestDecompressChunksErrors(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{DecompressChunks: true, MaxChunkSize: 1000})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	for name, test := range map[string]struct {
+		body     string
+		encoding string
+		status   int
+	}{
+		// Decompressed chunks are limited like any other.
+		"bomb":        {gzipped(t, strings.Repeat("x", 1<<20)), "gzip", http.StatusRequestEntityTooLarge},
+		"unsupported": {"data", "br", http.StatusUnsupportedMediaType},
+		"corrupt":     {"not gzip", "gzip", http.StatusBadRequest},
+		"truncated":   {gzipped(t, strings.Repeat("x", 100))[:20], "gzip", http.StatusBadRequest},
+	} {
+		rec := ta.send(uploadID, 0, test.body, map[string]string{"Content-Encoding": test.encoding})
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", name, rec.Code, rec.Body.String(), test.status)
+		}
+	}
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 0 {
+		t.Errorf("got received chunks %v, %v", received, err)
+	}
+}
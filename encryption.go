@@ -0,0 +1,174 @@
+package assemble
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Encrypted chunks are stored as a random nonce followed by the sealed
+// data. Encrypted completed files are a sequence of encrypted chunks, each
+// preceded by its length as a 4-byte big-endian integer.
+const (
+	nonceSize       = 12
+	recordOverhead  = nonceSize + 16
+	frameHeaderSize = 4
+)
+
+var (
+	errEncryptedFileCorrupt = errors.New("encrypted file is corrupt")
+	errInvalidEncryptionKey = errors.New("encryption key must be 32 bytes long")
+)
+
+// newAEAD returns AES-GCM for a 16, 24 or 32 byte key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealChunk(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	record := make([]byte, nonceSize, nonceSize+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(record); err != nil {
+		return nil, err
+	}
+	return aead.Seal(record, record, plaintext, nil), nil
+}
+
+func openChunk(aead cipher.AEAD, record []byte) ([]byte, error) {
+	if len(record) < recordOverhead {
+		return nil, errEncryptedFileCorrupt
+	}
+	return aead.Open(nil, record[:nonceSize], record[nonceSize:], nil)
+}
+
+// readChunkFile returns the contents of a chunk, decrypted if
+// EncryptionKey is set.
+func (s *FilesystemStore) readChunkFile(fileID string, seq int64) ([]byte, error) {
+	data, err := s.readStoredChunk(fileID, seq)
+	if err != nil || s.EncryptionKey == nil {
+		return data, err
+	}
+	aead, err := newAEAD(s.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return openChunk(aead, data)
+}
+
+// readStoredChunk returns a chunk as it was written to the store.
+func (s *FilesystemStore) readStoredChunk(fileID string, seq int64) ([]byte, error) {
+	if s.AppendChunks {
+		return s.readLoggedChunk(fileID, seq)
+	}
+	return os.ReadFile(s.chunkFilePath(fileID, seq))
+}
+
+// copyEncryptedChunk decrypts a chunk and writes it to the completed file,
+// re-encrypted with its length in front if EncryptCompletedFiles is set and
+// the chunk was encrypted with EncryptionKey, which OpenCompleted uses.
+func (s *FilesystemStore) copyEncryptedChunk(w io.Writer, digest io.Writer, fileID string, seq int64, key []byte) error {
+	record, err := s.readStoredChunk(fileID, seq)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := openChunk(aead, record)
+	if err != nil {
+		return err
+	}
+	if digest != nil {
+		if _, err := digest.Write(plaintext); err != nil {
+			return err
+		}
+	}
+	if !s.EncryptCompletedFiles || !bytes.Equal(key, s.EncryptionKey) {
+		_, err := w.Write(plaintext)
+		return err
+	}
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(record)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(record)
+	return err
+}
+
+// openEncryptedCompleted returns a reader that decrypts an encrypted
+// completed file, and the size of its plaintext.
+func (s *FilesystemStore) openEncryptedCompleted(f *os.File) (io.ReadCloser, int64, error) {
+	aead, err := newAEAD(s.EncryptionKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The plaintext size can be worked out from the frame headers alone.
+	var size int64
+	var header [frameHeaderSize]byte
+	for {
+		_, err := io.ReadFull(f, header[:])
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, errEncryptedFileCorrupt
+		}
+		n := int64(binary.BigEndian.Uint32(header[:]))
+		if n < recordOverhead {
+			return nil, 0, errEncryptedFileCorrupt
+		}
+		if _, err := f.Seek(n, io.SeekCurrent); err != nil {
+			return nil, 0, err
+		}
+		size += n - recordOverhead
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return &decryptingReader{f: f, aead: aead}, size, nil
+}
+
+// decryptingReader decrypts an encrypted completed file one chunk at a
+// time.
+type decryptingReader struct {
+	f    *os.File
+	aead cipher.AEAD
+	buf  bytes.Reader
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for d.buf.Len() == 0 {
+		var header [frameHeaderSize]byte
+		if _, err := io.ReadFull(d.f, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, errEncryptedFileCorrupt
+		}
+		record := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(d.f, record); err != nil {
+			return 0, errEncryptedFileCorrupt
+		}
+		plaintext, err := openChunk(d.aead, record)
+		if err != nil {
+			return 0, fmt.Errorf("decrypting completed file: %w", err)
+		}
+		d.buf.Reset(plaintext)
+	}
+	return d.buf.Read(p)
+}
+
+func (d *decryptingReader) Close() error {
+	return d.f.Close()
+}
@@ -0,0 +1,137 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{7}, 32)
+
+func newEncryptedStore(t *testing.T) *FilesystemStore {
+	t.Helper()
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.EncryptionKey = testEncryptionKey
+	return store
+}
+
+// checkNoPlaintext fails the test if any file under dir contains secret.
+func checkNoPlaintext(t *testing.T, dir string, secret string) {
+	t.Helper()
+	files, err := readDirFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if strings.Contains(data, secret) {
+			t.Errorf("%s holds the plaintext", name)
+		}
+	}
+}
+
+func TestEncryptedChunks(t *testing.T) {
+	store := newEncryptedStore(t)
+	writeChunks(t, store, "1", "secret one ", "secret two")
+	checkNoPlaintext(t, store.ChunksDir, "secret")
+	if got := readChunk(t, store, "1", 1); got != "secret two" {
+		t.Errorf("got chunk %q", got)
+	}
+
+	digest := sha256.New()
+	if _, err := store.Finalize(context.Background(), "1", "file", 2, digest); err != nil {
+		t.Fatal(err)
+	}
+	// Completed files are decrypted unless EncryptCompletedFiles is set.
+	data, err := ioutil.ReadFile(filepath.Join(store.CompletedDir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret one secret two" {
+		t.Errorf("got completed file %q", data)
+	}
+	want := sha256.Sum256(data)
+	if !bytes.Equal(digest.Sum(nil), want[:]) {
+		t.Error("digest isn't of the plaintext")
+	}
+}
+
+func TestEncryptCompletedFiles(t *testing.T) {
+	store := newEncryptedStore(t)
+	store.EncryptCompletedFiles = true
+	writeChunks(t, store, "1", "secret one ", "", "secret two")
+	if _, err := store.Finalize(context.Background(), "1", "file", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkNoPlaintext(t, store.CompletedDir, "secret")
+	if got := readCompletedFile(t, store, "file"); got != "secret one secret two" {
+		t.Errorf("got completed file %q", got)
+	}
+}
+
+func TestEncryptedChunkWithWrongKey(t *testing.T) {
+	store := newEncryptedStore(t)
+	writeChunks(t, store, "1", "secret")
+	store.EncryptionKey = bytes.Repeat([]byte{8}, 32)
+	if _, err := store.Finalize(context.Background(), "1", "file", 1, nil); err == nil {
+		t.Fatal("chunk encrypted with another key was decrypted")
+	}
+	if _, err := os.Stat(filepath.Join(store.CompletedDir, "file")); !os.IsNotExist(err) {
+		t.Errorf("completed file was written: %v", err)
+	}
+}
+
+func TestCorruptEncryptedCompletedFile(t *testing.T) {
+	store := newEncryptedStore(t)
+	store.EncryptCompletedFiles = true
+	writeChunks(t, store, "1", "secret")
+	if _, err := store.Finalize(context.Background(), "1", "file", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(store.CompletedDir, "file")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := store.OpenCompleted("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("tampered file was decrypted")
+	}
+}
+
+func TestEncryptedUpload(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{EncryptionKey: testEncryptionKey})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "secret ", nil, http.StatusOK)
+	checkNoPlaintext(t, ta.a.Config.ChunksDir, "secret")
+	ta.mustSend(uploadID, 1, "data", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "secret data" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestInvalidEncryptionKey(t *testing.T) {
+	defer func() {
+		if recover() != errInvalidEncryptionKey {
+			t.Error("a 16 byte key was accepted")
+		}
+	}()
+	NewFileChunksAssembler(&AssemblerConfig{
+		ChunksDir:     t.TempDir(),
+		CompletedDir:  t.TempDir(),
+		EncryptionKey: make([]byte, 16),
+	})
+}
@@ -0,0 +1,57 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// plainErrors is an ErrorHandler that writes errors as text with the ID
+// of the request.
+func plainErrors(w http.ResponseWriter, r *http.Request, status int, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	code := "-"
+	var internalErr *InternalError
+	if errors.As(err, &internalErr) {
+		code = internalErr.Code
+	}
+	fmt.Fprintf(w, "%s %s %s", r.Header.Get("x-request-id"), code, err)
+}
+
+func TestErrorHandler(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ErrorHandler: plainErrors})
+	requestID := map[string]string{"x-request-id": "req-1"}
+
+	rec := ta.start(`{"total_chunks": 0}`, requestID)
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "req-1 - "+ErrInvalidChunkTotal.Error() {
+		t.Errorf("start: got %d %q", rec.Code, rec.Body.String())
+	}
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec = ta.send(uploadID, 0, "", requestID)
+	if rec.Code != http.StatusBadRequest || rec.Body.String() != "req-1 - "+ErrEmptyChunk.Error() {
+		t.Errorf("chunk: got %d %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+		t.Errorf("got content type %q", got)
+	}
+}
+
+func TestErrorHandlerInternalErrors(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ErrorHandler: plainErrors,
+		Store:        failingStore{NewMemoryStore()},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec := ta.send(uploadID, 0, "a", map[string]string{"x-request-id": "req-2"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %q, want %d", rec.Code, rec.Body.String(), http.StatusInternalServerError)
+	}
+	// The handler gets the code, but not the cause.
+	body := rec.Body.String()
+	if !strings.HasPrefix(body, "req-2 "+CodeChunkWriteFailed+" ") || strings.Contains(body, "disk on fire") {
+		t.Errorf("got %q", body)
+	}
+}
@@ -0,0 +1,101 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned for invalid requests, which can be matched with
+// errors.Is. Their messages are sent to clients with HTTP 400.
+var (
+	ErrMissingUploadID      = errors.New("missing upload ID")
+	ErrInvalidUploadID      = errors.New("invalid upload ID")
+	ErrInvalidChunkID       = errors.New("invalid chunk ID")
+	ErrInvalidChunkTotal    = errors.New("invalid number of expected chunks")
+	ErrSequenceOutOfRange   = errors.New("invalid chunk ID")
+	ErrEmptyChunk           = errors.New("chunk cannot be empty")
+	ErrInvalidFinalChunk    = errors.New("invalid final chunk")
+	ErrInvalidControlHeader = errors.New("invalid control header")
+)
+
+// ErrChunkQuantityChange is returned with HTTP 409 when a chunk declares a
+// different number of chunks than its upload already has. The response
+// includes both numbers.
+var ErrChunkQuantityChange = errors.New("number of expected chunks changed")
+
+// ErrTotalSizeChange and ErrContentTypeChange are returned with HTTP 409
+// when a chunk declares a different size or content type for the completed
+// file than its upload already has.
+var (
+	ErrTotalSizeChange   = errors.New("total size of the file changed")
+	ErrContentTypeChange = errors.New("content type of the file changed")
+)
+
+// chunkQuantityError is ErrChunkQuantityChange with the conflicting
+// numbers of chunks.
+type chunkQuantityError struct {
+	expected int64
+	declared int64
+}
+
+func (e *chunkQuantityError) Error() string {
+	return fmt.Sprintf("%s: upload has %d chunks but the chunk declares %d", ErrChunkQuantityChange, e.expected, e.declared)
+}
+
+func (e *chunkQuantityError) Is(target error) bool {
+	return target == ErrChunkQuantityChange
+}
+
+// requestError matches one of the errors above while keeping the message of
+// the underlying error.
+type requestError struct {
+	kind error
+	err  error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func (e *requestError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Codes of internal errors, which are sent to clients with HTTP 500 so
+// that they can tell what failed without seeing the cause.
+const (
+	CodeUploadLookupFailed      = "upload_lookup_failed"
+	CodeUploadStartFailed       = "upload_start_failed"
+	CodeUploadUpdateFailed      = "upload_update_failed"
+	CodeChunkLookupFailed       = "chunk_lookup_failed"
+	CodeChunkReadFailed         = "chunk_read_failed"
+	CodeChunkWriteFailed        = "chunk_write_failed"
+	CodeFreeSpaceCheckFailed    = "free_space_check_failed"
+	CodeCompletionClaimFailed   = "completion_claim_failed"
+	CodeCombineFailed           = "combine_failed"
+	CodeAbortFailed             = "abort_failed"
+	CodeContentLookupFailed     = "content_lookup_failed"
+	CodeCompletedFileOpenFailed = "completed_file_open_failed"
+	CodeQuotaLookupFailed       = "quota_lookup_failed"
+)
+
+// InternalError is given to ErrorHandler for failures on the server. Its
+// message only says what failed, and the cause is kept in Err, which has
+// already been logged. Code is one of the codes above.
+type InternalError struct {
+	Code    string
+	Message string
+	Err     error
+}
+
+func (e *InternalError) Error() string {
+	return e.Message
+}
+
+func (e *InternalError) Unwrap() error {
+	return e.Err
+}
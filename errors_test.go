@@ -0,0 +1,87 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRequestErrors(t *testing.T) {
+	var handled error
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			handled = err
+			WriteErrorJSON(w, r, status, err)
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+
+	for _, test := range []struct {
+		name    string
+		send    func() int
+		want    error
+		message string
+	}{{
+		name: "missing upload ID",
+		send: func() int {
+			return ta.send(uploadID, 0, "a", map[string]string{DefaultUploadIdentifierHeader: ""}).Code
+		},
+		want:    ErrMissingUploadID,
+		message: `strconv.ParseInt: parsing "": invalid syntax`,
+	}, {
+		name: "invalid upload ID",
+		send: func() int {
+			return ta.send(uploadID, 0, "a", map[string]string{DefaultUploadIdentifierHeader: "x"}).Code
+		},
+		want:    ErrInvalidUploadID,
+		message: `strconv.ParseInt: parsing "x": invalid syntax`,
+	}, {
+		name: "invalid chunk ID",
+		send: func() int {
+			return ta.send(uploadID, 0, "a", map[string]string{DefaultChunkIdentifierHeader: "x"}).Code
+		},
+		want:    ErrInvalidChunkID,
+		message: "must be an integer",
+	}, {
+		name:    "negative chunk ID",
+		send:    func() int { return ta.send(uploadID, -1, "a", nil).Code },
+		want:    ErrInvalidChunkID,
+		message: "cannot be negative",
+	}, {
+		name:    "chunk ID out of range",
+		send:    func() int { return ta.send(uploadID, 2, "a", nil).Code },
+		want:    ErrSequenceOutOfRange,
+		message: "invalid chunk ID",
+	}, {
+		name:    "empty chunk",
+		send:    func() int { return ta.send(uploadID, 0, "", nil).Code },
+		want:    ErrEmptyChunk,
+		message: "chunk cannot be empty",
+	}, {
+		name:    "no chunks",
+		send:    func() int { return ta.start(`{"total_chunks": 0}`, nil).Code },
+		want:    ErrInvalidChunkTotal,
+		message: "invalid number of expected chunks",
+	}} {
+		handled = nil
+		if status := test.send(); status != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", test.name, status, http.StatusBadRequest)
+		}
+		if !errors.Is(handled, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, handled, test.want)
+		} else if handled.Error() != test.message {
+			t.Errorf("%s: got message %q, want %q", test.name, handled.Error(), test.message)
+		}
+	}
+}
+
+func TestRequestErrorKinds(t *testing.T) {
+	err := &requestError{kind: ErrInvalidChunkID, err: errors.New("cannot be negative")}
+	if !errors.Is(err, ErrInvalidChunkID) {
+		t.Errorf("%v doesn't match its kind", err)
+	}
+	// ErrSequenceOutOfRange has the same message, but is a different error.
+	if errors.Is(err, ErrSequenceOutOfRange) {
+		t.Errorf("%v matches %v", err, ErrSequenceOutOfRange)
+	}
+}
@@ -0,0 +1,160 @@
+package assemble
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// blockingStore is a MemoryStore whose Finalize waits until proceed is
+// closed, after sending the file ID to finalizing.
+type blockingStore struct {
+	*MemoryStore
+	finalizing chan string
+	proceed    chan struct{}
+}
+
+func (s *blockingStore) Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error) {
+	s.finalizing <- fileID
+	<-s.proceed
+	return s.MemoryStore.Finalize(ctx, fileID, name, totalChunks, digest)
+}
+
+func TestOnExistingCompleted(t *testing.T) {
+	for _, test := range []struct {
+		policy ExistingCompletedPolicy
+		status int
+		want   map[string]string
+	}{
+		{
+			policy: ExistingCompletedOverwrite,
+			status: http.StatusOK,
+			want:   map[string]string{"report.txt": "second"},
+		},
+		{
+			policy: ExistingCompletedError,
+			status: http.StatusConflict,
+			want:   map[string]string{"report.txt": "first"},
+		},
+		{
+			policy: ExistingCompletedVersion,
+			status: http.StatusOK,
+			want:   map[string]string{"report.txt": "first", "report (1).txt": "second"},
+		},
+	} {
+		t.Run(string(test.policy), func(t *testing.T) {
+			ta := newTestAssembler(t, &AssemblerConfig{
+				CompletedNamer: func(string, map[string]interface{}) string {
+					return "report.txt"
+				},
+				OnExistingCompleted: test.policy,
+			})
+			first := ta.startUpload(`{"total_chunks": 1}`, nil)
+			ta.mustSend(first, 0, "first", nil, http.StatusOK)
+			second := ta.startUpload(`{"total_chunks": 1}`, nil)
+			ta.mustSend(second, 0, "second", nil, test.status)
+
+			files, err := readDirFiles(ta.a.Config.CompletedDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(files, test.want) {
+				t.Errorf("got completed files %v, want %v", files, test.want)
+			}
+		})
+	}
+}
+
+func TestOnExistingCompletedErrorRejectsUpload(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		CompletedNamer: func(string, map[string]interface{}) string {
+			return "report.txt"
+		},
+		OnExistingCompleted: ExistingCompletedError,
+	})
+	first := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(first, 0, "first", nil, http.StatusOK)
+	second := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(second, 0, "sec", nil, http.StatusOK)
+	progress := ta.mustSend(second, 1, "ond", nil, http.StatusConflict)
+	if progress.RejectedError == nil || *progress.RejectedError != errCompletedFileExists.Error() {
+		t.Errorf("got rejection %v", progress.RejectedError)
+	}
+	// The conflicting upload isn't passed downstream.
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"first"}) {
+		t.Errorf("got files passed downstream %q", files)
+	}
+}
+
+func TestOnExistingCompletedOverwriteConcurrent(t *testing.T) {
+	store := &blockingStore{
+		MemoryStore: NewMemoryStore(),
+		finalizing:  make(chan string, 2),
+		proceed:     make(chan struct{}),
+	}
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Store: store,
+		CompletedNamer: func(string, map[string]interface{}) string {
+			return "report.txt"
+		},
+		OnExistingCompleted: ExistingCompletedOverwrite,
+	})
+	first := ta.startUpload(`{"total_chunks": 1}`, nil)
+	second := ta.startUpload(`{"total_chunks": 1}`, nil)
+	codes := make(chan int, 2)
+	send := func(uploadID int64, body string) {
+		go func() { codes <- ta.send(uploadID, 0, body, nil).Code }()
+	}
+	send(first, "first")
+	<-store.finalizing
+	send(second, "second")
+	// The second upload waits for the first file to be written instead of
+	// being rejected.
+	responses := 0
+	select {
+	case code := <-codes:
+		responses++
+		t.Errorf("got %d while another upload was writing the same name", code)
+	case <-store.finalizing:
+		t.Error("two uploads wrote the same name at once")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(store.proceed)
+	for ; responses < 2; responses++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("got %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	files := ta.completedFiles()
+	sort.Strings(files)
+	if !reflect.DeepEqual(files, []string{"first", "second"}) {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	f, _, err := store.OpenCompleted("report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, _ := ioutil.ReadAll(f); string(data) != "second" {
+		t.Errorf("got completed file %q, want the second upload", data)
+	}
+}
+
+func TestInvalidExistingCompletedPolicy(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("an unknown policy was accepted")
+		}
+	}()
+	NewFileChunksAssembler(&AssemblerConfig{
+		ChunksDir:           t.TempDir(),
+		CompletedDir:        t.TempDir(),
+		OnExistingCompleted: "rename",
+	})
+}
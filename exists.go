@@ -0,0 +1,73 @@
+package assemble
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+var (
+	errExistsQuery     = errors.New(`either "name" or "sha256" must be given`)
+	errInvalidSHA256   = errors.New("sha256 must be a hex-encoded SHA256")
+	errNoContentLookup = errors.New("store can't find completed files by their contents")
+)
+
+type existsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+// ExistsHandler responds with whether a completed file already exists, so
+// that clients can skip uploading it again. The file is looked up by name
+// with the "name" query parameter, or by the hex-encoded SHA256 of its
+// contents with "sha256". Names are checked like by ServeCompletedHandler,
+// and other names are rejected with HTTP 400. Looking up contents requires
+// a store that implements ContentIndex, and responds with HTTP 501
+// otherwise.
+func (a *FileChunksAssembler) ExistsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.isClosed() {
+		a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+		return
+	}
+	query := r.URL.Query()
+	name, sum := query.Get("name"), query.Get("sha256")
+	if (name == "") == (sum == "") {
+		a.badRequest(w, r, errExistsQuery)
+		return
+	}
+	var exists bool
+	if name != "" {
+		if err := checkServedName(name); err != nil {
+			a.badRequest(w, r, err)
+			return
+		}
+		f, _, err := a.Config.Store.OpenCompleted(name)
+		if err != nil && !errors.Is(err, ErrCompletedFileNotFound) {
+			a.internalError(w, r, CodeCompletedFileOpenFailed, "failed to open completed file", err, "name", name)
+			return
+		}
+		if err == nil {
+			_ = f.Close()
+			exists = true
+		}
+	} else {
+		index, ok := a.Config.Store.(ContentIndex)
+		if !ok {
+			a.writeError(w, r, http.StatusNotImplemented, errNoContentLookup)
+			return
+		}
+		digest, err := hex.DecodeString(sum)
+		if err != nil || len(digest) != 32 {
+			a.badRequest(w, r, errInvalidSHA256)
+			return
+		}
+		exists, err = index.HasContent(digest)
+		if err != nil {
+			a.internalError(w, r, CodeContentLookupFailed, "failed to look up contents", err, "sha256", sum)
+			return
+		}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(existsResponse{Exists: exists})
+}
@@ -0,0 +1,100 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestExistsHandlerNames(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "contents", nil, http.StatusOK)
+
+	for _, test := range []struct {
+		name   string
+		status int
+		exists bool
+	}{
+		{ta.a.fileID(uploadID), http.StatusOK, true},
+		{"missing", http.StatusOK, false},
+		{"../etc", http.StatusBadRequest, false},
+		{"a/b", http.StatusBadRequest, false},
+		{".blobs", http.StatusBadRequest, false},
+	} {
+		rec := httptest.NewRecorder()
+		ta.a.ExistsHandler(rec, httptest.NewRequest(http.MethodGet, "/exists?name="+url.QueryEscape(test.name), nil))
+		if rec.Code != test.status {
+			t.Errorf("%q: got %d, want %d", test.name, rec.Code, test.status)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var response existsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Exists != test.exists {
+			t.Errorf("%q: got exists %v", test.name, response.Exists)
+		}
+	}
+}
+
+// exists sends a request to ExistsHandler with the query.
+func (ta *testAssembler) exists(query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ta.a.ExistsHandler(rec, httptest.NewRequest(http.MethodGet, "/exists?"+query, nil))
+	return rec
+}
+
+func TestExistsHandlerContents(t *testing.T) {
+	if !linkCountSupported {
+		t.Skip("link counts aren't supported")
+	}
+	ta := newTestAssembler(t, &AssemblerConfig{DeduplicateCompletedFiles: true})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "con", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "tents", nil, http.StatusOK)
+
+	for _, test := range []struct {
+		query  string
+		status int
+		exists bool
+	}{
+		{"sha256=" + sha256Hex("contents"), http.StatusOK, true},
+		{"sha256=" + strings.ToUpper(sha256Hex("contents")), http.StatusOK, true},
+		{"sha256=" + sha256Hex("other contents"), http.StatusOK, false},
+		{"sha256=abc", http.StatusBadRequest, false},
+		{"sha256=" + strings.Repeat("z", 64), http.StatusBadRequest, false},
+		{"", http.StatusBadRequest, false},
+		{"sha256=" + sha256Hex("contents") + "&name=" + ta.a.fileID(uploadID), http.StatusBadRequest, false},
+	} {
+		rec := ta.exists(test.query)
+		if rec.Code != test.status {
+			t.Errorf("%q: got %d %s, want %d", test.query, rec.Code, rec.Body.String(), test.status)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var response existsResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Exists != test.exists {
+			t.Errorf("%q: got exists %v", test.query, response.Exists)
+		}
+	}
+}
+
+func TestExistsHandlerContentsUnsupported(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Store: NewMemoryStore()})
+	rec := ta.exists("sha256=" + sha256Hex("contents"))
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusNotImplemented)
+	}
+}
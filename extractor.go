@@ -0,0 +1,50 @@
+package assemble
+
+import "net/http"
+
+// ChunkIDExtractor returns the upload and chunk IDs of a request as they
+// would be sent in headers, for clients that send them elsewhere such as in
+// the URL. Empty strings are returned for IDs that aren't in the request.
+type ChunkIDExtractor func(r *http.Request) (uploadID string, chunkID string)
+
+// QueryChunkIDs returns a ChunkIDExtractor that reads the IDs from query
+// parameters.
+func QueryChunkIDs(uploadParam string, chunkParam string) ChunkIDExtractor {
+	return func(r *http.Request) (string, string) {
+		query := r.URL.Query()
+		return query.Get(uploadParam), query.Get(chunkParam)
+	}
+}
+
+// uploadIDValue returns the upload ID from its header, the control header
+// or the extractor, in that order of precedence.
+func (a *FileChunksAssembler) uploadIDValue(r *http.Request) (string, error) {
+	value := r.Header.Get(a.Config.UploadIdentifierHeader)
+	if value == "" {
+		control, err := a.controlHeader(r)
+		if err != nil {
+			return "", err
+		}
+		value = string(control.ID)
+	}
+	if value == "" && a.Config.ChunkIDExtractor != nil {
+		value, _ = a.Config.ChunkIDExtractor(r)
+	}
+	return value, nil
+}
+
+// chunkIDValue is like uploadIDValue for the chunk ID.
+func (a *FileChunksAssembler) chunkIDValue(r *http.Request) (string, error) {
+	value := r.Header.Get(a.Config.ChunkIdentifierHeader)
+	if value == "" {
+		control, err := a.controlHeader(r)
+		if err != nil {
+			return "", err
+		}
+		value = string(control.Seq)
+	}
+	if value == "" && a.Config.ChunkIDExtractor != nil {
+		_, value = a.Config.ChunkIDExtractor(r)
+	}
+	return value, nil
+}
@@ -0,0 +1,93 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// sendTo sends a chunk to target without the ID headers.
+func (ta *testAssembler) sendTo(target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestQueryChunkIDs(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkIDExtractor: QueryChunkIDs("upload", "chunk")})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	for seq, body := range []string{"a", "b"} {
+		rec := ta.sendTo(fmt.Sprintf("/parts?upload=%d&chunk=%d", uploadID, seq), body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+		}
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+	rec := ta.sendTo("/parts?chunk=0", "a")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("without an upload ID: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestPathChunkIDs(t *testing.T) {
+	// IDs in paths like /uploads/{upload}/{chunk}.
+	extractor := func(r *http.Request) (string, string) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/uploads/"), "/")
+		if len(parts) != 2 {
+			return "", ""
+		}
+		return parts[0], parts[1]
+	}
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkIDExtractor: extractor})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.sendTo(fmt.Sprintf("/uploads/%d/1", uploadID), "b")
+	rec := ta.sendTo(fmt.Sprintf("/uploads/%d/0", uploadID), "a")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+}
+
+func TestChunkIDHeadersTakePrecedence(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkIDExtractor: QueryChunkIDs("upload", "chunk")})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	// The query names a chunk that's out of range, but the header is used.
+	req := chunkRequest(http.MethodPost, uploadID, 1, "b", nil)
+	req.URL.RawQuery = fmt.Sprintf("upload=%d&chunk=5", uploadID)
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 1 || received[0] != 1 {
+		t.Errorf("got received chunks %v, %v, want [1]", received, err)
+	}
+}
+
+func TestChunkIDExtractorStatus(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkIDExtractor: QueryChunkIDs("upload", "chunk")})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/status?upload=%d", uploadID), nil)
+	rec := httptest.NewRecorder()
+	ta.a.StatusHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	var status statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.ReceivedChunks) != 1 || status.ExpectedChunks != 2 {
+		t.Errorf("got status %+v, want 1 of 2 chunks received", status)
+	}
+}
@@ -0,0 +1,88 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// progressFields are the JSON keys of ProgressInfo that ProgressFieldNames
+// can rename.
+var progressFields = map[string]bool{
+	"have":              true,
+	"want":              true,
+	"received_bytes":    true,
+	"expected_bytes":    true,
+	"error":             true,
+	"hash":              true,
+	"location":          true,
+	"missing":           true,
+	"missing_truncated": true,
+	"result":            true,
+}
+
+func validateProgressFieldNames(names map[string]string) error {
+	used := make(map[string]string)
+	for field, name := range names {
+		if !progressFields[field] {
+			return fmt.Errorf("unknown progress field %q", field)
+		}
+		if name == "" {
+			return fmt.Errorf("progress field %q can't be renamed to an empty name", field)
+		}
+		used[name] = field
+	}
+	// Fields that aren't renamed keep their names.
+	for field := range progressFields {
+		if _, renamed := names[field]; !renamed {
+			if other, ok := used[field]; ok {
+				return fmt.Errorf("progress field %q can't be renamed to %q, which is already a field", other, field)
+			}
+			used[field] = field
+		}
+	}
+	if len(used) != len(progressFields) {
+		return errors.New("progress fields can't be renamed to the same name")
+	}
+	return nil
+}
+
+// MarshalJSON encodes the progress with its fields renamed by
+// ProgressFieldNames, keeping their order.
+func (p ProgressInfo) MarshalJSON() ([]byte, error) {
+	type progressInfo ProgressInfo
+	data, err := json.Marshal(progressInfo(p))
+	if err != nil || len(p.fieldNames) == 0 {
+		return data, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		field := token.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if name, ok := p.fieldNames[field]; ok {
+			field = name
+		}
+		key, _ := json.Marshal(field)
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
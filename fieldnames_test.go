@@ -0,0 +1,72 @@
+package assemble
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestProgressFieldNames(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ProgressFieldNames: map[string]string{
+			"have":  "received",
+			"want":  "total",
+			"error": "message",
+		},
+	})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		RejectFile(r, http.StatusForbidden, "no thanks")
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	// Fields keep their order, and the others keep their names.
+	rec := ta.send(uploadID, 0, "abc", nil)
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"received":1,"total":2,"received_bytes":3}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	rec = ta.send(uploadID, 1, "d", nil)
+	if got := rec.Body.String(); !strings.Contains(got, `"message":"no thanks"`) || strings.Contains(got, `"error"`) {
+		t.Errorf("got %s, want the rejection as message", got)
+	}
+}
+
+func TestProgressFieldNamesDefault(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	rec := ta.send(uploadID, 0, "abc", nil)
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"have":1,"want":2,"received_bytes":3}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestProgressFieldNamesSwapped(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ProgressFieldNames: map[string]string{"have": "want", "want": "have"},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	rec := ta.send(uploadID, 0, "abc", nil)
+	if got, want := strings.TrimSpace(rec.Body.String()), `{"want":1,"have":2,"received_bytes":3}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestInvalidProgressFieldNames(t *testing.T) {
+	for name, names := range map[string]map[string]string{
+		"unknown field":      {"total": "count"},
+		"empty name":         {"have": ""},
+		"existing field":     {"have": "want"},
+		"same name for both": {"have": "count", "want": "count"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v was accepted", names)
+				}
+			}()
+			NewFileChunksAssembler(&AssemblerConfig{
+				ChunksDir:          t.TempDir(),
+				CompletedDir:       t.TempDir(),
+				ProgressFieldNames: names,
+			})
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestFileIDValidatorReplacesBuiltInCheck(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.StrictFileNames = true
+	if err := store.WriteChunk("upload.", 0, []byte("a")); err == nil {
+		t.Fatal("strict file names accepted a trailing dot")
+	}
+	store.FileIDValidator = func(string) error { return nil }
+	if err := store.WriteChunk("upload.", 0, []byte("a")); err != nil {
+		t.Errorf("validator didn't replace the built-in check: %v", err)
+	}
+
+	errRejected := errors.New("rejected")
+	store.FileIDValidator = func(string) error { return errRejected }
+	if err := store.WriteChunk("upload", 0, []byte("a")); !errors.Is(err, errRejected) {
+		t.Errorf("got %v, want the validator's error", err)
+	}
+}
+
+func TestFileIDValidatorCantAllowTraversal(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.FileIDValidator = func(string) error { return nil }
+	for _, fileID := range []string{"..", ".", "../escape", "a/b", `a\b`, "a\x00b", ""} {
+		if err := store.WriteChunk(fileID, 0, []byte("a")); !errors.Is(err, errUnsafeFileID) {
+			t.Errorf("WriteChunk(%q): got %v, want %v", fileID, err, errUnsafeFileID)
+		}
+		if _, _, err := store.OpenCompleted(fileID); err == nil {
+			t.Errorf("OpenCompleted(%q) succeeded", fileID)
+		}
+	}
+}
+
+func TestFileIDValidatorRejectsUploadStart(t *testing.T) {
+	var checked []string
+	ta := newTestAssembler(t, &AssemblerConfig{
+		FileIDPrefix: "avatar-",
+		FileIDValidator: func(fileID string) error {
+			checked = append(checked, fileID)
+			return errors.New("no avatars today")
+		},
+	})
+	rec := ta.start(`{"total_chunks": 1}`, nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %s, want 500", rec.Code, rec.Body.String())
+	}
+	if len(checked) != 1 || !strings.HasPrefix(checked[0], "avatar-") {
+		t.Errorf("validator was called with %q", checked)
+	}
+	// The rejected upload isn't left in the tracker.
+	count, err := ta.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tracked uploads, want 0", count)
+	}
+}
+
+func TestFileIDValidatorRetriesGeneratedIDs(t *testing.T) {
+	next := int64(0)
+	ta := newTestAssembler(t, &AssemblerConfig{
+		UploadIDGenerator: func() (int64, error) {
+			next++
+			return next, nil
+		},
+		FileIDValidator: func(fileID string) error {
+			if fileID != "3" {
+				return errors.New("not 3")
+			}
+			return nil
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	if uploadID != 3 {
+		t.Fatalf("got upload %d, want 3", uploadID)
+	}
+	ta.mustSend(uploadID, 0, "data", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "data" {
+		t.Errorf("got completed files %q", files)
+	}
+	count, err := ta.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tracked uploads, want 0", count)
+	}
+}
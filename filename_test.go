@@ -0,0 +1,81 @@
+package assemble
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"report.pdf":             "report.pdf",
+		"../../etc/passwd":       "passwd",
+		`C:\Users\me\a.txt`:      "a.txt",
+		"dir/":                   "",
+		"..":                     "",
+		" spaced.txt ":           "spaced.txt",
+		"new\nline\x7f.txt":      "newline.txt",
+		strings.Repeat("é", 200): strings.Repeat("é", 127),
+	} {
+		if got := sanitizeFileName(name); got != want {
+			t.Errorf("%q: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFileNameHeader(t *testing.T) {
+	parent := t.TempDir()
+	completedDir := filepath.Join(parent, "completed")
+	var names []string
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ChunksDir:          t.TempDir(),
+		CompletedDir:       completedDir,
+		NameCompletedFiles: true,
+	})
+	ta.downstream = http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		names = append(names, GetFileMetadata(r)["name"].(string))
+	})
+	ta.h = ta.a.ChunksMiddleware(ta.downstream)
+
+	header := map[string]string{DefaultFileNameHeader: "../../etc/passwd"}
+	for _, body := range []string{"first", "second"} {
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, header)
+		ta.mustSend(uploadID, 0, body, nil, http.StatusOK)
+	}
+	if want := []string{"passwd", "passwd"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got names %q, want %q", names, want)
+	}
+	// The second file gets a free name instead of replacing the first.
+	files, err := readDirFiles(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join("completed", "passwd"):     "first",
+		filepath.Join("completed", "passwd (1)"): "second",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %v, want %v", files, want)
+	}
+}
+
+func TestFileNameHeaderOnChunk(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	var metadata map[string]interface{}
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		metadata = GetFileMetadata(r)
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", map[string]string{DefaultFileNameHeader: "photo.jpg"}, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if metadata["name"] != "photo.jpg" {
+		t.Errorf("got metadata %v, want name photo.jpg", metadata)
+	}
+	// Without NameCompletedFiles, the completed file is named after the
+	// upload ID.
+	if got := readCompletedFile(t, ta.a.Config.Store, ta.a.fileID(uploadID)); got != "ab" {
+		t.Errorf("got %q, want %q", got, "ab")
+	}
+}
@@ -0,0 +1,55 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// isFinalChunk returns whether FinalChunkHeader marks the chunk of a
+// request as the last one.
+func (a *FileChunksAssembler) isFinalChunk(r *http.Request) (bool, error) {
+	if a.Config.FinalChunkHeader == "" {
+		return false, nil
+	}
+	value := r.Header.Get(a.Config.FinalChunkHeader)
+	if value == "" {
+		return false, nil
+	}
+	final, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, &requestError{kind: ErrInvalidFinalChunk, err: fmt.Errorf("%s must be a boolean", a.Config.FinalChunkHeader)}
+	}
+	return final, nil
+}
+
+// setFinalChunk sets the number of chunks of an upload from its final
+// chunk. If the number is already known, the final chunk has to agree with
+// it. Chunks after the final one can't have been received.
+func (a *FileChunksAssembler) setFinalChunk(uploadID int64, seq int64, info *UploadInfo) error {
+	if info.TotalChunks > 0 {
+		if seq != info.TotalChunks-1 {
+			return &requestError{kind: ErrInvalidFinalChunk, err: fmt.Errorf("upload has %d chunks", info.TotalChunks)}
+		}
+		return nil
+	}
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		return err
+	}
+	if len(received) > 0 && received[len(received)-1] > seq {
+		return &requestError{kind: ErrInvalidFinalChunk, err: fmt.Errorf("chunk %d was received after the final chunk", a.toClientSeq(received[len(received)-1]))}
+	}
+	info.TotalChunks = seq + 1
+	return a.setTotalChunks(uploadID, *info)
+}
+
+func (a *FileChunksAssembler) setTotalChunks(uploadID int64, info UploadInfo) error {
+	if err := a.Config.Tracker.SetTotalChunks(uploadID, info.TotalChunks); err != nil {
+		return err
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		return recoverable.SaveUploadInfo(a.fileID(uploadID), info)
+	}
+	return nil
+}
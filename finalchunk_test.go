@@ -0,0 +1,92 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+const testFinalChunkHeader = "x-assemble-final"
+
+var finalChunk = map[string]string{testFinalChunkHeader: "true"}
+
+func TestFinalChunkHeader(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{FinalChunkHeader: testFinalChunkHeader})
+	uploadID := ta.startUpload(`{}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK); progress.ExpectedChunks != 0 {
+		t.Errorf("got %d expected chunks before the final chunk", progress.ExpectedChunks)
+	}
+	// Chunk 2 is missing when the final chunk arrives.
+	progress := ta.mustSend(uploadID, 3, "d", finalChunk, http.StatusOK)
+	if progress.CurrentChunks != 2 || progress.ExpectedChunks != 4 {
+		t.Errorf("got %d of %d chunks, want 2 of 4", progress.CurrentChunks, progress.ExpectedChunks)
+	}
+	ta.mustSend(uploadID, 1, "b", map[string]string{testFinalChunkHeader: "false"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Fatalf("completed with a gap: %q", files)
+	}
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abcd" {
+		t.Errorf("got completed files %q, want %q", files, []string{"abcd"})
+	}
+}
+
+func TestFinalChunkHeaderErrors(t *testing.T) {
+	var handled error
+	ta := newTestAssembler(t, &AssemblerConfig{
+		FinalChunkHeader: testFinalChunkHeader,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			handled = err
+			WriteErrorJSON(w, r, status, err)
+		},
+	})
+	uploadID := ta.startUpload(`{}`, nil)
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+
+	for _, test := range []struct {
+		name   string
+		seq    int64
+		header map[string]string
+		want   error
+	}{
+		{"not a boolean", 0, map[string]string{testFinalChunkHeader: "yes please"}, ErrInvalidFinalChunk},
+		{"before a received chunk", 1, finalChunk, ErrInvalidFinalChunk},
+	} {
+		handled = nil
+		ta.mustSend(uploadID, test.seq, "x", test.header, http.StatusBadRequest)
+		if !errors.Is(handled, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, handled, test.want)
+		}
+	}
+
+	ta.mustSend(uploadID, 3, "d", finalChunk, http.StatusOK)
+	handled = nil
+	ta.mustSend(uploadID, 4, "e", nil, http.StatusBadRequest)
+	if !errors.Is(handled, ErrSequenceOutOfRange) {
+		t.Errorf("chunk after the final chunk: got error %v, want %v", handled, ErrSequenceOutOfRange)
+	}
+	// Another final chunk has to agree with the first.
+	handled = nil
+	ta.mustSend(uploadID, 2, "c", finalChunk, http.StatusBadRequest)
+	if !errors.Is(handled, ErrInvalidFinalChunk) {
+		t.Errorf("second final chunk: got error %v, want %v", handled, ErrInvalidFinalChunk)
+	}
+}
+
+func TestFinalChunkHeaderWithTotal(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{FinalChunkHeader: testFinalChunkHeader})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", finalChunk, http.StatusBadRequest)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", finalChunk, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+}
+
+func TestStartWithoutTotal(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	if rec := ta.start(`{}`, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("starting without total_chunks or FinalChunkHeader: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
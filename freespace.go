@@ -0,0 +1,44 @@
+package assemble
+
+import "errors"
+
+var (
+	errInsufficientStorage  = errors.New("insufficient storage")
+	errFreeSpaceUnsupported = errors.New("free space can't be checked on this platform")
+)
+
+// FreeSpaceReporter is implemented by stores that can report how much space
+// is available for chunks, so that MinFreeDiskBytes can be enforced.
+type FreeSpaceReporter interface {
+	FreeSpace() (int64, error)
+}
+
+// FreeSpace returns the bytes available to unprivileged users on the
+// volume backing ChunksDir.
+func (s *FilesystemStore) FreeSpace() (int64, error) {
+	return freeSpace(s.ChunksDir)
+}
+
+// checkFreeSpace returns errInsufficientStorage if storing a chunk of the
+// given size would leave less than MinFreeDiskBytes available. Stores that
+// can't report their free space aren't checked.
+func (a *FileChunksAssembler) checkFreeSpace(chunkSize int) error {
+	if a.Config.MinFreeDiskBytes <= 0 {
+		return nil
+	}
+	reporter, ok := a.Config.Store.(FreeSpaceReporter)
+	if !ok {
+		return nil
+	}
+	free, err := reporter.FreeSpace()
+	if errors.Is(err, errFreeSpaceUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if free-int64(chunkSize) < a.Config.MinFreeDiskBytes {
+		return errInsufficientStorage
+	}
+	return nil
+}
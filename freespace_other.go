@@ -0,0 +1,7 @@
+//go:build !(linux || darwin || freebsd)
+
+package assemble
+
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnsupported
+}
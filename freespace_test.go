@@ -0,0 +1,88 @@
+package assemble
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// limitedStore reports a fixed amount of free space.
+type limitedStore struct {
+	*MemoryStore
+	free int64
+	err  error
+}
+
+func (s *limitedStore) FreeSpace() (int64, error) {
+	return s.free, s.err
+}
+
+func TestMinFreeDiskBytes(t *testing.T) {
+	store := &limitedStore{MemoryStore: NewMemoryStore(), free: 100}
+	ta := newTestAssembler(t, &AssemblerConfig{Store: store, MinFreeDiskBytes: 95})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "12345", nil, http.StatusOK)
+
+	// Storing this chunk would leave 94 bytes.
+	rec := ta.send(uploadID, 1, "123456", nil)
+	if rec.Code != http.StatusInsufficientStorage {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusInsufficientStorage)
+	}
+	if received, err := ta.a.Config.Tracker.ReceivedChunks(uploadID); err != nil || len(received) != 1 {
+		t.Errorf("got received chunks %v, %v, want only the first", received, err)
+	}
+
+	// The chunk is accepted once there's space.
+	store.free = 200
+	ta.mustSend(uploadID, 1, "123456", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "12345123456" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestMinFreeDiskBytesUnchecked(t *testing.T) {
+	for name, config := range map[string]*AssemblerConfig{
+		"unsupported platform": {
+			Store:            &limitedStore{MemoryStore: NewMemoryStore(), err: errFreeSpaceUnsupported},
+			MinFreeDiskBytes: 1000,
+		},
+		"no reporter": {Store: NewMemoryStore(), MinFreeDiskBytes: 1000},
+		"no minimum":  {Store: &limitedStore{MemoryStore: NewMemoryStore()}},
+	} {
+		ta := newTestAssembler(t, config)
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+		ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+		if files := ta.completedFiles(); len(files) != 1 {
+			t.Errorf("%s: got %d completed files, want 1", name, len(files))
+		}
+	}
+}
+
+func TestFreeSpaceCheckFailed(t *testing.T) {
+	store := &limitedStore{MemoryStore: NewMemoryStore(), err: errors.New("statfs failed")}
+	ta := newTestAssembler(t, &AssemblerConfig{Store: store, MinFreeDiskBytes: 1})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec := ta.send(uploadID, 0, "a", nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusInternalServerError)
+	}
+	var response errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Code != CodeFreeSpaceCheckFailed {
+		t.Errorf("got response %s, want code %q", rec.Body.String(), CodeFreeSpaceCheckFailed)
+	}
+}
+
+func TestFilesystemStoreFreeSpace(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	free, err := store.FreeSpace()
+	if errors.Is(err, errFreeSpaceUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free <= 0 {
+		t.Errorf("got %d bytes free", free)
+	}
+}
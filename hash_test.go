@@ -0,0 +1,38 @@
+package assemble
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"testing"
+)
+
+func TestCompletedFileHash(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	progress := ta.mustSend(uploadID, 0, "hello ", nil, http.StatusOK)
+	if progress.FileHash != "" {
+		t.Errorf("got hash %s before the upload completed", progress.FileHash)
+	}
+	progress = ta.mustSend(uploadID, 1, "world", nil, http.StatusOK)
+	sum := md5.Sum([]byte("hello world"))
+	if progress.FileHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("got hash %s, want the MD5 of the file", progress.FileHash)
+	}
+}
+
+func TestCompletedFileHashAlgorithm(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedFileHashAlgorithm: ChecksumSHA256})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "hello world", nil, http.StatusOK)
+	if progress.FileHash != sha256Hex("hello world") {
+		t.Errorf("got hash %s, want the SHA256 of the file", progress.FileHash)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("unsupported algorithm was accepted")
+		}
+	}()
+	newTestAssembler(t, &AssemblerConfig{CompletedFileHashAlgorithm: "sha1"})
+}
@@ -0,0 +1,55 @@
+package assemble
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHashFileNames(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.HashFileNames = true
+	fileID := "../../escape"
+	writeChunks(t, store, fileID, "a", "b")
+	if err := store.SaveUploadInfo(fileID, UploadInfo{TotalChunks: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Finalize(context.Background(), fileID, "dir/../../name", 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompletedFile(t, store, "dir/../../name"); got != "ab" {
+		t.Errorf("got completed file %q", got)
+	}
+
+	// Nothing is written outside the directories, and only hashes are used
+	// as file names.
+	sum := sha256.Sum256([]byte(fileID))
+	hashed := hex.EncodeToString(sum[:])
+	for _, dir := range []string{store.ChunksDir, store.CompletedDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.Contains(entry.Name(), "escape") || strings.Contains(entry.Name(), "name") {
+				t.Errorf("%s: unexpected entry %s", dir, entry.Name())
+			}
+			if dir == store.ChunksDir && !strings.HasPrefix(entry.Name(), hashed) {
+				t.Errorf("chunk file %s isn't named after the hash of the file ID", entry.Name())
+			}
+		}
+	}
+
+	// The file ID is recovered from the sidecar, since it can't be derived
+	// from the hash.
+	recovered, err := store.RecoverUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovered) != 1 || recovered[0].FileID != fileID || len(recovered[0].Chunks) != 2 {
+		t.Errorf("got recovered uploads %+v", recovered)
+	}
+}
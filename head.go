@@ -0,0 +1,52 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Headers of responses to HEAD requests on the chunks endpoint.
+const (
+	ReceivedChunksHeader = "x-assemble-received"
+	ReceivedBytesHeader  = "x-assemble-received-bytes"
+	ExpectedChunksHeader = "x-assemble-expected"
+)
+
+// chunksHead responds to a HEAD request on the chunks endpoint with the
+// progress of the upload in headers, so clients can check it cheaply before
+// resuming. Unknown uploads get HTTP 404.
+func (a *FileChunksAssembler) chunksHead(w http.ResponseWriter, r *http.Request) {
+	uploadID, err := a.getUploadID(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if completed, ok := a.getCompletion(uploadID); ok {
+		setProgressHeaders(w, completed.response.CurrentChunks, completed.response.ReceivedBytes, completed.response.ExpectedChunks)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	info, err := a.Config.Tracker.GetUpload(uploadID)
+	if err == nil {
+		var progress Progress
+		progress, err = a.Config.Tracker.GetProgress(uploadID)
+		if err == nil {
+			setProgressHeaders(w, progress.Chunks, progress.Bytes, info.TotalChunks)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	if errors.Is(err, ErrUploadNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	a.internalError(w, r, CodeUploadLookupFailed, "failed to get upload", err, "upload_id", uploadID)
+}
+
+func setProgressHeaders(w http.ResponseWriter, chunks int64, bytes int64, totalChunks int64) {
+	w.Header().Set(ReceivedChunksHeader, strconv.FormatInt(chunks, 10))
+	w.Header().Set(ReceivedBytesHeader, strconv.FormatInt(bytes, 10))
+	w.Header().Set(ExpectedChunksHeader, strconv.FormatInt(totalChunks, 10))
+}
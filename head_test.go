@@ -0,0 +1,65 @@
+package assemble
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func (ta *testAssembler) head(uploadID int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodHead, "/parts", nil)
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	return rec
+}
+
+func checkProgressHeaders(t *testing.T, rec *httptest.ResponseRecorder, chunks string, bytes string, expected string) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+	for header, want := range map[string]string{
+		ReceivedChunksHeader: chunks,
+		ReceivedBytesHeader:  bytes,
+		ExpectedChunksHeader: expected,
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("got %s %q, want %q", header, got, want)
+		}
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("got body %q", rec.Body.String())
+	}
+}
+
+func TestChunksHead(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{CompletedUploadTTL: time.Hour})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	checkProgressHeaders(t, ta.head(uploadID), "0", "0", "3")
+	ta.mustSend(uploadID, 0, "ab", nil, http.StatusOK)
+	ta.mustSend(uploadID, 2, "cde", nil, http.StatusOK)
+	checkProgressHeaders(t, ta.head(uploadID), "2", "5", "3")
+
+	// Completed uploads that are remembered report their final progress.
+	ta.mustSend(uploadID, 1, "f", nil, http.StatusOK)
+	checkProgressHeaders(t, ta.head(uploadID), "3", "6", "3")
+	if files := ta.completedFiles(); len(files) != 1 {
+		t.Errorf("got %d completed files, want 1", len(files))
+	}
+}
+
+func TestChunksHeadUnknownUpload(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	if rec := ta.head(12345); rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	req := httptest.NewRequest(http.MethodHead, "/parts", nil)
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("without an upload ID: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,195 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only moves when it's told to.
+type fakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testAssembler sends requests to an assembler's handlers and records the
+// completed files passed downstream.
+type testAssembler struct {
+	t *testing.T
+	a *FileChunksAssembler
+
+	// downstream receives the completed files, and h is the chunks
+	// middleware in front of it.
+	downstream http.Handler
+	h          http.Handler
+
+	lock      sync.Mutex
+	completed [][]byte
+}
+
+// newTestAssembler returns an assembler whose default store uses temporary
+// directories, unless config has a store or directories of its own.
+// Uploads are removed synchronously so that tests can check the store.
+func newTestAssembler(t *testing.T, config *AssemblerConfig) *testAssembler {
+	t.Helper()
+	if config == nil {
+		config = &AssemblerConfig{}
+	}
+	if config.Store == nil && config.ChunksDir == "" {
+		config.ChunksDir = t.TempDir()
+		config.CompletedDir = t.TempDir()
+	}
+	config.SynchronousCleanup = true
+	ta := &testAssembler{t: t, a: NewFileChunksAssembler(config)}
+	ta.downstream = http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading completed file: %v", err)
+		}
+		ta.lock.Lock()
+		ta.completed = append(ta.completed, body)
+		ta.lock.Unlock()
+	})
+	ta.h = ta.a.ChunksMiddleware(ta.downstream)
+	t.Cleanup(func() { _ = ta.a.Close() })
+	return ta
+}
+
+// start sends a request to UploadStartHandler.
+func (ta *testAssembler) start(body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/init", bytes.NewBufferString(body))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	ta.a.UploadStartHandler(rec, req)
+	return rec
+}
+
+// startUpload starts an upload and returns its ID, failing the test if it
+// can't be started.
+func (ta *testAssembler) startUpload(body string, header map[string]string) int64 {
+	ta.t.Helper()
+	rec := ta.start(body, header)
+	if rec.Code != http.StatusOK {
+		ta.t.Fatalf("starting upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	var response startResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		ta.t.Fatalf("decoding start response: %v", err)
+	}
+	return response.ID
+}
+
+// send sends a chunk to the chunks middleware.
+func (ta *testAssembler) send(uploadID int64, seq int64, body string, header map[string]string) *httptest.ResponseRecorder {
+	return ta.sendMethod(http.MethodPost, uploadID, seq, body, header)
+}
+
+func (ta *testAssembler) sendMethod(method string, uploadID int64, seq int64, body string, header map[string]string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, chunkRequest(method, uploadID, seq, body, header))
+	return rec
+}
+
+// chunkRequest returns a request sending a chunk with the default headers.
+func chunkRequest(method string, uploadID int64, seq int64, body string, header map[string]string) *http.Request {
+	req := httptest.NewRequest(method, "/parts", bytes.NewBufferString(body))
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	req.Header.Set(DefaultChunkIdentifierHeader, strconv.FormatInt(seq, 10))
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	return req
+}
+
+// mustSend sends a chunk and fails the test unless it gets the status.
+func (ta *testAssembler) mustSend(uploadID int64, seq int64, body string, header map[string]string, status int) ProgressInfo {
+	ta.t.Helper()
+	rec := ta.send(uploadID, seq, body, header)
+	if rec.Code != status {
+		ta.t.Fatalf("chunk %d: got %d %s, want %d", seq, rec.Code, rec.Body.String(), status)
+	}
+	var progress ProgressInfo
+	_ = json.Unmarshal(rec.Body.Bytes(), &progress)
+	return progress
+}
+
+// completedFiles returns the completed files passed downstream so far.
+func (ta *testAssembler) completedFiles() []string {
+	ta.lock.Lock()
+	defer ta.lock.Unlock()
+	files := make([]string, len(ta.completed))
+	for i, body := range ta.completed {
+		files[i] = string(body)
+	}
+	return files
+}
+
+// errorBody decodes the error message of an error response.
+func errorBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var response errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding error response %q: %v", rec.Body.String(), err)
+	}
+	return response.Error
+}
+
+// tus sends a request to the assembler's TusHandler mounted at /files/.
+func (ta *testAssembler) tus(method string, target string, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", tusContentType)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	ta.a.TusHandler("/files/", ta.downstream).ServeHTTP(rec, req)
+	return rec
+}
+
+// readDirFiles returns the contents of the regular files under dir by their
+// paths relative to dir.
+func readDirFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		files[rel] = string(data)
+		return nil
+	})
+	return files, err
+}
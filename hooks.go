@@ -0,0 +1,48 @@
+package assemble
+
+// AssemblerHooks are optional functions called after changes to the state
+// of an upload, e.g. to update a database or generate thumbnails. Hooks are
+// called synchronously while the upload is locked, so they must not block
+// for long.
+type AssemblerHooks struct {
+	// Called after a chunk has been written to the store and tracked.
+	OnChunkStored func(fileID string, seq int64)
+
+	// Called after each chunk received by ChunksMiddleware with the number
+	// of distinct chunks received so far, e.g. to push progress to a client
+	// over a websocket. Only the upload being reported on is locked.
+	OnProgress func(fileID string, received int64, total int64)
+
+	// Called after the chunks have been combined, before the downstream
+	// handler receives the completed file. path is the location returned by
+	// the store.
+	OnUploadComplete func(fileID string, path string)
+
+	// Called after an upload has been cancelled with Abort, including by
+	// Sweep.
+	OnUploadAborted func(fileID string)
+}
+
+func (h AssemblerHooks) chunkStored(fileID string, seq int64) {
+	if h.OnChunkStored != nil {
+		h.OnChunkStored(fileID, seq)
+	}
+}
+
+func (h AssemblerHooks) progress(fileID string, received int64, total int64) {
+	if h.OnProgress != nil {
+		h.OnProgress(fileID, received, total)
+	}
+}
+
+func (h AssemblerHooks) uploadComplete(fileID string, path string) {
+	if h.OnUploadComplete != nil {
+		h.OnUploadComplete(fileID, path)
+	}
+}
+
+func (h AssemblerHooks) uploadAborted(fileID string) {
+	if h.OnUploadAborted != nil {
+		h.OnUploadAborted(fileID)
+	}
+}
@@ -0,0 +1,92 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var events []string
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Hooks: AssemblerHooks{
+			OnChunkStored: func(fileID string, seq int64) {
+				events = append(events, fmt.Sprintf("stored %s %d", fileID, seq))
+			},
+			OnUploadComplete: func(fileID string, path string) {
+				events = append(events, fmt.Sprintf("complete %s %v", fileID, path != ""))
+			},
+		},
+	})
+	ta.downstream = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		events = append(events, "downstream")
+	})
+	ta.h = ta.a.ChunksMiddleware(ta.downstream)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	fileID := ta.a.fileID(uploadID)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+
+	want := []string{
+		"stored " + fileID + " 1",
+		"stored " + fileID + " 0",
+		"complete " + fileID + " true",
+		"downstream",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events\n%q\nwant\n%q", events, want)
+	}
+}
+
+func TestOnProgress(t *testing.T) {
+	var received []int64
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Hooks: AssemblerHooks{
+			OnProgress: func(fileID string, chunks int64, total int64) {
+				if total != 3 {
+					t.Errorf("got %d total chunks, want 3", total)
+				}
+				received = append(received, chunks)
+			},
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	// A resent chunk isn't counted twice.
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if want := []int64{1, 1, 2, 3}; !reflect.DeepEqual(received, want) {
+		t.Errorf("got progress %v, want %v", received, want)
+	}
+}
+
+func TestOnProgressDoesntBlockOtherUploads(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	var blockedID string
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Hooks: AssemblerHooks{
+			OnProgress: func(fileID string, received int64, total int64) {
+				if fileID == blockedID {
+					close(blocked)
+					<-release
+				}
+			},
+		},
+	})
+	first := ta.startUpload(`{"total_chunks": 2}`, nil)
+	second := ta.startUpload(`{"total_chunks": 1}`, nil)
+	blockedID = ta.a.fileID(first)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ta.send(first, 0, "a", nil)
+	}()
+	<-blocked
+	// The first upload's hook is still running.
+	ta.mustSend(second, 0, "b", nil, http.StatusOK)
+	close(release)
+	<-done
+}
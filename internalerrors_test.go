@@ -0,0 +1,131 @@
+package assemble
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var errFault = errors.New("injected fault")
+
+// faultyTracker is a MemoryTracker whose method named by fail returns
+// errFault.
+type faultyTracker struct {
+	*MemoryTracker
+	fail string
+}
+
+func (t *faultyTracker) CreateUpload(info UploadInfo) (int64, error) {
+	if t.fail == "CreateUpload" {
+		return 0, errFault
+	}
+	return t.MemoryTracker.CreateUpload(info)
+}
+
+func (t *faultyTracker) GetUpload(uploadID int64) (UploadInfo, error) {
+	if t.fail == "GetUpload" {
+		return UploadInfo{}, errFault
+	}
+	return t.MemoryTracker.GetUpload(uploadID)
+}
+
+func (t *faultyTracker) AddChunk(uploadID int64, seq int64, chunk ChunkInfo) (Progress, error) {
+	if t.fail == "AddChunk" {
+		return Progress{}, errFault
+	}
+	return t.MemoryTracker.AddChunk(uploadID, seq, chunk)
+}
+
+func (t *faultyTracker) ReceivedChunks(uploadID int64) ([]int64, error) {
+	if t.fail == "ReceivedChunks" {
+		return nil, errFault
+	}
+	return t.MemoryTracker.ReceivedChunks(uploadID)
+}
+
+func (t *faultyTracker) ClaimCompletion(uploadID int64) (bool, error) {
+	if t.fail == "ClaimCompletion" {
+		return false, errFault
+	}
+	return t.MemoryTracker.ClaimCompletion(uploadID)
+}
+
+// unfinishableStore is a MemoryStore that can't combine chunks.
+type unfinishableStore struct {
+	*MemoryStore
+}
+
+func (unfinishableStore) Finalize(context.Context, string, string, int64, io.Writer) (string, error) {
+	return "", errFault
+}
+
+func (unfinishableStore) FinalizeSequences(context.Context, string, string, []int64, io.Writer) (string, error) {
+	return "", errFault
+}
+
+func TestInternalErrorCodes(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		fail  string
+		store ChunkStore
+		start bool
+		total string
+		code  string
+	}{
+		{name: "start", fail: "CreateUpload", start: true, code: CodeUploadStartFailed},
+		{name: "upload lookup", fail: "GetUpload", code: CodeUploadLookupFailed},
+		{name: "chunk tracking", fail: "AddChunk", code: CodeChunkWriteFailed},
+		{name: "chunk write", store: failingStore{NewMemoryStore()}, code: CodeChunkWriteFailed},
+		{name: "missing chunks", fail: "ReceivedChunks", total: `{"total_chunks": 2}`, code: CodeChunkLookupFailed},
+		{name: "claim", fail: "ClaimCompletion", code: CodeCompletionClaimFailed},
+		{name: "combine", store: unfinishableStore{NewMemoryStore()}, code: CodeCombineFailed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := &faultyTracker{MemoryTracker: NewMemoryTracker()}
+			store := test.store
+			if store == nil {
+				store = NewMemoryStore()
+			}
+			ta := newTestAssembler(t, &AssemblerConfig{
+				Tracker:             tracker,
+				Store:               store,
+				ReportMissingChunks: true,
+			})
+			total := test.total
+			if total == "" {
+				total = `{"total_chunks": 1}`
+			}
+			var uploadID int64
+			if !test.start {
+				uploadID = ta.startUpload(total, nil)
+			}
+			tracker.fail = test.fail
+			var rec *httptest.ResponseRecorder
+			if test.start {
+				rec = ta.start(total, nil)
+			} else {
+				rec = ta.send(uploadID, 0, "a", nil)
+			}
+
+			if rec.Code != http.StatusInternalServerError {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusInternalServerError)
+			}
+			var response errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+				t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+			}
+			if response.Code != test.code || response.Error == "" {
+				t.Errorf("got %+v, want code %s", response, test.code)
+			}
+			// The cause isn't revealed to the client.
+			if strings.Contains(rec.Body.String(), errFault.Error()) || strings.Contains(rec.Body.String(), "disk on fire") {
+				t.Errorf("got %q", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package assemble
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestJanitorSweeps(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{IncompleteUploadTTL: time.Hour, Clock: clock})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	clock.Advance(2 * time.Hour)
+
+	stop := ta.a.StartJanitor(time.Millisecond)
+	defer stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := ta.a.Config.Tracker.GetUpload(uploadID)
+		if errors.Is(err, ErrUploadNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("janitor didn't remove the stale upload")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+	// Stopping again does nothing.
+	stop()
+}
+
+func TestCloseStopsJanitors(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.a.StartJanitor(time.Hour)
+	closed := make(chan error, 1)
+	go func() { closed <- ta.a.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the janitor")
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd)
+
+package assemble
+
+import "os"
+
+// The link count is unknown, so FilesystemStore.Deduplicate isn't
+// supported.
+const linkCountSupported = false
+
+func linkCount(info os.FileInfo) uint64 {
+	return 0
+}
+
+// Renames across filesystems can't be detected, so they fail.
+func isCrossDevice(err error) bool {
+	return false
+}
+
+func sameFilesystem(a string, b string) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+
+package assemble
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const linkCountSupported = true
+
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 0
+}
+
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// sameFilesystem returns whether two directories are on the same device.
+func sameFilesystem(a string, b string) (bool, error) {
+	aInfo, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bInfo, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	aStat, aOK := aInfo.Sys().(*syscall.Stat_t)
+	bStat, bOK := bInfo.Sys().(*syscall.Stat_t)
+	if !aOK || !bOK {
+		return true, nil
+	}
+	return aStat.Dev == bStat.Dev, nil
+}
@@ -0,0 +1,57 @@
+package assemble
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestExposeCompletedLocation(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ExposeCompletedLocation: true})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK); progress.Location != "" {
+		t.Errorf("got location %q before completion", progress.Location)
+	}
+	progress := ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	want := filepath.Join(ta.a.Config.CompletedDir, ta.a.fileID(uploadID))
+	if progress.Location != want {
+		t.Errorf("got location %q, want %q", progress.Location, want)
+	}
+}
+
+func TestCompletedLocationHidden(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK); progress.Location != "" {
+		t.Errorf("got location %q without ExposeCompletedLocation", progress.Location)
+	}
+}
+
+// urlStore gives clients URLs of completed files.
+type urlStore struct {
+	*MemoryStore
+}
+
+func (urlStore) Locate(name string) (string, error) {
+	return "https://files.example.com/" + name, nil
+}
+
+func TestLocator(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Store: urlStore{NewMemoryStore()}, ExposeCompletedLocation: true})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if want := "https://files.example.com/" + ta.a.fileID(uploadID); progress.Location != want {
+		t.Errorf("got location %q, want %q", progress.Location, want)
+	}
+}
+
+func TestRejectedFileHasNoLocation(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ExposeCompletedLocation: true})
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		RejectFile(r, http.StatusUnprocessableEntity, "no")
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusUnprocessableEntity); progress.Location != "" {
+		t.Errorf("got location %q of a rejected file", progress.Location)
+	}
+}
@@ -0,0 +1,81 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestConcurrentFirstChunks is meant to be run with -race. Every chunk of
+// each upload arrives at once, so no chunk is received before the others.
+func TestConcurrentFirstChunks(t *testing.T) {
+	const uploads = 5
+	const totalChunks = 20
+	ta := newTestAssembler(t, nil)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		uploadID := ta.startUpload(fmt.Sprintf(`{"total_chunks": %d}`, totalChunks), nil)
+		for seq := int64(0); seq < totalChunks; seq++ {
+			wg.Add(1)
+			go func(uploadID int64, seq int64) {
+				defer wg.Done()
+				if rec := ta.send(uploadID, seq, fmt.Sprintf("%02d", seq), nil); rec.Code != http.StatusOK {
+					t.Errorf("upload %d chunk %d: got %d %s", uploadID, seq, rec.Code, rec.Body.String())
+				}
+			}(uploadID, seq)
+		}
+	}
+	wg.Wait()
+
+	var want strings.Builder
+	for seq := 0; seq < totalChunks; seq++ {
+		fmt.Fprintf(&want, "%02d", seq)
+	}
+	files := ta.completedFiles()
+	if len(files) != uploads {
+		t.Fatalf("got %d completed files, want %d", len(files), uploads)
+	}
+	for _, file := range files {
+		if file != want.String() {
+			t.Errorf("got completed file %q, want %q", file, want.String())
+		}
+	}
+}
+
+// TestLockUploadAfterRemoval checks that a request waiting for the lock of
+// an upload that is removed doesn't get it while a later request holds the
+// lock that replaced it.
+func TestLockUploadAfterRemoval(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	unlockFirst := ta.a.lockUpload(1)
+
+	var waiterLocked int32
+	waiterDone := make(chan struct{})
+	go func() {
+		defer close(waiterDone)
+		unlock := ta.a.lockUpload(1)
+		atomic.StoreInt32(&waiterLocked, 1)
+		unlock()
+	}()
+	// Give the waiter time to block on the first lock.
+	time.Sleep(20 * time.Millisecond)
+
+	// The upload is removed, and a new request locks it again.
+	ta.a.uploadLocks.Delete(int64(1))
+	unlockLast := ta.a.lockUpload(1)
+	unlockFirst()
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&waiterLocked) != 0 {
+		t.Fatal("two requests held the upload's lock at once")
+	}
+	unlockLast()
+	select {
+	case <-waiterDone:
+	case <-time.After(time.Second):
+		t.Fatal("waiter never got the lock")
+	}
+}
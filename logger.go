@@ -0,0 +1,17 @@
+package assemble
+
+// Logger receives log messages with alternating key-value pairs, in the
+// style of slog and zap's SugaredLogger. Chunk contents are never logged.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Debug(string, ...interface{}) {}
+
+func (discardLogger) Info(string, ...interface{}) {}
+
+func (discardLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,76 @@
+package assemble
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records messages with their key-value pairs.
+type recordingLogger struct {
+	lock     sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) log(level string, msg string, keysAndValues []interface{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.messages = append(l.messages, fmt.Sprint(level, " ", msg, " ", keysAndValues))
+}
+
+func (l *recordingLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log("debug", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	ta := newTestAssembler(t, &AssemblerConfig{Logger: logger})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "secret contents", nil, http.StatusOK)
+
+	completed := false
+	for _, message := range logger.messages {
+		if strings.HasPrefix(message, "info upload completed") {
+			completed = true
+		}
+		// Chunk contents are never logged.
+		if strings.Contains(message, "secret") {
+			t.Errorf("chunk contents were logged: %s", message)
+		}
+	}
+	if !completed {
+		t.Errorf("completion wasn't logged: %q", logger.messages)
+	}
+}
+
+// failingStore is a MemoryStore whose chunk writes fail.
+type failingStore struct {
+	*MemoryStore
+}
+
+func (failingStore) WriteChunk(string, int64, []byte) error {
+	return fmt.Errorf("disk on fire")
+}
+
+func TestLoggerInternalErrors(t *testing.T) {
+	logger := &recordingLogger{}
+	ta := newTestAssembler(t, &AssemblerConfig{Logger: logger, Store: failingStore{NewMemoryStore()}})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusInternalServerError)
+	for _, message := range logger.messages {
+		if strings.HasPrefix(message, "error ") && strings.Contains(message, "disk on fire") {
+			return
+		}
+	}
+	t.Errorf("internal error wasn't logged: %q", logger.messages)
+}
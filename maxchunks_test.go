@@ -0,0 +1,31 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaxChunkTotal(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxChunkTotal: 3, FinalChunkHeader: "x-final"})
+	rec := ta.start(`{"total_chunks": 4}`, nil)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != errTooManyChunks.Error() {
+		t.Errorf("starting with too many chunks: got %d %s", rec.Code, rec.Body.String())
+	}
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	for seq, chunk := range []string{"a", "b", "c"} {
+		ta.mustSend(uploadID, int64(seq), chunk, nil, http.StatusOK)
+	}
+
+	// Without a known total, chunks past the limit are rejected.
+	uploadID = ta.startUpload(`{}`, nil)
+	rec = ta.send(uploadID, 3, "d", nil)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != errTooManyChunks.Error() {
+		t.Errorf("chunk past the limit: got %d %s", rec.Code, rec.Body.String())
+	}
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	ta.mustSend(uploadID, 2, "c", map[string]string{"x-final": "true"}, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 2 || files[0] != "abc" || files[1] != "abc" {
+		t.Errorf("got completed files %q", files)
+	}
+}
@@ -0,0 +1,113 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// MemoryStore keeps chunks and completed files in memory. It is intended for
+// tests and small uploads that don't need to survive a restart.
+//
+// Completed files are kept until DeleteCompleted is called.
+type MemoryStore struct {
+	chunks    map[string]map[int64][]byte
+	completed map[string][]byte
+	lock      sync.Mutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		chunks:    make(map[string]map[int64][]byte),
+		completed: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryStore) WriteChunk(fileID string, seq int64, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.chunks[fileID] == nil {
+		s.chunks[fileID] = make(map[int64][]byte)
+	}
+	// Callers may reuse the slice after returning.
+	s.chunks[fileID][seq] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) ReadChunk(fileID string, seq int64) (io.ReadCloser, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	chunk, exists := s.chunks[fileID][seq]
+	if !exists {
+		return nil, fmt.Errorf("chunk %d not found", seq)
+	}
+	return ioutil.NopCloser(bytes.NewReader(chunk)), nil
+}
+
+func (s *MemoryStore) DeleteChunk(fileID string, seq int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.chunks[fileID], seq)
+	if len(s.chunks[fileID]) == 0 {
+		delete(s.chunks, fileID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error) {
+	return s.FinalizeSequences(ctx, fileID, name, sequence(totalChunks), digest)
+}
+
+func (s *MemoryStore) FinalizeSequences(ctx context.Context, fileID string, name string, seqs []int64, digest io.Writer) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var completed bytes.Buffer
+	for _, seq := range seqs {
+		if err := ctx.Err(); err != nil {
+			return "", fmt.Errorf("chunk %d: %w", seq, err)
+		}
+		chunk, exists := s.chunks[fileID][seq]
+		if !exists {
+			return "", fmt.Errorf("chunk %d not found", seq)
+		}
+		completed.Write(chunk)
+	}
+	if digest != nil {
+		if _, err := digest.Write(completed.Bytes()); err != nil {
+			return "", err
+		}
+	}
+	s.completed[name] = completed.Bytes()
+	return name, nil
+}
+
+func (s *MemoryStore) OpenCompleted(name string) (io.ReadCloser, int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	completed, exists := s.completed[name]
+	if !exists {
+		return nil, 0, ErrCompletedFileNotFound
+	}
+	return completedFile{bytes.NewReader(completed)}, int64(len(completed)), nil
+}
+
+// completedFile can seek, so ServeCompletedHandler doesn't have to read
+// from the start for range requests.
+type completedFile struct {
+	*bytes.Reader
+}
+
+func (completedFile) Close() error {
+	return nil
+}
+
+// DeleteCompleted frees the memory held by a completed file.
+func (s *MemoryStore) DeleteCompleted(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.completed, name)
+	return nil
+}
@@ -0,0 +1,79 @@
+package assemble
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestMemoryStoreStreamsCompletedFile(t *testing.T) {
+	// Any temporary file would be created here.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	store := NewMemoryStore()
+	ta := newTestAssembler(t, &AssemblerConfig{Store: store})
+	var (
+		body          string
+		contentLength int64
+		header        string
+		isFile        bool
+	)
+	h := ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		_, isFile = r.Body.(*os.File)
+		contentLength = r.ContentLength
+		header = r.Header.Get("Content-Length")
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		body = string(data)
+	}))
+	ta.h = h
+
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "hello ", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "world", nil, http.StatusOK)
+
+	if body != "hello world" {
+		t.Errorf("got body %q", body)
+	}
+	if contentLength != 11 || header != "11" {
+		t.Errorf("got ContentLength %d and header %q, want 11", contentLength, header)
+	}
+	if isFile {
+		t.Error("body is a file")
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("%d files were written to the temporary directory", len(entries))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testChunkStore(t, func(*testing.T) ChunkStore {
+		return NewMemoryStore()
+	})
+}
+
+func TestMemoryStoreUpload(t *testing.T) {
+	store := NewMemoryStore()
+	ta := newTestAssembler(t, &AssemblerConfig{Store: store})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	for seq, chunk := range []string{"a", "b", "c"} {
+		ta.mustSend(uploadID, int64(seq), chunk, nil, http.StatusOK)
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abc" {
+		t.Errorf("got completed files %q", files)
+	}
+	// The chunks were removed once the upload completed.
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if len(store.chunks) != 0 {
+		t.Errorf("chunks of %d uploads were kept", len(store.chunks))
+	}
+}
@@ -0,0 +1,103 @@
+package assemble
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMetadataHeaderPrefix = "x-assemble-meta-"
+	DefaultFileNameHeader       = "x-assemble-filename"
+)
+
+// Most filesystems don't allow longer file names.
+const maxFileNameLength = 255
+
+// headerMetadata returns the headers starting with MetadataHeaderPrefix,
+// keyed by the rest of their lowercased name, the file name from
+// FileNameHeader as "name", and the "contentType" of ControlHeader as
+// "type".
+func (a *FileChunksAssembler) headerMetadata(r *http.Request) map[string]interface{} {
+	prefix := strings.ToLower(a.Config.MetadataHeaderPrefix)
+	var metadata map[string]interface{}
+	if name := sanitizeFileName(r.Header.Get(a.Config.FileNameHeader)); name != "" {
+		metadata = map[string]interface{}{"name": name}
+	}
+	// Chunk requests with a malformed control header are rejected when
+	// their IDs are read.
+	if control, err := a.controlHeader(r); err == nil && control.ContentType != "" {
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		metadata["type"] = control.ContentType
+	}
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if !strings.HasPrefix(name, prefix) || len(name) == len(prefix) || len(values) == 0 {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		metadata[strings.TrimPrefix(name, prefix)] = values[0]
+	}
+	return metadata
+}
+
+// sanitizeFileName returns the last element of a client's file path, so
+// that it can't refer to other directories, without control characters.
+// An empty string is returned if nothing usable is left.
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, "/\\"); i != -1 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	for len(name) > maxFileNameLength {
+		// Trim whole runes so the name stays valid UTF-8.
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// mergeMetadata adds metadata to the upload's existing metadata, replacing
+// values with the same key. It returns false if nothing changed.
+func mergeMetadata(info *UploadInfo, metadata map[string]interface{}) bool {
+	changed := false
+	for key, value := range metadata {
+		if existing, ok := info.Metadata[key]; ok && existing == value {
+			continue
+		}
+		if !changed {
+			// The map may be shared with the tracker.
+			merged := make(map[string]interface{}, len(info.Metadata)+len(metadata))
+			for k, v := range info.Metadata {
+				merged[k] = v
+			}
+			info.Metadata = merged
+			changed = true
+		}
+		info.Metadata[key] = value
+	}
+	return changed
+}
+
+func (a *FileChunksAssembler) setMetadata(uploadID int64, info UploadInfo) error {
+	if err := a.Config.Tracker.SetMetadata(uploadID, info.Metadata); err != nil {
+		return err
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		return recoverable.SaveUploadInfo(a.fileID(uploadID), info)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+// checkMethod responds with HTTP 405 and returns false if a request's
+// method isn't one of ChunkMethods or extra.
+func (a *FileChunksAssembler) checkMethod(w http.ResponseWriter, r *http.Request, extra ...string) bool {
+	allowed := append(append([]string(nil), a.Config.ChunkMethods...), extra...)
+	for _, method := range allowed {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	a.writeError(w, r, http.StatusMethodNotAllowed, errMethodNotAllowed)
+	return false
+}
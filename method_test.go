@@ -0,0 +1,58 @@
+package assemble
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestChunkMethods(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		rec := ta.sendMethod(method, uploadID, 0, "a", nil)
+		if rec.Code != http.StatusMethodNotAllowed || errorBody(t, rec) != errMethodNotAllowed.Error() {
+			t.Errorf("%s: got %d %s, want %d", method, rec.Code, rec.Body.String(), http.StatusMethodNotAllowed)
+		}
+		if got := rec.Header().Get("Allow"); got != "POST, HEAD" {
+			t.Errorf("%s: got Allow %q, want %q", method, got, "POST, HEAD")
+		}
+	}
+	// The rejected requests weren't stored as chunks.
+	checkProgressHeaders(t, ta.head(uploadID), "0", "0", "1")
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"a"}) {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestCustomChunkMethods(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkMethods: []string{http.MethodPut}})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	rec := ta.send(uploadID, 0, "a", nil)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusMethodNotAllowed)
+	}
+	if got := rec.Header().Get("Allow"); got != "PUT, HEAD" {
+		t.Errorf("got Allow %q, want %q", got, "PUT, HEAD")
+	}
+	if rec := ta.sendMethod(http.MethodPut, uploadID, 0, "a", nil); rec.Code != http.StatusOK {
+		t.Errorf("PUT: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBatchChunkMethods(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	req := httptest.NewRequest(http.MethodGet, "/batch", bytes.NewBufferString(""))
+	rec := httptest.NewRecorder()
+	ta.a.BatchChunksMiddleware(ta.downstream).ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusMethodNotAllowed)
+	}
+	// HEAD requests are only for checking the progress of single uploads.
+	if got := rec.Header().Get("Allow"); got != "POST" {
+		t.Errorf("got Allow %q, want POST", got)
+	}
+}
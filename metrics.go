@@ -0,0 +1,20 @@
+package assemble
+
+import "time"
+
+// Metrics receives events from the assembler, e.g. to update Prometheus
+// counters and histograms. Methods are called synchronously, so they should
+// return quickly.
+type Metrics interface {
+	ChunkReceived(fileID string, bytes int)
+	UploadCompleted(fileID string, totalBytes int64, duration time.Duration)
+	UploadRejected(fileID string, reason string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ChunkReceived(string, int) {}
+
+func (noopMetrics) UploadCompleted(string, int64, time.Duration) {}
+
+func (noopMetrics) UploadRejected(string, string) {}
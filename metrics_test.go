@@ -0,0 +1,59 @@
+package assemble
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics records the events it receives.
+type recordingMetrics struct {
+	lock      sync.Mutex
+	chunks    []int
+	completed []int64
+	durations []time.Duration
+	rejected  []string
+}
+
+func (m *recordingMetrics) ChunkReceived(_ string, bytes int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.chunks = append(m.chunks, bytes)
+}
+
+func (m *recordingMetrics) UploadCompleted(_ string, totalBytes int64, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.completed = append(m.completed, totalBytes)
+	m.durations = append(m.durations, duration)
+}
+
+func (m *recordingMetrics) UploadRejected(_ string, reason string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rejected = append(m.rejected, reason)
+}
+
+func TestMetrics(t *testing.T) {
+	clock := newFakeClock()
+	metrics := &recordingMetrics{}
+	ta := newTestAssembler(t, &AssemblerConfig{Metrics: metrics, Clock: clock, MaxFileSize: 5})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "ab", nil, http.StatusOK)
+	clock.Advance(3 * time.Second)
+	ta.mustSend(uploadID, 1, "cde", nil, http.StatusOK)
+
+	rejected := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(rejected, 0, "abcdef", nil, http.StatusRequestEntityTooLarge)
+
+	if len(metrics.chunks) < 2 || metrics.chunks[0] != 2 || metrics.chunks[1] != 3 {
+		t.Errorf("got chunk sizes %v", metrics.chunks)
+	}
+	if len(metrics.completed) != 1 || metrics.completed[0] != 5 || metrics.durations[0] != 3*time.Second {
+		t.Errorf("got completed uploads %v in %v", metrics.completed, metrics.durations)
+	}
+	if len(metrics.rejected) != 1 || metrics.rejected[0] != errFileTooLarge.Error() {
+		t.Errorf("got rejections %q", metrics.rejected)
+	}
+}
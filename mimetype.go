@@ -0,0 +1,133 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const defaultContentType = "application/octet-stream"
+
+var (
+	errMimeTypeNotAllowed = errors.New("file type is not allowed")
+	errMimeTypeMismatch   = errors.New("file contents don't match its type")
+)
+
+// Number of bytes used by http.DetectContentType.
+const sniffLength = 512
+
+// contentType returns the mimetype sent in the upload metadata.
+func contentType(info UploadInfo) string {
+	if t, ok := info.Metadata["type"].(string); ok && t != "" {
+		return t
+	}
+	return defaultContentType
+}
+
+// typeAllowed returns whether the type of an upload is in AllowedMimeTypes.
+func (a *FileChunksAssembler) typeAllowed(info UploadInfo) bool {
+	return len(a.Config.AllowedMimeTypes) == 0 || mimeTypeAllowed(a.Config.AllowedMimeTypes, contentType(info))
+}
+
+// maxFileSize returns the limit on the size of an upload's completed file,
+// or 0 if it's unlimited. An exact match in MaxFileSizeByType takes
+// precedence over "type/*", which takes precedence over "*/*".
+func (a *FileChunksAssembler) maxFileSize(info UploadInfo) int64 {
+	if len(a.Config.MaxFileSizeByType) == 0 {
+		return a.Config.MaxFileSize
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType(info))
+	if err != nil {
+		return a.Config.MaxFileSize
+	}
+	typeWildcard := strings.SplitN(mediaType, "/", 2)[0] + "/*"
+	maxSize, precedence := a.Config.MaxFileSize, 0
+	for pattern, size := range a.Config.MaxFileSizeByType {
+		switch strings.ToLower(pattern) {
+		case mediaType:
+			return size
+		case typeWildcard:
+			maxSize, precedence = size, 2
+		case "*/*":
+			if precedence < 1 {
+				maxSize, precedence = size, 1
+			}
+		}
+	}
+	return maxSize
+}
+
+// verifyContentType checks that the contents of a completed file match its
+// declared type, like scanCompleted. Uploads without a type are checked
+// against AllowedMimeTypes instead, if it's set.
+func (a *FileChunksAssembler) verifyContentType(uploadID int64, name string, info UploadInfo) (uploadResult, bool, error) {
+	if !a.Config.VerifyContentType {
+		return uploadResult{}, true, nil
+	}
+	declared, hasType := info.Metadata["type"].(string)
+	if (!hasType || declared == "") && len(a.Config.AllowedMimeTypes) == 0 {
+		return uploadResult{}, true, nil
+	}
+	completedFile, _, err := a.Config.Store.OpenCompleted(name)
+	if err != nil {
+		return uploadResult{}, false, fmt.Errorf("opening completed file: %w", err)
+	}
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(completedFile, head)
+	_ = completedFile.Close()
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return uploadResult{}, false, fmt.Errorf("reading completed file: %w", err)
+	}
+	detected := http.DetectContentType(head[:n])
+	if hasType && declared != "" {
+		if sameMediaType(declared, detected) {
+			return uploadResult{}, true, nil
+		}
+	} else if mimeTypeAllowed(a.Config.AllowedMimeTypes, detected) {
+		return uploadResult{}, true, nil
+	}
+	if err := a.Config.Store.DeleteCompleted(name); err != nil {
+		a.Config.Logger.Error("failed to delete rejected file", "upload_id", uploadID, "error", err)
+	}
+	a.Config.Logger.Info("file type mismatch", "upload_id", uploadID, "declared", declared, "detected", detected)
+	a.rejectUpload(uploadID, errMimeTypeMismatch.Error())
+	return uploadResult{
+		rejectedCode:  http.StatusUnsupportedMediaType,
+		rejectedError: errMimeTypeMismatch.Error(),
+	}, false, nil
+}
+
+// sameMediaType compares mimetypes without their parameters.
+func sameMediaType(a string, b string) bool {
+	aType, _, err := mime.ParseMediaType(a)
+	if err != nil {
+		return false
+	}
+	bType, _, err := mime.ParseMediaType(b)
+	if err != nil {
+		return false
+	}
+	return aType == bType
+}
+
+// mimeTypeAllowed checks a mimetype against a list that may contain
+// wildcards like "image/*". Parameters such as charset are ignored.
+func mimeTypeAllowed(allowed []string, mimeType string) bool {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*/*" || pattern == mediaType {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
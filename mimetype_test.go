@@ -0,0 +1,131 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMimeTypeAllowed(t *testing.T) {
+	allowed := []string{"image/*", "text/plain"}
+	for mimeType, want := range map[string]bool{
+		"image/png":                 true,
+		"IMAGE/PNG":                 true,
+		"text/plain; charset=utf-8": true,
+		"text/html":                 false,
+		"application/octet-stream":  false,
+		"not a type":                false,
+	} {
+		if got := mimeTypeAllowed(allowed, mimeType); got != want {
+			t.Errorf("%q: got %v, want %v", mimeType, got, want)
+		}
+	}
+}
+
+func TestAllowedMimeTypesOnStart(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AllowedMimeTypes: []string{"image/*"}})
+	if rec := ta.start(`{"total_chunks": 1, "metadata": {"type": "text/html"}}`, nil); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("disallowed type: got %d", rec.Code)
+	}
+	if rec := ta.start(`{"total_chunks": 1}`, nil); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("missing type: got %d", rec.Code)
+	}
+	ta.startUpload(`{"total_chunks": 1, "metadata": {"type": "image/png"}}`, nil)
+}
+
+func TestAllowedMimeTypesOnChunk(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		AllowedMimeTypes: []string{"image/*", "application/octet-stream"},
+	})
+	// Started without a type, which is allowed as application/octet-stream.
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+
+	rec := ta.send(uploadID, 0, "<html>", map[string]string{DefaultMetadataHeaderPrefix + "type": "text/html"})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("declaring a disallowed type: got %d %s", rec.Code, rec.Body.String())
+	}
+	// The type wasn't recorded, and the upload can continue.
+	info, err := ta.a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := info.Metadata["type"]; ok {
+		t.Errorf("got metadata %v", info.Metadata)
+	}
+	ta.mustSend(uploadID, 0, "a", map[string]string{DefaultMetadataHeaderPrefix + "type": "image/png"}, http.StatusOK)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 {
+		t.Errorf("got %d completed files", len(files))
+	}
+}
+
+func TestAllowedMimeTypesRejectedUploadNotTracked(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{AllowedMimeTypes: []string{"application/pdf"}})
+	rec := ta.start(`{"total_chunks": 1, "metadata": {"type": "image/png"}}`, nil)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusUnsupportedMediaType)
+	}
+	if got := errorBody(t, rec); got != errMimeTypeNotAllowed.Error() {
+		t.Errorf("got error %q, want %q", got, errMimeTypeNotAllowed)
+	}
+	count, err := ta.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("got %d tracked uploads after rejecting the only one", count)
+	}
+}
+
+func TestNoAllowedMimeTypes(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1, "metadata": {"type": "text/html"}}`, nil)
+	ta.mustSend(uploadID, 0, "<html>", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 {
+		t.Errorf("got %d completed files, want 1", len(files))
+	}
+}
+
+// pngHeader is enough of a PNG for http.DetectContentType.
+const pngHeader = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+func TestVerifyContentType(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{VerifyContentType: true})
+	for _, test := range []struct {
+		declared string
+		status   int
+	}{
+		{"image/png", http.StatusOK},
+		{"text/plain", http.StatusUnsupportedMediaType},
+	} {
+		uploadID := ta.startUpload(`{"total_chunks": 2, "metadata": {"type": "`+test.declared+`"}}`, nil)
+		ta.mustSend(uploadID, 0, pngHeader, nil, http.StatusOK)
+		progress := ta.mustSend(uploadID, 1, "pixels", nil, test.status)
+		if test.status != http.StatusOK {
+			if progress.RejectedError == nil || *progress.RejectedError != errMimeTypeMismatch.Error() {
+				t.Errorf("%s: got rejection %v, want %q", test.declared, progress.RejectedError, errMimeTypeMismatch)
+			}
+			if _, _, err := ta.a.Config.Store.OpenCompleted(ta.a.fileID(uploadID)); err == nil {
+				t.Errorf("%s: the rejected file wasn't deleted", test.declared)
+			}
+		}
+	}
+	// Sniffing doesn't consume the body passed downstream.
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != pngHeader+"pixels" {
+		t.Errorf("got completed files %q, want only the PNG", files)
+	}
+}
+
+func TestVerifyContentTypeWithoutType(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		VerifyContentType: true,
+		AllowedMimeTypes:  []string{"image/*", "application/octet-stream"},
+	})
+	// Without a declared type, the detected type has to be allowed.
+	png := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(png, 0, pngHeader, nil, http.StatusOK)
+	text := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(text, 0, "plain text", nil, http.StatusUnsupportedMediaType)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != pngHeader {
+		t.Errorf("got completed files %q, want only the PNG", files)
+	}
+}
@@ -0,0 +1,51 @@
+package assemble
+
+// DefaultMaxMissingChunks is the default of AssemblerConfig.MaxMissingChunks.
+const DefaultMaxMissingChunks = 1000
+
+// MissingChunks returns the sequence numbers of chunks that haven't been
+// received for an upload, in ascending order and numbered from
+// ChunkSequenceBase. If the number of chunks isn't known yet, only gaps
+// before the highest received chunk are returned. At most MaxMissingChunks
+// are returned, and truncated is set if there are more.
+func (a *FileChunksAssembler) MissingChunks(uploadID int64) (missing []int64, truncated bool, err error) {
+	info, err := a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		return nil, false, err
+	}
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		return nil, false, err
+	}
+	missing, truncated = missingChunks(received, info.TotalChunks, a.Config.MaxMissingChunks)
+	return a.toClientSeqs(missing), truncated, nil
+}
+
+// missingChunks returns up to limit gaps in received, which must be sorted,
+// and whether there were more. Only the gaps are walked, since the number
+// of chunks is chosen by the client.
+func missingChunks(received []int64, totalChunks int64, limit int) ([]int64, bool) {
+	end := totalChunks
+	if end == 0 && len(received) > 0 {
+		end = received[len(received)-1] + 1
+	}
+	missing := []int64{}
+	next := int64(0)
+	for i := 0; i <= len(received); i++ {
+		gapEnd := end
+		if i < len(received) && received[i] < end {
+			gapEnd = received[i]
+		}
+		for seq := next; seq < gapEnd; seq++ {
+			if len(missing) == limit {
+				return missing, true
+			}
+			missing = append(missing, seq)
+		}
+		if gapEnd == end {
+			break
+		}
+		next = gapEnd + 1
+	}
+	return missing, false
+}
@@ -0,0 +1,57 @@
+package assemble
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMissingChunksGaps(t *testing.T) {
+	for _, test := range []struct {
+		received    []int64
+		totalChunks int64
+		limit       int
+		missing     []int64
+		truncated   bool
+	}{
+		{nil, 3, 10, []int64{0, 1, 2}, false},
+		{[]int64{0, 1, 2}, 3, 10, []int64{}, false},
+		{[]int64{1, 4}, 6, 10, []int64{0, 2, 3, 5}, false},
+		{[]int64{1, 4}, 0, 10, []int64{0, 2, 3}, false},
+		{nil, 0, 10, []int64{}, false},
+		{[]int64{1, 4}, 6, 2, []int64{0, 2}, true},
+		{[]int64{0, 1}, 3, 1, []int64{2}, false},
+		{[]int64{5}, 1 << 50, 3, []int64{0, 1, 2}, true},
+		{[]int64{0, 1 << 50}, 0, 2, []int64{1, 2}, true},
+	} {
+		missing, truncated := missingChunks(test.received, test.totalChunks, test.limit)
+		if !reflect.DeepEqual(missing, test.missing) || truncated != test.truncated {
+			t.Errorf("missingChunks(%v, %d, %d) = %v, %v, want %v, %v",
+				test.received, test.totalChunks, test.limit, missing, truncated, test.missing, test.truncated)
+		}
+	}
+}
+
+func TestReportMissingChunksTruncated(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ReportMissingChunks: true,
+		MaxMissingChunks:    2,
+		ChunkSequenceBase:   1,
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1000000000}`, nil)
+
+	progress := ta.mustSend(uploadID, 2, "b", nil, http.StatusOK)
+	if !reflect.DeepEqual(progress.MissingChunks, []int64{1, 3}) || !progress.MissingTruncated {
+		t.Errorf("got missing %v, truncated %v", progress.MissingChunks, progress.MissingTruncated)
+	}
+}
+
+func TestReportMissingChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ReportMissingChunks: true})
+	uploadID := ta.startUpload(`{"total_chunks": 4}`, nil)
+
+	progress := ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	if !reflect.DeepEqual(progress.MissingChunks, []int64{0, 1, 3}) || progress.MissingTruncated {
+		t.Errorf("got missing %v, truncated %v", progress.MissingChunks, progress.MissingTruncated)
+	}
+}
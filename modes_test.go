@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd
+
+package assemble
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFileModes(t *testing.T) {
+	// Modes are reduced by the umask.
+	defer syscall.Umask(syscall.Umask(0))
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ChunkFileMode:     0600,
+		CompletedFileMode: 0640,
+		DirMode:           0700,
+		CompletedNamer: func(fileID string, _ map[string]interface{}) string {
+			return "nested/" + fileID
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	checkMode(t, filepath.Join(ta.a.Config.ChunksDir, ta.a.fileID(uploadID)+"-0"), 0600)
+	ta.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	checkMode(t, filepath.Join(ta.a.Config.CompletedDir, "nested"), os.ModeDir|0700)
+	checkMode(t, filepath.Join(ta.a.Config.CompletedDir, "nested", ta.a.fileID(uploadID)), 0640)
+}
+
+func checkMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != want {
+		t.Errorf("%s: got mode %v, want %v", path, info.Mode(), want)
+	}
+}
@@ -0,0 +1,74 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const DefaultChunkFormField = "file"
+
+// Form values are only expected to hold IDs.
+const maxFormValueSize = 1024
+
+func isMultipartChunk(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// readMultipartChunk reads the chunk from the ChunkFormField part of a
+// multipart/form-data request. Form values named after the upload and chunk
+// ID headers are copied into the request headers if the headers weren't
+// sent, so the rest of the middleware doesn't need to know where they came
+// from.
+func (a *FileChunksAssembler) readMultipartChunk(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	var chunkData []byte
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := part.FormName()
+		switch {
+		case name == a.Config.ChunkFormField && part.FileName() != "":
+			if found {
+				return nil, fmt.Errorf("multiple %q parts", name)
+			}
+			chunkData, err = a.readLimited(part)
+			if err != nil {
+				return nil, err
+			}
+			found = true
+		case isIDField(name, a.Config.UploadIdentifierHeader), isIDField(name, a.Config.ChunkIdentifierHeader):
+			if r.Header.Get(name) != "" {
+				continue
+			}
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxFormValueSize))
+			if err != nil {
+				return nil, err
+			}
+			r.Header.Set(name, string(value))
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("missing %q part", a.Config.ChunkFormField)
+	}
+	return chunkData, nil
+}
+
+// isIDField compares case-insensitively since header names are.
+func isIDField(name string, header string) bool {
+	return strings.EqualFold(name, header)
+}
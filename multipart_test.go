@@ -0,0 +1,116 @@
+package assemble
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// multipartChunk encodes a chunk as a file part named field, after the form
+// values, and returns it with its content type.
+func multipartChunk(t *testing.T, values map[string]string, field string, chunk string) (*bytes.Buffer, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, value := range values {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := mw.CreateFormFile(field, "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte(chunk))
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &body, mw.FormDataContentType()
+}
+
+// sendMultipart sends a multipart chunk without the ID headers.
+func (ta *testAssembler) sendMultipart(body *bytes.Buffer, contentType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/parts", body)
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMultipartChunks(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	for seq, chunk := range []string{"hello ", "world"} {
+		body, contentType := multipartChunk(t, map[string]string{
+			DefaultUploadIdentifierHeader: strconv.FormatInt(uploadID, 10),
+			DefaultChunkIdentifierHeader:  strconv.Itoa(seq),
+		}, DefaultChunkFormField, chunk)
+		if rec := ta.sendMultipart(body, contentType); rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+		}
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello world" {
+		t.Errorf("got completed files %q, want %q", files, []string{"hello world"})
+	}
+}
+
+func TestMultipartChunkHeadersTakePrecedence(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	// The form value names the wrong chunk, but the header is used.
+	body, contentType := multipartChunk(t, map[string]string{
+		DefaultChunkIdentifierHeader: "0",
+	}, DefaultChunkFormField, "b")
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	req := httptest.NewRequest(http.MethodPost, "/parts", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	req.Header.Set(DefaultChunkIdentifierHeader, "1")
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q, want %q", files, []string{"ab"})
+	}
+}
+
+func TestMultipartChunkFormField(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkFormField: "chunk"})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	values := map[string]string{
+		DefaultUploadIdentifierHeader: strconv.FormatInt(uploadID, 10),
+		DefaultChunkIdentifierHeader:  "0",
+	}
+	body, contentType := multipartChunk(t, values, DefaultChunkFormField, "data")
+	rec := ta.sendMultipart(body, contentType)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d %s for a chunk in the wrong field, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+	}
+	if got, want := errorBody(t, rec), `missing "chunk" part`; got != want {
+		t.Errorf("got error %q, want %q", got, want)
+	}
+	body, contentType = multipartChunk(t, values, "chunk", "data")
+	if rec := ta.sendMultipart(body, contentType); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "data" {
+		t.Errorf("got completed files %q, want %q", files, []string{"data"})
+	}
+}
+
+func TestMultipartChunkTooLarge(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxChunkSize: 4})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	body, contentType := multipartChunk(t, map[string]string{
+		DefaultUploadIdentifierHeader: strconv.FormatInt(uploadID, 10),
+		DefaultChunkIdentifierHeader:  "0",
+	}, DefaultChunkFormField, "too large")
+	if rec := ta.sendMultipart(body, contentType); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+}
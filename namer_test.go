@@ -0,0 +1,85 @@
+package assemble
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompletedNamer(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		CompletedNamer: func(fileID string, metadata map[string]interface{}) string {
+			if metadata["ext"] == nil {
+				return ""
+			}
+			return "2020/01/" + fileID + "." + metadata["ext"].(string)
+		},
+	})
+	header := map[string]string{DefaultMetadataHeaderPrefix + "ext": "txt"}
+	var uploadIDs []int64
+	for _, body := range []string{"first", "second"} {
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, header)
+		ta.mustSend(uploadID, 0, body, nil, http.StatusOK)
+		uploadIDs = append(uploadIDs, uploadID)
+	}
+	// An empty name falls back to the file ID.
+	unnamed := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(unnamed, 0, "third", nil, http.StatusOK)
+
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join("2020", "01", ta.a.fileID(uploadIDs[0])+".txt"): "first",
+		filepath.Join("2020", "01", ta.a.fileID(uploadIDs[1])+".txt"): "second",
+		ta.a.fileID(unnamed): "third",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
+
+func TestCompletedNamerClash(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		CompletedNamer: func(string, map[string]interface{}) string {
+			return "out/report.pdf"
+		},
+	})
+	for _, body := range []string{"first", "second"} {
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+		ta.mustSend(uploadID, 0, body, nil, http.StatusOK)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join("out", "report.pdf"):     "first",
+		filepath.Join("out", "report (1).pdf"): "second",
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
+
+func TestCompletedNamerOutsideCompletedDir(t *testing.T) {
+	parent := t.TempDir()
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ChunksDir:    t.TempDir(),
+		CompletedDir: filepath.Join(parent, "completed"),
+		CompletedNamer: func(string, map[string]interface{}) string {
+			return "../escaped"
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusInternalServerError)
+	files, err := readDirFiles(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got files %v", files)
+	}
+}
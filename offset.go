@@ -0,0 +1,105 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// ErrOverlappingRange is returned with HTTP 409 when a chunk sent with
+// OffsetHeader overlaps a range that was already received. A chunk sent
+// again with the same offset and size replaces it.
+var ErrOverlappingRange = errors.New("chunk overlaps a range that was already received")
+
+var (
+	errRangeOutOfBounds   = errors.New("chunk goes past the end of the file")
+	errOffsetsUnsupported = errors.New("OffsetHeader needs a store that implements SequenceFinalizer and a ChunkSequenceBase of 0")
+)
+
+// With OffsetHeader, each chunk is a byte range of the file, and is
+// recorded as the chunk numbered by its offset. Ranges can't overlap or go
+// past the size of the file, so the upload is complete once the received
+// bytes add up to its size. Until then, the bytes that haven't been
+// received are gaps between the ranges.
+
+// getOffset returns the offset of a request's chunk in the file.
+func (a *FileChunksAssembler) getOffset(r *http.Request) (int64, error) {
+	value := r.Header.Get(a.Config.OffsetHeader)
+	if value == "" {
+		return 0, &requestError{kind: ErrInvalidChunkID, err: fmt.Errorf("missing %s", a.Config.OffsetHeader)}
+	}
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, &requestError{kind: ErrInvalidChunkID, err: fmt.Errorf("%s must be a non-negative integer", a.Config.OffsetHeader)}
+	}
+	return offset, nil
+}
+
+// checkRange checks that a chunk fits in the file without overlapping
+// the ranges received so far, and returns the number of ranges the upload
+// will have with it.
+func (a *FileChunksAssembler) checkRange(uploadID int64, info UploadInfo, offset int64, length int64) (int64, error) {
+	if info.Size <= 0 {
+		return 0, errInvalidTotalSize
+	}
+	if offset+length > info.Size {
+		return 0, errRangeOutOfBounds
+	}
+	received, err := a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	ranges := int64(len(received))
+	i := sort.Search(len(received), func(i int) bool {
+		return received[i] >= offset
+	})
+	if i < len(received) {
+		if received[i] == offset {
+			chunk, _, err := a.Config.Tracker.GetChunk(uploadID, offset)
+			if err != nil {
+				return 0, err
+			}
+			if chunk.Size != length {
+				return 0, ErrOverlappingRange
+			}
+			return ranges, nil
+		}
+		if received[i] < offset+length {
+			return 0, ErrOverlappingRange
+		}
+	}
+	if i > 0 {
+		previous, _, err := a.Config.Tracker.GetChunk(uploadID, received[i-1])
+		if err != nil {
+			return 0, err
+		}
+		if received[i-1]+previous.Size > offset {
+			return 0, ErrOverlappingRange
+		}
+	}
+	return ranges + 1, nil
+}
+
+// completeRanges sets the number of chunks of an upload once its ranges
+// cover the whole file, so that it can be claimed.
+func (a *FileChunksAssembler) completeRanges(uploadID int64, info *UploadInfo, progress Progress) error {
+	if info.TotalChunks != 0 || progress.Bytes != info.Size {
+		return nil
+	}
+	info.TotalChunks = progress.Chunks
+	return a.setTotalChunks(uploadID, *info)
+}
+
+// rangeError writes the response for an error from checkRange.
+func (a *FileChunksAssembler) rangeError(w http.ResponseWriter, r *http.Request, uploadID int64, err error) {
+	switch {
+	case errors.Is(err, ErrOverlappingRange):
+		a.writeError(w, r, http.StatusConflict, err)
+	case errors.Is(err, errRangeOutOfBounds), errors.Is(err, errInvalidTotalSize), errors.Is(err, ErrInvalidChunkTotal):
+		a.badRequest(w, r, err)
+	default:
+		a.internalError(w, r, CodeChunkLookupFailed, "failed to check range", err, "upload_id", uploadID)
+	}
+}
@@ -0,0 +1,110 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const testOffsetHeader = "x-assemble-offset"
+
+// sendOffset sends a chunk placed at an offset of the file.
+func (ta *testAssembler) sendOffset(uploadID int64, offset int64, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/parts", bytes.NewBufferString(body))
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	req.Header.Set(testOffsetHeader, strconv.FormatInt(offset, 10))
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	return rec
+}
+
+// mustSendOffset sends a chunk at an offset and fails the test unless it
+// gets the status.
+func (ta *testAssembler) mustSendOffset(uploadID int64, offset int64, body string, status int) ProgressInfo {
+	ta.t.Helper()
+	rec := ta.sendOffset(uploadID, offset, body)
+	if rec.Code != status {
+		ta.t.Fatalf("offset %d: got %d %s, want %d", offset, rec.Code, rec.Body.String(), status)
+	}
+	var progress ProgressInfo
+	_ = json.Unmarshal(rec.Body.Bytes(), &progress)
+	return progress
+}
+
+func TestOffsetsContiguous(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{OffsetHeader: testOffsetHeader})
+	uploadID := ta.startUpload(`{"size": 11}`, nil)
+	// Ranges can arrive in any order.
+	if progress := ta.mustSendOffset(uploadID, 6, "world", http.StatusOK); progress.ReceivedBytes != 5 {
+		t.Errorf("got %d received bytes, want 5", progress.ReceivedBytes)
+	}
+	ta.mustSendOffset(uploadID, 0, "hello ", http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"hello world"}) {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestOffsetsGap(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{OffsetHeader: testOffsetHeader})
+	uploadID := ta.startUpload(`{"size": 11}`, nil)
+	ta.mustSendOffset(uploadID, 0, "hello", http.StatusOK)
+	ta.mustSendOffset(uploadID, 7, "orld", http.StatusOK)
+	// Bytes 5 and 6 are missing.
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Fatalf("got completed files %q with a gap", files)
+	}
+	ta.mustSendOffset(uploadID, 5, " w", http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"hello world"}) {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestOffsetsOverlap(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{OffsetHeader: testOffsetHeader})
+	uploadID := ta.startUpload(`{"size": 11}`, nil)
+	ta.mustSendOffset(uploadID, 3, "lo w", http.StatusOK)
+
+	for _, test := range []struct {
+		offset int64
+		body   string
+	}{
+		{0, "hello"},
+		{5, "world"},
+		{3, "lo"},
+		{2, "llo wo"},
+	} {
+		rec := ta.sendOffset(uploadID, test.offset, test.body)
+		if rec.Code != http.StatusConflict || errorBody(t, rec) != ErrOverlappingRange.Error() {
+			t.Errorf("%q at %d: got %d %s, want %d", test.body, test.offset, rec.Code, rec.Body.String(), http.StatusConflict)
+		}
+	}
+	// The same range can be sent again.
+	ta.mustSendOffset(uploadID, 3, "lo w", http.StatusOK)
+	if rec := ta.sendOffset(uploadID, 8, "orld"); rec.Code != http.StatusBadRequest {
+		t.Errorf("range past the end: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+	}
+
+	ta.mustSendOffset(uploadID, 0, "hel", http.StatusOK)
+	ta.mustSendOffset(uploadID, 7, "orld", http.StatusOK)
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"hello world"}) {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestOffsetsWithSequenceBase(t *testing.T) {
+	defer func() {
+		if recover() != errOffsetsUnsupported {
+			t.Error("OffsetHeader was accepted with a ChunkSequenceBase of 1")
+		}
+	}()
+	NewFileChunksAssembler(&AssemblerConfig{
+		ChunksDir:         t.TempDir(),
+		CompletedDir:      t.TempDir(),
+		OffsetHeader:      testOffsetHeader,
+		ChunkSequenceBase: 1,
+	})
+}
@@ -0,0 +1,63 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+var errPostProcessFailed = errors.New("file couldn't be processed")
+
+// postProcess passes a completed file to PostProcessor and returns whether
+// it succeeded. If the processor wrote a new file, the original is deleted
+// and combined is updated to refer to the new one. If it failed, the file
+// is deleted and the upload is rejected with the returned result.
+func (a *FileChunksAssembler) postProcess(ctx context.Context, uploadID int64, combined *combinedFile) (uploadResult, bool, error) {
+	if a.Config.PostProcessor == nil {
+		return uploadResult{}, true, nil
+	}
+	processedPath, processErr := a.Config.PostProcessor(ctx, combined.location)
+	if processErr == nil && processedPath != combined.location {
+		if err := a.Config.Store.DeleteCompleted(combined.name); err != nil {
+			a.Config.Logger.Error("failed to delete original of processed file", "upload_id", uploadID, "error", err)
+		}
+		combined.location = processedPath
+		combined.processed = true
+	}
+	if processErr == nil {
+		return uploadResult{}, true, nil
+	}
+	if err := a.Config.Store.DeleteCompleted(combined.name); err != nil {
+		a.Config.Logger.Error("failed to delete rejected file", "upload_id", uploadID, "error", err)
+	}
+	if ctx.Err() != nil {
+		// The client has gone away.
+		return uploadResult{}, false, ctx.Err()
+	}
+	a.Config.Logger.Info("file couldn't be processed", "upload_id", uploadID, "error", processErr)
+	a.rejectUpload(uploadID, errPostProcessFailed.Error())
+	return uploadResult{
+		rejectedCode:  http.StatusUnprocessableEntity,
+		rejectedError: errPostProcessFailed.Error(),
+	}, false, nil
+}
+
+// openCombined opens the file to pass downstream, which is the output of
+// PostProcessor if it wrote a new file.
+func (a *FileChunksAssembler) openCombined(combined combinedFile) (io.ReadCloser, int64, error) {
+	if !combined.processed {
+		return a.Config.Store.OpenCompleted(combined.name)
+	}
+	f, err := os.Open(combined.location)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
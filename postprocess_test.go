@@ -0,0 +1,98 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// upperCaser is a PostProcessor that writes the completed file in upper
+// case to a new file in dir.
+func upperCaser(dir string) func(context.Context, string) (string, error) {
+	return func(_ context.Context, path string) (string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		processed := filepath.Join(dir, filepath.Base(path)+".upper")
+		return processed, ioutil.WriteFile(processed, bytes.ToUpper(data), 0644)
+	}
+}
+
+func TestPostProcessorNewFile(t *testing.T) {
+	processedDir := t.TempDir()
+	ta := newTestAssembler(t, &AssemblerConfig{
+		PostProcessor:           upperCaser(processedDir),
+		ExposeCompletedLocation: true,
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(uploadID, 0, "hello ", nil, http.StatusOK)
+	progress := ta.mustSend(uploadID, 1, "world", nil, http.StatusOK)
+
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"HELLO WORLD"}) {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	want := filepath.Join(processedDir, ta.a.fileID(uploadID)+".upper")
+	if progress.Location != want {
+		t.Errorf("got location %q, want %q", progress.Location, want)
+	}
+	// The original is deleted.
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got completed files %v after processing", files)
+	}
+}
+
+func TestPostProcessorInPlace(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		PostProcessor: func(_ context.Context, path string) (string, error) {
+			return path, ioutil.WriteFile(path, []byte("rewritten"), 0644)
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "original", nil, http.StatusOK)
+
+	if files := ta.completedFiles(); !reflect.DeepEqual(files, []string{"rewritten"}) {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{ta.a.fileID(uploadID): "rewritten"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
+
+func TestPostProcessorError(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		PostProcessor: func(context.Context, string) (string, error) {
+			return "", errors.New("unsupported codec")
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusUnprocessableEntity)
+	// The processor's error isn't sent to the client.
+	if progress.RejectedError == nil || *progress.RejectedError != errPostProcessFailed.Error() {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, errPostProcessFailed)
+	}
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got completed files %v after a failed processor", files)
+	}
+}
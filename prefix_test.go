@@ -0,0 +1,64 @@
+package assemble
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestAssemblersSharingStore(t *testing.T) {
+	chunksDir, completedDir := t.TempDir(), t.TempDir()
+	// Both assemblers give out the same upload IDs.
+	sequentialIDs := func() func() (int64, error) {
+		next := int64(0)
+		return func() (int64, error) {
+			next++
+			return next, nil
+		}
+	}
+	avatars := newTestAssembler(t, &AssemblerConfig{
+		ChunksDir:         chunksDir,
+		CompletedDir:      completedDir,
+		FileIDPrefix:      "avatar-",
+		UploadIDGenerator: sequentialIDs(),
+		MaxFileSize:       8,
+	})
+	videos := newTestAssembler(t, &AssemblerConfig{
+		ChunksDir:         chunksDir,
+		CompletedDir:      completedDir,
+		FileIDPrefix:      "video-",
+		UploadIDGenerator: sequentialIDs(),
+	})
+
+	avatar := avatars.startUpload(`{"total_chunks": 2}`, nil)
+	video := videos.startUpload(`{"total_chunks": 2}`, nil)
+	if avatar != video {
+		t.Fatalf("got upload IDs %d and %d, want the same", avatar, video)
+	}
+	// Interleaved chunks of the two uploads don't mix.
+	avatars.mustSend(avatar, 0, "face", nil, http.StatusOK)
+	videos.mustSend(video, 0, "a long ", nil, http.StatusOK)
+	avatars.mustSend(avatar, 1, ".png", nil, http.StatusOK)
+	videos.mustSend(video, 1, "film", nil, http.StatusOK)
+
+	// Each assembler keeps its own limits.
+	big := avatars.startUpload(`{"total_chunks": 1}`, nil)
+	if rec := avatars.send(big, 0, "too big for an avatar", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+
+	if files := avatars.completedFiles(); !reflect.DeepEqual(files, []string{"face.png"}) {
+		t.Errorf("got avatars %q", files)
+	}
+	if files := videos.completedFiles(); !reflect.DeepEqual(files, []string{"a long film"}) {
+		t.Errorf("got videos %q", files)
+	}
+	files, err := readDirFiles(completedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"avatar-1": "face.png", "video-1": "a long film"}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got completed files %v, want %v", files, want)
+	}
+}
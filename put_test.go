@@ -0,0 +1,60 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// countingStore is a MemoryStore that counts chunk writes.
+type countingStore struct {
+	*MemoryStore
+	lock   sync.Mutex
+	writes int
+}
+
+func (s *countingStore) WriteChunk(fileID string, seq int64, data []byte) error {
+	s.lock.Lock()
+	s.writes++
+	s.lock.Unlock()
+	return s.MemoryStore.WriteChunk(fileID, seq, data)
+}
+
+func TestPutChunkIdempotent(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	ta := newTestAssembler(t, &AssemblerConfig{
+		Store:        store,
+		ChunkMethods: []string{http.MethodPost, http.MethodPut},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	put := func(seq int64, body string, status int) ProgressInfo {
+		t.Helper()
+		rec := ta.sendMethod(http.MethodPut, uploadID, seq, body, nil)
+		if rec.Code != status {
+			t.Fatalf("PUT chunk %d %q: got %d %s, want %d", seq, body, rec.Code, rec.Body.String(), status)
+		}
+		var progress ProgressInfo
+		_ = json.Unmarshal(rec.Body.Bytes(), &progress)
+		return progress
+	}
+
+	put(0, "abc", http.StatusOK)
+	// The same chunk again is a no-op that gets the current progress.
+	if progress := put(0, "abc", http.StatusOK); progress.CurrentChunks != 1 || progress.ReceivedBytes != 3 {
+		t.Errorf("got progress %+v after a duplicate PUT", progress)
+	}
+	if store.writes != 1 {
+		t.Errorf("got %d chunk writes, want 1", store.writes)
+	}
+	// Different contents conflict, even without RejectChunkOverwrite.
+	rec := ta.sendMethod(http.MethodPut, uploadID, 0, "xyz", nil)
+	if rec.Code != http.StatusConflict || errorBody(t, rec) != errChunkConflict.Error() {
+		t.Errorf("conflicting PUT: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+
+	put(1, "de", http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abcde" {
+		t.Errorf("got completed files %q", files)
+	}
+}
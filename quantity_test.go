@@ -0,0 +1,52 @@
+package assemble
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestChunkQuantityChange(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ControlHeader: testControlHeader})
+	// The total is set by the first chunk that declares it.
+	uploadID := ta.startUpload(`{}`, nil)
+	if rec := ta.sendControl(fmt.Sprintf(`{"id": %d, "seq": 0, "total": 2}`, uploadID), "a"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	rec := ta.sendControl(fmt.Sprintf(`{"id": %d, "seq": 1, "total": 3}`, uploadID), "b")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusConflict)
+	}
+	var response chunkQuantityResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := chunkQuantityResponse{
+		Error:          (&chunkQuantityError{expected: 2, declared: 3}).Error(),
+		ExpectedChunks: 2,
+		DeclaredChunks: 3,
+	}
+	if response != want {
+		t.Errorf("got %+v, want %+v", response, want)
+	}
+
+	// The rejected chunk isn't stored, and the upload can still complete.
+	if rec := ta.sendControl(fmt.Sprintf(`{"id": %d, "seq": 1, "total": 2}`, uploadID), "b"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestChunkQuantityErrorIs(t *testing.T) {
+	var err error = &chunkQuantityError{expected: 2, declared: 3}
+	if !errors.Is(err, ErrChunkQuantityChange) {
+		t.Errorf("%v isn't ErrChunkQuantityChange", err)
+	}
+	if errors.Is(err, ErrInvalidChunkTotal) {
+		t.Errorf("%v is ErrInvalidChunkTotal", err)
+	}
+}
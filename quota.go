@@ -0,0 +1,113 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var errQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaResolver returns the user a request is from and how many bytes of
+// completed files they can have. Requests with an empty user ID or a limit
+// of 0 aren't limited.
+type QuotaResolver func(r *http.Request) (userID string, limitBytes int64)
+
+// QuotaStore records how many bytes of completed files each user has, for
+// QuotaResolver. Implementations shared by multiple assemblers must make
+// AddUsage atomic. Applications that delete completed files can call
+// AddUsage with a negative size to give the space back.
+type QuotaStore interface {
+	Usage(userID string) (int64, error)
+	AddUsage(userID string, bytes int64) error
+}
+
+// MemoryQuotaStore keeps usage in the memory of a single process, so it's
+// lost on restart.
+type MemoryQuotaStore struct {
+	usage map[string]int64
+	lock  sync.Mutex
+}
+
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{
+		usage: make(map[string]int64),
+	}
+}
+
+func (s *MemoryQuotaStore) Usage(userID string) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.usage[userID], nil
+}
+
+func (s *MemoryQuotaStore) AddUsage(userID string, bytes int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.usage[userID] += bytes
+	if s.usage[userID] <= 0 {
+		delete(s.usage, userID)
+	}
+	return nil
+}
+
+// checkQuota returns errQuotaExceeded if the user of a request can't have
+// another file of the given size. Files of uploads in progress don't count
+// towards the usage until they complete.
+func (a *FileChunksAssembler) checkQuota(r *http.Request, size int64) error {
+	if a.Config.QuotaResolver == nil {
+		return nil
+	}
+	userID, limit := a.Config.QuotaResolver(r)
+	if userID == "" || limit <= 0 {
+		return nil
+	}
+	usage, err := a.Config.QuotaStore.Usage(userID)
+	if err != nil {
+		return err
+	}
+	if usage+size > limit {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// addQuotaUsage counts a completed file towards the usage of the user of
+// the request that completed it.
+func (a *FileChunksAssembler) addQuotaUsage(r *http.Request, uploadID int64, size int64) {
+	if a.Config.QuotaResolver == nil {
+		return
+	}
+	userID, _ := a.Config.QuotaResolver(r)
+	if userID == "" {
+		return
+	}
+	if err := a.Config.QuotaStore.AddUsage(userID, size); err != nil {
+		a.Config.Logger.Error("failed to add quota usage", "upload_id", uploadID, "user_id", userID, "error", err)
+	}
+}
+
+// quotaError writes the response for an error from checkQuota.
+func (a *FileChunksAssembler) quotaError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errQuotaExceeded) {
+		a.writeError(w, r, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	a.internalError(w, r, CodeQuotaLookupFailed, "failed to check quota", err)
+}
+
+// cancelOverQuota checks the quota against the bytes received for an
+// upload, and cancels the upload if it's exceeded. It returns false if the
+// upload can't continue, in which case the response has been written.
+func (a *FileChunksAssembler) cancelOverQuota(w http.ResponseWriter, r *http.Request, uploadID int64, receivedBytes int64) bool {
+	err := a.checkQuota(r, receivedBytes)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		a.cleanupUpload(uploadID)
+		a.rejectUpload(uploadID, err.Error())
+	}
+	a.quotaError(w, r, err)
+	return false
+}
@@ -0,0 +1,69 @@
+package assemble
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// headerQuota is a QuotaResolver that takes the user from a header and
+// gives every user the same limit.
+func headerQuota(limit int64) QuotaResolver {
+	return func(r *http.Request) (string, int64) {
+		return r.Header.Get("x-user"), limit
+	}
+}
+
+func TestQuotaExceededOnChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{QuotaResolver: headerQuota(10)})
+	alice := map[string]string{"x-user": "alice"}
+
+	first := ta.startUpload(`{"total_chunks": 1}`, alice)
+	ta.mustSend(first, 0, "123456", alice, http.StatusOK)
+	// The second upload only goes over the quota once its chunks add up to
+	// more than alice has left.
+	second := ta.startUpload(`{"total_chunks": 2}`, alice)
+	ta.mustSend(second, 0, "123", alice, http.StatusOK)
+	rec := ta.send(second, 1, "45", alice)
+	if rec.Code != http.StatusRequestEntityTooLarge || errorBody(t, rec) != errQuotaExceeded.Error() {
+		t.Errorf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+	// The upload was cancelled.
+	if rec := ta.send(second, 1, "4", alice); rec.Code != http.StatusBadRequest {
+		t.Errorf("chunk of the cancelled upload: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+	}
+
+	// Other users have their own quota.
+	bob := map[string]string{"x-user": "bob"}
+	third := ta.startUpload(`{"total_chunks": 1}`, bob)
+	ta.mustSend(third, 0, "123456", bob, http.StatusOK)
+
+	usage, err := ta.a.Config.QuotaStore.Usage("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage != 6 {
+		t.Errorf("got usage %d for alice, want 6", usage)
+	}
+	if files := ta.completedFiles(); len(files) != 2 {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestQuotaExceededOnStart(t *testing.T) {
+	quota := NewMemoryQuotaStore()
+	if err := quota.AddUsage("alice", 8); err != nil {
+		t.Fatal(err)
+	}
+	ta := newTestAssembler(t, &AssemblerConfig{QuotaResolver: headerQuota(10), QuotaStore: quota})
+	alice := map[string]string{"x-user": "alice"}
+	for _, size := range []int64{3, 20} {
+		rec := ta.start(`{"total_chunks": 1, "size": `+strconv.FormatInt(size, 10)+`}`, alice)
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("size %d: got %d %s, want %d", size, rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+		}
+	}
+	ta.startUpload(`{"total_chunks": 1, "size": 2}`, alice)
+	// Requests without a user aren't limited.
+	ta.startUpload(`{"total_chunks": 1, "size": 20}`, nil)
+}
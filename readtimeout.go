@@ -0,0 +1,72 @@
+package assemble
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var errChunkReadTimeout = errors.New("timed out reading chunk")
+
+// readDeadliner is implemented by the server's ResponseWriter, for setting
+// the read deadline of the connection.
+type readDeadliner interface {
+	SetReadDeadline(deadline time.Time) error
+}
+
+// limitBodyRead makes reads of a request's body fail with
+// errChunkReadTimeout after ChunkReadTimeout, and returns a function that
+// must be called once the body has been read. If the connection's read
+// deadline can't be set, a client that stops sending entirely is only
+// caught by the server's ReadTimeout, but one that trickles data is still
+// caught on its next read.
+func (a *FileChunksAssembler) limitBodyRead(w http.ResponseWriter, r *http.Request) func() {
+	if a.Config.ChunkReadTimeout <= 0 {
+		return func() {}
+	}
+	deadline := time.Now().Add(a.Config.ChunkReadTimeout)
+	conn := findReadDeadliner(w)
+	if conn != nil {
+		_ = conn.SetReadDeadline(deadline)
+	}
+	body := &deadlineReader{ReadCloser: r.Body, deadline: deadline}
+	r.Body = body
+	return func() {
+		// After a timeout, the deadline stays so that the server doesn't
+		// wait for the rest of the body before closing the connection.
+		if conn != nil && !body.timedOut {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+	}
+}
+
+// findReadDeadliner looks through ResponseWriters that wrap others like
+// http.ResponseController does.
+func findReadDeadliner(w http.ResponseWriter) readDeadliner {
+	for {
+		if conn, ok := w.(readDeadliner); ok {
+			return conn
+		}
+		wrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = wrapper.Unwrap()
+	}
+}
+
+type deadlineReader struct {
+	io.ReadCloser
+	deadline time.Time
+	timedOut bool
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != io.EOF && time.Now().After(r.deadline) {
+		r.timedOut = true
+		return n, errChunkReadTimeout
+	}
+	return n, err
+}
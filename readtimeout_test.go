@@ -0,0 +1,82 @@
+package assemble
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowReader returns one byte of data per read, after a delay.
+type slowReader struct {
+	data  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p[:1], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestChunkReadTimeout(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkReadTimeout: 50 * time.Millisecond})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+
+	req := chunkRequest(http.MethodPost, uploadID, 0, "", nil)
+	req.Body = ioutil.NopCloser(&slowReader{data: "trickled slowly", delay: 10 * time.Millisecond})
+	rec := httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestTimeout || errorBody(t, rec) != errChunkReadTimeout.Error() {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestTimeout)
+	}
+
+	// A chunk read in time is accepted.
+	req = chunkRequest(http.MethodPost, uploadID, 0, "", nil)
+	req.Body = ioutil.NopCloser(&slowReader{data: "ok", delay: time.Millisecond})
+	rec = httptest.NewRecorder()
+	ta.h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ok" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestChunkReadTimeoutStalledClient(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkReadTimeout: 50 * time.Millisecond})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	server := httptest.NewServer(ta.h)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The client sends part of the body and then nothing.
+	request := "POST /parts HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n" +
+		DefaultUploadIdentifierHeader + ": " + strconv.FormatInt(uploadID, 10) + "\r\n" +
+		DefaultChunkIdentifierHeader + ": 0\r\n\r\npartial"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("the server didn't respond to a stalled client: %v", err)
+	}
+	if !strings.HasPrefix(string(response), "HTTP/1.1 408") {
+		t.Errorf("got response %q, want 408", response)
+	}
+}
@@ -0,0 +1,60 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUploadsSurviveRestart(t *testing.T) {
+	chunksDir, completedDir := t.TempDir(), t.TempDir()
+	before := newTestAssembler(t, &AssemblerConfig{ChunksDir: chunksDir, CompletedDir: completedDir})
+	uploadID := before.startUpload(`{"total_chunks": 3, "metadata": {"name": "a.txt"}}`, nil)
+	before.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	before.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	if err := before.a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := newTestAssembler(t, &AssemblerConfig{ChunksDir: chunksDir, CompletedDir: completedDir})
+	info, err := after.a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		t.Fatalf("upload wasn't recovered: %v", err)
+	}
+	if info.TotalChunks != 3 || info.Metadata["name"] != "a.txt" {
+		t.Errorf("got recovered info %+v", info)
+	}
+	received, err := after.a.Config.Tracker.ReceivedChunks(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 || received[0] != 0 || received[1] != 2 {
+		t.Errorf("got received chunks %v, want [0 2]", received)
+	}
+	progress := after.mustSend(uploadID, 1, "b", nil, http.StatusOK)
+	if progress.ReceivedBytes != 3 {
+		t.Errorf("got %d received bytes, want 3", progress.ReceivedBytes)
+	}
+	if files := after.completedFiles(); len(files) != 1 || files[0] != "abc" {
+		t.Errorf("got completed files %q", files)
+	}
+
+	// New uploads don't reuse the recovered ID.
+	if next := after.startUpload(`{"total_chunks": 1}`, nil); next == uploadID {
+		t.Errorf("new upload got the recovered upload's ID %d", next)
+	}
+}
+
+func TestRecoverySkipsOtherPrefixes(t *testing.T) {
+	chunksDir, completedDir := t.TempDir(), t.TempDir()
+	avatars := newTestAssembler(t, &AssemblerConfig{ChunksDir: chunksDir, CompletedDir: completedDir, FileIDPrefix: "avatar-"})
+	avatars.startUpload(`{"total_chunks": 2}`, nil)
+
+	documents := newTestAssembler(t, &AssemblerConfig{ChunksDir: chunksDir, CompletedDir: completedDir, FileIDPrefix: "document-"})
+	count, err := documents.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("recovered %d uploads of another prefix", count)
+	}
+}
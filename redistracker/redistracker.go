@@ -0,0 +1,398 @@
+// Package redistracker provides a Tracker that keeps upload state in Redis,
+// so that chunks of the same upload can be received by different assembler
+// instances.
+package redistracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/dchenz/go-assemble"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ assemble.Tracker = (*Tracker)(nil)
+
+// Scripts check that the upload exists and update the activity set in the
+// same step as the rest, so that an upload removed concurrently isn't left
+// in the activity set or brought back with only some of its fields.
+var (
+	// The activity of an upload only moves forward, since chunks can be
+	// added out of order.
+	addChunkScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+local previous = redis.call("HGET", KEYS[2], ARGV[1]) or 0
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+if ARGV[3] == "" then
+	redis.call("HDEL", KEYS[3], ARGV[1])
+else
+	redis.call("HSET", KEYS[3], ARGV[1], ARGV[3])
+end
+local bytes = redis.call("HINCRBY", KEYS[1], "bytes", ARGV[2] - previous)
+local active = redis.call("ZSCORE", KEYS[4], ARGV[5])
+if not active or tonumber(active) < tonumber(ARGV[4]) then
+	redis.call("ZADD", KEYS[4], ARGV[4], ARGV[5])
+end
+return {redis.call("HLEN", KEYS[2]), bytes}
+`)
+
+	// Upload info is only set if the upload doesn't exist yet.
+	createUploadScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[1], ARGV[2])
+redis.call("HSET", KEYS[1], unpack(ARGV, 3))
+return 1
+`)
+
+	// Fields of an upload are only set if it exists, since HSET would
+	// otherwise create a partial upload.
+	setFieldScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+	releaseCompletionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+redis.call("HDEL", KEYS[1], "completed")
+return 1
+`)
+
+	removeUploadScript = redis.NewScript(`
+redis.call("DEL", KEYS[1], KEYS[2], KEYS[3])
+redis.call("ZREM", KEYS[4], ARGV[1])
+return 1
+`)
+
+	// Only the replica that sets the "completed" field gets to combine.
+	claimCompletionScript = redis.NewScript(`
+local total = redis.call("HGET", KEYS[1], "total")
+if not total then
+	return -1
+end
+if tonumber(total) == 0 or redis.call("HLEN", KEYS[2]) < tonumber(total) then
+	return 0
+end
+return redis.call("HSETNX", KEYS[1], "completed", 1)
+`)
+)
+
+// Tracker stores each upload's info in a hash, and the sizes and checksums
+// of its received chunks in hashes keyed by sequence number.
+//
+// Scripts use the keys of an upload together with the activity set, so with
+// Redis Cluster, Prefix must contain a hash tag, e.g. "{assemble}:", for
+// all of them to be in the same slot.
+type Tracker struct {
+	Client redis.UniversalClient
+	Prefix string
+}
+
+func NewTracker(client redis.UniversalClient, prefix string) *Tracker {
+	return &Tracker{
+		Client: client,
+		Prefix: prefix,
+	}
+}
+
+func (t *Tracker) CreateUpload(info assemble.UploadInfo) (int64, error) {
+	for {
+		// IDs start from 0 like the in-memory tracker.
+		id, err := t.Client.Incr(context.Background(), t.Prefix+"next-id").Result()
+		if err != nil {
+			return 0, err
+		}
+		id--
+		// IDs may have been taken by CreateUploadWithID.
+		err = t.CreateUploadWithID(id, info)
+		if errors.Is(err, assemble.ErrUploadExists) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+}
+
+func (t *Tracker) CreateUploadWithID(uploadID int64, info assemble.UploadInfo) error {
+	ctx := context.Background()
+	metadata, err := json.Marshal(info.Metadata)
+	if err != nil {
+		return err
+	}
+	created, err := createUploadScript.Run(ctx, t.Client, []string{t.infoKey(uploadID), t.activityKey()},
+		info.CreatedAt.UnixNano(), uploadID,
+		"total", info.TotalChunks,
+		"metadata", metadata,
+		"checksum", info.Checksum,
+		"size", info.Size,
+		"created", info.CreatedAt.UnixNano(),
+		"key_fingerprint", info.KeyFingerprint,
+	).Int64()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return assemble.ErrUploadExists
+	}
+	return nil
+}
+
+func (t *Tracker) GetUpload(uploadID int64) (assemble.UploadInfo, error) {
+	values, err := t.Client.HMGet(context.Background(), t.infoKey(uploadID), "total", "metadata", "checksum", "created", "size", "key_fingerprint").Result()
+	if err != nil {
+		return assemble.UploadInfo{}, err
+	}
+	if values[0] == nil {
+		return assemble.UploadInfo{}, assemble.ErrUploadNotFound
+	}
+	var info assemble.UploadInfo
+	info.TotalChunks, err = strconv.ParseInt(values[0].(string), 10, 64)
+	if err != nil {
+		return assemble.UploadInfo{}, err
+	}
+	if metadata, ok := values[1].(string); ok {
+		if err := json.Unmarshal([]byte(metadata), &info.Metadata); err != nil {
+			return assemble.UploadInfo{}, err
+		}
+	}
+	if checksum, ok := values[2].(string); ok {
+		info.Checksum = checksum
+	}
+	if created, ok := values[3].(string); ok {
+		createdAt, err := strconv.ParseInt(created, 10, 64)
+		if err != nil {
+			return assemble.UploadInfo{}, err
+		}
+		info.CreatedAt = time.Unix(0, createdAt)
+	}
+	if size, ok := values[4].(string); ok {
+		info.Size, err = strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return assemble.UploadInfo{}, err
+		}
+	}
+	if fingerprint, ok := values[5].(string); ok {
+		info.KeyFingerprint = fingerprint
+	}
+	return info, nil
+}
+
+func (t *Tracker) AddChunk(uploadID int64, seq int64, chunk assemble.ChunkInfo) (assemble.Progress, error) {
+	result, err := addChunkScript.Run(
+		context.Background(),
+		t.Client,
+		[]string{t.infoKey(uploadID), t.chunksKey(uploadID), t.checksumsKey(uploadID), t.activityKey()},
+		seq,
+		chunk.Size,
+		chunk.Checksum,
+		chunk.ReceivedAt.UnixNano(),
+		uploadID,
+	).Result()
+	if err != nil {
+		return assemble.Progress{}, err
+	}
+	values, ok := result.([]interface{})
+	if !ok {
+		return assemble.Progress{}, assemble.ErrUploadNotFound
+	}
+	return assemble.Progress{
+		Chunks: values[0].(int64),
+		Bytes:  values[1].(int64),
+	}, nil
+}
+
+func (t *Tracker) GetChunk(uploadID int64, seq int64) (assemble.ChunkInfo, bool, error) {
+	if err := t.checkExists(uploadID); err != nil {
+		return assemble.ChunkInfo{}, false, err
+	}
+	ctx := context.Background()
+	field := strconv.FormatInt(seq, 10)
+	size, err := t.Client.HGet(ctx, t.chunksKey(uploadID), field).Int64()
+	if errors.Is(err, redis.Nil) {
+		return assemble.ChunkInfo{}, false, nil
+	}
+	if err != nil {
+		return assemble.ChunkInfo{}, false, err
+	}
+	checksum, err := t.Client.HGet(ctx, t.checksumsKey(uploadID), field).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return assemble.ChunkInfo{}, false, err
+	}
+	return assemble.ChunkInfo{Size: size, Checksum: checksum}, true, nil
+}
+
+func (t *Tracker) CountChunks(uploadID int64) (int64, error) {
+	if err := t.checkExists(uploadID); err != nil {
+		return 0, err
+	}
+	return t.Client.HLen(context.Background(), t.chunksKey(uploadID)).Result()
+}
+
+func (t *Tracker) GetProgress(uploadID int64) (assemble.Progress, error) {
+	ctx := context.Background()
+	bytes, err := t.Client.HGet(ctx, t.infoKey(uploadID), "bytes").Int64()
+	if errors.Is(err, redis.Nil) {
+		// The field is only set once a chunk is added.
+		if err := t.checkExists(uploadID); err != nil {
+			return assemble.Progress{}, err
+		}
+		bytes = 0
+	} else if err != nil {
+		return assemble.Progress{}, err
+	}
+	chunks, err := t.Client.HLen(ctx, t.chunksKey(uploadID)).Result()
+	if err != nil {
+		return assemble.Progress{}, err
+	}
+	return assemble.Progress{Chunks: chunks, Bytes: bytes}, nil
+}
+
+func (t *Tracker) ReceivedChunks(uploadID int64) ([]int64, error) {
+	if err := t.checkExists(uploadID); err != nil {
+		return nil, err
+	}
+	fields, err := t.Client.HKeys(context.Background(), t.chunksKey(uploadID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	received := make([]int64, 0, len(fields))
+	for _, field := range fields {
+		seq, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		received = append(received, seq)
+	}
+	sort.Slice(received, func(i, j int) bool {
+		return received[i] < received[j]
+	})
+	return received, nil
+}
+
+func (t *Tracker) ClaimCompletion(uploadID int64) (bool, error) {
+	n, err := claimCompletionScript.Run(
+		context.Background(),
+		t.Client,
+		[]string{t.infoKey(uploadID), t.chunksKey(uploadID)},
+	).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, assemble.ErrUploadNotFound
+	}
+	return n == 1, nil
+}
+
+func (t *Tracker) ReleaseCompletion(uploadID int64) error {
+	return t.runOnUpload(releaseCompletionScript, uploadID)
+}
+
+func (t *Tracker) SetChecksum(uploadID int64, checksum string) error {
+	return t.runOnUpload(setFieldScript, uploadID, "checksum", checksum)
+}
+
+func (t *Tracker) SetMetadata(uploadID int64, metadata map[string]interface{}) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return t.runOnUpload(setFieldScript, uploadID, "metadata", data)
+}
+
+func (t *Tracker) SetTotalChunks(uploadID int64, totalChunks int64) error {
+	return t.runOnUpload(setFieldScript, uploadID, "total", totalChunks)
+}
+
+func (t *Tracker) SetSize(uploadID int64, size int64) error {
+	return t.runOnUpload(setFieldScript, uploadID, "size", size)
+}
+
+func (t *Tracker) RemoveUpload(uploadID int64) error {
+	return removeUploadScript.Run(
+		context.Background(),
+		t.Client,
+		[]string{t.infoKey(uploadID), t.chunksKey(uploadID), t.checksumsKey(uploadID), t.activityKey()},
+		uploadID,
+	).Err()
+}
+
+func (t *Tracker) StaleUploads(lastActiveBefore time.Time) ([]int64, error) {
+	members, err := t.Client.ZRangeByScore(context.Background(), t.activityKey(), &redis.ZRangeBy{
+		Min: "-inf",
+		Max: "(" + strconv.FormatInt(lastActiveBefore.UnixNano(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	stale := make([]int64, 0, len(members))
+	for _, member := range members {
+		uploadID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, uploadID)
+	}
+	return stale, nil
+}
+
+func (t *Tracker) CountUploads() (int64, error) {
+	return t.Client.ZCard(context.Background(), t.activityKey()).Result()
+}
+
+// runOnUpload runs a script on the info of an upload, which returns -1 if
+// the upload doesn't exist.
+func (t *Tracker) runOnUpload(script *redis.Script, uploadID int64, args ...interface{}) error {
+	n, err := script.Run(context.Background(), t.Client, []string{t.infoKey(uploadID)}, args...).Int64()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return assemble.ErrUploadNotFound
+	}
+	return nil
+}
+
+func (t *Tracker) checkExists(uploadID int64) error {
+	n, err := t.Client.Exists(context.Background(), t.infoKey(uploadID)).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return assemble.ErrUploadNotFound
+	}
+	return nil
+}
+
+func (t *Tracker) infoKey(uploadID int64) string {
+	return fmt.Sprintf("%s{%d}:info", t.Prefix, uploadID)
+}
+
+func (t *Tracker) chunksKey(uploadID int64) string {
+	return fmt.Sprintf("%s{%d}:chunks", t.Prefix, uploadID)
+}
+
+func (t *Tracker) checksumsKey(uploadID int64) string {
+	return fmt.Sprintf("%s{%d}:checksums", t.Prefix, uploadID)
+}
+
+// activityKey is a sorted set of upload IDs scored by the time they were
+// last active.
+func (t *Tracker) activityKey() string {
+	return t.Prefix + "activity"
+}
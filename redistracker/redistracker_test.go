@@ -0,0 +1,270 @@
+package redistracker
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/dchenz/go-assemble"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestTracker(t *testing.T) (*Tracker, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return NewTracker(client, "{test}:"), server
+}
+
+func TestUploadInfo(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	info := assemble.UploadInfo{
+		TotalChunks:    3,
+		Metadata:       map[string]interface{}{"name": "a.txt"},
+		Checksum:       "abc",
+		Size:           10,
+		KeyFingerprint: "fingerprint",
+		CreatedAt:      time.Unix(100, 5),
+	}
+	uploadID, err := tracker.CreateUpload(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.CreateUploadWithID(uploadID, info); !errors.Is(err, assemble.ErrUploadExists) {
+		t.Errorf("creating the upload again: got %v", err)
+	}
+	got, err := tracker.GetUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, info) {
+		t.Errorf("got %+v, want %+v", got, info)
+	}
+
+	if err := tracker.SetTotalChunks(uploadID, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.SetSize(uploadID, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.SetChecksum(uploadID, "def"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.SetMetadata(uploadID, map[string]interface{}{"name": "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = tracker.GetUpload(uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.TotalChunks != 4 || got.Size != 20 || got.Checksum != "def" || got.Metadata["name"] != "b.txt" {
+		t.Errorf("got %+v after updates", got)
+	}
+}
+
+func TestMissingUpload(t *testing.T) {
+	tracker, server := newTestTracker(t)
+	for name, err := range map[string]error{
+		"AddChunk":          func() error { _, err := tracker.AddChunk(7, 0, assemble.ChunkInfo{Size: 1}); return err }(),
+		"SetChecksum":       tracker.SetChecksum(7, "abc"),
+		"SetMetadata":       tracker.SetMetadata(7, nil),
+		"SetTotalChunks":    tracker.SetTotalChunks(7, 1),
+		"SetSize":           tracker.SetSize(7, 1),
+		"ReleaseCompletion": tracker.ReleaseCompletion(7),
+	} {
+		if !errors.Is(err, assemble.ErrUploadNotFound) {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+	// Nothing is left behind for the missing upload.
+	if keys := server.Keys(); len(keys) != 0 {
+		t.Errorf("got keys %v", keys)
+	}
+	if n, err := tracker.CountUploads(); err != nil || n != 0 {
+		t.Errorf("got %d uploads, %v", n, err)
+	}
+}
+
+func TestAddChunk(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	uploadID, err := tracker.CreateUpload(assemble.UploadInfo{TotalChunks: 3, CreatedAt: time.Unix(100, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		seq      int64
+		chunk    assemble.ChunkInfo
+		progress assemble.Progress
+	}{
+		{2, assemble.ChunkInfo{Size: 5, Checksum: "c2"}, assemble.Progress{Chunks: 1, Bytes: 5}},
+		{0, assemble.ChunkInfo{Size: 3}, assemble.Progress{Chunks: 2, Bytes: 8}},
+		{2, assemble.ChunkInfo{Size: 4}, assemble.Progress{Chunks: 2, Bytes: 7}},
+	} {
+		progress, err := tracker.AddChunk(uploadID, test.seq, test.chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if progress != test.progress {
+			t.Errorf("chunk %d: got %+v, want %+v", test.seq, progress, test.progress)
+		}
+	}
+	chunk, ok, err := tracker.GetChunk(uploadID, 2)
+	if err != nil || !ok || chunk.Size != 4 || chunk.Checksum != "" {
+		t.Errorf("got chunk %+v, %v, %v", chunk, ok, err)
+	}
+	if _, ok, _ := tracker.GetChunk(uploadID, 1); ok {
+		t.Error("chunk 1 was received")
+	}
+	received, err := tracker.ReceivedChunks(uploadID)
+	if err != nil || !reflect.DeepEqual(received, []int64{0, 2}) {
+		t.Errorf("got received %v, %v", received, err)
+	}
+	if progress, err := tracker.GetProgress(uploadID); err != nil || progress != (assemble.Progress{Chunks: 2, Bytes: 7}) {
+		t.Errorf("got progress %+v, %v", progress, err)
+	}
+}
+
+func TestClaimCompletion(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	uploadID, err := tracker.CreateUpload(assemble.UploadInfo{TotalChunks: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim := func() bool {
+		t.Helper()
+		claimed, err := tracker.ClaimCompletion(uploadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claimed
+	}
+	if _, err := tracker.AddChunk(uploadID, 0, assemble.ChunkInfo{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if claim() {
+		t.Error("claimed an incomplete upload")
+	}
+	if _, err := tracker.AddChunk(uploadID, 1, assemble.ChunkInfo{Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !claim() || claim() {
+		t.Error("upload wasn't claimed exactly once")
+	}
+	if err := tracker.ReleaseCompletion(uploadID); err != nil {
+		t.Fatal(err)
+	}
+	if !claim() {
+		t.Error("released upload couldn't be claimed")
+	}
+}
+
+func TestActivity(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	first, err := tracker.CreateUpload(assemble.UploadInfo{TotalChunks: 2, CreatedAt: time.Unix(100, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := tracker.CreateUpload(assemble.UploadInfo{TotalChunks: 2, CreatedAt: time.Unix(200, 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := tracker.StaleUploads(time.Unix(150, 0))
+	if err != nil || !reflect.DeepEqual(stale, []int64{first}) {
+		t.Errorf("got stale %v, %v", stale, err)
+	}
+
+	// Chunks received out of order don't move the activity back.
+	if _, err := tracker.AddChunk(first, 0, assemble.ChunkInfo{Size: 1, ReceivedAt: time.Unix(300, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.AddChunk(first, 1, assemble.ChunkInfo{Size: 1, ReceivedAt: time.Unix(250, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	stale, err = tracker.StaleUploads(time.Unix(280, 0))
+	if err != nil || !reflect.DeepEqual(stale, []int64{second}) {
+		t.Errorf("got stale %v, %v", stale, err)
+	}
+}
+
+func TestRemoveUpload(t *testing.T) {
+	tracker, server := newTestTracker(t)
+	uploadID, err := tracker.CreateUpload(assemble.UploadInfo{TotalChunks: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tracker.AddChunk(uploadID, 0, assemble.ChunkInfo{Size: 1, Checksum: "abc"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.RemoveUpload(uploadID); err != nil {
+		t.Fatal(err)
+	}
+	// A chunk that arrives after the upload was removed doesn't bring it
+	// back into the activity set.
+	if _, err := tracker.AddChunk(uploadID, 1, assemble.ChunkInfo{Size: 1, ReceivedAt: time.Now()}); !errors.Is(err, assemble.ErrUploadNotFound) {
+		t.Errorf("adding a chunk to a removed upload: got %v", err)
+	}
+	if n, err := tracker.CountUploads(); err != nil || n != 0 {
+		t.Errorf("got %d uploads, %v", n, err)
+	}
+	if _, err := tracker.GetUpload(uploadID); !errors.Is(err, assemble.ErrUploadNotFound) {
+		t.Errorf("getting a removed upload: got %v", err)
+	}
+	for _, key := range server.Keys() {
+		if key != "{test}:next-id" {
+			t.Errorf("key %s was left behind", key)
+		}
+	}
+}
+
+func TestAssembler(t *testing.T) {
+	tracker, _ := newTestTracker(t)
+	var completed []byte
+	a := assemble.NewFileChunksAssembler(&assemble.AssemblerConfig{
+		ChunksDir:    t.TempDir(),
+		CompletedDir: t.TempDir(),
+		Tracker:      tracker,
+
+		SynchronousCleanup: true,
+	})
+	defer a.Close()
+	h := a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		completed, _ = ioutil.ReadAll(r.Body)
+	}))
+
+	rec := httptest.NewRecorder()
+	a.UploadStartHandler(rec, httptest.NewRequest(http.MethodPost, "/init", strings.NewReader(`{"total_chunks": 2}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("starting upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	var started struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+	for seq, body := range []string{"hello ", "world"} {
+		req := httptest.NewRequest(http.MethodPost, "/parts", strings.NewReader(body))
+		req.Header.Set(assemble.DefaultUploadIdentifierHeader, strconv.FormatInt(started.ID, 10))
+		req.Header.Set(assemble.DefaultChunkIdentifierHeader, strconv.Itoa(seq))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+		}
+	}
+	if string(completed) != "hello world" {
+		t.Errorf("got completed file %q", completed)
+	}
+	if n, err := tracker.CountUploads(); err != nil || n != 0 {
+		t.Errorf("got %d uploads after completion, %v", n, err)
+	}
+}
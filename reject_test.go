@@ -0,0 +1,41 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRejectFileStatus(t *testing.T) {
+	for _, test := range []struct {
+		status int
+		want   int
+	}{
+		{http.StatusForbidden, http.StatusForbidden},
+		{http.StatusInsufficientStorage, http.StatusInsufficientStorage},
+		{http.StatusOK, DefaultRejectionStatus},
+		{http.StatusFound, DefaultRejectionStatus},
+		{0, DefaultRejectionStatus},
+		{600, DefaultRejectionStatus},
+	} {
+		ta := newTestAssembler(t, nil)
+		ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			RejectFile(r, test.status, "rejected")
+		}))
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+		rec := ta.send(uploadID, 0, "a", nil)
+		if rec.Code != test.want {
+			t.Errorf("RejectFile with %d: got %d %s, want %d", test.status, rec.Code, rec.Body.String(), test.want)
+		}
+	}
+}
+
+func TestRejectFileWithError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	RejectFileWithError(r, errors.New("not a PDF"))
+	status, reason, ok := GetRejection(r)
+	if !ok || status != DefaultRejectionStatus || reason != "not a PDF" {
+		t.Errorf("got rejection %d %q %v, want %d %q", status, reason, ok, DefaultRejectionStatus, "not a PDF")
+	}
+}
@@ -0,0 +1,47 @@
+package assemble
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// withValue is middleware that passes a request with another context
+// value to h.
+func withValue(key interface{}, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), key, true)))
+	})
+}
+
+func TestRejectFileFromNestedHandler(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	rejecting := http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		RejectFile(r.Clone(r.Context()), http.StatusForbidden, "rejected deep down")
+	})
+	ta.h = ta.a.ChunksMiddleware(withValue(userKey(1), withValue(userKey(2), withValue(userKey(3), rejecting))))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusForbidden)
+	if progress.RejectedError == nil || *progress.RejectedError != "rejected deep down" {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, "rejected deep down")
+	}
+}
+
+func TestRejectFileFromGoroutine(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.h = ta.a.ChunksMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RejectFile(r.WithContext(context.WithValue(r.Context(), userKey(1), true)), http.StatusUnprocessableEntity, "rejected in the background")
+		}()
+		wg.Wait()
+	}))
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusUnprocessableEntity)
+	if progress.RejectedError == nil || *progress.RejectedError != "rejected in the background" {
+		t.Errorf("got rejection %v", progress.RejectedError)
+	}
+}
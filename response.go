@@ -0,0 +1,49 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+// bufferedResponse is given to downstream handlers by
+// ChunksMiddlewareWithResponse, so that what they write can be added to the
+// final progress update.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header)}
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) Write(data []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(data)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// result returns the body as JSON, or as a JSON string if it isn't JSON.
+func (b *bufferedResponse) result() json.RawMessage {
+	if b.body.Len() == 0 {
+		return nil
+	}
+	if json.Valid(b.body.Bytes()) {
+		return json.RawMessage(b.body.Bytes())
+	}
+	encoded, _ := json.Marshal(b.body.String())
+	return encoded
+}
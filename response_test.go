@@ -0,0 +1,90 @@
+package assemble
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// withResponse replaces the assembler's handler with
+// ChunksMiddlewareWithResponse serving h.
+func (ta *testAssembler) withResponse(h http.HandlerFunc) {
+	ta.downstream = h
+	ta.h = ta.a.ChunksMiddlewareWithResponse(h)
+}
+
+func TestChunksMiddlewareWithResponse(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.withResponse(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]string{"url": "/files/" + string(body)})
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	// Only the completing chunk gets the handler's response.
+	if progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK); progress.Result != nil {
+		t.Errorf("got result %s before completion", progress.Result)
+	}
+	progress := ta.mustSend(uploadID, 1, "b", nil, http.StatusCreated)
+	var result struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(progress.Result, &result); err != nil {
+		t.Fatalf("decoding result %s: %v", progress.Result, err)
+	}
+	if result.URL != "/files/ab" {
+		t.Errorf("got URL %q, want %q", result.URL, "/files/ab")
+	}
+	if progress.CurrentChunks != 2 || progress.ExpectedChunks != 2 {
+		t.Errorf("got %d of %d chunks, want 2 of 2", progress.CurrentChunks, progress.ExpectedChunks)
+	}
+}
+
+func TestChunksMiddlewareWithTextResponse(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.withResponse(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("processed"))
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if string(progress.Result) != `"processed"` {
+		t.Errorf("got result %s, want %q as a string", progress.Result, "processed")
+	}
+}
+
+func TestChunksMiddlewareWithResponseRejected(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.withResponse(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		RejectFile(r, http.StatusForbidden, "not allowed")
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusForbidden)
+	if progress.RejectedError == nil || *progress.RejectedError != "not allowed" {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, "not allowed")
+	}
+}
+
+func TestChunksMiddlewareNilResponse(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	called := false
+	var downstream http.ResponseWriter
+	ta.downstream = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		downstream = w
+	})
+	ta.h = ta.a.ChunksMiddleware(ta.downstream)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if !called {
+		t.Fatal("the downstream handler wasn't called")
+	}
+	if downstream != nil {
+		t.Errorf("got response writer %T, want nil", downstream)
+	}
+	if progress.Result != nil {
+		t.Errorf("got result %s", progress.Result)
+	}
+}
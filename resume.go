@@ -0,0 +1,139 @@
+package assemble
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+// With ResumeCombine, a partial file left behind by a combine that was
+// interrupted by a crash is kept, and the next combine continues after the
+// last chunk it holds in full. Chunks are appended in order, so the partial
+// file holds the first K chunks if its size is the sum of their sizes.
+// Otherwise it is written again from the start.
+//
+// Partial files are named after the file ID, which can be reused by another
+// upload, e.g. when IDs start from 0 again after a restart. The upload that
+// wrote a partial file is recorded next to it, and the file is only resumed
+// by the same upload.
+
+// Suffix of the file next to a partial file that records its upload.
+const partialOwnerExt = ".owner"
+
+// partialOwner identifies an upload by its file ID and start time, which
+// are kept in its upload info sidecar. An empty string is returned if there
+// is no sidecar, in which case partial files are never resumed.
+func (s *FilesystemStore) partialOwner(fileID string) (string, error) {
+	data, err := os.ReadFile(s.uploadInfoPath(fileID))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var f uploadInfoFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return "", err
+	}
+	return fileID + "\n" + strconv.FormatInt(f.CreatedAt.UnixNano(), 10), nil
+}
+
+// resumePartial returns how many of the chunks are already in the partial
+// file, and leaves the file positioned for the rest to be appended. The
+// existing contents are written to digest. Files written by another upload
+// are truncated, and the owner is recorded before anything is written.
+func (s *FilesystemStore) resumePartial(f *os.File, partialFilePath string, owner string, fileID string, seqs []int64, digest io.Writer) (int, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	ownerPath := partialFilePath + partialOwnerExt
+	if info.Size() == 0 || !partialOwnedBy(ownerPath, owner) {
+		return 0, s.restartPartial(f, ownerPath, owner)
+	}
+	resumed := -1
+	var size int64
+	for i, seq := range seqs {
+		if size >= info.Size() {
+			if size == info.Size() {
+				resumed = i
+			}
+			break
+		}
+		chunkSize, err := s.chunkSize(fileID, seq)
+		if err != nil {
+			return 0, err
+		}
+		size += chunkSize
+	}
+	if resumed < 0 && size == info.Size() {
+		// Every chunk was written, but the file wasn't moved into place.
+		resumed = len(seqs)
+	}
+	if resumed < 0 {
+		return 0, s.restartPartial(f, ownerPath, owner)
+	}
+	if digest != nil {
+		if _, err := io.Copy(digest, io.NewSectionReader(f, 0, info.Size())); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return 0, err
+	}
+	return resumed, nil
+}
+
+// restartPartial empties a partial file and records its new owner.
+func (s *FilesystemStore) restartPartial(f *os.File, ownerPath string, owner string) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if owner == "" {
+		err := os.Remove(ownerPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(ownerPath, []byte(owner), s.CompletedFileMode)
+}
+
+func partialOwnedBy(ownerPath string, owner string) bool {
+	if owner == "" {
+		return false
+	}
+	data, err := os.ReadFile(ownerPath)
+	return err == nil && bytes.Equal(data, []byte(owner))
+}
+
+// removePartial removes a partial file and its owner.
+func removePartial(partialFilePath string) {
+	_ = os.Remove(partialFilePath)
+	_ = os.Remove(partialFilePath + partialOwnerExt)
+}
+
+// chunkSize returns the size of a stored chunk, which is its size in the
+// completed file since chunks aren't encrypted when combines are resumed.
+func (s *FilesystemStore) chunkSize(fileID string, seq int64) (int64, error) {
+	if s.AppendChunks {
+		var size int64
+		err := s.withChunkLog(fileID, false, func(l *chunkLog) error {
+			entry, ok := l.chunks[seq]
+			if !ok {
+				return errChunkNotLogged
+			}
+			size = entry.length
+			return nil
+		})
+		return size, err
+	}
+	info, err := os.Stat(s.chunkFilePath(fileID, seq))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
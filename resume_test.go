@@ -0,0 +1,98 @@
+package assemble
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newResumeStore(t *testing.T) *FilesystemStore {
+	t.Helper()
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.ResumeCombine = true
+	return store
+}
+
+// interruptCombine leaves a partial file as if a combine of the upload had
+// crashed after writing contents.
+func interruptCombine(t *testing.T, store *FilesystemStore, fileID string, owner string, contents string) {
+	t.Helper()
+	partialFilePath := filepath.Join(store.CompletedDir, store.fileName(fileID)+".partial")
+	if err := ioutil.WriteFile(partialFilePath, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if owner != "" {
+		if err := ioutil.WriteFile(partialFilePath+partialOwnerExt, []byte(owner), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func startStoreUpload(t *testing.T, store *FilesystemStore, fileID string, createdAt time.Time, chunks ...string) string {
+	t.Helper()
+	if err := store.SaveUploadInfo(fileID, UploadInfo{TotalChunks: int64(len(chunks)), CreatedAt: createdAt}); err != nil {
+		t.Fatal(err)
+	}
+	for seq, chunk := range chunks {
+		if err := store.WriteChunk(fileID, int64(seq), []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	owner, err := store.partialOwner(fileID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return owner
+}
+
+func TestResumeCombine(t *testing.T) {
+	store := newResumeStore(t)
+	owner := startStoreUpload(t, store, "7", time.Unix(100, 0), "aaa", "bbb", "ccc")
+	// The partial file differs from the chunks, so a resumed combine is
+	// told apart from one that started over.
+	interruptCombine(t, store, "7", owner, "AAA")
+
+	if _, err := store.Finalize(context.Background(), "7", "done", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompleted(t, store, "done"); got != "AAAbbbccc" {
+		t.Errorf("got %q, want the partial file to be resumed", got)
+	}
+	if files, _ := filepath.Glob(filepath.Join(store.CompletedDir, "*.partial*")); len(files) != 0 {
+		t.Errorf("partial files were left behind: %v", files)
+	}
+}
+
+func TestResumeCombineOtherUpload(t *testing.T) {
+	store := newResumeStore(t)
+	oldOwner := startStoreUpload(t, store, "7", time.Unix(100, 0), "aaa")
+	// The file ID is reused by an upload started later.
+	startStoreUpload(t, store, "7", time.Unix(200, 0), "aaa", "bbb", "ccc")
+	interruptCombine(t, store, "7", oldOwner, "AAA")
+
+	if _, err := store.Finalize(context.Background(), "7", "done", 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompleted(t, store, "done"); got != "aaabbbccc" {
+		t.Errorf("got %q, want the partial file of the other upload to be discarded", got)
+	}
+}
+
+func TestResumeCombineWithoutOwner(t *testing.T) {
+	store := newResumeStore(t)
+	for seq, chunk := range []string{"aaa", "bbb"} {
+		if err := store.WriteChunk("7", int64(seq), []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	interruptCombine(t, store, "7", "", "AAA")
+
+	if _, err := store.Finalize(context.Background(), "7", "done", 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCompleted(t, store, "done"); got != "aaabbb" {
+		t.Errorf("got %q, want a partial file without an owner to be discarded", got)
+	}
+}
@@ -0,0 +1,317 @@
+// Package s3store provides a ChunkStore that keeps chunks and completed
+// files in an S3 bucket, so that assemblers running on different hosts
+// don't depend on a shared local disk.
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/dchenz/go-assemble"
+)
+
+// S3 requires every part of a multipart upload except the last to be at
+// least 5 MiB.
+const minPartSize = 5 * 1024 * 1024
+
+var (
+	_ assemble.ChunkStore        = (*Store)(nil)
+	_ assemble.SequenceFinalizer = (*Store)(nil)
+)
+
+// Store saves each chunk as the object prefix/fileID/seq and completed
+// files as the object prefix/fileID.
+type Store struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+func NewStore(client *s3.Client, bucket string, prefix string) *Store {
+	return &Store{
+		Client: client,
+		Bucket: bucket,
+		Prefix: prefix,
+	}
+}
+
+func (s *Store) WriteChunk(fileID string, seq int64, data []byte) error {
+	_, err := s.Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(s.chunkKey(fileID, seq)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+	})
+	return err
+}
+
+func (s *Store) ReadChunk(fileID string, seq int64) (io.ReadCloser, error) {
+	out, err := s.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.chunkKey(fileID, seq)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *Store) DeleteChunk(fileID string, seq int64) error {
+	_, err := s.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.chunkKey(fileID, seq)),
+	})
+	return err
+}
+
+// Finalize concatenates the chunks with a multipart upload. Chunks large
+// enough to be a part on their own are copied server-side, and smaller
+// chunks are merged in memory until they reach the minimum part size, so at
+// most one part's worth of data is buffered at a time.
+//
+// If digest is not nil, chunks copied server-side also need to be
+// downloaded to compute it.
+func (s *Store) Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error) {
+	seqs := make([]int64, totalChunks)
+	for i := range seqs {
+		seqs[i] = int64(i)
+	}
+	return s.FinalizeSequences(ctx, fileID, name, seqs, digest)
+}
+
+// FinalizeSequences is like Finalize for chunks with the given sequence
+// numbers, in that order.
+func (s *Store) FinalizeSequences(ctx context.Context, fileID string, name string, seqs []int64, digest io.Writer) (string, error) {
+	key := s.completedKey(name)
+	upload, err := s.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+	m := &multipartUpload{
+		store:    s,
+		ctx:      ctx,
+		key:      key,
+		uploadID: upload.UploadId,
+		digest:   digest,
+	}
+	if err := m.copyChunks(fileID, seqs); err != nil {
+		// ctx may have been cancelled, but the parts still need cleaning up.
+		_, _ = s.Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(s.Bucket),
+			Key:      aws.String(key),
+			UploadId: upload.UploadId,
+		})
+		return "", err
+	}
+	_, err = s.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.Bucket),
+		Key:             aws.String(key),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: m.parts},
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, key), nil
+}
+
+func (s *Store) OpenCompleted(name string) (io.ReadCloser, int64, error) {
+	out, err := s.Client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.completedKey(name)),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, 0, assemble.ErrCompletedFileNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return out.Body, aws.ToInt64(out.ContentLength), nil
+}
+
+func (s *Store) DeleteCompleted(name string) error {
+	_, err := s.Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.completedKey(name)),
+	})
+	return err
+}
+
+func (s *Store) chunkKey(fileID string, seq int64) string {
+	return path.Join(s.Prefix, fileID, strconv.FormatInt(seq, 10))
+}
+
+func (s *Store) completedKey(name string) string {
+	return path.Join(s.Prefix, name)
+}
+
+type multipartUpload struct {
+	store    *Store
+	ctx      context.Context
+	key      string
+	uploadID *string
+	parts    []types.CompletedPart
+	pending  bytes.Buffer
+	digest   io.Writer
+}
+
+func (m *multipartUpload) copyChunks(fileID string, seqs []int64) error {
+	for _, seq := range seqs {
+		if err := m.ctx.Err(); err != nil {
+			return fmt.Errorf("chunk %d: %w", seq, err)
+		}
+		chunkKey := m.store.chunkKey(fileID, seq)
+		head, err := m.store.Client.HeadObject(m.ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(m.store.Bucket),
+			Key:    aws.String(chunkKey),
+		})
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", seq, err)
+		}
+		size := aws.ToInt64(head.ContentLength)
+		offset := int64(0)
+		// Top up a partially filled part before copying the rest.
+		if m.pending.Len() > 0 {
+			n := int64(minPartSize - m.pending.Len())
+			if n > size {
+				n = size
+			}
+			if err := m.readRange(chunkKey, 0, n); err != nil {
+				return fmt.Errorf("chunk %d: %w", seq, err)
+			}
+			offset = n
+			if m.pending.Len() >= minPartSize {
+				if err := m.flush(); err != nil {
+					return err
+				}
+			}
+		}
+		remaining := size - offset
+		if remaining >= minPartSize {
+			if err := m.copyRange(chunkKey, offset, size); err != nil {
+				return fmt.Errorf("chunk %d: %w", seq, err)
+			}
+			if m.digest != nil {
+				if err := m.digestRange(chunkKey, offset, size); err != nil {
+					return fmt.Errorf("chunk %d: %w", seq, err)
+				}
+			}
+		} else if remaining > 0 {
+			if err := m.readRange(chunkKey, offset, size); err != nil {
+				return fmt.Errorf("chunk %d: %w", seq, err)
+			}
+		}
+	}
+	if m.pending.Len() > 0 || len(m.parts) == 0 {
+		return m.flush()
+	}
+	return nil
+}
+
+// readRange appends bytes [start, end) of an object to the pending part.
+func (m *multipartUpload) readRange(key string, start int64, end int64) error {
+	out, err := m.store.Client.GetObject(m.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.store.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange(start, end)),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	var w io.Writer = &m.pending
+	if m.digest != nil {
+		w = io.MultiWriter(&m.pending, m.digest)
+	}
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+// digestRange writes bytes [start, end) of an object to the digest.
+func (m *multipartUpload) digestRange(key string, start int64, end int64) error {
+	out, err := m.store.Client.GetObject(m.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(m.store.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(byteRange(start, end)),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(m.digest, out.Body)
+	return err
+}
+
+// copyRange adds bytes [start, end) of an object as a part without
+// downloading it.
+func (m *multipartUpload) copyRange(key string, start int64, end int64) error {
+	partNumber := m.nextPartNumber()
+	out, err := m.store.Client.UploadPartCopy(m.ctx, &s3.UploadPartCopyInput{
+		Bucket:          aws.String(m.store.Bucket),
+		Key:             aws.String(m.key),
+		UploadId:        m.uploadID,
+		PartNumber:      aws.Int32(partNumber),
+		CopySource:      aws.String(copySource(m.store.Bucket, key)),
+		CopySourceRange: aws.String(byteRange(start, end)),
+	})
+	if err != nil {
+		return err
+	}
+	m.parts = append(m.parts, types.CompletedPart{
+		ETag:       out.CopyPartResult.ETag,
+		PartNumber: aws.Int32(partNumber),
+	})
+	return nil
+}
+
+// flush uploads the pending bytes as a part.
+func (m *multipartUpload) flush() error {
+	partNumber := m.nextPartNumber()
+	out, err := m.store.Client.UploadPart(m.ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(m.store.Bucket),
+		Key:           aws.String(m.key),
+		UploadId:      m.uploadID,
+		PartNumber:    aws.Int32(partNumber),
+		Body:          bytes.NewReader(m.pending.Bytes()),
+		ContentLength: aws.Int64(int64(m.pending.Len())),
+	})
+	if err != nil {
+		return err
+	}
+	m.parts = append(m.parts, types.CompletedPart{
+		ETag:       out.ETag,
+		PartNumber: aws.Int32(partNumber),
+	})
+	m.pending.Reset()
+	return nil
+}
+
+func (m *multipartUpload) nextPartNumber() int32 {
+	return int32(len(m.parts) + 1)
+}
+
+func byteRange(start int64, end int64) string {
+	return fmt.Sprintf("bytes=%d-%d", start, end-1)
+}
+
+func copySource(bucket string, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,290 @@
+package s3store
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 implements the parts of the S3 API used by Store, with path-style
+// URLs. Like S3, it rejects multipart uploads with parts other than the last
+// smaller than the minimum part size.
+type fakeS3 struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	nextID  int
+
+	copiedParts   int
+	uploadedParts int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *s3.Client) {
+	t.Helper()
+	fake := &fakeS3{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials:  aws.AnonymousCredentials{},
+	})
+	return fake, client
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.nextID++
+		uploadID := strconv.Itoa(f.nextID)
+		f.uploads[uploadID] = make(map[int][]byte)
+		writeXML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			UploadID string   `xml:"UploadId"`
+		}{UploadID: uploadID})
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.putPart(w, r, query)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeUpload(w, r, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.objects[key] = data
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			writeXML(w, struct {
+				XMLName xml.Name `xml:"Error"`
+				Code    string
+			}{Code: "NoSuchKey"})
+			return
+		}
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+			var err error
+			if data, err = sliceRange(data, rangeHeader); err != nil {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) putPart(w http.ResponseWriter, r *http.Request, query url.Values) {
+	parts, ok := f.uploads[query.Get("uploadId")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+	source := r.Header.Get("x-amz-copy-source")
+	if source == "" {
+		data, _ := ioutil.ReadAll(r.Body)
+		parts[partNumber] = data
+		f.uploadedParts++
+		w.Header().Set("ETag", etag(data))
+		return
+	}
+	sourceKey, _ := url.PathUnescape(source)
+	data, ok := f.objects[strings.TrimPrefix(sourceKey, "/")]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data, err := sliceRange(data, r.Header.Get("x-amz-copy-source-range"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	parts[partNumber] = data
+	f.copiedParts++
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyPartResult"`
+		ETag    string
+	}{ETag: etag(data)})
+}
+
+func (f *fakeS3) completeUpload(w http.ResponseWriter, r *http.Request, key string, uploadID string) {
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	numbers := make([]int, 0, len(request.Parts))
+	for _, part := range request.Parts {
+		numbers = append(numbers, part.PartNumber)
+	}
+	sort.Ints(numbers)
+	var combined bytes.Buffer
+	for i, number := range numbers {
+		data, ok := parts[number]
+		if !ok || (i < len(numbers)-1 && len(data) < minPartSize) {
+			w.WriteHeader(http.StatusBadRequest)
+			writeXML(w, struct {
+				XMLName xml.Name `xml:"Error"`
+				Code    string
+			}{Code: "EntityTooSmall"})
+			return
+		}
+		combined.Write(data)
+	}
+	f.objects[key] = combined.Bytes()
+	delete(f.uploads, uploadID)
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Key     string
+	}{Key: key})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// sliceRange returns the bytes of a "bytes=start-end" range.
+func sliceRange(data []byte, header string) ([]byte, error) {
+	var start, end int
+	if _, err := fmt.Sscanf(header, "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	if start < 0 || start > end || end >= len(data) {
+		return nil, fmt.Errorf("invalid range %s", header)
+	}
+	return data[start : end+1], nil
+}
+
+func TestFinalizeMixedChunkSizes(t *testing.T) {
+	fake, client := newFakeS3(t)
+	store := NewStore(client, "bucket", "uploads")
+
+	// Chunks of at least a part are copied unless a buffered part has to be
+	// topped up first, and smaller ones are buffered.
+	sizes := []int{minPartSize + 10, 1024, 2 * minPartSize, 100, 3 * 1024 * 1024, minPartSize - 1, 10}
+	var want bytes.Buffer
+	for seq, size := range sizes {
+		chunk := bytes.Repeat([]byte{byte('a' + seq)}, size)
+		want.Write(chunk)
+		if err := store.WriteChunk("1", int64(seq), chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digest := sha256.New()
+	location, err := store.Finalize(context.Background(), "1", "file", int64(len(sizes)), digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != "s3://bucket/uploads/file" {
+		t.Errorf("got location %s", location)
+	}
+	f, size, err := store.OpenCompleted("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(want.Len()) || !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("got %d bytes (size %d), want %d", len(got), size, want.Len())
+	}
+	wantSum := sha256.Sum256(want.Bytes())
+	if !bytes.Equal(digest.Sum(nil), wantSum[:]) {
+		t.Error("digest doesn't match the completed file")
+	}
+	if fake.copiedParts == 0 || fake.uploadedParts == 0 {
+		t.Errorf("got %d copied and %d uploaded parts, want both", fake.copiedParts, fake.uploadedParts)
+	}
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads were left open", len(fake.uploads))
+	}
+}
+
+func TestFinalizeEmptyFile(t *testing.T) {
+	_, client := newFakeS3(t)
+	store := NewStore(client, "bucket", "uploads")
+	if err := store.WriteChunk("1", 0, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Finalize(context.Background(), "1", "empty", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	f, size, err := store.OpenCompleted("empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, _ := io.ReadAll(f)
+	if size != 0 || len(got) != 0 {
+		t.Errorf("got %d bytes (size %d), want an empty file", len(got), size)
+	}
+}
+
+func TestFinalizeMissingChunk(t *testing.T) {
+	fake, client := newFakeS3(t)
+	store := NewStore(client, "bucket", "uploads")
+	if err := store.WriteChunk("1", 0, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Finalize(context.Background(), "1", "file", 2, nil); err == nil {
+		t.Fatal("finalizing with a missing chunk succeeded")
+	}
+	// The multipart upload is aborted.
+	if len(fake.uploads) != 0 {
+		t.Errorf("%d multipart uploads were left open", len(fake.uploads))
+	}
+	if _, _, err := store.OpenCompleted("file"); err == nil {
+		t.Error("completed file exists")
+	}
+}
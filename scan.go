@@ -0,0 +1,60 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefaultScanRejectedStatus is the status of uploads rejected by Scanner.
+const DefaultScanRejectedStatus = http.StatusUnprocessableEntity
+
+var (
+	errScanRejected = errors.New("file was rejected by the content scanner")
+	errScanTimeout  = errors.New("scanning the file took too long")
+)
+
+// scanCompleted passes a completed file to Scanner and returns whether it
+// passed. If it didn't, the file is deleted and the upload is rejected with
+// the returned result. Files are also rejected if the scan times out, so
+// that files are never passed downstream without being scanned.
+func (a *FileChunksAssembler) scanCompleted(ctx context.Context, uploadID int64, name string) (uploadResult, bool, error) {
+	if a.Config.Scanner == nil {
+		return uploadResult{}, true, nil
+	}
+	scanCtx := ctx
+	if a.Config.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, a.Config.ScanTimeout)
+		defer cancel()
+	}
+	completedFile, _, err := a.Config.Store.OpenCompleted(name)
+	if err != nil {
+		return uploadResult{}, false, fmt.Errorf("opening completed file: %w", err)
+	}
+	scanErr := a.Config.Scanner(scanCtx, completedFile)
+	_ = completedFile.Close()
+	if scanErr == nil {
+		return uploadResult{}, true, nil
+	}
+	if err := a.Config.Store.DeleteCompleted(name); err != nil {
+		a.Config.Logger.Error("failed to delete rejected file", "upload_id", uploadID, "error", err)
+	}
+	if ctx.Err() != nil {
+		// The client has gone away.
+		return uploadResult{}, false, ctx.Err()
+	}
+	result := uploadResult{
+		rejectedCode:  a.Config.ScanRejectedStatus,
+		rejectedError: errScanRejected.Error(),
+	}
+	if errors.Is(scanCtx.Err(), context.DeadlineExceeded) {
+		result.rejectedCode = http.StatusGatewayTimeout
+		result.rejectedError = errScanTimeout.Error()
+	} else {
+		a.Config.Logger.Info("file rejected by scanner", "upload_id", uploadID, "error", scanErr)
+	}
+	a.rejectUpload(uploadID, result.rejectedError)
+	return result, false, nil
+}
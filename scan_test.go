@@ -0,0 +1,76 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errInfected = errors.New("infected")
+
+// magicScanner rejects files starting with "X5O!".
+func magicScanner(_ context.Context, r io.Reader) error {
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(r, head)
+	if string(head[:n]) == "X5O!" {
+		return errInfected
+	}
+	// The rest is read to check that it doesn't affect the downstream body.
+	_, err := ioutil.ReadAll(r)
+	return err
+}
+
+func TestScanner(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Scanner: magicScanner})
+	clean := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(clean, 0, "clean ", nil, http.StatusOK)
+	ta.mustSend(clean, 1, "file", nil, http.StatusOK)
+
+	infected := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(infected, 0, "X5O!", nil, http.StatusOK)
+	progress := ta.mustSend(infected, 1, "P%@AP", nil, DefaultScanRejectedStatus)
+	if progress.RejectedError == nil || *progress.RejectedError != errScanRejected.Error() {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, errScanRejected)
+	}
+
+	// Only the clean file is passed downstream, and the infected one is
+	// deleted.
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "clean file" {
+		t.Errorf("got completed files %q, want %q", files, []string{"clean file"})
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files[ta.a.fileID(infected)]; ok {
+		t.Error("the infected file wasn't deleted")
+	}
+}
+
+func TestScanRejectedStatus(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{Scanner: magicScanner, ScanRejectedStatus: http.StatusForbidden})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "X5O!", nil, http.StatusForbidden)
+}
+
+func TestScanTimeout(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ScanTimeout: 10 * time.Millisecond,
+		Scanner: func(ctx context.Context, _ io.Reader) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusGatewayTimeout)
+	if progress.RejectedError == nil || *progress.RejectedError != errScanTimeout.Error() {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, errScanTimeout)
+	}
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Errorf("got completed files %q from a scan that timed out", files)
+	}
+}
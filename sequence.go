@@ -0,0 +1,23 @@
+package assemble
+
+import "errors"
+
+var errInvalidChunkSequenceBase = errors.New("chunk sequence base must be 0 or 1")
+
+// Chunk sequence numbers are stored starting at 0. These convert them from
+// and to the numbering used by clients, which starts at ChunkSequenceBase.
+
+func (a *FileChunksAssembler) toClientSeq(seq int64) int64 {
+	return seq + int64(a.Config.ChunkSequenceBase)
+}
+
+func (a *FileChunksAssembler) toClientSeqs(seqs []int64) []int64 {
+	if a.Config.ChunkSequenceBase == 0 {
+		return seqs
+	}
+	converted := make([]int64, len(seqs))
+	for i, seq := range seqs {
+		converted[i] = a.toClientSeq(seq)
+	}
+	return converted
+}
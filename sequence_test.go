@@ -0,0 +1,53 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestChunkSequenceBase(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{ChunkSequenceBase: 1})
+	rec := ta.start(`{"total_chunks": 3}`, nil)
+	var started startResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+	if started.FirstChunk != 1 {
+		t.Errorf("got first chunk %d, want 1", started.FirstChunk)
+	}
+	uploadID := started.ID
+
+	// Chunk 0 doesn't exist, and neither does chunk 4.
+	ta.mustSend(uploadID, 0, "x", nil, http.StatusBadRequest)
+	ta.mustSend(uploadID, 4, "x", nil, http.StatusBadRequest)
+	ta.mustSend(uploadID, 3, "c", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "a", nil, http.StatusOK)
+
+	var status statusResponse
+	if err := json.Unmarshal(ta.status(uploadID).Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(status.ReceivedChunks, []int64{1, 3}) {
+		t.Errorf("got received chunks %v, want [1 3]", status.ReceivedChunks)
+	}
+
+	ta.mustSend(uploadID, 2, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abc" {
+		t.Errorf("got completed files %q, want %q", files, []string{"abc"})
+	}
+}
+
+func TestInvalidChunkSequenceBase(t *testing.T) {
+	defer func() {
+		if recover() != errInvalidChunkSequenceBase {
+			t.Error("a chunk sequence base of 2 was accepted")
+		}
+	}()
+	NewFileChunksAssembler(&AssemblerConfig{
+		ChunksDir:         t.TempDir(),
+		CompletedDir:      t.TempDir(),
+		ChunkSequenceBase: 2,
+	})
+}
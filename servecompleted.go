@@ -0,0 +1,150 @@
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errSeekBeforeStart = errors.New("seek before start of file")
+	errInvalidFileName = errors.New("file name must be a single path segment")
+)
+
+// checkServedName makes sure that a name from a client refers to a
+// completed file rather than anything else in the store: stores such as
+// s3store join names to a prefix, so "../x" would escape it, and "5/0"
+// would be chunk 0 of file 5. Hidden names are used by FilesystemStore for
+// deduplicated blobs and partial files.
+func checkServedName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, "/\\\x00") {
+		return errInvalidFileName
+	}
+	return nil
+}
+
+// ServeCompletedHandler returns a handler that lets clients download
+// completed files. It must be mounted at basePath, and the rest of the URL
+// path is the name of the completed file, which is the file ID unless
+// NameCompletedFiles or CompletedNamer is set. Only names that are a single
+// path segment and don't start with "." are served, so files named into
+// subdirectories can't be downloaded. Range requests are supported so that
+// downloads can be resumed.
+//
+// Stores don't keep the type of completed files, so Content-Type is based
+// on the extension of the name or sniffed from the contents.
+func (a *FileChunksAssembler) ServeCompletedHandler(basePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isClosed() {
+			a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, basePath), "/")
+		if err := checkServedName(name); err != nil {
+			a.writeError(w, r, http.StatusNotFound, ErrCompletedFileNotFound)
+			return
+		}
+		f, size, err := a.Config.Store.OpenCompleted(name)
+		if err != nil {
+			if errors.Is(err, ErrCompletedFileNotFound) {
+				a.writeError(w, r, http.StatusNotFound, err)
+			} else {
+				a.internalError(w, r, CodeCompletedFileOpenFailed, "failed to open completed file", err, "name", name)
+			}
+			return
+		}
+		content := &completedReader{store: a.Config.Store, name: name, size: size, r: f}
+		defer content.Close()
+		http.ServeContent(w, r, name, time.Time{}, content)
+	})
+}
+
+// completedReader adds seeking to a completed file from any store, which
+// http.ServeContent needs for range requests. Files that can't seek are
+// opened again and read up to the new offset.
+type completedReader struct {
+	store  ChunkStore
+	name   string
+	size   int64
+	r      io.ReadCloser
+	offset int64
+
+	// Where the next read has to start, if it isn't the offset of r.
+	seekTo int64
+	seeked bool
+}
+
+func (c *completedReader) Read(p []byte) (int, error) {
+	if c.seeked {
+		if err := c.seek(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.r.Read(p)
+	c.offset += int64(n)
+	return n, err
+}
+
+func (c *completedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.position()
+	case io.SeekEnd:
+		offset += c.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, errSeekBeforeStart
+	}
+	// Seeking is deferred since ServeContent seeks to the end just to find
+	// the size.
+	c.seekTo = offset
+	c.seeked = true
+	return offset, nil
+}
+
+func (c *completedReader) position() int64 {
+	if c.seeked {
+		return c.seekTo
+	}
+	return c.offset
+}
+
+func (c *completedReader) seek() error {
+	c.seeked = false
+	if seeker, ok := c.r.(io.Seeker); ok {
+		offset, err := seeker.Seek(c.seekTo, io.SeekStart)
+		c.offset = offset
+		return err
+	}
+	if c.seekTo < c.offset {
+		_ = c.r.Close()
+		r, _, err := c.store.OpenCompleted(c.name)
+		if err != nil {
+			return err
+		}
+		c.r = r
+		c.offset = 0
+	}
+	n, err := io.CopyN(ioutil.Discard, c.r, c.seekTo-c.offset)
+	c.offset += n
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (c *completedReader) Close() error {
+	return c.r.Close()
+}
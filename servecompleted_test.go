@@ -0,0 +1,65 @@
+package assemble
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeCompletedNames(t *testing.T) {
+	config := &AssemblerConfig{}
+	ta := newTestAssembler(t, config)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "contents", nil, http.StatusOK)
+
+	// Files that mustn't be reachable through the handler.
+	if err := ioutil.WriteFile(filepath.Join(config.CompletedDir, ".hidden"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(config.CompletedDir, "sub"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(config.CompletedDir, "sub", "file"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := ta.a.ServeCompletedHandler("/download/")
+	for _, test := range []struct {
+		path   string
+		status int
+	}{
+		{"/download/" + ta.a.fileID(uploadID), http.StatusOK},
+		{"/download/", http.StatusNotFound},
+		{"/download/.hidden", http.StatusNotFound},
+		{"/download/sub/file", http.StatusNotFound},
+		{"/download/sub%2Ffile", http.StatusNotFound},
+		{"/download/..%2F" + filepath.Base(config.ChunksDir), http.StatusNotFound},
+		{"/download/missing", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.path, rec.Code, test.status)
+		}
+		if rec.Code == http.StatusOK && rec.Body.String() != "contents" {
+			t.Errorf("%s: got %q", test.path, rec.Body.String())
+		}
+	}
+}
+
+func TestServeCompletedRange(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "0123456789", nil, http.StatusOK)
+
+	req := httptest.NewRequest(http.MethodGet, "/download/"+ta.a.fileID(uploadID), nil)
+	req.Header.Set("Range", "bytes=3-5")
+	rec := httptest.NewRecorder()
+	ta.a.ServeCompletedHandler("/download/").ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "345" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
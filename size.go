@@ -0,0 +1,25 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const DefaultTotalSizeHeader = "x-assemble-total-size"
+
+var errInvalidTotalSize = errors.New("invalid total size")
+
+// totalSize returns the size of the completed file from TotalSizeHeader, or
+// 0 if it wasn't sent.
+func (a *FileChunksAssembler) totalSize(r *http.Request) (int64, error) {
+	value := r.Header.Get(a.Config.TotalSizeHeader)
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, errInvalidTotalSize
+	}
+	return size, nil
+}
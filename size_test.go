@@ -0,0 +1,66 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaxFileSizeByTypeOnStart(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{
+		MaxFileSize:       100,
+		MaxFileSizeByType: map[string]int64{"image/*": 10},
+	})
+	for _, test := range []struct {
+		body   string
+		header map[string]string
+		status int
+	}{
+		{`{"total_chunks": 1, "size": 50}`, nil, http.StatusOK},
+		{`{"total_chunks": 1, "size": 500}`, nil, http.StatusRequestEntityTooLarge},
+		{`{"total_chunks": 1, "size": 50, "metadata": {"type": "image/png"}}`, nil, http.StatusRequestEntityTooLarge},
+		// The type sent as a header is used for the limit as well.
+		{`{"total_chunks": 1, "size": 50}`, map[string]string{DefaultMetadataHeaderPrefix + "type": "image/png"}, http.StatusRequestEntityTooLarge},
+		{`{"total_chunks": 1}`, map[string]string{DefaultMetadataHeaderPrefix + "type": "image/png", DefaultTotalSizeHeader: "50"}, http.StatusRequestEntityTooLarge},
+		{`{"total_chunks": 1, "size": 5}`, map[string]string{DefaultMetadataHeaderPrefix + "type": "image/png"}, http.StatusOK},
+	} {
+		if rec := ta.start(test.body, test.header); rec.Code != test.status {
+			t.Errorf("%s %v: got %d, want %d", test.body, test.header, rec.Code, test.status)
+		}
+	}
+}
+
+func TestMaxFileSizeOnChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxFileSize: 8})
+	uploadID := ta.startUpload(`{"total_chunks": 3}`, nil)
+	ta.mustSend(uploadID, 0, "12345", nil, http.StatusOK)
+	rec := ta.send(uploadID, 1, "67890", nil)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	// The upload was cancelled.
+	if rec := ta.send(uploadID, 2, "x", nil); rec.Code != http.StatusBadRequest || errorBody(t, rec) != ErrUploadNotFound.Error() {
+		t.Errorf("chunk of a cancelled upload: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMaxFileSizeRemovesStoredChunks(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxFileSize: 10})
+	uploadID := ta.startUpload(`{"total_chunks": 4}`, nil)
+	// Chunks arrive out of order, so the running total is what counts.
+	ta.mustSend(uploadID, 3, "1234", nil, http.StatusOK)
+	ta.mustSend(uploadID, 1, "5678", nil, http.StatusOK)
+	rec := ta.send(uploadID, 0, "9012", nil)
+	if rec.Code != http.StatusRequestEntityTooLarge || errorBody(t, rec) != errFileTooLarge.Error() {
+		t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got chunk files %v after the upload was cancelled", files)
+	}
+	if count, _ := ta.a.Config.Tracker.CountUploads(); count != 0 {
+		t.Errorf("%d uploads are still tracked", count)
+	}
+}
@@ -0,0 +1,70 @@
+package assemble
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// AssemblerStats is a snapshot of an assembler's activity. Apart from
+// ActiveUploads, the figures only cover requests handled by this assembler
+// since it was created.
+type AssemblerStats struct {
+	// Uploads being tracked, including those started by other instances
+	// sharing the tracker.
+	ActiveUploads int64
+
+	ChunksReceived int64
+
+	// Size of the chunks of uploads that haven't been completed or removed
+	// yet.
+	BytesInFlight int64
+
+	CompletedUploads int64
+}
+
+// assemblerCounters are updated atomically so that reading them doesn't
+// block uploads.
+type assemblerCounters struct {
+	chunksReceived   int64
+	bytesInFlight    int64
+	completedUploads int64
+
+	// Bytes of each upload counted in bytesInFlight by upload ID, so that
+	// chunks that are sent again aren't counted twice. Updated while
+	// holding the upload's lock.
+	uploadBytes sync.Map
+}
+
+// Stats returns the current statistics of the assembler.
+func (a *FileChunksAssembler) Stats() (AssemblerStats, error) {
+	active, err := a.Config.Tracker.CountUploads()
+	if err != nil {
+		return AssemblerStats{}, err
+	}
+	return AssemblerStats{
+		ActiveUploads:    active,
+		ChunksReceived:   atomic.LoadInt64(&a.counters.chunksReceived),
+		BytesInFlight:    atomic.LoadInt64(&a.counters.bytesInFlight),
+		CompletedUploads: atomic.LoadInt64(&a.counters.completedUploads),
+	}, nil
+}
+
+// countChunk records a received chunk, given the progress of its upload
+// afterwards.
+func (a *FileChunksAssembler) countChunk(uploadID int64, progress Progress) {
+	atomic.AddInt64(&a.counters.chunksReceived, 1)
+	delta := progress.Bytes
+	if previous, ok := a.counters.uploadBytes.Load(uploadID); ok {
+		delta -= previous.(int64)
+	}
+	a.counters.uploadBytes.Store(uploadID, progress.Bytes)
+	atomic.AddInt64(&a.counters.bytesInFlight, delta)
+}
+
+// uncountUpload removes the bytes of an upload from BytesInFlight once its
+// chunks have been removed.
+func (a *FileChunksAssembler) uncountUpload(uploadID int64) {
+	if bytes, ok := a.counters.uploadBytes.LoadAndDelete(uploadID); ok {
+		atomic.AddInt64(&a.counters.bytesInFlight, -bytes.(int64))
+	}
+}
@@ -0,0 +1,72 @@
+package assemble
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func (ta *testAssembler) checkStats(want AssemblerStats) {
+	ta.t.Helper()
+	stats, err := ta.a.Stats()
+	if err != nil {
+		ta.t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stats, want) {
+		ta.t.Errorf("got stats %+v, want %+v", stats, want)
+	}
+}
+
+func TestStats(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	ta.checkStats(AssemblerStats{})
+
+	completed := ta.startUpload(`{"total_chunks": 2}`, nil)
+	aborted := ta.startUpload(`{"total_chunks": 2}`, nil)
+	pending := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(completed, 0, "abc", nil, http.StatusOK)
+	ta.mustSend(aborted, 0, "de", nil, http.StatusOK)
+	ta.mustSend(pending, 0, "f", nil, http.StatusOK)
+	// A chunk sent again is counted as received, but its bytes replace the
+	// ones sent before.
+	ta.mustSend(pending, 0, "fg", nil, http.StatusOK)
+	ta.checkStats(AssemblerStats{ActiveUploads: 3, ChunksReceived: 4, BytesInFlight: 7})
+
+	ta.mustSend(completed, 1, "h", nil, http.StatusOK)
+	if err := ta.a.Abort(aborted); err != nil {
+		t.Fatal(err)
+	}
+	ta.checkStats(AssemblerStats{ActiveUploads: 1, ChunksReceived: 5, BytesInFlight: 2, CompletedUploads: 1})
+}
+
+func TestStatsDuringUploads(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	var uploadIDs []int64
+	for i := 0; i < 20; i++ {
+		uploadIDs = append(uploadIDs, ta.startUpload(`{"total_chunks": 2}`, nil))
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Chunks are sent to uploads started in advance, since t.Fatal
+		// can't be called from this goroutine.
+		for _, uploadID := range uploadIDs {
+			for seq, body := range []string{"a", "b"} {
+				if rec := ta.send(uploadID, int64(seq), body, nil); rec.Code != http.StatusOK {
+					t.Errorf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+				}
+			}
+		}
+	}()
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+		if _, err := ta.a.Stats(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ta.checkStats(AssemblerStats{ChunksReceived: 40, CompletedUploads: 20})
+}
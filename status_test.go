@@ -0,0 +1,50 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// status sends a request to StatusHandler.
+func (ta *testAssembler) status(uploadID int64) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	rec := httptest.NewRecorder()
+	ta.a.StatusHandler(rec, req)
+	return rec
+}
+
+func TestStatusHandler(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(`{"total_chunks": 4}`, nil)
+	ta.mustSend(uploadID, 2, "c", nil, http.StatusOK)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+
+	rec := ta.status(uploadID)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	var status statusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	want := statusResponse{ReceivedChunks: []int64{0, 2}, ExpectedChunks: 4}
+	if !reflect.DeepEqual(status, want) {
+		t.Errorf("got status %+v, want %+v", status, want)
+	}
+
+	rec = ta.status(uploadID + 1)
+	if rec.Code != http.StatusNotFound || errorBody(t, rec) != ErrUploadNotFound.Error() {
+		t.Errorf("unknown upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	rec = httptest.NewRecorder()
+	ta.a.StatusHandler(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("missing upload ID: got %d %s", rec.Code, rec.Body.String())
+	}
+}
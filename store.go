@@ -0,0 +1,678 @@
+package assemble
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultChunkFileMode     os.FileMode = 0644
+	DefaultCompletedFileMode os.FileMode = 0666
+	DefaultDirMode           os.FileMode = 0755
+)
+
+const DefaultCombineBufferSize = 32 << 10
+
+const uploadInfoExt = ".meta"
+
+// ErrCompletedFileNotFound is returned by stores for completed files that
+// don't exist.
+var ErrCompletedFileNotFound = errors.New("completed file not found")
+
+var (
+	errUnsafeFileID        = errors.New("file ID can't be used as a file name")
+	errUnsafeCompletedName = errors.New("completed file name must be a relative path within the completed directory")
+	errChunkFileMissing    = errors.New("chunk file is missing")
+)
+
+// ChunkStore is the storage backend for chunks of in-progress uploads and
+// the files they are assembled into.
+type ChunkStore interface {
+	WriteChunk(fileID string, seq int64, data []byte) error
+	ReadChunk(fileID string, seq int64) (io.ReadCloser, error)
+	DeleteChunk(fileID string, seq int64) error
+
+	// Finalize combines chunks 0 to totalChunks-1 into the completed file
+	// with the given name, which is usually the file ID, and returns its
+	// location. Names can contain forward slashes. An existing completed
+	// file with the same name is replaced. If digest is not nil, the contents
+	// of the completed file are also written to it. If ctx is cancelled,
+	// Finalize stops between chunks and doesn't leave a completed file
+	// behind. Partially written files should not be visible under the name.
+	Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error)
+
+	// OpenCompleted returns the contents and size of a finalized file, or
+	// ErrCompletedFileNotFound if there isn't one with the given name. The
+	// assembler passes the contents downstream as the request body as they
+	// are, with the size as its Content-Length, so stores don't need to
+	// write completed files anywhere else.
+	OpenCompleted(name string) (io.ReadCloser, int64, error)
+	DeleteCompleted(name string) error
+}
+
+// RecoverableStore is implemented by stores that persist the info of
+// in-progress uploads, so that the uploads can be resumed after a restart.
+type RecoverableStore interface {
+	SaveUploadInfo(fileID string, info UploadInfo) error
+	DeleteUploadInfo(fileID string) error
+	RecoverUploads() ([]RecoveredUpload, error)
+}
+
+// Locator is implemented by stores that give clients a different location
+// for completed files than the one returned by Finalize, e.g. a public URL
+// instead of an internal path.
+type Locator interface {
+	Locate(name string) (string, error)
+}
+
+// ContentIndex is implemented by stores that can find completed files by
+// the SHA256 of their contents.
+type ContentIndex interface {
+	HasContent(sum []byte) (bool, error)
+}
+
+// SequenceFinalizer is implemented by stores that can combine chunks with
+// any sequence numbers, in the given order. OffsetHeader needs it, since
+// chunks are numbered by their offsets in the file.
+type SequenceFinalizer interface {
+	FinalizeSequences(ctx context.Context, fileID string, name string, seqs []int64, digest io.Writer) (string, error)
+}
+
+// CompletedSweeper is implemented by stores that can delete completed files
+// by age, which CompletedFileTTL needs.
+type CompletedSweeper interface {
+	// DeleteCompletedBefore deletes completed files written before the
+	// given time and returns how many were deleted.
+	DeleteCompletedBefore(before time.Time) (int, error)
+}
+
+// KeyedStore is implemented by stores that can encrypt the chunks of each
+// upload with a key of its own, which UploadKeyHeader needs. Keys are only
+// used by the call they're given to, and must not be kept. Completed files
+// are written decrypted.
+type KeyedStore interface {
+	WriteChunkWithKey(fileID string, seq int64, data []byte, key []byte) error
+	FinalizeWithKey(ctx context.Context, fileID string, name string, seqs []int64, key []byte, digest io.Writer) (string, error)
+}
+
+type RecoveredUpload struct {
+	FileID string
+	Info   UploadInfo
+
+	// Sizes of stored chunks by sequence number.
+	Chunks map[int64]int64
+}
+
+// FilesystemStore saves each chunk as a separate file in ChunksDir and
+// writes completed files to CompletedDir.
+type FilesystemStore struct {
+	ChunksDir         string
+	CompletedDir      string
+	ChunkFileMode     os.FileMode
+	CompletedFileMode os.FileMode
+
+	// Mode of directories created for completed files with nested names.
+	// DefaultDirMode is used if it's 0.
+	DirMode os.FileMode
+
+	// Name files after the hex-encoded SHA256 of the file ID instead of the
+	// file ID itself, so that any file ID can be used safely. Completed file
+	// names are hashed too, so they are never put in subdirectories.
+	HashFileNames bool
+
+	// Encrypt chunks with AES-GCM using this key, which must be 16, 24 or 32
+	// bytes long. Chunks are decrypted when they are combined.
+	EncryptionKey []byte
+
+	// Keep completed files encrypted with EncryptionKey. OpenCompleted
+	// decrypts them, so the assembler still passes the plaintext downstream.
+	// This must not change while completed files are kept.
+	EncryptCompletedFiles bool
+
+	// Store one copy of completed files with the same contents, which are
+	// hard links to a file in the ".blobs" directory of CompletedDir. This
+	// requires a filesystem that supports hard links, and a platform where
+	// their number can be read (Linux, macOS or FreeBSD), so that blobs are
+	// removed once no completed file links to them.
+	Deduplicate bool
+
+	// Append the chunks of each upload to a single file with an index,
+	// instead of saving each chunk as a separate file. This uses fewer files
+	// for uploads with many chunks. It must not change while uploads are in
+	// progress.
+	AppendChunks bool
+
+	// Directory where completed files are written before they are moved
+	// into CompletedDir. If it's empty, they are written next to their final
+	// path. If it's on a different filesystem, files are copied next to
+	// their final path and then renamed, so they still appear atomically.
+	WorkingDir string
+
+	// Size in bytes of the buffers used to copy chunks into completed
+	// files. DefaultCombineBufferSize is used if it's 0.
+	CombineBufferSize int
+
+	// Keep the partially written file of a combine that was interrupted by
+	// a crash, and continue from the first chunk that it doesn't hold in
+	// full when the upload is combined again. Partial files are named
+	// after the file ID, and are only resumed by the upload that wrote
+	// them, which is told apart by the start time in its upload info
+	// sidecar, so uploads without one are never resumed. This doesn't
+	// apply to encrypted chunks.
+	ResumeCombine bool
+
+	// Reject file IDs and completed file names that Windows can't use,
+	// such as "CON" or names ending with a dot, so that uploads fail the
+	// same way on every platform. This is always enabled on Windows.
+	StrictFileNames bool
+
+	// Checks file IDs in place of StrictFileNames. File IDs containing
+	// path separators, NUL, "." or ".." are rejected regardless.
+	FileIDValidator func(fileID string) error
+
+	// Open logs of uploads by file ID when AppendChunks is set.
+	chunkLogs sync.Map
+}
+
+// uploadInfoFile is the contents of a sidecar file. The file ID is saved
+// since it can't be derived from a hashed file name.
+type uploadInfoFile struct {
+	FileID string `json:"file_id,omitempty"`
+	UploadInfo
+}
+
+func NewFilesystemStore(chunksDir string, completedDir string) *FilesystemStore {
+	return &FilesystemStore{
+		ChunksDir:         chunksDir,
+		CompletedDir:      completedDir,
+		ChunkFileMode:     DefaultChunkFileMode,
+		CompletedFileMode: DefaultCompletedFileMode,
+		DirMode:           DefaultDirMode,
+		CombineBufferSize: DefaultCombineBufferSize,
+	}
+}
+
+func (s *FilesystemStore) WriteChunk(fileID string, seq int64, data []byte) error {
+	return s.writeChunk(fileID, seq, data, s.EncryptionKey)
+}
+
+func (s *FilesystemStore) WriteChunkWithKey(fileID string, seq int64, data []byte, key []byte) error {
+	return s.writeChunk(fileID, seq, data, key)
+}
+
+func (s *FilesystemStore) writeChunk(fileID string, seq int64, data []byte, key []byte) error {
+	if err := s.checkFileID(fileID); err != nil {
+		return err
+	}
+	if key != nil {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		if data, err = sealChunk(aead, data); err != nil {
+			return err
+		}
+	}
+	if s.AppendChunks {
+		return s.appendChunk(fileID, seq, data)
+	}
+	return os.WriteFile(s.chunkFilePath(fileID, seq), data, s.ChunkFileMode)
+}
+
+func (s *FilesystemStore) ReadChunk(fileID string, seq int64) (io.ReadCloser, error) {
+	if err := s.checkFileID(fileID); err != nil {
+		return nil, err
+	}
+	if s.EncryptionKey != nil || s.AppendChunks {
+		data, err := s.readChunkFile(fileID, seq)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return os.Open(s.chunkFilePath(fileID, seq))
+}
+
+func (s *FilesystemStore) DeleteChunk(fileID string, seq int64) error {
+	if err := s.checkFileID(fileID); err != nil {
+		return err
+	}
+	if s.AppendChunks {
+		return s.deleteLoggedChunk(fileID, seq)
+	}
+	return os.Remove(s.chunkFilePath(fileID, seq))
+}
+
+func (s *FilesystemStore) Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error) {
+	return s.FinalizeSequences(ctx, fileID, name, sequence(totalChunks), digest)
+}
+
+func (s *FilesystemStore) FinalizeSequences(ctx context.Context, fileID string, name string, seqs []int64, digest io.Writer) (string, error) {
+	return s.finalize(ctx, fileID, name, seqs, s.EncryptionKey, digest)
+}
+
+func (s *FilesystemStore) FinalizeWithKey(ctx context.Context, fileID string, name string, seqs []int64, key []byte, digest io.Writer) (string, error) {
+	return s.finalize(ctx, fileID, name, seqs, key, digest)
+}
+
+// finalize combines chunks encrypted with key, if it isn't nil.
+func (s *FilesystemStore) finalize(ctx context.Context, fileID string, name string, seqs []int64, key []byte, digest io.Writer) (string, error) {
+	if err := s.checkFileID(fileID); err != nil {
+		return "", err
+	}
+	if err := s.checkCompletedName(name); err != nil {
+		return "", err
+	}
+	if err := s.checkChunkFiles(fileID, seqs); err != nil {
+		return "", err
+	}
+	if s.Deduplicate && !linkCountSupported {
+		return "", errDeduplicateUnsupported
+	}
+	completedFilePath := s.completedFilePath(name)
+	if err := os.MkdirAll(path.Dir(completedFilePath), s.dirMode()); err != nil {
+		return "", err
+	}
+	var contentHash hash.Hash
+	var replacedBlob *dedupBlob
+	if s.Deduplicate {
+		// The file is replaced by a rename, which leaves the blob it links
+		// to as it is. The blob is removed once the new file is in place,
+		// so the existing file is kept if combining fails.
+		var err error
+		replacedBlob, err = s.soleBlob(name)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		contentHash = sha256.New()
+		if digest != nil {
+			digest = io.MultiWriter(digest, contentHash)
+		} else {
+			digest = contentHash
+		}
+	}
+	// The file is written next to its final path and renamed into place, so
+	// a partially written file is never seen at that path, even after a
+	// crash.
+	partialFilePath := completedFilePath + ".partial"
+	resume := s.ResumeCombine && key == nil
+	if s.WorkingDir != "" {
+		partialFilePath = path.Join(s.WorkingDir, s.fileName(fileID)+".partial")
+	} else if resume {
+		partialFilePath = path.Join(path.Dir(completedFilePath), s.fileName(fileID)+".partial")
+	}
+	flags := os.O_RDWR | os.O_CREATE
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	finalFile, err := os.OpenFile(partialFilePath, flags, s.CompletedFileMode)
+	if err != nil {
+		return "", err
+	}
+	defer finalFile.Close()
+	if resume {
+		owner, err := s.partialOwner(fileID)
+		if err != nil {
+			removePartial(partialFilePath)
+			return "", err
+		}
+		resumed, err := s.resumePartial(finalFile, partialFilePath, owner, fileID, seqs, digest)
+		if err != nil {
+			removePartial(partialFilePath)
+			return "", err
+		}
+		seqs = seqs[resumed:]
+	}
+	// Small chunks are buffered so that each one doesn't need a write.
+	bufferSize := s.combineBufferSize()
+	w := bufio.NewWriterSize(finalFile, bufferSize)
+	buf := make([]byte, bufferSize)
+	for _, seq := range seqs {
+		if err := ctx.Err(); err != nil {
+			removePartial(partialFilePath)
+			return "", fmt.Errorf("chunk %d: %w", seq, err)
+		}
+		if err := s.copyChunk(w, digest, fileID, seq, buf, key); err != nil {
+			removePartial(partialFilePath)
+			return "", fmt.Errorf("chunk %d: %w", seq, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		removePartial(partialFilePath)
+		return "", err
+	}
+	// The contents must be on disk before the rename is.
+	if err := finalFile.Sync(); err != nil {
+		removePartial(partialFilePath)
+		return "", err
+	}
+	if err := finalFile.Close(); err != nil {
+		removePartial(partialFilePath)
+		return "", err
+	}
+	if err := s.moveCompleted(partialFilePath, completedFilePath); err != nil {
+		removePartial(partialFilePath)
+		return "", err
+	}
+	if resume {
+		_ = os.Remove(partialFilePath + partialOwnerExt)
+	}
+	if contentHash != nil {
+		// Deduplication only saves space, so the completed file is kept as
+		// it is if it fails.
+		_ = s.deduplicate(completedFilePath, contentHash.Sum(nil))
+		// A blob left behind is removed by DeleteCompletedBefore.
+		_ = replacedBlob.remove()
+	}
+	return completedFilePath, nil
+}
+
+// moveCompleted renames a written file to its final path. Files can't be
+// renamed across filesystems, so in that case the file is copied next to
+// its final path first.
+func (s *FilesystemStore) moveCompleted(partialFilePath string, completedFilePath string) error {
+	err := os.Rename(partialFilePath, completedFilePath)
+	if !isCrossDevice(err) {
+		return err
+	}
+	copyPath := completedFilePath + ".partial"
+	if err := s.copyCompleted(partialFilePath, copyPath); err != nil {
+		_ = os.Remove(copyPath)
+		return err
+	}
+	if err := os.Rename(copyPath, completedFilePath); err != nil {
+		_ = os.Remove(copyPath)
+		return err
+	}
+	return os.Remove(partialFilePath)
+}
+
+func (s *FilesystemStore) copyCompleted(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.CompletedFileMode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// checkChunkFiles makes sure that every chunk is still stored before
+// anything is written, so that a chunk deleted from outside the store fails
+// the combine without touching the completed file.
+func (s *FilesystemStore) checkChunkFiles(fileID string, seqs []int64) error {
+	for _, seq := range seqs {
+		if _, err := s.chunkSize(fileID, seq); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("chunk %d: %w", seq, errChunkFileMissing)
+			}
+			return fmt.Errorf("chunk %d: %w", seq, err)
+		}
+	}
+	return nil
+}
+
+// copyChunk streams a chunk to w and digest through buf, so that memory
+// usage doesn't depend on the chunk size. Encrypted chunks have to be read
+// whole.
+func (s *FilesystemStore) copyChunk(w io.Writer, digest io.Writer, fileID string, seq int64, buf []byte, key []byte) error {
+	if key != nil {
+		return s.copyEncryptedChunk(w, digest, fileID, seq, key)
+	}
+	if digest != nil {
+		w = io.MultiWriter(w, digest)
+	}
+	if s.AppendChunks {
+		return s.copyLoggedChunk(w, fileID, seq, buf)
+	}
+	chunk, err := os.Open(s.chunkFilePath(fileID, seq))
+	if err != nil {
+		return err
+	}
+	defer chunk.Close()
+	// Files implement io.WriterTo, which io.CopyBuffer would use instead of
+	// buf.
+	_, err = io.CopyBuffer(w, struct{ io.Reader }{chunk}, buf)
+	return err
+}
+
+func (s *FilesystemStore) OpenCompleted(name string) (io.ReadCloser, int64, error) {
+	if err := s.checkCompletedName(name); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrCompletedFileNotFound, err)
+	}
+	f, err := os.Open(s.completedFilePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrCompletedFileNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.EncryptionKey != nil && s.EncryptCompletedFiles {
+		r, size, err := s.openEncryptedCompleted(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, 0, err
+		}
+		return r, size, nil
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *FilesystemStore) DeleteCompleted(name string) error {
+	if err := s.checkCompletedName(name); err != nil {
+		return err
+	}
+	if s.Deduplicate {
+		return s.removeDeduplicated(name)
+	}
+	return os.Remove(s.completedFilePath(name))
+}
+
+// SaveUploadInfo writes a sidecar file next to the chunks, since the
+// expected number of chunks can't be derived from the chunk files.
+func (s *FilesystemStore) SaveUploadInfo(fileID string, info UploadInfo) error {
+	if err := s.checkFileID(fileID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(uploadInfoFile{FileID: fileID, UploadInfo: info})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.uploadInfoPath(fileID), data, s.ChunkFileMode)
+}
+
+func (s *FilesystemStore) DeleteUploadInfo(fileID string) error {
+	if err := s.checkFileID(fileID); err != nil {
+		return err
+	}
+	err := os.Remove(s.uploadInfoPath(fileID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RecoverUploads returns uploads that have a sidecar file, along with the
+// chunks found for each of them.
+func (s *FilesystemStore) RecoverUploads() ([]RecoveredUpload, error) {
+	entries, err := os.ReadDir(s.ChunksDir)
+	if err != nil {
+		return nil, err
+	}
+	uploads := make(map[string]*RecoveredUpload)
+	chunks := make(map[string]map[int64]int64)
+	for _, entry := range entries {
+		name := entry.Name()
+		if fileName := strings.TrimSuffix(name, uploadInfoExt); fileName != name {
+			data, err := os.ReadFile(path.Join(s.ChunksDir, name))
+			if err != nil {
+				return nil, err
+			}
+			var f uploadInfoFile
+			if err := json.Unmarshal(data, &f); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			// Sidecars written by older versions don't have the file ID.
+			if f.FileID == "" {
+				f.FileID = fileName
+			}
+			uploads[fileName] = &RecoveredUpload{FileID: f.FileID, Info: f.UploadInfo}
+			continue
+		}
+		if fileName := strings.TrimSuffix(name, chunkIndexExt); fileName != name {
+			sizes, err := s.loggedChunkSizes(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if s.EncryptionKey != nil {
+				for seq := range sizes {
+					sizes[seq] -= recordOverhead
+				}
+			}
+			chunks[fileName] = sizes
+			continue
+		}
+		sep := strings.LastIndex(name, "-")
+		if sep == -1 {
+			continue
+		}
+		seq, err := strconv.ParseInt(name[sep+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		if chunks[name[:sep]] == nil {
+			chunks[name[:sep]] = make(map[int64]int64)
+		}
+		size := info.Size()
+		if s.EncryptionKey != nil {
+			size -= recordOverhead
+		}
+		chunks[name[:sep]][seq] = size
+	}
+	recovered := make([]RecoveredUpload, 0, len(uploads))
+	for fileName, u := range uploads {
+		u.Chunks = chunks[fileName]
+		if u.Info.KeyFingerprint != "" && s.EncryptionKey == nil {
+			// The chunks were encrypted with the upload's own key.
+			for seq := range u.Chunks {
+				u.Chunks[seq] -= recordOverhead
+			}
+		}
+		recovered = append(recovered, *u)
+	}
+	return recovered, nil
+}
+
+// checkFileID rejects IDs that could escape the store's directories when
+// used as a file name. The assembler only generates numeric IDs, but the
+// store can be used directly.
+func (s *FilesystemStore) checkFileID(fileID string) error {
+	if s.HashFileNames {
+		return nil
+	}
+	if fileID == "" || fileID == "." || fileID == ".." || strings.ContainsAny(fileID, "/\\\x00") {
+		return errUnsafeFileID
+	}
+	if s.FileIDValidator != nil {
+		return s.FileIDValidator(fileID)
+	}
+	if s.strictFileNames() {
+		return checkPortableName(fileID)
+	}
+	return nil
+}
+
+// checkCompletedName is like checkFileID, but allows names in
+// subdirectories of CompletedDir.
+func (s *FilesystemStore) checkCompletedName(name string) error {
+	if s.HashFileNames {
+		return nil
+	}
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name ||
+		name == "." || name == ".." || strings.HasPrefix(name, "../") ||
+		strings.ContainsAny(name, "\\\x00") {
+		return errUnsafeCompletedName
+	}
+	if s.strictFileNames() {
+		return checkPortableName(name)
+	}
+	return nil
+}
+
+// sequence returns the sequence numbers of chunks 0 to totalChunks-1.
+func sequence(totalChunks int64) []int64 {
+	seqs := make([]int64, totalChunks)
+	for i := range seqs {
+		seqs[i] = int64(i)
+	}
+	return seqs
+}
+
+func (s *FilesystemStore) combineBufferSize() int {
+	if s.CombineBufferSize <= 0 {
+		return DefaultCombineBufferSize
+	}
+	return s.CombineBufferSize
+}
+
+func (s *FilesystemStore) dirMode() os.FileMode {
+	if s.DirMode == 0 {
+		return DefaultDirMode
+	}
+	return s.DirMode
+}
+
+// fileName returns the name that files of an upload are based on.
+func (s *FilesystemStore) fileName(fileID string) string {
+	if !s.HashFileNames {
+		return fileID
+	}
+	sum := sha256.Sum256([]byte(fileID))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *FilesystemStore) chunkFilePath(fileID string, chunkID int64) string {
+	return path.Join(s.ChunksDir, fmt.Sprintf("%s-%d", s.fileName(fileID), chunkID))
+}
+
+func (s *FilesystemStore) uploadInfoPath(fileID string) string {
+	return path.Join(s.ChunksDir, s.fileName(fileID)+uploadInfoExt)
+}
+
+func (s *FilesystemStore) completedFilePath(name string) string {
+	return path.Join(s.CompletedDir, s.fileName(name))
+}
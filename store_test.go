@@ -0,0 +1,186 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testChunkStore checks the behavior every ChunkStore must have.
+func testChunkStore(t *testing.T, newStore func(t *testing.T) ChunkStore) {
+	t.Run("chunks", func(t *testing.T) {
+		store := newStore(t)
+		data := []byte("chunk")
+		if err := store.WriteChunk("1", 0, data); err != nil {
+			t.Fatal(err)
+		}
+		// Callers may reuse the slice.
+		copy(data, "xxxxx")
+		if got := readChunk(t, store, "1", 0); got != "chunk" {
+			t.Errorf("got chunk %q", got)
+		}
+		if err := store.DeleteChunk("1", 0); err != nil {
+			t.Fatal(err)
+		}
+		if r, err := store.ReadChunk("1", 0); err == nil {
+			_ = r.Close()
+			t.Error("deleted chunk can still be read")
+		}
+	})
+
+	t.Run("finalize", func(t *testing.T) {
+		store := newStore(t)
+		writeChunks(t, store, "1", "hello", " ", "world")
+		digest := sha256.New()
+		if _, err := store.Finalize(context.Background(), "1", "file", 3, digest); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCompletedFile(t, store, "file"); got != "hello world" {
+			t.Errorf("got completed file %q", got)
+		}
+		want := sha256.Sum256([]byte("hello world"))
+		if !bytes.Equal(digest.Sum(nil), want[:]) {
+			t.Error("digest doesn't match the completed file")
+		}
+
+		// An existing completed file is replaced.
+		writeChunks(t, store, "2", "bye")
+		if _, err := store.Finalize(context.Background(), "2", "file", 1, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCompletedFile(t, store, "file"); got != "bye" {
+			t.Errorf("got replaced file %q", got)
+		}
+
+		if err := store.DeleteCompleted("file"); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := store.OpenCompleted("file"); !errors.Is(err, ErrCompletedFileNotFound) {
+			t.Errorf("got %v after deleting, want %v", err, ErrCompletedFileNotFound)
+		}
+	})
+
+	t.Run("missing chunk", func(t *testing.T) {
+		store := newStore(t)
+		writeChunks(t, store, "1", "a")
+		if _, err := store.Finalize(context.Background(), "1", "file", 2, nil); err == nil {
+			t.Fatal("finalizing without chunk 1 succeeded")
+		}
+		if _, _, err := store.OpenCompleted("file"); !errors.Is(err, ErrCompletedFileNotFound) {
+			t.Errorf("got %v, want %v", err, ErrCompletedFileNotFound)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		store := newStore(t)
+		writeChunks(t, store, "1", "a", "b")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := store.Finalize(ctx, "1", "file", 2, nil); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+		if _, _, err := store.OpenCompleted("file"); !errors.Is(err, ErrCompletedFileNotFound) {
+			t.Errorf("got %v, want %v", err, ErrCompletedFileNotFound)
+		}
+		// The chunks are kept, so the upload can be completed later.
+		if _, err := store.Finalize(context.Background(), "1", "file", 2, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := readCompletedFile(t, store, "file"); got != "ab" {
+			t.Errorf("got completed file %q", got)
+		}
+	})
+}
+
+func writeChunks(t *testing.T, store ChunkStore, fileID string, chunks ...string) {
+	t.Helper()
+	for seq, chunk := range chunks {
+		if err := store.WriteChunk(fileID, int64(seq), []byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readChunk(t *testing.T, store ChunkStore, fileID string, seq int64) string {
+	t.Helper()
+	r, err := store.ReadChunk(fileID, seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func readCompletedFile(t *testing.T, store ChunkStore, name string) string {
+	t.Helper()
+	r, size, err := store.OpenCompleted(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(data)) != size {
+		t.Errorf("%s: got %d bytes, but the size is %d", name, len(data), size)
+	}
+	return string(data)
+}
+
+func TestFilesystemStore(t *testing.T) {
+	testChunkStore(t, func(t *testing.T) ChunkStore {
+		return NewFilesystemStore(t.TempDir(), t.TempDir())
+	})
+}
+
+func TestFilesystemStoreNestedNames(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	writeChunks(t, store, "1", "a")
+	location, err := store.Finalize(context.Background(), "1", "dir/file", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location != filepath.Join(store.CompletedDir, "dir", "file") {
+		t.Errorf("got location %s", location)
+	}
+	for _, name := range []string{"../file", "/file", "dir/../../file"} {
+		if _, err := store.Finalize(context.Background(), "1", name, 1, nil); !errors.Is(err, errUnsafeCompletedName) {
+			t.Errorf("%s: got %v, want %v", name, err, errUnsafeCompletedName)
+		}
+	}
+}
+
+func TestFilesystemStoreLeavesNoPartialFiles(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	writeChunks(t, store, "1", "a")
+	if _, err := store.Finalize(context.Background(), "1", "file", 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	// A failed combine keeps the existing file.
+	if _, err := store.Finalize(context.Background(), "1", "file", 2, nil); !errors.Is(err, errChunkFileMissing) {
+		t.Fatalf("got %v, want %v", err, errChunkFileMissing)
+	}
+	if got := readCompletedFile(t, store, "file"); got != "a" {
+		t.Errorf("got completed file %q", got)
+	}
+	entries, err := os.ReadDir(store.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".partial") {
+			t.Errorf("%s was left behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSweepRemovesStaleUploads(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{IncompleteUploadTTL: time.Hour, Clock: clock})
+	stale := ta.startUpload(`{"total_chunks": 2}`, nil)
+	active := ta.startUpload(`{"total_chunks": 2}`, nil)
+	ta.mustSend(stale, 0, "a", nil, http.StatusOK)
+	ta.mustSend(active, 0, "a", nil, http.StatusOK)
+
+	clock.Advance(45 * time.Minute)
+	ta.mustSend(active, 0, "a", nil, http.StatusOK)
+	if removed, err := ta.a.Sweep(); err != nil || removed != 0 {
+		t.Fatalf("got %d removed (%v), want none before the TTL", removed, err)
+	}
+
+	clock.Advance(30 * time.Minute)
+	removed, err := ta.a.Sweep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Fatalf("got %d removed, want 1", removed)
+	}
+	if _, err := ta.a.Config.Tracker.GetUpload(stale); !errors.Is(err, ErrUploadNotFound) {
+		t.Errorf("stale upload is still tracked: %v", err)
+	}
+	rec := ta.send(stale, 1, "b", nil)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != ErrUploadNotFound.Error() {
+		t.Errorf("chunk of a removed upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	files, err := readDirFiles(ta.a.Config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := files[ta.a.fileID(stale)+"-0"]; ok {
+		t.Error("chunk of the stale upload was kept")
+	}
+
+	// The active upload is untouched.
+	ta.mustSend(active, 1, "b", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "ab" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestSweepWithoutTTL(t *testing.T) {
+	clock := newFakeClock()
+	ta := newTestAssembler(t, &AssemblerConfig{Clock: clock})
+	ta.startUpload(`{"total_chunks": 2}`, nil)
+	clock.Advance(1000 * time.Hour)
+	if removed, err := ta.a.Sweep(); err != nil || removed != 0 {
+		t.Errorf("got %d removed (%v) without a TTL", removed, err)
+	}
+}
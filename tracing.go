@@ -0,0 +1,84 @@
+package assemble
+
+import (
+	"context"
+	"net/http"
+)
+
+// Names of the spans started by the assembler.
+const (
+	SpanChunk      = "assemble.chunk"
+	SpanStoreChunk = "assemble.store_chunk"
+	SpanCombine    = "assemble.combine"
+)
+
+// Attribute keys of the spans started by the assembler.
+const (
+	AttrFileID      = "assemble.file_id"
+	AttrChunk       = "assemble.chunk_id"
+	AttrBytes       = "assemble.bytes"
+	AttrTotalChunks = "assemble.total_chunks"
+)
+
+// Tracer starts spans for distributed tracing, e.g. with an adapter for an
+// OpenTelemetry tracer, so that the package doesn't depend on a tracing
+// library. Spans are started from the context of the request, so they are
+// children of any span already in it.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...Attribute)
+	RecordError(err error)
+	End()
+}
+
+// TracePropagator is implemented by Tracers that can continue a trace from
+// the headers of an incoming request, e.g. W3C traceparent. It's called for
+// each chunk request before its span is started.
+type TracePropagator interface {
+	Extract(ctx context.Context, header http.Header) context.Context
+}
+
+// Attribute is a key-value pair attached to a span. Values are strings,
+// int64s or bools.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) RecordError(error) {}
+
+func (noopSpan) End() {}
+
+// startChunkSpan starts the span of a chunk request, continuing the trace
+// of the client if the Tracer can, and returns the request with the span's
+// context.
+func (a *FileChunksAssembler) startChunkSpan(r *http.Request) (*http.Request, Span) {
+	ctx := r.Context()
+	if propagator, ok := a.Config.Tracer.(TracePropagator); ok {
+		ctx = propagator.Extract(ctx, r.Header)
+	}
+	ctx, span := a.Config.Tracer.Start(ctx, SpanChunk)
+	return r.WithContext(ctx), span
+}
+
+// endSpan records err, if there is one, and ends the span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
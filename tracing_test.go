@@ -0,0 +1,159 @@
+package assemble
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// recordedSpan is a span started by recordingTracer. Spans are numbered
+// from 1 in the order they're started, and parent is 0 for root spans.
+type recordedSpan struct {
+	id     int
+	name   string
+	parent int
+	trace  string
+	attrs  map[string]interface{}
+	errs   []error
+	ended  bool
+}
+
+type spanKey struct{}
+
+type traceKey struct{}
+
+// recordingTracer records spans in memory, and continues traces from the
+// traceparent header.
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	span := &recordedSpan{name: name, attrs: make(map[string]interface{})}
+	if parent, ok := ctx.Value(spanKey{}).(*recordedSpan); ok {
+		span.parent = parent.id
+	}
+	span.trace, _ = ctx.Value(traceKey{}).(string)
+	t.lock.Lock()
+	span.id = len(t.spans) + 1
+	t.spans = append(t.spans, span)
+	t.lock.Unlock()
+	s := &tracedSpan{tracer: t, span: span}
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, span), s
+}
+
+func (t *recordingTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	if trace := header.Get("traceparent"); trace != "" {
+		return context.WithValue(ctx, traceKey{}, trace)
+	}
+	return ctx
+}
+
+// recorded returns the spans started so far.
+func (t *recordingTracer) recorded() []recordedSpan {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	spans := make([]recordedSpan, len(t.spans))
+	for i, span := range t.spans {
+		spans[i] = *span
+	}
+	return spans
+}
+
+type tracedSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (s *tracedSpan) SetAttributes(attrs ...Attribute) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	for _, attr := range attrs {
+		s.span.attrs[attr.Key] = attr.Value
+	}
+}
+
+func (s *tracedSpan) RecordError(err error) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.span.errs = append(s.span.errs, err)
+}
+
+func (s *tracedSpan) End() {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+	s.span.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	ta := newTestAssembler(t, &AssemblerConfig{Tracer: tracer})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+	traceparent := map[string]string{"traceparent": "00-trace-span-01"}
+	ta.mustSend(uploadID, 0, "hello ", traceparent, http.StatusOK)
+	ta.mustSend(uploadID, 1, "world", traceparent, http.StatusOK)
+
+	spans := tracer.recorded()
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.name)
+	}
+	want := []string{SpanChunk, SpanStoreChunk, SpanChunk, SpanStoreChunk, SpanCombine}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("got spans %q, want %q", names, want)
+	}
+	fileID := ta.a.fileID(uploadID)
+	for i, span := range spans {
+		if !span.ended {
+			t.Errorf("%s span wasn't ended", span.name)
+		}
+		if len(span.errs) != 0 {
+			t.Errorf("%s span recorded errors %v", span.name, span.errs)
+		}
+		// Every span continues the client's trace.
+		if span.trace != "00-trace-span-01" {
+			t.Errorf("%s span has trace %q", span.name, span.trace)
+		}
+		// Storing the chunk is part of the chunk request, and so is
+		// combining for the final chunk.
+		parent := 0
+		if span.name != SpanChunk {
+			parent = spans[2].id
+			if i < 2 {
+				parent = spans[0].id
+			}
+		}
+		if span.parent != parent {
+			t.Errorf("%s span %d has parent %d, want %d", span.name, span.id, span.parent, parent)
+		}
+	}
+
+	checkAttrs := func(span recordedSpan, want map[string]interface{}) {
+		t.Helper()
+		if !reflect.DeepEqual(span.attrs, want) {
+			t.Errorf("%s span has attributes %v, want %v", span.name, span.attrs, want)
+		}
+	}
+	checkAttrs(spans[0], map[string]interface{}{AttrFileID: fileID, AttrChunk: int64(0), AttrBytes: int64(6)})
+	checkAttrs(spans[1], map[string]interface{}{AttrFileID: fileID, AttrChunk: int64(0), AttrBytes: int64(6)})
+	checkAttrs(spans[3], map[string]interface{}{AttrFileID: fileID, AttrChunk: int64(1), AttrBytes: int64(5)})
+	checkAttrs(spans[4], map[string]interface{}{AttrFileID: fileID, AttrTotalChunks: int64(2)})
+}
+
+func TestTracerRecordsErrors(t *testing.T) {
+	tracer := &recordingTracer{}
+	ta := newTestAssembler(t, &AssemblerConfig{Tracer: tracer, Store: failingStore{NewMemoryStore()}})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusInternalServerError)
+	spans := tracer.recorded()
+	if len(spans) != 2 || spans[1].name != SpanStoreChunk {
+		t.Fatalf("got %d spans", len(spans))
+	}
+	if len(spans[1].errs) != 1 || !spans[1].ended {
+		t.Errorf("got store span %+v, want it ended with the error", spans[1])
+	}
+}
@@ -1,106 +1,381 @@
 package assemble
 
 import (
-	"fmt"
-	"io/ioutil"
-	"os"
-	"path"
+	"errors"
 	"sync"
+	"time"
 )
 
-type fileInfo struct {
+var (
+	ErrUploadNotFound = errors.New("upload ID not found")
+	ErrUploadExists   = errors.New("upload ID already exists")
+)
+
+// UploadInfo is sent by the client when starting an upload.
+type UploadInfo struct {
 	TotalChunks int64                  `json:"total_chunks"`
 	Metadata    map[string]interface{} `json:"metadata"`
+
+	// Hex-encoded SHA256 of the completed file, if it should be verified.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Size of the completed file in bytes, if it is known in advance. Chunks
+	// of uploads started with TusHandler or sent with OffsetHeader can't go
+	// past it, and chunks that declare a different total size are rejected.
+	Size int64 `json:"size,omitempty"`
+
+	// Hex-encoded SHA256 of the upload's encryption key with
+	// UploadKeyHeader, which every chunk's key must match. The key itself
+	// isn't kept.
+	KeyFingerprint string `json:"key_fingerprint,omitempty"`
+
+	// Set by the server when the upload is started.
+	CreatedAt time.Time `json:"created_at"`
 }
 
-type activeUpload struct {
-	id     int64
-	info   fileInfo
-	chunks map[int64]interface{}
-	lock   sync.Mutex
+// Tracker records which chunks of each upload have been received. Methods
+// return ErrUploadNotFound for unknown upload IDs.
+//
+// Implementations shared by multiple assemblers (e.g. across replicas) must
+// make ClaimCompletion atomic so that only one of them combines the chunks.
+type Tracker interface {
+	CreateUpload(info UploadInfo) (int64, error)
+
+	// CreateUploadWithID is like CreateUpload for an ID chosen by the
+	// caller. It returns ErrUploadExists if the ID is in use.
+	CreateUploadWithID(uploadID int64, info UploadInfo) error
+	GetUpload(uploadID int64) (UploadInfo, error)
+
+	// AddChunk marks a chunk as received and returns the progress of the
+	// upload. If the chunk was already received, its info is replaced.
+	AddChunk(uploadID int64, seq int64, chunk ChunkInfo) (Progress, error)
+
+	// GetChunk returns the info of a chunk and whether it has been received.
+	GetChunk(uploadID int64, seq int64) (ChunkInfo, bool, error)
+	CountChunks(uploadID int64) (int64, error)
+	GetProgress(uploadID int64) (Progress, error)
+
+	// ReceivedChunks returns the sequence numbers of received chunks in
+	// ascending order.
+	ReceivedChunks(uploadID int64) ([]int64, error)
+
+	// ClaimCompletion returns true if all chunks have been received and no
+	// previous call has claimed the upload. Uploads with 0 total chunks
+	// can't be claimed, since their number of chunks isn't known yet.
+	// Unless the claim is released, it returns true at most once per upload.
+	ClaimCompletion(uploadID int64) (bool, error)
+
+	// ReleaseCompletion allows an upload to be claimed again, for when its
+	// chunks couldn't be combined into a valid file.
+	ReleaseCompletion(uploadID int64) error
+
+	SetChecksum(uploadID int64, checksum string) error
+	SetMetadata(uploadID int64, metadata map[string]interface{}) error
+
+	// SetTotalChunks and SetSize are for uploads that don't know their size
+	// when they are started.
+	SetTotalChunks(uploadID int64, totalChunks int64) error
+	SetSize(uploadID int64, size int64) error
+
+	RemoveUpload(uploadID int64) error
+
+	// StaleUploads returns uploads that were last active (started or
+	// received a chunk) before the given time.
+	StaleUploads(lastActiveBefore time.Time) ([]int64, error)
+
+	// CountUploads returns the number of uploads being tracked.
+	CountUploads() (int64, error)
+}
+
+type ChunkInfo struct {
+	Size       int64
+	ReceivedAt time.Time
+
+	// Hex-encoded SHA256 of the chunk. This is only recorded when
+	// RejectChunkOverwrite is enabled or the chunk was sent with PUT.
+	Checksum string
+}
+
+// Progress is the amount of distinct chunks received for an upload and
+// their total size.
+type Progress struct {
+	Chunks int64
+	Bytes  int64
 }
 
-type tracker struct {
-	uploads      sync.Map
-	nextID       int64
-	lock         sync.Mutex
-	chunkDir     string
-	completedDir string
+// MemoryTracker keeps track of uploads in the memory of a single process.
+//
+// lock guards count and nextID, and is held while adding or deleting
+// uploads so that count stays accurate. Each upload has its own lock which
+// guards all of its fields, so reads such as ReceivedChunks can run
+// concurrently with AddChunk for the same upload.
+type MemoryTracker struct {
+	// Record received chunks in a bitset instead of a map, which uses much
+	// less memory for uploads with many chunks. Receive times of chunks
+	// aren't kept, and memory grows with the highest sequence number
+	// received, so NewFileChunksAssembler requires MaxChunkTotal with it
+	// and refuses it with OffsetHeader. It must be set before the tracker
+	// is used.
+	CompactChunks bool
+
+	uploads sync.Map
+	count   int64
+	nextID  int64
+	lock    sync.Mutex
 }
 
-func (a *tracker) createUpload(info fileInfo) int64 {
-	a.lock.Lock()
-	defer a.lock.Unlock()
-	id := a.nextID
-	a.uploads.Store(id, &activeUpload{
-		id:     id,
-		info:   info,
-		chunks: make(map[int64]interface{}),
+type memoryUpload struct {
+	info UploadInfo
+
+	// Received chunks by sequence number.
+	chunks       chunkSet
+	bytes        int64
+	lastActivity time.Time
+	completed    bool
+
+	// Must be held to read or write any of the fields above.
+	lock sync.Mutex
+}
+
+func NewMemoryTracker() *MemoryTracker {
+	return &MemoryTracker{}
+}
+
+func (t *MemoryTracker) CreateUpload(info UploadInfo) (int64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// IDs may have been taken by CreateUploadWithID.
+	for {
+		if _, exists := t.uploads.Load(t.nextID); !exists {
+			break
+		}
+		t.nextID++
+	}
+	id := t.nextID
+	t.addUpload(id, info)
+	t.nextID++
+	return id, nil
+}
+
+func (t *MemoryTracker) CreateUploadWithID(uploadID int64, info UploadInfo) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, exists := t.uploads.Load(uploadID); exists {
+		return ErrUploadExists
+	}
+	t.addUpload(uploadID, info)
+	return nil
+}
+
+// addUpload must be called with t.lock held.
+func (t *MemoryTracker) addUpload(uploadID int64, info UploadInfo) {
+	t.uploads.Store(uploadID, &memoryUpload{
+		info:         info,
+		chunks:       newChunkSet(t.CompactChunks),
+		lastActivity: info.CreatedAt,
 	})
-	a.nextID++
-	return id
+	t.count++
+}
+
+// RestoreUpload adds an upload that was started by a previous process. New
+// uploads will be given IDs greater than uploadID. Chunks are given as a
+// map of sequence number to size. The upload is considered active at
+// restoredAt.
+func (t *MemoryTracker) RestoreUpload(uploadID int64, info UploadInfo, chunks map[int64]int64, restoredAt time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	f := &memoryUpload{
+		info:         info,
+		chunks:       newChunkSet(t.CompactChunks),
+		lastActivity: restoredAt,
+	}
+	for seq, size := range chunks {
+		f.chunks.add(seq, ChunkInfo{Size: size, ReceivedAt: restoredAt})
+		f.bytes += size
+	}
+	if _, exists := t.uploads.Load(uploadID); !exists {
+		t.count++
+	}
+	t.uploads.Store(uploadID, f)
+	if uploadID >= t.nextID {
+		t.nextID = uploadID + 1
+	}
+}
+
+func (t *MemoryTracker) getUpload(uploadID int64) (*memoryUpload, error) {
+	f, exists := t.uploads.Load(uploadID)
+	if !exists {
+		return nil, ErrUploadNotFound
+	}
+	return f.(*memoryUpload), nil
+}
+
+func (t *MemoryTracker) GetUpload(uploadID int64) (UploadInfo, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.info, nil
 }
 
-func (a *tracker) addChunk(f *activeUpload, chunkID int64, chunkData []byte) error {
-	chunkFilePath := a.chunkFilePath(f.id, chunkID)
-	if err := ioutil.WriteFile(chunkFilePath, chunkData, 0644); err != nil {
+func (t *MemoryTracker) AddChunk(uploadID int64, seq int64, chunk ChunkInfo) (Progress, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return Progress{}, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.bytes += chunk.Size - f.chunks.add(seq, chunk)
+	if chunk.ReceivedAt.After(f.lastActivity) {
+		f.lastActivity = chunk.ReceivedAt
+	}
+	return Progress{
+		Chunks: f.chunks.len(),
+		Bytes:  f.bytes,
+	}, nil
+}
+
+func (t *MemoryTracker) GetChunk(uploadID int64, seq int64) (ChunkInfo, bool, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return ChunkInfo{}, false, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	chunk, exists := f.chunks.get(seq)
+	return chunk, exists, nil
+}
+
+func (t *MemoryTracker) CountChunks(uploadID int64) (int64, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.chunks.len(), nil
+}
+
+func (t *MemoryTracker) GetProgress(uploadID int64) (Progress, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return Progress{}, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return Progress{
+		Chunks: f.chunks.len(),
+		Bytes:  f.bytes,
+	}, nil
+}
+
+func (t *MemoryTracker) ReceivedChunks(uploadID int64) ([]int64, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.chunks.sequences(), nil
+}
+
+func (t *MemoryTracker) ClaimCompletion(uploadID int64) (bool, error) {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return false, err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	// The number of chunks may not be known yet.
+	if f.completed || f.info.TotalChunks == 0 || f.chunks.len() < f.info.TotalChunks {
+		return false, nil
+	}
+	f.completed = true
+	return true, nil
+}
+
+func (t *MemoryTracker) ReleaseCompletion(uploadID int64) error {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
 		return err
 	}
-	f.chunks[chunkID] = nil
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.completed = false
 	return nil
 }
 
-func (a *tracker) deleteChunk(f *activeUpload, chunkID int64) error {
-	if err := os.Remove(a.chunkFilePath(f.id, chunkID)); err != nil {
+func (t *MemoryTracker) SetChecksum(uploadID int64, checksum string) error {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
 		return err
 	}
-	delete(f.chunks, chunkID)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.info.Checksum = checksum
 	return nil
 }
 
-func (f *activeUpload) countChunks() int64 {
-	return int64(len(f.chunks))
+func (t *MemoryTracker) SetMetadata(uploadID int64, metadata map[string]interface{}) error {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.info.Metadata = metadata
+	return nil
 }
 
-func (f *activeUpload) totalChunks() int64 {
-	return f.info.TotalChunks
+func (t *MemoryTracker) SetTotalChunks(uploadID int64, totalChunks int64) error {
+	f, err := t.getUpload(uploadID)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.info.TotalChunks = totalChunks
+	return nil
 }
 
-func (a *tracker) combineChunks(f *activeUpload) (string, error) {
-	if f.countChunks() < f.totalChunks() {
-		return "", nil
-	}
-	completedFilePath := a.completedFilePath(f.id)
-	finalFile, err := os.Create(completedFilePath)
+func (t *MemoryTracker) SetSize(uploadID int64, size int64) error {
+	f, err := t.getUpload(uploadID)
 	if err != nil {
-		return "", err
-	}
-	defer finalFile.Close()
-	totalChunks := f.totalChunks()
-	for i := int64(0); i < totalChunks; i++ {
-		chunk, err := os.ReadFile(a.chunkFilePath(f.id, i))
-		if err != nil {
-			return "", err
-		}
-		if _, err := finalFile.Write(chunk); err != nil {
-			return "", err
-		}
+		return err
 	}
-	go func() {
-		for i := int64(0); i < totalChunks; i++ {
-			_ = a.deleteChunk(f, i)
-			if len(f.chunks) == 0 {
-				a.uploads.Delete(f.id)
-			}
-		}
-	}()
-	return completedFilePath, nil
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.info.Size = size
+	return nil
 }
 
-func (a *tracker) chunkFilePath(uploadID int64, chunkID int64) string {
-	return path.Join(a.chunkDir, fmt.Sprintf("%d-%d", uploadID, chunkID))
+func (t *MemoryTracker) RemoveUpload(uploadID int64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, exists := t.uploads.LoadAndDelete(uploadID); exists {
+		t.count--
+	}
+	return nil
+}
+
+func (t *MemoryTracker) CountUploads() (int64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.count, nil
 }
 
-func (a *tracker) completedFilePath(uploadID int64) string {
-	return path.Join(a.completedDir, fmt.Sprintf("%d", uploadID))
+func (t *MemoryTracker) StaleUploads(lastActiveBefore time.Time) ([]int64, error) {
+	var stale []int64
+	t.uploads.Range(func(key, value interface{}) bool {
+		f := value.(*memoryUpload)
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.lastActivity.Before(lastActiveBefore) {
+			stale = append(stale, key.(int64))
+		}
+		return true
+	})
+	return stale, nil
 }
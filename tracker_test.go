@@ -0,0 +1,129 @@
+package assemble
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestMemoryTrackerConcurrentReadsAndWrites is meant to be run with -race.
+func TestMemoryTrackerConcurrentReadsAndWrites(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compact=%v", compact), func(t *testing.T) {
+			const totalChunks = 200
+			tracker := &MemoryTracker{CompactChunks: compact}
+			uploadID, err := tracker.CreateUpload(UploadInfo{TotalChunks: totalChunks})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var writers, readers sync.WaitGroup
+			done := make(chan struct{})
+			for i := 0; i < 4; i++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						_, _ = tracker.GetUpload(uploadID)
+						_, _ = tracker.GetProgress(uploadID)
+						_, _ = tracker.ReceivedChunks(uploadID)
+						_, _ = tracker.CountChunks(uploadID)
+						_, _, _ = tracker.GetChunk(uploadID, 5)
+						_, _ = tracker.StaleUploads(time.Now())
+						_, _ = tracker.CountUploads()
+					}
+				}()
+			}
+			for seq := int64(0); seq < totalChunks; seq++ {
+				writers.Add(1)
+				go func(seq int64) {
+					defer writers.Done()
+					if _, err := tracker.AddChunk(uploadID, seq, ChunkInfo{Size: 1, ReceivedAt: time.Now()}); err != nil {
+						t.Error(err)
+					}
+					if seq%50 == 0 {
+						_ = tracker.SetMetadata(uploadID, map[string]interface{}{"seq": seq})
+					}
+				}(seq)
+			}
+			writers.Wait()
+			close(done)
+			readers.Wait()
+
+			progress, err := tracker.GetProgress(uploadID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if progress.Chunks != totalChunks || progress.Bytes != totalChunks {
+				t.Errorf("got progress %+v, want %d chunks and bytes", progress, totalChunks)
+			}
+			claimed, err := tracker.ClaimCompletion(uploadID)
+			if err != nil || !claimed {
+				t.Errorf("completion wasn't claimed: %v", err)
+			}
+		})
+	}
+}
+
+func TestStatusDuringUpload(t *testing.T) {
+	const totalChunks = 50
+	ta := newTestAssembler(t, nil)
+	uploadID := ta.startUpload(fmt.Sprintf(`{"total_chunks": %d}`, totalChunks), nil)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+			rec := httptest.NewRecorder()
+			ta.a.StatusHandler(rec, req)
+			if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+				t.Errorf("status: got %d %s", rec.Code, rec.Body.String())
+			}
+		}
+	}()
+	var senders sync.WaitGroup
+	for seq := int64(0); seq < totalChunks; seq++ {
+		senders.Add(1)
+		go func(seq int64) {
+			defer senders.Done()
+			if rec := ta.send(uploadID, seq, "x", nil); rec.Code != http.StatusOK {
+				t.Errorf("chunk %d: got %d %s", seq, rec.Code, rec.Body.String())
+			}
+		}(seq)
+	}
+	senders.Wait()
+	close(done)
+	wg.Wait()
+
+	files := ta.completedFiles()
+	if len(files) != 1 || len(files[0]) != totalChunks {
+		t.Fatalf("got completed files %q", files)
+	}
+	var status statusResponse
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	req.Header.Set(DefaultUploadIdentifierHeader, strconv.FormatInt(uploadID, 10))
+	rec := httptest.NewRecorder()
+	ta.a.StatusHandler(rec, req)
+	if rec.Code == http.StatusOK {
+		_ = json.Unmarshal(rec.Body.Bytes(), &status)
+		t.Errorf("completed upload is still tracked: %+v", status)
+	}
+}
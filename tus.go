@@ -0,0 +1,379 @@
+package assemble
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,creation-defer-length"
+	tusContentType = "application/offset+octet-stream"
+)
+
+var errOffsetMismatch = errors.New("upload offset doesn't match")
+
+// TusHandler returns a handler for the core tus 1.0.0 protocol with the
+// creation and creation-defer-length extensions, so that tus clients can
+// upload files. It must be mounted at basePath, which is used to build the
+// URL of each upload. Each PATCH request is stored as a chunk, and h
+// receives the completed file like it does from ChunksMiddleware.
+//
+// The "filetype" in Upload-Metadata is used as the "type" of the upload.
+// Uploads created with an Upload-Length of 0 complete right away. Uploads
+// are removed once they complete, so HEAD requests for completed uploads
+// return HTTP 404.
+func (a *FileChunksAssembler) TusHandler(basePath string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if a.isClosed() {
+			a.writeError(w, r, http.StatusServiceUnavailable, errClosed)
+			return
+		}
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", tusExtensions)
+			if a.Config.MaxFileSize > 0 {
+				w.Header().Set("Tus-Max-Size", strconv.FormatInt(a.Config.MaxFileSize, 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, basePath), "/")
+		if id == "" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			a.tusCreate(w, r, h, basePath)
+			return
+		}
+		uploadID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodHead:
+			a.tusHead(w, r, uploadID)
+		case http.MethodPatch:
+			a.tusPatch(w, r, h, uploadID)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func (a *FileChunksAssembler) tusCreate(w http.ResponseWriter, r *http.Request, h http.Handler, basePath string) {
+	var info UploadInfo
+	empty := false
+	if r.Header.Get("Upload-Defer-Length") != "1" {
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size < 0 {
+			a.badRequest(w, r, fmt.Errorf("invalid upload length"))
+			return
+		}
+		info.Size = size
+		empty = size == 0
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	info.Metadata = metadata
+	if maxSize := a.maxFileSize(info); maxSize > 0 && info.Size > maxSize {
+		a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+	if err := a.checkQuota(r, info.Size); err != nil {
+		a.quotaError(w, r, err)
+		return
+	}
+	if err := a.setKeyFingerprint(r, &info); err != nil {
+		a.badRequest(w, r, err)
+		return
+	}
+	uploadID, err := a.startUpload(info)
+	if err != nil {
+		a.startUploadError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", path.Join(basePath, strconv.FormatInt(uploadID, 10)))
+	if empty && !a.tusCompleteEmpty(w, r, h, uploadID, info) {
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusCompleteEmpty completes an upload of an empty file, which is stored as
+// a single empty chunk since uploads without chunks can't be claimed.
+func (a *FileChunksAssembler) tusCompleteEmpty(w http.ResponseWriter, r *http.Request, h http.Handler, uploadID int64, info UploadInfo) bool {
+	unlock := a.lockUpload(uploadID)
+	defer unlock()
+	key, err := a.uploadKey(r)
+	if err != nil {
+		a.badRequest(w, r, err)
+		return false
+	}
+	progress, err := a.storeChunk(r.Context(), uploadID, 0, []byte{}, ChunkInfo{ReceivedAt: a.Config.Clock.Now()}, key)
+	if err != nil {
+		a.internalError(w, r, CodeChunkWriteFailed, "failed to store chunk", err, "upload_id", uploadID, "chunk", 0)
+		return false
+	}
+	r.Header.Del("Upload-Length")
+	r.Header.Del("Upload-Metadata")
+	if !a.tusComplete(w, r, h, uploadID, &info, progress, key) {
+		return false
+	}
+	setTusOffset(w, info, progress)
+	return true
+}
+
+// parseTusMetadata decodes comma-separated pairs of keys and base64-encoded
+// values. Values are optional.
+func parseTusMetadata(header string) (map[string]interface{}, error) {
+	metadata := make(map[string]interface{})
+	if header == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid upload metadata")
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid upload metadata")
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	if _, ok := metadata["type"]; !ok && metadata["filetype"] != nil {
+		metadata["type"] = metadata["filetype"]
+	}
+	return metadata, nil
+}
+
+func (a *FileChunksAssembler) tusHead(w http.ResponseWriter, r *http.Request, uploadID int64) {
+	info, progress, err := a.tusUpload(uploadID)
+	if err != nil {
+		a.tusUploadError(w, r, uploadID, err)
+		return
+	}
+	setTusOffset(w, info, progress)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *FileChunksAssembler) tusPatch(w http.ResponseWriter, r *http.Request, h http.Handler, uploadID int64) {
+	r, span := a.startChunkSpan(r)
+	defer span.End()
+	span.SetAttributes(Attribute{Key: AttrFileID, Value: a.fileID(uploadID)})
+	if r.Header.Get("Content-Type") != tusContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		a.badRequest(w, r, fmt.Errorf("invalid upload offset"))
+		return
+	}
+	unlock := a.lockUpload(uploadID)
+	defer unlock()
+
+	info, progress, err := a.tusUpload(uploadID)
+	if err != nil {
+		if errors.Is(err, ErrUploadNotFound) {
+			a.uploadLocks.Delete(uploadID)
+		}
+		a.tusUploadError(w, r, uploadID, err)
+		return
+	}
+	if a.uploadExpired(info) {
+		a.cleanupUpload(uploadID)
+		a.rejectUpload(uploadID, errUploadExpired.Error())
+		a.writeError(w, r, http.StatusGone, errUploadExpired)
+		return
+	}
+	key, ok := a.checkUploadKey(w, r, info)
+	if !ok {
+		return
+	}
+	if offset != progress.Bytes {
+		a.writeError(w, r, http.StatusConflict, errOffsetMismatch)
+		return
+	}
+	// Each PATCH request is stored as a chunk.
+	if a.Config.MaxChunkTotal > 0 && progress.Chunks >= a.Config.MaxChunkTotal {
+		a.badRequest(w, r, errTooManyChunks)
+		return
+	}
+	if info.Size == 0 && r.Header.Get("Upload-Length") != "" {
+		size, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || size < progress.Bytes || size <= 0 {
+			a.badRequest(w, r, fmt.Errorf("invalid upload length"))
+			return
+		}
+		if maxSize := a.maxFileSize(info); maxSize > 0 && size > maxSize {
+			a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+			return
+		}
+		info.Size = size
+		if err := a.setSize(uploadID, info); err != nil {
+			a.internalError(w, r, CodeUploadUpdateFailed, "failed to set upload size", err, "upload_id", uploadID)
+			return
+		}
+	}
+
+	stop := a.limitBodyRead(w, r)
+	defer stop()
+	// The body can't go past the end of the file.
+	var body io.Reader = r.Body
+	if info.Size > 0 {
+		body = io.LimitReader(r.Body, info.Size-offset+1)
+	}
+	chunkData, readErr := a.readLimited(body)
+	stop()
+	if errors.Is(readErr, errChunkTooLarge) {
+		a.writeError(w, r, http.StatusRequestEntityTooLarge, readErr)
+		return
+	}
+	if info.Size > 0 && offset+int64(len(chunkData)) > info.Size {
+		a.badRequest(w, r, fmt.Errorf("upload exceeds upload length"))
+		return
+	}
+	// Bytes received before the connection was interrupted are kept, so the
+	// client can resume from the new offset.
+	if err := a.checkFreeSpace(len(chunkData)); err != nil {
+		a.freeSpaceError(w, r, uploadID, err)
+		return
+	}
+	if len(chunkData) > 0 {
+		seq := progress.Chunks
+		progress, err = a.storeChunk(r.Context(), uploadID, seq, chunkData, ChunkInfo{
+			Size:       int64(len(chunkData)),
+			ReceivedAt: a.Config.Clock.Now(),
+		}, key)
+		if err != nil {
+			a.internalError(w, r, CodeChunkWriteFailed, "failed to store chunk", err, "upload_id", uploadID, "chunk", seq)
+			return
+		}
+	}
+	if errors.Is(readErr, errChunkReadTimeout) {
+		setTusOffset(w, info, progress)
+		a.writeError(w, r, http.StatusRequestTimeout, readErr)
+		return
+	}
+	if readErr != nil {
+		a.Config.Logger.Info("upload interrupted", "upload_id", uploadID, "offset", progress.Bytes, "error", readErr)
+		return
+	}
+	if maxSize := a.maxFileSize(info); maxSize > 0 && progress.Bytes > maxSize {
+		a.cleanupUpload(uploadID)
+		a.rejectUpload(uploadID, errFileTooLarge.Error())
+		a.writeError(w, r, http.StatusRequestEntityTooLarge, errFileTooLarge)
+		return
+	}
+	if !a.cancelOverQuota(w, r, uploadID, progress.Bytes) {
+		return
+	}
+	if info.Size > 0 && progress.Bytes == info.Size && !a.tusComplete(w, r, h, uploadID, &info, progress, key) {
+		return
+	}
+	setTusOffset(w, info, progress)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusComplete combines the chunks once the whole file has been received.
+// The number of chunks is only known at this point, and is set in info. It
+// returns false if it responded with an error.
+func (a *FileChunksAssembler) tusComplete(w http.ResponseWriter, r *http.Request, h http.Handler, uploadID int64, info *UploadInfo, progress Progress, key []byte) bool {
+	info.TotalChunks = progress.Chunks
+	if err := a.setTotalChunks(uploadID, *info); err != nil {
+		a.internalError(w, r, CodeUploadUpdateFailed, "failed to set total chunks", err, "upload_id", uploadID)
+		return false
+	}
+	completed, err := a.claimCompletion(uploadID)
+	if err != nil {
+		a.internalError(w, r, CodeCompletionClaimFailed, "failed to claim completion", err, "upload_id", uploadID)
+		return false
+	}
+	if completed {
+		r.Header.Del("Upload-Offset")
+		r.Header.Del("Upload-Length")
+		r.Header.Del("Tus-Resumable")
+		result, err := a.completeUpload(r, h, uploadID, false, key)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				a.Config.Logger.Info("upload cancelled while combining chunks", "upload_id", uploadID)
+				return false
+			}
+			a.internalError(w, r, CodeCombineFailed, "failed to complete upload", err, "upload_id", uploadID)
+			return false
+		}
+		if result.rejectedCode != 0 {
+			a.writeError(w, r, result.rejectedCode, errors.New(result.rejectedError))
+			return false
+		}
+	}
+	return true
+}
+
+func (a *FileChunksAssembler) setSize(uploadID int64, info UploadInfo) error {
+	if err := a.Config.Tracker.SetSize(uploadID, info.Size); err != nil {
+		return err
+	}
+	if recoverable, ok := a.Config.Store.(RecoverableStore); ok {
+		return recoverable.SaveUploadInfo(a.fileID(uploadID), info)
+	}
+	return nil
+}
+
+// tusUpload returns the info and progress of an upload.
+func (a *FileChunksAssembler) tusUpload(uploadID int64) (UploadInfo, Progress, error) {
+	info, err := a.Config.Tracker.GetUpload(uploadID)
+	if err != nil {
+		return UploadInfo{}, Progress{}, err
+	}
+	progress, err := a.Config.Tracker.GetProgress(uploadID)
+	if err != nil {
+		return UploadInfo{}, Progress{}, err
+	}
+	return info, progress, nil
+}
+
+func (a *FileChunksAssembler) tusUploadError(w http.ResponseWriter, r *http.Request, uploadID int64, err error) {
+	if errors.Is(err, ErrUploadNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	a.internalError(w, r, CodeUploadLookupFailed, "failed to get upload", err, "upload_id", uploadID)
+}
+
+// setTusOffset sets the offset and length headers. The length of an upload
+// is known once it has a size, or once it has completed, since an empty
+// file has neither a size nor chunks until then.
+func setTusOffset(w http.ResponseWriter, info UploadInfo, progress Progress) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(progress.Bytes, 10))
+	if info.Size > 0 || info.TotalChunks > 0 {
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	} else {
+		w.Header().Set("Upload-Defer-Length", "1")
+	}
+}
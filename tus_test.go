@@ -0,0 +1,145 @@
+package assemble
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTusUpload(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filetype dGV4dC9wbGFpbg==",
+	})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+
+	rec = ta.tus(http.MethodPatch, location, "hello ", map[string]string{"Upload-Offset": "0"})
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("first patch: got %d, offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	rec = ta.tus(http.MethodHead, location, "", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "6" || rec.Header().Get("Upload-Length") != "11" {
+		t.Errorf("head: got %d, offset %s, length %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Header().Get("Upload-Length"))
+	}
+	if rec := ta.tus(http.MethodPatch, location, "world", map[string]string{"Upload-Offset": "5"}); rec.Code != http.StatusConflict {
+		t.Errorf("patch at the wrong offset: got %d", rec.Code)
+	}
+	rec = ta.tus(http.MethodPatch, location, "world", map[string]string{"Upload-Offset": "6"})
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("last patch: got %d, offset %s", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello world" {
+		t.Errorf("got completed files %q", files)
+	}
+	if rec := ta.tus(http.MethodHead, location, "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("head of a completed upload: got %d", rec.Code)
+	}
+}
+
+func TestTusEmptyUpload(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "0"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Location") == "" || rec.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("got location %q, offset %q", rec.Header().Get("Location"), rec.Header().Get("Upload-Offset"))
+	}
+	// The length isn't deferred just because it's 0.
+	if rec.Header().Get("Upload-Length") != "0" || rec.Header().Get("Upload-Defer-Length") != "" {
+		t.Errorf("got length %q, deferred length %q", rec.Header().Get("Upload-Length"), rec.Header().Get("Upload-Defer-Length"))
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "" {
+		t.Errorf("got completed files %q", files)
+	}
+	if rec := ta.tus(http.MethodHead, rec.Header().Get("Location"), "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("head of a completed upload: got %d", rec.Code)
+	}
+}
+
+func TestTusInvalidLength(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	for _, length := range []string{"", "-1", "abc"} {
+		if rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": length}); rec.Code != http.StatusBadRequest {
+			t.Errorf("length %q: got %d", length, rec.Code)
+		}
+	}
+}
+
+func TestTusOptionsAndVersion(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{MaxFileSize: 100})
+	rec := ta.tus(http.MethodOptions, "/files/", "", nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Version") != tusVersion ||
+		rec.Header().Get("Tus-Extension") != tusExtensions || rec.Header().Get("Tus-Max-Size") != "100" {
+		t.Errorf("options: got %d %v", rec.Code, rec.Header())
+	}
+	rec = ta.tus(http.MethodPost, "/files/", "", map[string]string{"Tus-Resumable": "0.2.0", "Upload-Length": "1"})
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("unsupported version: got %d", rec.Code)
+	}
+	if rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "101"}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over MaxFileSize: got %d", rec.Code)
+	}
+	if rec := ta.tus(http.MethodHead, "/files/abc", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("invalid upload ID: got %d", rec.Code)
+	}
+}
+
+func TestTusDeferredLength(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Defer-Length": "1"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	if rec := ta.tus(http.MethodPatch, location, "abc", map[string]string{"Upload-Offset": "0"}); rec.Code != http.StatusNoContent {
+		t.Fatalf("first patch: got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = ta.tus(http.MethodHead, location, "", nil)
+	if rec.Header().Get("Upload-Defer-Length") != "1" || rec.Header().Get("Upload-Length") != "" {
+		t.Errorf("got deferred length %q, length %q", rec.Header().Get("Upload-Defer-Length"), rec.Header().Get("Upload-Length"))
+	}
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Fatalf("upload of unknown length completed: %q", files)
+	}
+	// The length can't be less than what was already received.
+	rec = ta.tus(http.MethodPatch, location, "de", map[string]string{"Upload-Offset": "3", "Upload-Length": "2"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("length before the offset: got %d", rec.Code)
+	}
+	rec = ta.tus(http.MethodPatch, location, "de", map[string]string{"Upload-Offset": "3", "Upload-Length": "5"})
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "5" {
+		t.Fatalf("last patch: got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "abcde" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestTusPatchErrors(t *testing.T) {
+	ta := newTestAssembler(t, nil)
+	rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "3"})
+	location := rec.Header().Get("Location")
+
+	rec = ta.tus(http.MethodPatch, location, "a", map[string]string{"Upload-Offset": "0", "Content-Type": "text/plain"})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %d", rec.Code)
+	}
+	if rec := ta.tus(http.MethodPatch, location, "abcd", map[string]string{"Upload-Offset": "0"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("patch past the length: got %d", rec.Code)
+	}
+	if rec := ta.tus(http.MethodPatch, location, "a", map[string]string{"Upload-Offset": "-1"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative offset: got %d", rec.Code)
+	}
+	if rec := ta.tus(http.MethodPatch, "/files/12345", "a", map[string]string{"Upload-Offset": "0"}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: got %d", rec.Code)
+	}
+	// Nothing was stored by the rejected patches.
+	rec = ta.tus(http.MethodHead, location, "", nil)
+	if rec.Header().Get("Upload-Offset") != "0" {
+		t.Errorf("got offset %s after rejected patches", rec.Header().Get("Upload-Offset"))
+	}
+}
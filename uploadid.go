@@ -0,0 +1,82 @@
+package assemble
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Attempts at generating an unused upload ID before giving up.
+const maxUploadIDAttempts = 10
+
+var errUploadIDsExhausted = errors.New("failed to generate an unused upload ID")
+
+// UploadIDGenerator returns the ID of a new upload. IDs must not be
+// negative.
+type UploadIDGenerator func() (int64, error)
+
+// RandomUploadID is an UploadIDGenerator that returns random IDs, so that
+// clients can't guess the IDs of other uploads.
+func RandomUploadID() (int64, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(b[:]) >> 1), nil
+}
+
+// newUploadID creates an upload with an ID from UploadIDGenerator, trying
+// again if the ID is already in use or its file ID is rejected.
+func (a *FileChunksAssembler) newUploadID(info UploadInfo) (int64, error) {
+	if a.Config.UploadIDGenerator == nil {
+		uploadID, err := a.Config.Tracker.CreateUpload(info)
+		if err != nil {
+			return 0, err
+		}
+		if err := a.validateFileID(uploadID); err != nil {
+			return 0, err
+		}
+		return uploadID, nil
+	}
+	var rejected error
+	for i := 0; i < maxUploadIDAttempts; i++ {
+		uploadID, err := a.Config.UploadIDGenerator()
+		if err != nil {
+			return 0, fmt.Errorf("generating upload ID: %w", err)
+		}
+		if uploadID < 0 {
+			return 0, fmt.Errorf("generated upload ID %d is negative", uploadID)
+		}
+		err = a.Config.Tracker.CreateUploadWithID(uploadID, info)
+		if errors.Is(err, ErrUploadExists) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if err := a.validateFileID(uploadID); err != nil {
+			rejected = err
+			continue
+		}
+		return uploadID, nil
+	}
+	if rejected != nil {
+		return 0, fmt.Errorf("%w: %v", errUploadIDsExhausted, rejected)
+	}
+	return 0, errUploadIDsExhausted
+}
+
+// validateFileID checks the file ID of a new upload with FileIDValidator,
+// and stops tracking the upload if it's rejected.
+func (a *FileChunksAssembler) validateFileID(uploadID int64) error {
+	if a.Config.FileIDValidator == nil {
+		return nil
+	}
+	fileID := a.fileID(uploadID)
+	if err := a.Config.FileIDValidator(fileID); err != nil {
+		_ = a.Config.Tracker.RemoveUpload(uploadID)
+		return fmt.Errorf("file ID %q: %w", fileID, err)
+	}
+	return nil
+}
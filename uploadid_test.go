@@ -0,0 +1,85 @@
+package assemble
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestRandomUploadID(t *testing.T) {
+	seen := make(map[int64]bool)
+	for i := 0; i < 10000; i++ {
+		uploadID, err := RandomUploadID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uploadID < 0 {
+			t.Fatalf("got negative upload ID %d", uploadID)
+		}
+		if seen[uploadID] {
+			t.Fatalf("got upload ID %d twice", uploadID)
+		}
+		seen[uploadID] = true
+	}
+}
+
+func TestRandomUploadIDs(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{UploadIDGenerator: RandomUploadID})
+	seen := make(map[int64]bool)
+	for i := 0; i < 100; i++ {
+		uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+		if seen[uploadID] {
+			t.Fatalf("got upload ID %d twice", uploadID)
+		}
+		seen[uploadID] = true
+	}
+	// The IDs work for sending chunks.
+	for uploadID := range seen {
+		ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	}
+	if files := ta.completedFiles(); len(files) != 100 {
+		t.Errorf("got %d completed files, want 100", len(files))
+	}
+}
+
+func TestUploadIDGeneratorCollisions(t *testing.T) {
+	ids := []int64{5, 5, 5, 7}
+	ta := newTestAssembler(t, &AssemblerConfig{
+		UploadIDGenerator: func() (int64, error) {
+			uploadID := ids[0]
+			ids = ids[1:]
+			return uploadID, nil
+		},
+	})
+	if uploadID := ta.startUpload(`{"total_chunks": 1}`, nil); uploadID != 5 {
+		t.Errorf("got upload ID %d, want 5", uploadID)
+	}
+	// IDs in use are replaced.
+	if uploadID := ta.startUpload(`{"total_chunks": 1}`, nil); uploadID != 7 {
+		t.Errorf("got upload ID %d, want 7", uploadID)
+	}
+}
+
+func TestUploadIDGeneratorErrors(t *testing.T) {
+	for name, generator := range map[string]UploadIDGenerator{
+		"always in use": func() (int64, error) { return 1, nil },
+		"negative":      func() (int64, error) { return -1, nil },
+		"failing":       func() (int64, error) { return 0, errors.New("no entropy") },
+	} {
+		ta := newTestAssembler(t, &AssemblerConfig{UploadIDGenerator: generator})
+		if name == "always in use" {
+			ta.startUpload(`{"total_chunks": 1}`, nil)
+		}
+		if rec := ta.start(`{"total_chunks": 1}`, nil); rec.Code != http.StatusInternalServerError {
+			t.Errorf("%s: got %d %s, want %d", name, rec.Code, rec.Body.String(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func TestUploadIDsExhausted(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{UploadIDGenerator: func() (int64, error) { return 1, nil }})
+	ta.startUpload(`{"total_chunks": 1}`, nil)
+	if _, err := ta.a.newUploadID(UploadInfo{TotalChunks: 1}); !errors.Is(err, errUploadIDsExhausted) {
+		t.Errorf("got error %v, want %v", err, errUploadIDsExhausted)
+	}
+}
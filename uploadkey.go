@@ -0,0 +1,72 @@
+package assemble
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+var (
+	errMissingUploadKey       = errors.New("missing upload encryption key")
+	errInvalidUploadKey       = errors.New("upload encryption key must be 32 bytes encoded as base64")
+	errUploadKeyMismatch      = errors.New("upload encryption key doesn't match the key the upload was started with")
+	errUploadKeysUnsupported  = errors.New("UploadKeyHeader needs a store that implements KeyedStore")
+	errUploadKeyWithMasterKey = errors.New("EncryptionKey and UploadKeyHeader can't both be set")
+)
+
+// uploadKey returns the encryption key sent in UploadKeyHeader, or nil if
+// UploadKeyHeader isn't set.
+func (a *FileChunksAssembler) uploadKey(r *http.Request) ([]byte, error) {
+	if a.Config.UploadKeyHeader == "" {
+		return nil, nil
+	}
+	encoded := r.Header.Get(a.Config.UploadKeyHeader)
+	if encoded == "" {
+		return nil, errMissingUploadKey
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errInvalidUploadKey
+	}
+	return key, nil
+}
+
+func keyFingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// setKeyFingerprint records the fingerprint of the key that an upload is
+// started with, which must then be sent with each of its chunks. Any
+// fingerprint sent by the client is replaced.
+func (a *FileChunksAssembler) setKeyFingerprint(r *http.Request, info *UploadInfo) error {
+	info.KeyFingerprint = ""
+	key, err := a.uploadKey(r)
+	if err != nil || key == nil {
+		return err
+	}
+	info.KeyFingerprint = keyFingerprint(key)
+	return nil
+}
+
+// checkUploadKey returns the key sent with a chunk of an upload. If it's
+// missing or isn't the key the upload was started with, only the chunk is
+// rejected: upload IDs can be guessed, so a request without the key must not
+// be able to remove the upload.
+func (a *FileChunksAssembler) checkUploadKey(w http.ResponseWriter, r *http.Request, info UploadInfo) ([]byte, bool) {
+	key, err := a.uploadKey(r)
+	if err == nil && key != nil && keyFingerprint(key) != info.KeyFingerprint {
+		err = errUploadKeyMismatch
+	}
+	if err != nil {
+		if errors.Is(err, errUploadKeyMismatch) {
+			a.writeError(w, r, http.StatusForbidden, err)
+		} else {
+			a.badRequest(w, r, err)
+		}
+		return nil, false
+	}
+	return key, true
+}
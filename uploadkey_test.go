@@ -0,0 +1,174 @@
+package assemble
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadKeyRejectsOnlyTheChunk(t *testing.T) {
+	const keyHeader = "x-upload-key"
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	otherKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	ta := newTestAssembler(t, &AssemblerConfig{UploadKeyHeader: keyHeader})
+
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, map[string]string{keyHeader: key})
+	ta.mustSend(uploadID, 0, "hello ", map[string]string{keyHeader: key}, http.StatusOK)
+
+	for _, test := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{"not base64", http.StatusBadRequest},
+		{otherKey, http.StatusForbidden},
+	} {
+		header := map[string]string{}
+		if test.key != "" {
+			header[keyHeader] = test.key
+		}
+		rec := ta.send(uploadID, 1, "world", header)
+		if rec.Code != test.status {
+			t.Errorf("key %q: got %d, want %d", test.key, rec.Code, test.status)
+		}
+	}
+
+	// The upload isn't affected by the rejected chunks.
+	progress := ta.mustSend(uploadID, 1, "world", map[string]string{keyHeader: key}, http.StatusOK)
+	if progress.CurrentChunks != 2 {
+		t.Errorf("got %d chunks, want 2", progress.CurrentChunks)
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello world" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestUploadKeyCompletedUpload(t *testing.T) {
+	const keyHeader = "x-upload-key"
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	otherKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	ta := newTestAssembler(t, &AssemblerConfig{
+		UploadKeyHeader:    keyHeader,
+		CompletedUploadTTL: time.Hour,
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, map[string]string{keyHeader: key})
+	ta.mustSend(uploadID, 0, "secret", map[string]string{keyHeader: key}, http.StatusOK)
+
+	// The completion is only sent again to requests with the upload's key.
+	for _, test := range []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusBadRequest},
+		{otherKey, http.StatusForbidden},
+	} {
+		header := map[string]string{}
+		if test.key != "" {
+			header[keyHeader] = test.key
+		}
+		rec := ta.send(uploadID, 0, "secret", header)
+		if rec.Code != test.status {
+			t.Errorf("key %q: got %d, want %d", test.key, rec.Code, test.status)
+		}
+		if rec.Header().Get(CompleteHeader) != "" {
+			t.Errorf("key %q: got the completion of the upload", test.key)
+		}
+	}
+	rec := ta.send(uploadID, 0, "secret", map[string]string{keyHeader: key})
+	if rec.Code != http.StatusOK || rec.Header().Get(CompleteHeader) != "true" {
+		t.Errorf("the upload's key didn't get its completion: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadKeyTus(t *testing.T) {
+	const keyHeader = "x-upload-key"
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	otherKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("o", 32)))
+	ta := newTestAssembler(t, &AssemblerConfig{UploadKeyHeader: keyHeader})
+
+	rec := ta.tus(http.MethodPost, "/files/", "", map[string]string{"Upload-Length": "5", keyHeader: key})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("creating upload: got %d %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+
+	rec = ta.tus(http.MethodPatch, location, "hello", map[string]string{"Upload-Offset": "0", keyHeader: otherKey})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("wrong key: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = ta.tus(http.MethodPatch, location, "hello", map[string]string{"Upload-Offset": "0", keyHeader: key})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("right key: got %d %s", rec.Code, rec.Body.String())
+	}
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "hello" {
+		t.Errorf("got completed files %q", files)
+	}
+}
+
+func TestUploadKeyEncryptsChunks(t *testing.T) {
+	const keyHeader = "x-upload-key"
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	config := &AssemblerConfig{UploadKeyHeader: keyHeader}
+	ta := newTestAssembler(t, config)
+
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, map[string]string{keyHeader: key})
+	ta.mustSend(uploadID, 0, "plaintext", map[string]string{keyHeader: key}, http.StatusOK)
+
+	stored, err := readDirFiles(config.ChunksDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) == 0 {
+		t.Fatal("no chunk was stored")
+	}
+	for name, data := range stored {
+		if strings.Contains(data, "plaintext") {
+			t.Errorf("chunk %s is stored unencrypted", name)
+		}
+	}
+}
+
+func TestUploadKeyRequiredToStart(t *testing.T) {
+	const keyHeader = "x-upload-key"
+	ta := newTestAssembler(t, &AssemblerConfig{UploadKeyHeader: keyHeader})
+	rec := ta.start(`{"total_chunks": 1}`, nil)
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != errMissingUploadKey.Error() {
+		t.Errorf("starting without a key: got %d %s", rec.Code, rec.Body.String())
+	}
+	short := base64.StdEncoding.EncodeToString([]byte("short"))
+	rec = ta.start(`{"total_chunks": 1}`, map[string]string{keyHeader: short})
+	if rec.Code != http.StatusBadRequest || errorBody(t, rec) != errInvalidUploadKey.Error() {
+		t.Errorf("starting with a short key: got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUploadKeyConfig(t *testing.T) {
+	for name, test := range map[string]struct {
+		config *AssemblerConfig
+		err    error
+	}{
+		"with EncryptionKey": {
+			&AssemblerConfig{UploadKeyHeader: "x-upload-key", EncryptionKey: make([]byte, 32)},
+			errUploadKeyWithMasterKey,
+		},
+		"with a store without keys": {
+			&AssemblerConfig{UploadKeyHeader: "x-upload-key", Store: NewMemoryStore()},
+			errUploadKeysUnsupported,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if got := recover(); got != test.err {
+					t.Errorf("got panic %v, want %v", got, test.err)
+				}
+			}()
+			if test.config.Store == nil {
+				test.config.ChunksDir = t.TempDir()
+				test.config.CompletedDir = t.TempDir()
+			}
+			NewFileChunksAssembler(test.config)
+		})
+	}
+}
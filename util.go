@@ -3,45 +3,205 @@ package assemble
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"os"
+	"sync"
 )
 
-type progressResponse struct {
+// ProgressInfo is the progress update sent in response to a chunk.
+type ProgressInfo struct {
+	// HTTP status of the response.
+	Status int `json:"-"`
+
+	// Whether the chunk completed the upload, which is sent in
+	// CompleteHeader rather than the body.
+	Complete bool `json:"-"`
+
 	CurrentChunks  int64   `json:"have"`
 	ExpectedChunks int64   `json:"want"`
+	ReceivedBytes  int64   `json:"received_bytes"`
+	ExpectedBytes  int64   `json:"expected_bytes,omitempty"`
 	RejectedError  *string `json:"error,omitempty"`
+	FileHash       string  `json:"hash,omitempty"`
+	Location       string  `json:"location,omitempty"`
+	MissingChunks  []int64 `json:"missing,omitempty"`
+
+	// Whether MissingChunks was cut off at MaxMissingChunks.
+	MissingTruncated bool `json:"missing_truncated,omitempty"`
+
+	// Response of the downstream handler with ChunksMiddlewareWithResponse.
+	Result json.RawMessage `json:"result,omitempty"`
+
+	// ProgressFieldNames, applied when the progress is encoded as JSON.
+	fieldNames map[string]string
+}
+
+// EncodeProgressJSON is the default ResponseEncoder, which writes progress
+// updates as JSON.
+func EncodeProgressJSON(w http.ResponseWriter, progress ProgressInfo) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(progress.Status)
+	_ = json.NewEncoder(w).Encode(progress)
+}
+
+// startResponse tells the client the ID of its upload and how to send its
+// chunks.
+type startResponse struct {
+	ID             int64  `json:"id"`
+	TotalChunks    int64  `json:"total_chunks,omitempty"`
+	FirstChunk     int    `json:"first_chunk"`
+	MaxChunkSize   int64  `json:"max_chunk_size,omitempty"`
+	UploadIDHeader string `json:"upload_id_header"`
+	ChunkIDHeader  string `json:"chunk_id_header"`
 }
+
+type statusResponse struct {
+	ReceivedChunks []int64 `json:"received"`
+	ExpectedChunks int64   `json:"want"`
+	Complete       bool    `json:"complete"`
+}
+
 type errorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
+}
+
+type chunkQuantityResponse struct {
+	Error          string `json:"error"`
+	ExpectedChunks int64  `json:"expected_chunks"`
+	DeclaredChunks int64  `json:"declared_chunks"`
 }
 
-func badRequest(w http.ResponseWriter, err error) {
+// WriteErrorJSON is the default ErrorHandler, which writes the error's
+// message as JSON. Internal errors also include their code, and errors
+// matching ErrChunkQuantityChange include the conflicting numbers of
+// chunks.
+func WriteErrorJSON(w http.ResponseWriter, r *http.Request, status int, err error) {
 	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(status)
+	var quantityErr *chunkQuantityError
+	if errors.As(err, &quantityErr) {
+		_ = json.NewEncoder(w).Encode(chunkQuantityResponse{
+			Error:          quantityErr.Error(),
+			ExpectedChunks: quantityErr.expected,
+			DeclaredChunks: quantityErr.declared,
+		})
+		return
+	}
+	var internalErr *InternalError
+	if errors.As(err, &internalErr) {
+		_ = json.NewEncoder(w).Encode(errorResponse{
+			Error: internalErr.Message,
+			Code:  internalErr.Code,
+		})
+		return
+	}
 	_ = json.NewEncoder(w).Encode(errorResponse{
 		Error: err.Error(),
 	})
 }
 
-type contextKey string
+func (a *FileChunksAssembler) badRequest(w http.ResponseWriter, r *http.Request, err error) {
+	a.writeError(w, r, http.StatusBadRequest, err)
+}
+
+func (a *FileChunksAssembler) writeError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	a.Config.ErrorHandler(w, r, status, err)
+}
+
+// contextKey is unexported so that the keys can't collide with keys set by
+// other packages, even ones with the same underlying value.
+type contextKey int
+
+const (
+	metadataKey contextKey = iota
+	fileIDKey
+	rejectionKey
+)
+
+type rejection struct {
+	status int
+	reason string
+}
+
+// rejectionSlot is put in the context of the request passed downstream.
+// Requests derived from it share the pointer, so a rejection through any of
+// them is seen by the assembler.
+type rejectionSlot struct {
+	lock      sync.Mutex
+	rejection *rejection
+}
+
+func (s *rejectionSlot) set(rejected rejection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rejection = &rejected
+}
 
+func (s *rejectionSlot) get() (rejection, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.rejection == nil {
+		return rejection{}, false
+	}
+	return *s.rejection, true
+}
+
+// GetFileMetadata returns the metadata of the completed file passed to a
+// downstream handler, or nil for other requests.
 func GetFileMetadata(r *http.Request) map[string]interface{} {
-	m := r.Context().Value(contextKey("metadata"))
-	return m.(map[string]interface{})
+	m, _ := r.Context().Value(metadataKey).(map[string]interface{})
+	return m
+}
+
+// GetFileID returns the file ID of the completed file passed to a
+// downstream handler, and false for other requests.
+func GetFileID(r *http.Request) (string, bool) {
+	fileID, ok := r.Context().Value(fileIDKey).(string)
+	return fileID, ok
 }
 
+// DefaultRejectionStatus is used for files rejected with
+// RejectFileWithError, or with a status that isn't an error.
+const DefaultRejectionStatus = http.StatusUnprocessableEntity
+
+// RejectFile is called by a downstream handler to reject the completed
+// file with the given status and reason, which are sent to the client.
+// Statuses other than 4xx and 5xx are replaced with DefaultRejectionStatus,
+// so that clients don't mistake a rejection for success.
+//
+// r can be the request passed to the handler or any request whose context
+// is derived from its context, e.g. by r.WithContext or r.Clone in nested
+// handlers, and it can be called from other goroutines. It must be called
+// before the handler returns.
 func RejectFile(r *http.Request, status int, reason string) {
-	ctx := r.Context()
-	ctx = context.WithValue(ctx, contextKey("error-code"), status)
-	ctx = context.WithValue(ctx, contextKey("error-message"), reason)
-	*r = *r.WithContext(ctx)
+	if status < 400 || status > 599 {
+		status = DefaultRejectionStatus
+	}
+	rejected := rejection{status: status, reason: reason}
+	if slot, ok := r.Context().Value(rejectionKey).(*rejectionSlot); ok {
+		slot.set(rejected)
+		return
+	}
+	// Outside the assembler, only this request knows about the rejection.
+	slot := &rejectionSlot{}
+	slot.set(rejected)
+	*r = *r.WithContext(context.WithValue(r.Context(), rejectionKey, slot))
+}
+
+// RejectFileWithError rejects the completed file with
+// DefaultRejectionStatus and the message of err.
+func RejectFileWithError(r *http.Request, err error) {
+	RejectFile(r, DefaultRejectionStatus, err.Error())
 }
 
-func getFileSize(path string) (int64, error) {
-	f, err := os.Stat(path)
-	if err != nil {
-		return 0, err
+// GetRejection returns the status and reason given to RejectFile for a
+// request, and false if it hasn't been rejected.
+func GetRejection(r *http.Request) (int, string, bool) {
+	slot, ok := r.Context().Value(rejectionKey).(*rejectionSlot)
+	if !ok {
+		return 0, "", false
 	}
-	return f.Size(), nil
+	rejected, ok := slot.get()
+	return rejected.status, rejected.reason, ok
 }
@@ -0,0 +1,63 @@
+package assemble
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var errValidateOnly = errors.New("chunks aren't stored in validation-only mode")
+
+// discardStore is the store of an assembler with ValidateOnly set, which
+// accepts chunks without storing them.
+type discardStore struct{}
+
+func (discardStore) WriteChunk(fileID string, seq int64, data []byte) error {
+	return nil
+}
+
+func (discardStore) ReadChunk(fileID string, seq int64) (io.ReadCloser, error) {
+	return nil, os.ErrNotExist
+}
+
+func (discardStore) DeleteChunk(fileID string, seq int64) error {
+	return nil
+}
+
+func (discardStore) Finalize(ctx context.Context, fileID string, name string, totalChunks int64, digest io.Writer) (string, error) {
+	return "", errValidateOnly
+}
+
+func (discardStore) FinalizeSequences(ctx context.Context, fileID string, name string, seqs []int64, digest io.Writer) (string, error) {
+	return "", errValidateOnly
+}
+
+func (discardStore) DeleteCompletedBefore(before time.Time) (int, error) {
+	return 0, nil
+}
+
+func (discardStore) WriteChunkWithKey(fileID string, seq int64, data []byte, key []byte) error {
+	return nil
+}
+
+func (discardStore) FinalizeWithKey(ctx context.Context, fileID string, name string, seqs []int64, key []byte, digest io.Writer) (string, error) {
+	return "", errValidateOnly
+}
+
+func (discardStore) OpenCompleted(name string) (io.ReadCloser, int64, error) {
+	return nil, 0, ErrCompletedFileNotFound
+}
+
+func (discardStore) DeleteCompleted(name string) error {
+	return nil
+}
+
+// completeValidation finishes an upload in validation-only mode, where
+// there are no chunks to combine or file to pass downstream.
+func (a *FileChunksAssembler) completeValidation(uploadID int64) (uploadResult, error) {
+	a.Config.Logger.Info("upload validated", "upload_id", uploadID)
+	a.finishUpload(uploadID)
+	return uploadResult{}, nil
+}
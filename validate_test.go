@@ -0,0 +1,54 @@
+package assemble
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateOnly(t *testing.T) {
+	dir := t.TempDir()
+	ta := newTestAssembler(t, &AssemblerConfig{
+		ChunksDir:    filepath.Join(dir, "chunks"),
+		CompletedDir: filepath.Join(dir, "completed"),
+		ValidateOnly: true,
+		MaxChunkSize: 4,
+	})
+	uploadID := ta.startUpload(`{"total_chunks": 2}`, nil)
+
+	// Chunks are still validated.
+	if rec := ta.send(uploadID, 2, "a", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("out of range chunk: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusBadRequest)
+	}
+	if rec := ta.send(uploadID, 0, "too large", nil); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large chunk: got %d %s, want %d", rec.Code, rec.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+
+	if progress := ta.mustSend(uploadID, 0, "abc", nil, http.StatusOK); progress.CurrentChunks != 1 || progress.ReceivedBytes != 3 {
+		t.Errorf("got progress %+v after the first chunk", progress)
+	}
+	progress := ta.mustSend(uploadID, 1, "de", nil, http.StatusOK)
+	if progress.CurrentChunks != 2 || progress.ExpectedChunks != 2 || progress.ReceivedBytes != 5 {
+		t.Errorf("got progress %+v after the last chunk", progress)
+	}
+
+	if files := ta.completedFiles(); len(files) != 0 {
+		t.Errorf("got files passed downstream %q", files)
+	}
+	// Nothing is written, not even the directories.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in the assembler's directory, want none", len(entries))
+	}
+	count, err := ta.a.Config.Tracker.CountUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d uploads are still tracked", count)
+	}
+}
@@ -0,0 +1,77 @@
+package assemble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+
+	webhookAttempts = 5
+)
+
+// Delay before the first retry of a webhook, which doubles with each retry.
+var webhookInitialBackoff = time.Second
+
+// completionWebhook is the payload sent to CompletionWebhookURL.
+type completionWebhook struct {
+	FileID      string `json:"file_id"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	Hash        string `json:"hash,omitempty"`
+}
+
+// notifyCompletion posts the payload to CompletionWebhookURL, retrying with
+// exponential backoff until ctx is done. It is meant to run in the
+// background, so failures are only logged.
+func (a *FileChunksAssembler) notifyCompletion(ctx context.Context, payload completionWebhook) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		a.Config.Logger.Error("failed to encode webhook", "upload_id", payload.FileID, "error", err)
+		return
+	}
+	backoff := webhookInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = a.postWebhook(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			a.Config.Logger.Error("webhook cancelled", "upload_id", payload.FileID, "url", a.Config.CompletionWebhookURL, "error", err)
+			return
+		}
+		backoff *= 2
+	}
+	a.Config.Logger.Error("failed to send webhook", "upload_id", payload.FileID, "url", a.Config.CompletionWebhookURL, "error", err)
+}
+
+func (a *FileChunksAssembler) postWebhook(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, a.Config.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Config.CompletionWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.Config.WebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package assemble
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCompletionWebhook(t *testing.T) {
+	defer func(backoff time.Duration) { webhookInitialBackoff = backoff }(webhookInitialBackoff)
+	webhookInitialBackoff = time.Millisecond
+
+	var attempts int32
+	received := make(chan completionWebhook, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, so the payload is retried.
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload completionWebhook
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	ta := newTestAssembler(t, &AssemblerConfig{CompletionWebhookURL: server.URL})
+	uploadID := ta.startUpload(`{"total_chunks": 1, "metadata": {"type": "text/plain"}}`, nil)
+	ta.mustSend(uploadID, 0, "hello", nil, http.StatusOK)
+
+	select {
+	case payload := <-received:
+		if payload.FileID != ta.a.fileID(uploadID) || payload.Size != 5 || payload.ContentType != "text/plain" {
+			t.Errorf("got payload %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook wasn't received")
+	}
+}
+
+func TestCloseStopsWebhookRetries(t *testing.T) {
+	defer func(backoff time.Duration) { webhookInitialBackoff = backoff }(webhookInitialBackoff)
+	webhookInitialBackoff = time.Hour
+
+	attempted := make(chan struct{}, webhookAttempts)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempted <- struct{}{}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ta := newTestAssembler(t, &AssemblerConfig{CompletionWebhookURL: server.URL})
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "hello", nil, http.StatusOK)
+	<-attempted
+
+	// Close waits for the webhook, which would otherwise wait an hour
+	// before retrying.
+	closed := make(chan error)
+	go func() { closed <- ta.a.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close didn't stop the webhook")
+	}
+	if n := len(attempted); n != 0 {
+		t.Errorf("webhook was retried %d times after Close", n)
+	}
+}
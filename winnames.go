@@ -0,0 +1,46 @@
+package assemble
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+)
+
+var errReservedFileName = errors.New("file name isn't allowed on Windows")
+
+// Device names that Windows reserves in every directory, with or without
+// an extension.
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// strictFileNames returns whether names must also be valid on Windows.
+func (s *FilesystemStore) strictFileNames() bool {
+	return s.StrictFileNames || runtime.GOOS == "windows"
+}
+
+// checkPortableName rejects slash-separated names with an element that
+// Windows can't use: reserved device names, names ending with a dot or
+// space, and names with characters that Windows doesn't allow.
+func checkPortableName(name string) error {
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasSuffix(element, ".") || strings.HasSuffix(element, " ") {
+			return errReservedFileName
+		}
+		if strings.ContainsAny(element, `<>:"|?*`) {
+			return errReservedFileName
+		}
+		base := element
+		if i := strings.IndexByte(base, '.'); i != -1 {
+			base = base[:i]
+		}
+		if reservedFileNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+			return errReservedFileName
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package assemble
+
+import (
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestCheckPortableName(t *testing.T) {
+	for name := range reservedFileNames {
+		for _, variant := range []string{name, name + ".txt", name + ".tar.gz", name + " ", "dir/" + name} {
+			if err := checkPortableName(variant); err != errReservedFileName {
+				t.Errorf("%q: got %v, want %v", variant, err, errReservedFileName)
+			}
+		}
+	}
+	for _, name := range []string{"nul", "Con.txt", "lpt1", "report.", "report ", "a:b", "what?", "dir./file"} {
+		if err := checkPortableName(name); err != errReservedFileName {
+			t.Errorf("%q: got %v, want %v", name, err, errReservedFileName)
+		}
+	}
+	for _, name := range []string{"CONSOLE", "com10", "LPT", "report.txt", "aux_file", ".hidden", "dir/file"} {
+		if err := checkPortableName(name); err != nil {
+			t.Errorf("%q: got %v", name, err)
+		}
+	}
+}
+
+func TestStrictFilenameSafety(t *testing.T) {
+	ta := newTestAssembler(t, &AssemblerConfig{NameCompletedFiles: true, StrictFilenameSafety: true})
+	header := map[string]string{DefaultFileNameHeader: "nul.txt"}
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, header)
+	progress := ta.mustSend(uploadID, 0, "a", nil, http.StatusBadRequest)
+	if progress.RejectedError == nil || *progress.RejectedError != errReservedFileName.Error() {
+		t.Errorf("got rejection %v, want %q", progress.RejectedError, errReservedFileName)
+	}
+	files, err := readDirFiles(ta.a.Config.CompletedDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got completed files %v", files)
+	}
+	// Retrying can't help, so the upload is gone.
+	checkNoUploadsLeft(t, ta)
+}
+
+func TestReservedNamesAllowedByDefault(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("names are always checked on Windows")
+	}
+	ta := newTestAssembler(t, &AssemblerConfig{NameCompletedFiles: true})
+	header := map[string]string{DefaultFileNameHeader: "nul.txt"}
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, header)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+}
@@ -0,0 +1,48 @@
+package assemble
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkingDir(t *testing.T) {
+	store := NewFilesystemStore(t.TempDir(), t.TempDir())
+	store.WorkingDir = t.TempDir()
+	writeChunks(t, store, "1", "a", "b")
+	observed := false
+	digest := observingWriter{observe: func() {
+		partial, _ := readDirFiles(store.WorkingDir)
+		completed, _ := readDirFiles(store.CompletedDir)
+		if len(partial) == 1 && len(completed) == 0 {
+			observed = true
+		}
+	}}
+	if _, err := store.Finalize(context.Background(), "1", "file", 2, digest); err != nil {
+		t.Fatal(err)
+	}
+	if !observed {
+		t.Error("the file wasn't written in the working directory")
+	}
+	if got := readCompletedFile(t, store, "file"); got != "ab" {
+		t.Errorf("got completed file %q", got)
+	}
+	if files, _ := readDirFiles(store.WorkingDir); len(files) != 0 {
+		t.Errorf("files were left in the working directory: %v", files)
+	}
+}
+
+func TestWorkingDirIsCreated(t *testing.T) {
+	workingDir := filepath.Join(t.TempDir(), "work")
+	ta := newTestAssembler(t, &AssemblerConfig{WorkingDir: workingDir})
+	if info, err := os.Stat(workingDir); err != nil || !info.IsDir() {
+		t.Fatalf("working directory wasn't created: %v", err)
+	}
+	uploadID := ta.startUpload(`{"total_chunks": 1}`, nil)
+	ta.mustSend(uploadID, 0, "a", nil, http.StatusOK)
+	if files := ta.completedFiles(); len(files) != 1 || files[0] != "a" {
+		t.Errorf("got completed files %q", files)
+	}
+}